@@ -0,0 +1,198 @@
+package build
+
+// access_control.go applies a deployment's access control to the running site:
+//   - the password (HTTP basic auth), only its bcrypt hash is stored (basic_auth_hash column)
+//   - the IP allow and deny lists (ip_allow_list, ip_deny_list columns, plus the platform defaults)
+//
+// they are enforced by:
+//   - container mode: Traefik ipallowlist and basicauth middlewares, set through the labels of the nginx container
+//   - shared mode: the shared static server itself (the IP check and sharedSite.isAuthorized in ServeHTTP)
+//
+// changing either does not redeploy the files, the served directory stays as it is.
+// the endpoints hold the deployment lock (LockDeployment) while they save and apply a change,
+// a pipeline run of the same deployment cannot start in between.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/docker"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/util"
+)
+
+// basicAuthUsers returns the htpasswd line ("user:<bcrypt hash>") of a password protected deployment,
+// the format the Traefik basicauth middleware reads. "" for a public deployment.
+func basicAuthUsers(deployment *models.Deployment) string {
+	if deployment.BasicAuthUsername == nil || deployment.BasicAuthPasswordHash == nil {
+		return ""
+	}
+	return *deployment.BasicAuthUsername + ":" + *deployment.BasicAuthPasswordHash
+}
+
+// accessControlApplyTimeout bounds replacing the nginx container for an access control change.
+// a stop (10s grace period) and a create / start of an image that is already pulled, far below this.
+const accessControlApplyTimeout = 2 * time.Minute
+
+// refreshAccessControl reloads the password and IP access lists of deployment from the database,
+// the rest of the struct is left as it is.
+func (deployerPipeline *DeployerPipeline) refreshAccessControl(deployment *models.Deployment) error {
+	storedDeployment, err := deployerPipeline.database.GetDeployment(deployment.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get deployment: %w", err)
+	}
+	deployment.BasicAuthUsername = storedDeployment.BasicAuthUsername
+	deployment.BasicAuthPasswordHash = storedDeployment.BasicAuthPasswordHash
+	deployment.IPAllowList = storedDeployment.IPAllowList
+	deployment.IPDenyList = storedDeployment.IPDenyList
+	return nil
+}
+
+// allowedIPRanges resolves the address ranges the deployment is reachable from,
+// its own lists combined with the platform defaults (see util.IPAccessPolicy.AllowedRanges).
+// nil when it is reachable from everywhere.
+func (deployerPipeline *DeployerPipeline) allowedIPRanges(deployment *models.Deployment) ([]netip.Prefix, error) {
+	if deployerPipeline.ipAccessPolicy == nil {
+		return nil, nil
+	}
+	return deployerPipeline.ipAccessPolicy.AllowedRanges(deployment.IPAllowList, deployment.IPDenyList)
+}
+
+// nginxContainerAccessConfig fills the access control fields of a container config:
+// the htpasswd line and the resolved IP ranges, both turned into Traefik middleware labels.
+func (deployerPipeline *DeployerPipeline) nginxContainerAccessConfig(
+	deployment *models.Deployment,
+	containerConfig docker.NginxContainerConfig,
+) (docker.NginxContainerConfig, error) {
+	allowedRanges, err := deployerPipeline.allowedIPRanges(deployment)
+	if err != nil {
+		return containerConfig, fmt.Errorf("failed to resolve ip access lists: %w", err)
+	}
+	containerConfig.BasicAuthUsers = basicAuthUsers(deployment)
+	containerConfig.IPAllowRanges = util.FormatIPRangeList(allowedRanges)
+	if allowedRanges != nil {
+		containerConfig.IPStrategyDepth = deployerPipeline.ipAccessPolicy.ForwardedDepth
+	}
+	return containerConfig, nil
+}
+
+// ApplyAccessControl puts the deployment's current password and IP access lists (already saved
+// in the database) live. previousDeployment is the deployment as it was before the change,
+// its settings are put back on the site when the new ones cannot be applied.
+// in the shared mode the site is published again with the new settings, the swap is atomic.
+// in the container mode Traefik labels cannot be changed on a running container, so the nginx
+// container is replaced by one with the new labels, over the same served directory and nginx config.
+// a deployment that is not live has nothing to update, its next deploy picks the settings up.
+//
+// the caller holds LockDeployment(deployment.ID) and checked that no pipeline run is deploying it.
+// applyContext is only used for its values (the request's trace), the swap is not cancelled with it:
+// a client going away between removing the old container and starting the new one would leave the
+// site without any container (see newDeployerPipelineLogger for the same reasoning).
+func (deployerPipeline *DeployerPipeline) ApplyAccessControl(
+	applyContext context.Context,
+	deployment *models.Deployment,
+	previousDeployment *models.Deployment,
+) error {
+	if deployment.Status != models.StatusLive {
+		return nil
+	}
+	servedDirectory, err := deployerPipeline.liveServedDirectory(deployment.Slug)
+	if err != nil {
+		return err
+	}
+
+	swapContext, cancelSwap := context.WithTimeout(context.WithoutCancel(applyContext), accessControlApplyTimeout)
+	defer cancelSwap()
+
+	if deployerPipeline.sharedStaticServer != nil {
+		site, err := deployerPipeline.loadSharedSite(servedDirectory, deployment)
+		if err != nil {
+			return fmt.Errorf("failed to load site: %w", err)
+		}
+		deployerPipeline.sharedStaticServer.publish(deployment.Slug, site)
+	} else if err := deployerPipeline.replaceNginxContainerForAccessControl(swapContext, deployment, previousDeployment, servedDirectory); err != nil {
+		return err
+	}
+
+	deployerPipeline.logger.Info("access control applied",
+		"id", deployment.ID,
+		"slug", deployment.Slug,
+		"password_protected", deployment.BasicAuthUsername != nil,
+		"ip_allow_list_set", deployment.IPAllowList != nil,
+		"ip_deny_list_set", deployment.IPDenyList != nil,
+	)
+	return nil
+}
+
+// replaceNginxContainerForAccessControl recreates the deployment's nginx container with the current
+// access control labels, over the same served directory and nginx config.
+// when the new container cannot be started, one with previousDeployment's labels is started instead,
+// so the site stays up with its old settings rather than going offline.
+func (deployerPipeline *DeployerPipeline) replaceNginxContainerForAccessControl(
+	applyContext context.Context,
+	deployment *models.Deployment,
+	previousDeployment *models.Deployment,
+	servedDirectory string,
+) error {
+
+	runtimeEnvVarsList, err := decodeEnvVarsToSlice(deployment.RuntimeEnvironmentVariables)
+	if err != nil {
+		return fmt.Errorf("failed to decode runtime environment variables: %w", err)
+	}
+
+	// the config rendered by the last deploy is reused as it is. a deployment made before rendered
+	// configs existed has none, "" keeps the stock nginx config it was running with
+	// (a missing path would make docker create an empty directory in its place).
+	nginxConfigPath := deployerPipeline.nginxConfigPath(deployment.Slug)
+	if _, errStat := os.Stat(nginxConfigPath); errors.Is(errStat, os.ErrNotExist) {
+		nginxConfigPath = ""
+	} else if errStat != nil {
+		return fmt.Errorf("failed to stat nginx config: %w", errStat)
+	}
+
+	containerName := "deploy-" + deployment.Slug
+	baseContainerConfig := docker.NginxContainerConfig{
+		ContainerName:        containerName,
+		Slug:                 deployment.Slug,
+		HostSourceDirectory:  servedDirectory,
+		HostNginxConfigFile:  nginxConfigPath,
+		TraefikNetwork:       deployerPipeline.traefikNetwork,
+		EnvironmentVariables: runtimeEnvVarsList,
+	}
+	containerConfig, err := deployerPipeline.nginxContainerAccessConfig(deployment, baseContainerConfig)
+	if err != nil {
+		return err
+	}
+	// resolved before anything is stopped, the fallback must not be what fails
+	previousContainerConfig, err := deployerPipeline.nginxContainerAccessConfig(previousDeployment, baseContainerConfig)
+	if err != nil {
+		return fmt.Errorf("failed to resolve the previous access control: %w", err)
+	}
+
+	if err := deployerPipeline.dockerClient.StopAndRemoveContainer(applyContext, containerName); err != nil {
+		return fmt.Errorf("failed to remove existing container: %w", err)
+	}
+	errStartContainer := deployerPipeline.dockerClient.CreateAndStartNginxContainer(applyContext, containerConfig)
+	if errStartContainer == nil {
+		return nil
+	}
+
+	// a container created but not started still holds the name, it is removed before the fallback
+	errRestore := deployerPipeline.dockerClient.StopAndRemoveContainer(applyContext, containerName)
+	if errRestore == nil {
+		errRestore = deployerPipeline.dockerClient.CreateAndStartNginxContainer(applyContext, previousContainerConfig)
+	}
+	if errRestore != nil {
+		deployerPipeline.logger.Error("failed to restore the nginx container with the previous access control, the site is offline",
+			"id", deployment.ID,
+			"slug", deployment.Slug,
+			"error", errRestore,
+		)
+		return fmt.Errorf("failed to start nginx container: %w (restoring the previous one failed too: %v)", errStartContainer, errRestore)
+	}
+	return fmt.Errorf("failed to start nginx container, the previous access control is still in place: %w", errStartContainer)
+}
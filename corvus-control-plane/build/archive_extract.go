@@ -0,0 +1,112 @@
+package build
+
+// archive_extract.go detects the format of an uploaded archive from its magic bytes
+// and hands it to the matching extractor (zip_extract.go or tar_extract.go).
+// the file name and Content-Type of the upload are never trusted, only the content is.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveFormat is the detected format of an uploaded archive.
+type archiveFormat string
+
+const (
+	archiveFormatZip    archiveFormat = "zip"
+	archiveFormatTar    archiveFormat = "tar"
+	archiveFormatTarGz  archiveFormat = "tar.gz"
+	archiveFormatTarZst archiveFormat = "tar.zst"
+)
+
+// magic byte signatures at the start of each supported format.
+// tar has no signature at offset 0, its "ustar" marker sits at offset 257 of the first header block.
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	emptyZipMagic = []byte("PK\x05\x06") // a zip with no entries is only an end-of-central-directory record
+	gzipMagic     = []byte{0x1f, 0x8b}
+	zstdMagic     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic      = []byte("ustar")
+)
+
+const (
+	tarMagicOffset    = 257
+	archiveHeaderSize = 512 // one tar header block, enough for every signature above
+)
+
+// detectArchiveFormat reads the first bytes of the archive and returns its format.
+// gzip and zstd are assumed to wrap a tar, a compressed single file is not a deployable site anyway,
+// and the tar reader fails with a clear error if it is not one.
+func detectArchiveFormat(archiveFilePath string) (archiveFormat, error) {
+	archiveFile, err := os.Open(archiveFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive %q: %w", archiveFilePath, err)
+	}
+	defer archiveFile.Close()
+
+	header := make([]byte, archiveHeaderSize)
+	headerLength, err := io.ReadFull(archiveFile, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("failed to read archive header of %q: %w", archiveFilePath, err)
+	}
+	header = header[:headerLength]
+
+	switch {
+	case bytes.HasPrefix(header, zipMagic), bytes.HasPrefix(header, emptyZipMagic):
+		return archiveFormatZip, nil
+	case bytes.HasPrefix(header, gzipMagic):
+		return archiveFormatTarGz, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		return archiveFormatTarZst, nil
+	case len(header) >= tarMagicOffset+len(tarMagic) &&
+		bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return archiveFormatTar, nil
+	}
+	return "", fmt.Errorf("unsupported archive format (expected zip, tar, tar.gz or tar.zst)")
+}
+
+// ExtractArchiveUpload detects the format of the archive at archiveFilePath and extracts it
+// into destinationDirectory with the matching extractor. returns the detected format for logging.
+// every extractor applies the same path traversal protection (entryPathInsideDestination)
+// and the same resource limits (archive_limits.go).
+func ExtractArchiveUpload(archiveFilePath string, destinationDirectory string, limits ArchiveLimits) (archiveFormat, error) {
+	format, err := detectArchiveFormat(archiveFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case archiveFormatZip:
+		return format, ExtractZipUpload(archiveFilePath, destinationDirectory, limits)
+	default:
+		return format, ExtractTarUpload(archiveFilePath, format, destinationDirectory, limits)
+	}
+}
+
+// entryPathInsideDestination joins an archive entry name onto destinationDirectory and
+// rejects the entry if the result is outside of it (zip slip / tar slip).
+//
+// filepath.Join cleans the path, resolving any `..` components (cmd to go to parent folder).
+// example malicious entry name: "../../etc/passwd"
+// after Join + Clean: "/tmp/builds/abc123/../../etc/passwd" -> "/etc/passwd"
+// the HasPrefix() check catches this and rejects it.
+// the trailing separator on both sides makes "/tmp/builds/abc123-evil" not match "/tmp/builds/abc123",
+// and lets the destination directory itself ("." entries) pass.
+func entryPathInsideDestination(destinationDirectory string, entryName string) (string, error) {
+	entryDestPath := filepath.Join(destinationDirectory, entryName)
+	if !pathInsideDirectory(destinationDirectory, entryDestPath) {
+		return "", fmt.Errorf("path traversal detected: entry %q would write outside destination directory", entryName)
+	}
+	return entryDestPath, nil
+}
+
+// pathInsideDirectory reports whether the cleaned path is directory itself or anything below it.
+func pathInsideDirectory(directory string, path string) bool {
+	safePrefix := filepath.Clean(directory) + string(os.PathSeparator)
+	potentiallyMaliciousPath := filepath.Clean(path) + string(os.PathSeparator)
+	return strings.HasPrefix(potentiallyMaliciousPath, safePrefix)
+}
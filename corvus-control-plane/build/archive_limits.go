@@ -0,0 +1,118 @@
+package build
+
+// archive_limits.go enforces the resource limits on uploaded archives (zip bomb protection).
+// the sizes stored in zip and tar headers are written by whoever made the archive, so none of them
+// are trusted. every limit is checked against the bytes actually produced while decompressing,
+// and extraction stops the moment one is crossed, before the offending bytes reach the disk.
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// archiveCompressionRatioGraceBytes is how much an archive may expand before the compression ratio
+// limit is checked at all. small text-heavy sites (a few html/css/js files) legitimately compress
+// 20-50x, checking the ratio on a 30 KB upload would reject normal sites, while the damage a
+// zip bomb does only starts well above this size.
+const archiveCompressionRatioGraceBytes = 10 << 20 // 10MB
+
+// ArchiveLimits caps what extracting a single uploaded archive may write to disk.
+// a zero (or negative) value disables that particular limit.
+type ArchiveLimits struct {
+	// MaxTotalUncompressedBytes caps the sum of all extracted file sizes.
+	MaxTotalUncompressedBytes int64
+
+	// MaxEntryCount caps the number of entries (files, directories and links) in the archive.
+	// millions of empty files exhaust inodes without using any bytes.
+	MaxEntryCount int64
+
+	// MaxFileBytes caps the uncompressed size of a single file.
+	MaxFileBytes int64
+
+	// MaxCompressionRatio caps total uncompressed bytes / archive bytes on disk
+	// (after archiveCompressionRatioGraceBytes). a 40 KB archive expanding to 10 GB has a ratio of ~250000.
+	MaxCompressionRatio int64
+}
+
+// archiveBudget tracks what one extraction has used so far against its ArchiveLimits.
+// created once per archive by ExtractZipUpload / ExtractTarUpload and shared by every entry of that archive.
+type archiveBudget struct {
+	limits ArchiveLimits
+
+	// archiveBytes is the size of the (compressed) archive file, the base of the ratio check
+	archiveBytes int64
+
+	entryCount             int64
+	totalUncompressedBytes int64
+}
+
+// newArchiveBudget creates an empty budget for the archive at archiveFilePath.
+// the archive size is read from the file on disk (the bytes actually received), not from any header.
+func newArchiveBudget(limits ArchiveLimits, archiveFilePath string) (*archiveBudget, error) {
+	archiveInfo, err := os.Stat(archiveFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat archive %q: %w", archiveFilePath, err)
+	}
+	return &archiveBudget{limits: limits, archiveBytes: archiveInfo.Size()}, nil
+}
+
+// addEntry counts one more archive entry and fails once MaxEntryCount is crossed.
+// called for every entry before anything is created on disk for it.
+func (budget *archiveBudget) addEntry() error {
+	budget.entryCount++
+	if budget.limits.MaxEntryCount > 0 && budget.entryCount > budget.limits.MaxEntryCount {
+		return fmt.Errorf("archive has more than %d entries", budget.limits.MaxEntryCount)
+	}
+	return nil
+}
+
+// copyEntry streams one file's decompressed content from source into destination,
+// counting every byte against the per-file, total and ratio limits.
+// used instead of a plain io.Copy by both the zip and the tar extractor.
+func (budget *archiveBudget) copyEntry(destination io.Writer, source io.Reader) error {
+	_, err := io.Copy(destination, &budgetedReader{source: source, budget: budget})
+	return err
+}
+
+// budgetedReader wraps a decompressing reader and fails the read that crosses a limit.
+// the failing read returns 0 bytes, so io.Copy never writes the chunk that went over.
+type budgetedReader struct {
+	source    io.Reader
+	budget    *archiveBudget
+	fileBytes int64
+}
+
+func (reader *budgetedReader) Read(buffer []byte) (int, error) {
+	bytesRead, errRead := reader.source.Read(buffer)
+	if bytesRead == 0 {
+		return 0, errRead
+	}
+
+	reader.fileBytes += int64(bytesRead)
+	reader.budget.totalUncompressedBytes += int64(bytesRead)
+	errLimit := reader.budget.checkSizeLimits(reader.fileBytes)
+	if errLimit != nil {
+		return 0, errLimit
+	}
+	return bytesRead, errRead
+}
+
+// checkSizeLimits compares the bytes produced so far against the size related limits.
+// currentFileBytes is the running size of the entry currently being extracted.
+func (budget *archiveBudget) checkSizeLimits(currentFileBytes int64) error {
+	limits := budget.limits
+	if limits.MaxFileBytes > 0 && currentFileBytes > limits.MaxFileBytes {
+		return fmt.Errorf("a file in the archive is larger than the %d byte per-file limit", limits.MaxFileBytes)
+	}
+	if limits.MaxTotalUncompressedBytes > 0 && budget.totalUncompressedBytes > limits.MaxTotalUncompressedBytes {
+		return fmt.Errorf("archive expands to more than the %d byte total limit", limits.MaxTotalUncompressedBytes)
+	}
+	if limits.MaxCompressionRatio > 0 && budget.totalUncompressedBytes > archiveCompressionRatioGraceBytes {
+		// ratio compared as a multiplication so a tiny archiveBytes (or 0) cannot divide by zero
+		if budget.totalUncompressedBytes > budget.archiveBytes*limits.MaxCompressionRatio {
+			return fmt.Errorf("archive expands more than %dx its compressed size (possible zip bomb)", limits.MaxCompressionRatio)
+		}
+	}
+	return nil
+}
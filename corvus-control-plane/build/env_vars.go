@@ -3,15 +3,27 @@ package build
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
 )
 
-// decodeEnvVarsToSlice converts the JSON-encoded environment variables string
-// stored in the database into a []string of "KEY=VALUE" pairs that the Docker
-// SDK expects for container.Config.Env.
-//
-// Returns nil (not an error) when the input pointer is nil or the JSON object
-// is empty, meaning no environment variables were configured.
-func decodeEnvVarsToSlice(encodedEnvVars *string) ([]string, error) {
+// runtimeEnvConfigFileName is the file generated in the served directory for
+// runtime-scoped variables. a site reads them with <script src="/corvus-env.js">
+// and then window.__CORVUS_ENV__.KEY, so the values can change on redeploy without a rebuild.
+const runtimeEnvConfigFileName = "corvus-env.js"
+
+// secretMaskReplacement is what a secret value is replaced with in log output.
+const secretMaskReplacement = "********"
+
+// decodeEnvVarsToMap converts one of the JSON-encoded environment variable columns
+// (env_vars, runtime_env_vars, secret_env_vars) back into a Go map.
+// Returns nil (not an error) when the input pointer is nil or empty.
+func decodeEnvVarsToMap(encodedEnvVars *string) (map[string]string, error) {
 	if encodedEnvVars == nil || *encodedEnvVars == "" {
 		return nil, nil
 	}
@@ -21,6 +33,20 @@ func decodeEnvVarsToSlice(encodedEnvVars *string) ([]string, error) {
 	if unmarshalError != nil {
 		return nil, fmt.Errorf("failed to unmarshal environment variables JSON: %w", unmarshalError)
 	}
+	return envVarsMap, nil
+}
+
+// decodeEnvVarsToSlice converts the JSON-encoded environment variables string
+// stored in the database into a []string of "KEY=VALUE" pairs that the Docker
+// SDK expects for container.Config.Env.
+//
+// Returns nil (not an error) when the input pointer is nil or the JSON object
+// is empty, meaning no environment variables were configured.
+func decodeEnvVarsToSlice(encodedEnvVars *string) ([]string, error) {
+	envVarsMap, err := decodeEnvVarsToMap(encodedEnvVars)
+	if err != nil {
+		return nil, err
+	}
 
 	if len(envVarsMap) == 0 {
 		return nil, nil
@@ -32,3 +58,98 @@ func decodeEnvVarsToSlice(encodedEnvVars *string) ([]string, error) {
 	}
 	return envVarsList, nil
 }
+
+// buildStageEnvVars returns the environment variables the build container is allowed to see:
+// build-scoped and secret-scoped variables. runtime-scoped variables are deliberately
+// left out so they cannot be baked into the build output.
+func buildStageEnvVars(deployment *models.Deployment) ([]string, error) {
+	buildEnvVars, err := decodeEnvVarsToSlice(deployment.EnvironmentVariables)
+	if err != nil {
+		return nil, fmt.Errorf("build-scoped variables: %w", err)
+	}
+	secretEnvVars, err := decodeEnvVarsToSlice(deployment.SecretEnvironmentVariables)
+	if err != nil {
+		return nil, fmt.Errorf("secret-scoped variables: %w", err)
+	}
+	return append(buildEnvVars, secretEnvVars...), nil
+}
+
+// writeRuntimeEnvConfig writes corvus-env.js into the served directory when the deployment
+// has runtime-scoped variables. the values are JSON-encoded, which also makes them safe
+// to embed in a JS file (no way to break out of the object literal).
+// returns false (and writes nothing) when the deployment has no runtime-scoped variables.
+func writeRuntimeEnvConfig(servedDirectory string, encodedRuntimeEnvVars *string) (bool, error) {
+	runtimeEnvVars, err := decodeEnvVarsToMap(encodedRuntimeEnvVars)
+	if err != nil {
+		return false, err
+	}
+	if len(runtimeEnvVars) == 0 {
+		return false, nil
+	}
+
+	// json.Marshal sorts map keys, so the generated file is stable across redeploys.
+	encodedObject, err := json.Marshal(runtimeEnvVars)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode runtime environment variables: %w", err)
+	}
+
+	content := "window.__CORVUS_ENV__ = Object.freeze(" + string(encodedObject) + ");\n"
+	configPath := filepath.Join(servedDirectory, runtimeEnvConfigFileName)
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", configPath, err)
+	}
+	return true, nil
+}
+
+// newSecretMasker builds a strings.Replacer that replaces every secret-scoped value
+// of the deployment (plus additionalSecretValues, eg, the decrypted repository credential)
+// with secretMaskReplacement. returns nil when there are no secrets,
+// so callers can skip masking entirely.
+//
+// longer values are listed first so a secret that contains another secret
+// is masked as a whole rather than partially.
+func newSecretMasker(deployment *models.Deployment, additionalSecretValues ...string) *strings.Replacer {
+	// a decode error is treated as "no secret env vars", the additional values are still masked
+	secretEnvVars, _ := decodeEnvVarsToMap(deployment.SecretEnvironmentVariables)
+
+	secretValues := make([]string, 0, len(secretEnvVars)+len(additionalSecretValues))
+	for _, value := range secretEnvVars {
+		if value != "" {
+			secretValues = append(secretValues, value)
+		}
+	}
+	for _, value := range additionalSecretValues {
+		if value != "" {
+			secretValues = append(secretValues, value)
+		}
+	}
+	if len(secretValues) == 0 {
+		return nil
+	}
+	sort.Slice(secretValues, func(i, j int) bool { return len(secretValues[i]) > len(secretValues[j]) })
+
+	replacerPairs := make([]string, 0, len(secretValues)*2)
+	for _, value := range secretValues {
+		replacerPairs = append(replacerPairs, value, secretMaskReplacement)
+	}
+	return strings.NewReplacer(replacerPairs...)
+}
+
+// secretMaskingWriter wraps the deployment log writer so git and build container output
+// cannot leak secret values into <slug>.log (eg, a build script that echoes $NPM_TOKEN).
+// each Write is masked independently. Docker log frames and git progress are line-oriented,
+// so a secret split across two writes is not a practical concern here.
+type secretMaskingWriter struct {
+	destination io.Writer
+	masker      *strings.Replacer
+}
+
+// Write masks the chunk and forwards it. it reports len(chunk) as written (not the masked
+// length) because io.Writer callers treat a shorter count as a short write error.
+func (maskingWriter *secretMaskingWriter) Write(chunk []byte) (int, error) {
+	masked := maskingWriter.masker.Replace(string(chunk))
+	if _, err := io.WriteString(maskingWriter.destination, masked); err != nil {
+		return 0, err
+	}
+	return len(chunk), nil
+}
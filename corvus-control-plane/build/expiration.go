@@ -44,6 +44,7 @@ func (deployerPipeline *DeployerPipeline) cleanupExpiredDeployments(
 	expiredDeployments, err := deployerPipeline.database.ListExpiredDeployments()
 	if err != nil {
 		logger.Error("failed to list expired deployments", "error", err)
+		deployerPipeline.metrics.ExpirationCleanupFailed()
 		return
 	}
 
@@ -66,8 +67,10 @@ func (deployerPipeline *DeployerPipeline) cleanupExpiredDeployments(
 				"slug", deployment.Slug,
 				"error", err,
 			)
+			deployerPipeline.metrics.ExpirationCleanupFailed()
 			continue
 		}
+		deployerPipeline.metrics.ExpirationCleanupPerformed()
 
 		logger.Info("expired deployment cleaned up",
 			"id", deployment.ID,
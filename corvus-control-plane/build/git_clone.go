@@ -8,7 +8,7 @@ import (
 	"strings"
 )
 
-// cloneGitHubRepo clones a public GitHub repository into the specified
+// cloneGitRepo clones a public git repository (GitHub or any other https:// / file:// host) into the specified
 // destination directory using a shallow, single-branch clone.
 // Shallow clone (--depth 1) downloads only the latest commit, which is
 // all the deployment pipeline needs. no history, tags, or other branches.
@@ -22,7 +22,14 @@ import (
 // faster, handles all protocol edge cases, and avoids pulling in ~30+
 // transitive dependencies for a single fire-and-forget clone operation.
 // The Go backend's Docker image must include git (one `apk add git` line).
-func cloneGitHubRepo(repoURL string, branch string, destinationDir string, logWriter io.Writer) error {
+//
+// credentialEnvironment carries the token or deploy key of a private repository (nil for public ones).
+// it only ever reaches git through the environment, never the command line, so it cannot show up in `ps`.
+//
+// sparseDirectories (monorepo root_directory + shared_directories, nil for the whole repo) turns the
+// clone into a partial clone: --filter=blob:none downloads no file contents up front and --sparse
+// checks out only the root level files, applySparseCheckout then fetches exactly the listed directories.
+func cloneGitRepo(repoURL string, branch string, destinationDir string, sparseDirectories []string, logWriter io.Writer, credentialEnvironment *gitCredentialEnvironment) error {
 	// exec.Command constructs the command but does not run it yet.
 	// the command is equivalent to:
 	//   git clone --depth 1 --single-branch --branch <branch> <repoURL> <destinationDir>
@@ -33,14 +40,25 @@ func cloneGitHubRepo(repoURL string, branch string, destinationDir string, logWr
 	//
 	// the destination directory must NOT already exist, git clone creates it.
 	// the caller is responsible for ensuring the path is available.
-	gitCloneCommand := exec.Command(
-		"git", "clone",
+	cloneArguments := []string{
+		"clone",
 		"--depth", "1",
 		"--single-branch",
 		"--branch", branch,
+	}
+	if len(sparseDirectories) > 0 {
+		// a server without partial clone support prints a warning and sends everything, still correct
+		cloneArguments = append(cloneArguments, "--filter=blob:none", "--sparse")
+	}
+	cloneArguments = append(cloneArguments,
+		"--", // ends the options, repoURL and destinationDir are never read as flags
 		repoURL,
 		destinationDir,
 	)
+	gitCloneCommand := exec.Command("git", cloneArguments...)
+	// never prompt for credentials (there is no terminal, the clone would hang), see git_remote.go
+	// plus the askpass / ssh settings of a private repository, see git_credentials.go
+	gitCloneCommand.Env = credentialEnvironment.environment()
 
 	// git writes clone progress (remote counting, receiving objects, resolving deltas)
 	// to stderr, not stdout. stdout is used for plumbing commands (git log, git diff)
@@ -70,7 +88,7 @@ func cloneGitHubRepo(repoURL string, branch string, destinationDir string, logWr
 		//   "fatal: Remote branch main not found in upstream origin"
 		//   "fatal: repository 'https://...' not found"
 		stderrOutput := strings.TrimSpace(stderrBuf.String())
-		hint := extractGitErrorHint(stderrOutput)
+		hint := extractGitErrorHint(stderrOutput, credentialEnvironment)
 
 		if hint != "" {
 			return fmt.Errorf("git clone failed for %q (branch %q): %s", repoURL, branch, hint)
@@ -78,20 +96,72 @@ func cloneGitHubRepo(repoURL string, branch string, destinationDir string, logWr
 		return fmt.Errorf("git clone failed for %q (branch %q): %w", repoURL, branch, errGitClone)
 	}
 
+	if len(sparseDirectories) > 0 {
+		return applySparseCheckout(destinationDir, sparseDirectories, logWriter, credentialEnvironment)
+	}
 	return nil
 }
 
+// gitErrorHintRule maps a known git / git-lfs failure to a specific, actionable hint.
+// matched before the generic "last fatal: line" fallback, because for submodule and LFS failures
+// that line is often unhelpful (eg, "fatal: Failed to recurse into submodule path 'themes/x'").
+type gitErrorHintRule struct {
+	// stderrSubstring is looked for anywhere in git's stderr
+	stderrSubstring string
+
+	// hint is returned instead of the raw line. a %s is replaced with the matching line (minus "fatal:" / "error:").
+	hint string
+}
+
+// gitErrorHintRules are checked in order, the first match wins.
+var gitErrorHintRules = []gitErrorHintRule{
+	// submodules
+	{"transport 'file' not allowed", "submodules with file:// URLs are not allowed"},
+	{"into submodule path", "a submodule could not be cloned (check the URL in .gitmodules, and that a private submodule is reachable with this deployment's credentials): %s"},
+	{"Fetched in submodule path", "a submodule commit no longer exists upstream (force-pushed or deleted), update the submodule in the parent repository: %s"},
+	{"No url found for submodule path", "a submodule is missing from .gitmodules: %s"},
+
+	// git lfs
+	{"git: 'lfs' is not a git command", errGitLFSNotInstalled.Error()},
+	{"over its data quota", "the repository is over its Git LFS bandwidth or storage quota"},
+	{"Smudge error", "an LFS object could not be downloaded: %s"},
+	{"Repository or object not found", "LFS objects were not found on the server (missing upload, or the LFS server needs credentials): %s"},
+	{"batch response:", "the Git LFS server rejected the download: %s"},
+}
+
 // extractGitErrorHint parses git's stderr output and returns a concise,
-// user-facing error message. Git prefixes fatal errors with "fatal: "
-// which contains the actual reason for the failure.
-func extractGitErrorHint(stderr string) string {
-	// look for the last "fatal:" line, which is the most specific error
+// user-facing error message. known submodule and LFS failures get a specific hint
+// (gitErrorHintRules), otherwise the last "fatal:" line is used, which contains the actual reason.
+// git-lfs reports with "error:" rather than "fatal:", so that is the last fallback.
+// the hint is scrubbed of the repository credential and of any user:password@ in URLs,
+// it becomes part of an error message that is logged, stored as a pipeline event and traced.
+func extractGitErrorHint(stderr string, credentialEnvironment *gitCredentialEnvironment) string {
 	lines := strings.Split(stderr, "\n")
-	for i := len(lines) - 1; i >= 0; i-- {
-		line := strings.TrimSpace(lines[i])
-		if strings.HasPrefix(line, "fatal:") {
-			// return the message after "fatal: "
-			return strings.TrimSpace(strings.TrimPrefix(line, "fatal:"))
+
+	for _, rule := range gitErrorHintRules {
+		for _, line := range lines {
+			if !strings.Contains(line, rule.stderrSubstring) {
+				continue
+			}
+			hint := rule.hint
+			if strings.Contains(hint, "%s") {
+				matchedLine := strings.TrimSpace(line)
+				matchedLine = strings.TrimPrefix(matchedLine, "fatal:")
+				matchedLine = strings.TrimPrefix(matchedLine, "error:")
+				hint = fmt.Sprintf(hint, strings.TrimSpace(matchedLine))
+			}
+			return credentialEnvironment.scrubSecrets(hint)
+		}
+	}
+
+	for _, linePrefix := range []string{"fatal:", "error:"} {
+		// look for the last matching line, which is the most specific error
+		for i := len(lines) - 1; i >= 0; i-- {
+			line := strings.TrimSpace(lines[i])
+			if strings.HasPrefix(line, linePrefix) {
+				// return the message after the prefix
+				return credentialEnvironment.scrubSecrets(strings.TrimSpace(strings.TrimPrefix(line, linePrefix)))
+			}
 		}
 	}
 	return ""
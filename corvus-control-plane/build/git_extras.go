@@ -0,0 +1,76 @@
+package build
+
+// git_extras.go runs the optional post-clone steps of a github/git deployment:
+//   - submodules (Deployment.GitSubmodules): themes are very often a submodule (the usual Hugo setup),
+//     without them the build runs against an empty theme directory
+//   - Git LFS (Deployment.GitLFS): without `git lfs pull` the checkout holds ~130 byte pointer files
+//     where the images should be, the site deploys "fine" with every image broken
+//
+// both are opt-in: they cost extra clone time, and LFS needs the git-lfs binary on the host.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// errGitLFSNotInstalled is returned by pullGitLFSObjects when the host has no git-lfs binary.
+var errGitLFSNotInstalled = errors.New("git-lfs is not installed on the server, LFS deployments are unavailable")
+
+// updateGitSubmodules initialises and checks out every submodule, recursively, as shallow clones:
+//
+//	git submodule update --init --recursive --depth 1
+//
+// submodules inherit the clone's environment, so a private parent repository's token or deploy key
+// is also offered to submodules (a common setup for private themes on the same host).
+// a token is only given to submodules on the repository's own host, never to another server
+// a .gitmodules URL points at (see gitAskPassScript). the same goes for LFS servers.
+// in a sparse checkout only the submodules inside the checked out directories are initialised.
+func updateGitSubmodules(repositoryDir string, logWriter io.Writer, credentialEnvironment *gitCredentialEnvironment) error {
+	err := runGitCommand(logWriter, credentialEnvironment,
+		"-C", repositoryDir, "submodule", "update", "--init", "--recursive", "--depth", "1")
+	if err != nil {
+		return fmt.Errorf("git submodule update failed: %w", err)
+	}
+	return nil
+}
+
+// pullGitLFSObjects replaces the LFS pointer files of the checkout with their real content:
+//
+//	git lfs install --local   (smudge/clean filters for this repository only, the host's ~/.gitconfig is untouched)
+//	git lfs pull [--include <sparse directories>]
+//
+// with submodules, the same is done inside every submodule.
+// sparseDirectories limits the download to the monorepo directories that are checked out.
+func pullGitLFSObjects(repositoryDir string, sparseDirectories []string, withSubmodules bool, logWriter io.Writer, credentialEnvironment *gitCredentialEnvironment) error {
+	if _, err := exec.LookPath("git-lfs"); err != nil {
+		return errGitLFSNotInstalled
+	}
+
+	if err := runGitCommand(logWriter, credentialEnvironment, "-C", repositoryDir, "lfs", "install", "--local"); err != nil {
+		return fmt.Errorf("git lfs install failed: %w", err)
+	}
+
+	pullArguments := []string{"-C", repositoryDir, "lfs", "pull"}
+	if len(sparseDirectories) > 0 {
+		includePatterns := make([]string, 0, len(sparseDirectories))
+		for _, sparseDirectory := range sparseDirectories {
+			includePatterns = append(includePatterns, sparseDirectory+"/**")
+		}
+		pullArguments = append(pullArguments, "--include", strings.Join(includePatterns, ","))
+	}
+	if err := runGitCommand(logWriter, credentialEnvironment, pullArguments...); err != nil {
+		return fmt.Errorf("git lfs pull failed: %w", err)
+	}
+
+	if withSubmodules {
+		err := runGitCommand(logWriter, credentialEnvironment,
+			"-C", repositoryDir, "submodule", "foreach", "--recursive", "git lfs install --local && git lfs pull")
+		if err != nil {
+			return fmt.Errorf("git lfs pull in submodules failed: %w", err)
+		}
+	}
+	return nil
+}
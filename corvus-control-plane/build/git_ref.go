@@ -0,0 +1,175 @@
+package build
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// maxGitRefLength is far above any real branch or tag name, it only stops abuse
+const maxGitRefLength = 255
+
+// maxCommitMessageLength caps the stored commit message. the subject is what the UI shows,
+// a multi-kilobyte body (eg, a squash merge of 200 commits) is cut.
+const maxCommitMessageLength = 2000
+
+// fullCommitSHAPattern matches a full SHA-1 (40 hex) or SHA-256 (64 hex) object name.
+var fullCommitSHAPattern = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// abbreviatedCommitSHAPattern matches what looks like a short SHA (eg, "3f1c9e2").
+// git fetch only accepts full object names, a short one fails with a confusing
+// "couldn't find remote ref". it cannot be rejected up front: branch and tag names made only of
+// hex characters are real ("20240115", "1234567", "deadbeef"), so a failed fetch of such a ref
+// is only reported as an abbreviated SHA when the remote has no branch or tag of that name.
+var abbreviatedCommitSHAPattern = regexp.MustCompile(`^[0-9a-f]{7,39}$`)
+
+// ValidateGitRef checks the ref of a create or redeploy request: a branch, a tag, or a full commit SHA.
+// the rules are a subset of `git check-ref-format`, strict enough that the ref can never be
+// read as a flag or break out of the refspec:
+//   - no leading "-" (would be an option to git fetch), no leading or trailing "/"
+//   - no whitespace, control characters, or any of ~ ^ : ? * [ \
+//   - no "..", "@{", "//" and no trailing ".lock" or "."
+func ValidateGitRef(ref string) error {
+	if ref == "" {
+		return errors.New("ref is empty")
+	}
+	if len(ref) > maxGitRefLength {
+		return fmt.Errorf("ref is longer than %d characters", maxGitRefLength)
+	}
+	if strings.HasPrefix(ref, "-") || strings.HasPrefix(ref, "/") || strings.HasSuffix(ref, "/") {
+		return errors.New("ref must not start with '-' or start/end with '/'")
+	}
+	for _, character := range ref {
+		if unicode.IsSpace(character) || unicode.IsControl(character) || strings.ContainsRune("~^:?*[\\", character) {
+			return fmt.Errorf("ref contains an invalid character %q", character)
+		}
+	}
+	for _, forbiddenSequence := range []string{"..", "@{", "//", "/."} {
+		if strings.Contains(ref, forbiddenSequence) {
+			return fmt.Errorf("ref must not contain %q", forbiddenSequence)
+		}
+	}
+	if strings.HasSuffix(ref, ".lock") || strings.HasSuffix(ref, ".") || strings.HasPrefix(ref, ".") {
+		return errors.New("ref must not start with '.' or end with '.' or '.lock'")
+	}
+	return nil
+}
+
+// fetchGitRef checks out exactly one ref (branch, tag or full SHA) of a repository into destinationDir.
+// `git clone --branch` only takes branch and tag names, so a pinned ref uses the lower level
+// equivalent instead:
+//
+//	git init <destinationDir>
+//	git remote add origin <repoURL>
+//	git fetch --depth 1 origin <ref>
+//	git checkout --detach FETCH_HEAD
+//
+// still a shallow fetch of a single commit. the named remote is needed for monorepo sparse checkouts
+// (sparseDirectories, see cloneGitRepo): a partial clone fetches missing blobs lazily from it. fetching a commit by SHA needs protocol v2 or
+// uploadpack.allowReachableSHA1InWant on the server (GitHub, GitLab, Gitea and git >= 2.18 over
+// file:// all allow it). when a server refuses, the full history is fetched and the SHA
+// checked out from it, slower but it works everywhere.
+func fetchGitRef(repoURL string, ref string, destinationDir string, sparseDirectories []string, logWriter io.Writer, credentialEnvironment *gitCredentialEnvironment) error {
+	// init.defaultBranch only silences git's "using 'master' as the name for the initial branch" hint,
+	// the checkout below detaches from that branch anyway
+	if err := runGitCommand(logWriter, credentialEnvironment, "-c", "init.defaultBranch=main", "init", "--quiet", "--", destinationDir); err != nil {
+		return fmt.Errorf("git init failed: %w", err)
+	}
+	// `--` ends the options, the URL is never read as a flag
+	if err := runGitCommand(logWriter, credentialEnvironment, "-C", destinationDir, "remote", "add", "--", "origin", repoURL); err != nil {
+		return fmt.Errorf("git remote add failed: %w", err)
+	}
+
+	fetchArguments := []string{"-C", destinationDir, "fetch", "--depth", "1"}
+	if len(sparseDirectories) > 0 {
+		fetchArguments = append(fetchArguments, "--filter=blob:none")
+	}
+	fetchArguments = append(fetchArguments, "--", "origin", ref)
+	errShallowFetch := runGitCommand(logWriter, credentialEnvironment, fetchArguments...)
+	if errShallowFetch != nil {
+		if !fullCommitSHAPattern.MatchString(ref) {
+			if abbreviatedCommitSHAPattern.MatchString(ref) {
+				// a lookup failure (host unreachable) keeps the fetch error below, which says more
+				refExists, errLookup := gitRemoteHasBranchOrTag(destinationDir, ref, credentialEnvironment)
+				if errLookup == nil && !refExists {
+					return fmt.Errorf("%q is not a branch or tag of %q, abbreviated commit SHAs are not supported, use the full 40 character SHA", ref, repoURL)
+				}
+			}
+			return fmt.Errorf("git fetch failed for %q (ref %q): %w", repoURL, ref, errShallowFetch)
+		}
+		fmt.Fprintf(logWriter, "shallow fetch of %s was refused, fetching the full history instead\n", ref)
+		errFullFetch := runGitCommand(logWriter, credentialEnvironment,
+			"-C", destinationDir, "fetch", "--", "origin", "+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*")
+		if errFullFetch != nil {
+			return fmt.Errorf("git fetch failed for %q (ref %q): %w", repoURL, ref, errFullFetch)
+		}
+		if len(sparseDirectories) > 0 {
+			if err := applySparseCheckout(destinationDir, sparseDirectories, logWriter, credentialEnvironment); err != nil {
+				return err
+			}
+		}
+		if err := runGitCommand(logWriter, credentialEnvironment, "-C", destinationDir, "checkout", "--quiet", "--detach", ref); err != nil {
+			return fmt.Errorf("commit %q not found in %q: %w", ref, repoURL, err)
+		}
+		return nil
+	}
+
+	// the sparse patterns are set before the checkout, so only those directories are ever written
+	if len(sparseDirectories) > 0 {
+		if err := applySparseCheckout(destinationDir, sparseDirectories, logWriter, credentialEnvironment); err != nil {
+			return err
+		}
+	}
+	if err := runGitCommand(logWriter, credentialEnvironment, "-C", destinationDir, "checkout", "--quiet", "--detach", "FETCH_HEAD"); err != nil {
+		return fmt.Errorf("git checkout of %q failed: %w", ref, err)
+	}
+	return nil
+}
+
+// runGitCommand runs one git command with the pipeline's git environment (see cloneGitRepo),
+// output goes to logWriter. the returned error carries git's "fatal:" line, scrubbed of credentials.
+func runGitCommand(logWriter io.Writer, credentialEnvironment *gitCredentialEnvironment, arguments ...string) error {
+	gitCommand := exec.Command("git", arguments...)
+	gitCommand.Env = credentialEnvironment.environment()
+
+	var stderrBuf bytes.Buffer
+	gitCommand.Stderr = io.MultiWriter(logWriter, &stderrBuf)
+	gitCommand.Stdout = logWriter
+
+	errRun := gitCommand.Run()
+	if errRun != nil {
+		hint := extractGitErrorHint(strings.TrimSpace(stderrBuf.String()), credentialEnvironment)
+		if hint != "" {
+			return errors.New(hint)
+		}
+		return errRun
+	}
+	return nil
+}
+
+// readGitHeadCommit returns the SHA and message of the checked out commit in repositoryDir.
+// works for a regular clone and for a detached fetchGitRef checkout alike.
+func readGitHeadCommit(repositoryDir string) (commitSHA string, commitMessage string, err error) {
+	// %H = full SHA, %x00 = NUL separator (cannot appear in a commit message), %B = raw message
+	gitLogCommand := exec.Command("git", "-C", repositoryDir, "log", "-1", "--format=%H%x00%B")
+	gitLogCommand.Env = nonInteractiveGitEnvironment()
+	output, err := gitLogCommand.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read the checked out commit: %w", err)
+	}
+
+	commitSHA, commitMessage, found := strings.Cut(string(output), "\x00")
+	if !found || commitSHA == "" {
+		return "", "", errors.New("failed to read the checked out commit: unexpected git log output")
+	}
+	commitMessage = strings.TrimSpace(commitMessage)
+	if len(commitMessage) > maxCommitMessageLength {
+		commitMessage = strings.ToValidUTF8(commitMessage[:maxCommitMessageLength], "") + "..."
+	}
+	return commitSHA, commitMessage, nil
+}
@@ -0,0 +1,100 @@
+package build
+
+// monorepo.go holds the root_directory / shared_directories handling of github and git deployments.
+// a monorepo deployment builds one app out of a larger repository:
+//   - RootDirectory is the app's directory, the build command runs there and OutputDirectory is relative to it
+//   - SharedDirectories are the other directories the build needs (shared packages, configs)
+//
+// only those directories (plus the files at the repository root, see sparseCheckoutDirectories)
+// are checked out, the rest of the repository is never downloaded.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+)
+
+// maxSharedDirectories caps shared_directories, a sparse checkout of 50 directories is not sparse anymore
+const maxSharedDirectories = 20
+
+// ValidateRelativeDirectory checks a user supplied directory inside the source content
+// (root_directory, output_directory, a shared directory) and returns it cleaned.
+// the value is joined onto host paths by the pipeline, so anything that could climb out of
+// the clone or the extracted archive (absolute paths, "..") is rejected here.
+// "" and "." both mean the top level and are returned as ".".
+func ValidateRelativeDirectory(fieldName string, rawDirectory string) (string, error) {
+	rawDirectory = strings.TrimSpace(rawDirectory)
+	if rawDirectory == "" {
+		return ".", nil
+	}
+	if strings.ContainsAny(rawDirectory, "\\\x00") {
+		return "", fmt.Errorf("%s must use forward slashes", fieldName)
+	}
+	if strings.HasPrefix(rawDirectory, "/") {
+		return "", fmt.Errorf("%s must be relative, not an absolute path", fieldName)
+	}
+	cleanedDirectory := path.Clean(rawDirectory)
+	if cleanedDirectory == ".." || strings.HasPrefix(cleanedDirectory, "../") {
+		return "", fmt.Errorf("%s must not point outside the source", fieldName)
+	}
+	// a leading "-" would be an option to `git sparse-checkout set`
+	if strings.HasPrefix(cleanedDirectory, "-") {
+		return "", fmt.Errorf("%s must not start with '-'", fieldName)
+	}
+	return cleanedDirectory, nil
+}
+
+// ParseSharedDirectories validates the comma separated shared_directories field
+// and returns the cleaned list joined back with commas (the stored form), "" for none.
+func ParseSharedDirectories(rawSharedDirectories string) (string, error) {
+	var sharedDirectories []string
+	for _, rawDirectory := range strings.Split(rawSharedDirectories, ",") {
+		if strings.TrimSpace(rawDirectory) == "" {
+			continue
+		}
+		sharedDirectory, err := ValidateRelativeDirectory("shared_directories entry", rawDirectory)
+		if err != nil {
+			return "", err
+		}
+		if sharedDirectory == "." {
+			return "", errors.New("shared_directories must not contain the repository root, leave root_directory empty instead")
+		}
+		sharedDirectories = append(sharedDirectories, sharedDirectory)
+	}
+	if len(sharedDirectories) > maxSharedDirectories {
+		return "", fmt.Errorf("shared_directories has more than %d entries", maxSharedDirectories)
+	}
+	return strings.Join(sharedDirectories, ","), nil
+}
+
+// sparseCheckoutDirectories returns the directories to check out for a deployment,
+// nil means the whole repository (no root_directory set).
+//
+// sparse checkout runs in cone mode, which always includes the files directly at the
+// repository root. that is what a monorepo build needs anyway: the workspace package.json,
+// the lockfile, pnpm-workspace.yaml, turbo.json and friends all live there.
+func sparseCheckoutDirectories(deployment *models.Deployment) []string {
+	if deployment.RootDirectory == "" || deployment.RootDirectory == "." {
+		return nil
+	}
+	directories := []string{deployment.RootDirectory}
+	if deployment.SharedDirectories != nil && *deployment.SharedDirectories != "" {
+		directories = append(directories, strings.Split(*deployment.SharedDirectories, ",")...)
+	}
+	return directories
+}
+
+// applySparseCheckout restricts the working tree of a partial clone to directories.
+// the blobs of those directories are fetched lazily from the promisor remote ("origin")
+// at this point, so it runs with the same credential environment as the clone.
+func applySparseCheckout(repositoryDir string, directories []string, logWriter io.Writer, credentialEnvironment *gitCredentialEnvironment) error {
+	arguments := append([]string{"-C", repositoryDir, "sparse-checkout", "set", "--cone", "--"}, directories...)
+	if err := runGitCommand(logWriter, credentialEnvironment, arguments...); err != nil {
+		return fmt.Errorf("git sparse-checkout failed: %w", err)
+	}
+	return nil
+}
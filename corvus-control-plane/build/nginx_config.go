@@ -0,0 +1,756 @@
+package build
+
+// nginx_config.go renders the per-deployment nginx server config that replaces the stock
+// nginx:alpine default.conf in the serving container. it holds:
+//   - the SPA fallback (deployment.SPAFallback), try_files ... /index.html for client-side routed apps
+//   - the served-site options: 404.html as the error page, clean URLs (/about from about.html)
+//     and the trailing slash policy
+//   - serving the precompressed .gz / .br siblings written by precompress.go
+//   - the rules of a Netlify-style _redirects file at the root of the output directory
+//   - the rules of a Netlify-style _headers file at the root of the output directory
+//
+// the config is written to <assetStorageRoot>/.nginx/<slug>.conf, next to (never inside) the served
+// directory, so it cannot be downloaded. it is bind-mounted read-only over /etc/nginx/conf.d/default.conf.
+//
+// every value that reaches the config comes from the user's files, so nothing is pasted in as-is:
+// paths are limited to a small character set and turned into regexes here, targets and header values
+// are checked for the characters that would break out of a quoted nginx string or read an nginx variable ($).
+// the rendered config is then tested with `nginx -t` before it goes live (docker.ValidateNginxConfig).
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+)
+
+const (
+	// redirectsFileName and headersFileName are read from the root of the output directory, same names as Netlify
+	redirectsFileName = "_redirects"
+	headersFileName   = "_headers"
+
+	// notFoundPageFileName is the error page served with deployment.Custom404, the name every static site generator uses
+	notFoundPageFileName = "404.html"
+
+	// nginxConfigDirectoryName is the directory under the asset storage root holding the rendered configs.
+	// slugs never start with a dot, so it cannot collide with a deployment directory.
+	nginxConfigDirectoryName = ".nginx"
+
+	// maxNginxRulesFileBytes caps the size of _redirects and _headers
+	maxNginxRulesFileBytes = 128 << 10 // 128KB
+
+	// maxRedirectRules and maxHeaderRules cap the rule count. every rule is a regex nginx checks
+	// in order, a few thousand of them would slow down every request to the site.
+	maxRedirectRules = 1000
+	maxHeaderRules   = 200
+
+	// maxHeaderValueLength caps one header value, a long Content-Security-Policy fits comfortably
+	maxHeaderValueLength = 4096
+)
+
+// redirectStatusCodes are the statuses a _redirects rule may use.
+// 200 is a rewrite (serve the target with the original URL), 404 serves the target as a not found page.
+var redirectStatusCodes = map[int]bool{200: true, 301: true, 302: true, 303: true, 307: true, 308: true, 404: true}
+
+// rulePathCharactersPattern is what the literal parts of a rule path may contain.
+// no regex metacharacters other than "." and "+" (escaped when rendered), no quotes, no "$", no "%"
+// (nginx matches the decoded URI, a percent-encoded rule would never match).
+var rulePathCharactersPattern = regexp.MustCompile(`^[A-Za-z0-9/._~@!+,=-]*$`)
+
+// rulePlaceholderPattern is the shape of a :placeholder name in a rule path
+var rulePlaceholderPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// targetPlaceholderPattern finds :splat and :placeholder references in a redirect target
+var targetPlaceholderPattern = regexp.MustCompile(`:([A-Za-z][A-Za-z0-9_]*)`)
+
+// headerNamePattern is an HTTP header name (a subset of the RFC 9110 token, which is all real header names use)
+var headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// forbiddenHeaderNames are the headers a _headers file cannot set, nginx owns the framing
+// and connection handling of the response and Traefik sits in front of it.
+var forbiddenHeaderNames = map[string]bool{
+	"content-length":    true,
+	"content-encoding":  true,
+	"transfer-encoding": true,
+	"connection":        true,
+	"keep-alive":        true,
+	"upgrade":           true,
+	"date":              true,
+	"server":            true,
+}
+
+// nginxRedirectRule is one parsed line of _redirects.
+type nginxRedirectRule struct {
+	lineNumber int
+
+	// locationPattern is the nginx regex of the rule's path, with named captures for :splat and placeholders
+	locationPattern string
+
+	// target is the destination with the captures substituted as nginx variables (eg, "/blog/${corvus_splat}")
+	target string
+
+	status int
+
+	// force ("301!") applies the rule even when a file exists at the path.
+	// without it the rule only applies when no file matches, same "shadowing" as Netlify.
+	force bool
+}
+
+// nginxHeaderRule is one path block of _headers.
+type nginxHeaderRule struct {
+	// requestPattern is the nginx regex of the block's path, matched against $request_uri
+	requestPattern string
+
+	headers []nginxHeader
+}
+
+type nginxHeader struct {
+	name  string
+	value string
+}
+
+// nginxSiteConfig is everything that goes into a deployment's rendered server config.
+type nginxSiteConfig struct {
+	spaFallback   bool
+	custom404     bool
+	cleanURLs     bool
+	trailingSlash models.TrailingSlashPolicy
+	redirectRules []nginxRedirectRule
+	headerRules   []nginxHeaderRule
+}
+
+// hasUserRules reports whether the config contains rules from the user's files.
+// the config without them is a fixed template that is known to be valid.
+func (siteConfig *nginxSiteConfig) hasUserRules() bool {
+	return len(siteConfig.redirectRules) > 0 || len(siteConfig.headerRules) > 0
+}
+
+// loadNginxSiteConfig takes the deployment's served-site options and reads _redirects and _headers
+// (both optional) from the served directory.
+// a rule that cannot be applied fails the deploy with its line number, silently dropping a redirect
+// the user wrote is worse than a failed deploy. so does custom_404 without a 404.html.
+func loadNginxSiteConfig(servedDirectory string, deployment *models.Deployment) (*nginxSiteConfig, error) {
+	siteConfig := &nginxSiteConfig{
+		spaFallback:   deployment.SPAFallback,
+		custom404:     deployment.Custom404,
+		cleanURLs:     deployment.CleanURLs,
+		trailingSlash: deployment.TrailingSlash,
+	}
+
+	if siteConfig.custom404 {
+		notFoundPageInfo, err := os.Stat(filepath.Join(servedDirectory, notFoundPageFileName))
+		if err != nil || !notFoundPageInfo.Mode().IsRegular() {
+			return nil, fmt.Errorf("custom_404 is set but the output directory has no %s", notFoundPageFileName)
+		}
+	}
+
+	redirectsLines, err := readNginxRulesFile(filepath.Join(servedDirectory, redirectsFileName))
+	if err != nil {
+		return nil, err
+	}
+	siteConfig.redirectRules, err = parseRedirectRules(redirectsLines)
+	if err != nil {
+		return nil, err
+	}
+
+	headersLines, err := readNginxRulesFile(filepath.Join(servedDirectory, headersFileName))
+	if err != nil {
+		return nil, err
+	}
+	siteConfig.headerRules, err = parseHeaderRules(headersLines)
+	if err != nil {
+		return nil, err
+	}
+
+	return siteConfig, nil
+}
+
+// readNginxRulesFile returns the lines of a rules file, nil when the file does not exist.
+// Lstat, a symlink in the user's output could point anywhere on the host.
+func readNginxRulesFile(rulesFilePath string) ([]string, error) {
+	fileInfo, err := os.Lstat(rulesFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", filepath.Base(rulesFilePath), err)
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", filepath.Base(rulesFilePath))
+	}
+	if fileInfo.Size() > maxNginxRulesFileBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", filepath.Base(rulesFilePath), maxNginxRulesFileBytes)
+	}
+
+	rulesFile, err := os.Open(rulesFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filepath.Base(rulesFilePath), err)
+	}
+	defer rulesFile.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(io.LimitReader(rulesFile, maxNginxRulesFileBytes))
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSuffix(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(rulesFilePath), err)
+	}
+	return lines, nil
+}
+
+// parseRedirectRules parses the lines of a _redirects file. each rule is
+//
+//	<from path> <to path or URL> [status][!]
+//
+// the status defaults to 301. blank lines and lines starting with # are skipped.
+// the from path may use :placeholder segments and a trailing /* (referenced as :splat in the target).
+// Netlify's conditions (Country=, Role=, query parameter matching) and proxying to another
+// domain are not supported and fail with a clear error.
+func parseRedirectRules(lines []string) ([]nginxRedirectRule, error) {
+	var rules []nginxRedirectRule
+	for index, line := range lines {
+		lineNumber := index + 1
+		trimmedLine := strings.TrimSpace(line)
+		if trimmedLine == "" || strings.HasPrefix(trimmedLine, "#") {
+			continue
+		}
+		if len(rules) == maxRedirectRules {
+			return nil, fmt.Errorf("%s has more than %d rules", redirectsFileName, maxRedirectRules)
+		}
+
+		rule, err := parseRedirectRule(strings.Fields(trimmedLine))
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", redirectsFileName, lineNumber, err)
+		}
+		rule.lineNumber = lineNumber
+		rules = append(rules, *rule)
+	}
+	return rules, nil
+}
+
+// parseRedirectRule parses the fields of one _redirects line.
+func parseRedirectRule(fields []string) (*nginxRedirectRule, error) {
+	if len(fields) < 2 {
+		return nil, errors.New("expected '<from> <to> [status]'")
+	}
+	if len(fields) > 3 || (strings.Contains(fields[1], "=") && !strings.HasPrefix(fields[1], "/") && !isAbsoluteHTTPURL(fields[1])) {
+		return nil, errors.New("conditions and query parameter matching are not supported, use '<from> <to> [status]'")
+	}
+
+	rule := &nginxRedirectRule{status: 301}
+	if len(fields) == 3 {
+		statusField := fields[2]
+		if strings.HasSuffix(statusField, "!") {
+			rule.force = true
+			statusField = strings.TrimSuffix(statusField, "!")
+		}
+		status, err := strconv.Atoi(statusField)
+		if err != nil || !redirectStatusCodes[status] {
+			return nil, fmt.Errorf("unsupported status %q, use 200, 301, 302, 303, 307, 308 or 404", fields[2])
+		}
+		rule.status = status
+	}
+
+	locationPattern, placeholders, err := compileRulePath(fields[0], true)
+	if err != nil {
+		return nil, err
+	}
+	rule.locationPattern = locationPattern
+
+	target := fields[1]
+	if !strings.HasPrefix(target, "/") && !isAbsoluteHTTPURL(target) {
+		return nil, fmt.Errorf("target %q must be a path starting with / or an http(s) URL", target)
+	}
+	if strings.HasPrefix(target, "//") {
+		return nil, fmt.Errorf("target %q must be a path starting with a single / or an http(s) URL", target)
+	}
+	if isAbsoluteHTTPURL(target) && (rule.status == 200 || rule.status == 404) {
+		return nil, fmt.Errorf("status %d needs a path on this site as the target, proxying to another URL is not supported", rule.status)
+	}
+	if err := validateNginxStringValue("target", target); err != nil {
+		return nil, err
+	}
+
+	// :splat and :placeholders of the from path become the nginx variables of its named captures.
+	// ${name} rather than $name so a following letter does not extend the variable name.
+	// a ":word" that is not a placeholder (eg, the "https:" of a URL never matches, it has no letter after it) is kept as-is.
+	var errTarget error
+	rule.target = targetPlaceholderPattern.ReplaceAllStringFunc(target, func(reference string) string {
+		name := reference[1:]
+		if !placeholders[name] {
+			if name == "splat" {
+				errTarget = errors.New("target uses :splat but the from path does not end with /*")
+			}
+			return reference
+		}
+		return "${" + rulePlaceholderVariable(name) + "}"
+	})
+	if errTarget != nil {
+		return nil, errTarget
+	}
+
+	return rule, nil
+}
+
+// parseHeaderRules parses the lines of a _headers file. a path line starts at the beginning of
+// the line, the headers for it follow on indented "Name: value" lines:
+//
+//	/assets/*
+//	  Cache-Control: public, max-age=31536000, immutable
+//
+// blank lines and lines starting with # are skipped.
+func parseHeaderRules(lines []string) ([]nginxHeaderRule, error) {
+	var rules []nginxHeaderRule
+	for index, line := range lines {
+		lineNumber := index + 1
+		trimmedLine := strings.TrimSpace(line)
+		if trimmedLine == "" || strings.HasPrefix(trimmedLine, "#") {
+			continue
+		}
+
+		// path line
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			if len(rules) == maxHeaderRules {
+				return nil, fmt.Errorf("%s has more than %d path blocks", headersFileName, maxHeaderRules)
+			}
+			requestPattern, _, err := compileRulePath(trimmedLine, false)
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: %w", headersFileName, lineNumber, err)
+			}
+			rules = append(rules, nginxHeaderRule{requestPattern: requestPattern})
+			continue
+		}
+
+		// header line
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("%s line %d: header before the first path", headersFileName, lineNumber)
+		}
+		name, value, found := strings.Cut(trimmedLine, ":")
+		if !found {
+			return nil, fmt.Errorf("%s line %d: expected 'Name: value'", headersFileName, lineNumber)
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if !headerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%s line %d: invalid header name %q", headersFileName, lineNumber, name)
+		}
+		if forbiddenHeaderNames[strings.ToLower(name)] {
+			return nil, fmt.Errorf("%s line %d: header %q cannot be set", headersFileName, lineNumber, name)
+		}
+		if len(value) > maxHeaderValueLength {
+			return nil, fmt.Errorf("%s line %d: value of %q is longer than %d characters", headersFileName, lineNumber, name, maxHeaderValueLength)
+		}
+		if err := validateNginxStringValue("value of "+name, value); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", headersFileName, lineNumber, err)
+		}
+		currentRule := &rules[len(rules)-1]
+		currentRule.headers = append(currentRule.headers, nginxHeader{name: name, value: value})
+	}
+	return rules, nil
+}
+
+// compileRulePath turns a Netlify rule path into an nginx regex.
+//
+//	/about          -> ^/about/?$                (the trailing slash is optional, like Netlify)
+//	/blog/:year/:id -> ^/blog/(?<corvus_year>[^/]+)/(?<corvus_id>[^/]+)/?$
+//	/app/*          -> ^/app(?:/(?<corvus_splat>.*))?$
+//
+// literal "." and "+" become [.] and [+] rather than backslash escapes, so the regex never
+// contains a backslash or quote and can be written into a quoted nginx string as-is.
+// withCaptures false (for _headers) uses non-capturing groups, the map regexes there must not set
+// variables, and matches against $request_uri, so the query string is allowed after the path.
+// returns the placeholder names that are available to a redirect target ("splat" for a trailing /*).
+func compileRulePath(rulePath string, withCaptures bool) (string, map[string]bool, error) {
+	if isAbsoluteHTTPURL(rulePath) {
+		return "", nil, fmt.Errorf("path %q: rules for a full URL (domain-level rules) are not supported, use a path", rulePath)
+	}
+	if !strings.HasPrefix(rulePath, "/") {
+		return "", nil, fmt.Errorf("path %q must start with /", rulePath)
+	}
+
+	placeholders := make(map[string]bool)
+	segments := strings.Split(strings.TrimPrefix(rulePath, "/"), "/")
+	hasSplat := segments[len(segments)-1] == "*"
+	if hasSplat {
+		segments = segments[:len(segments)-1]
+	}
+	// "/about/" and "/about" are the same rule, the slash is optional in the regex
+	if !hasSplat && len(segments) > 1 && segments[len(segments)-1] == "" {
+		segments = segments[:len(segments)-1]
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for _, segment := range segments {
+		if segment == "" {
+			// only the root path "/" has an empty segment here
+			if len(segments) > 1 {
+				return "", nil, fmt.Errorf("path %q has an empty segment", rulePath)
+			}
+			continue
+		}
+		pattern.WriteString("/")
+		if strings.HasPrefix(segment, ":") {
+			name := segment[1:]
+			if !rulePlaceholderPattern.MatchString(name) || name == "splat" {
+				return "", nil, fmt.Errorf("path %q: invalid placeholder %q", rulePath, segment)
+			}
+			if placeholders[name] {
+				return "", nil, fmt.Errorf("path %q: placeholder %q is used twice", rulePath, segment)
+			}
+			placeholders[name] = true
+			if withCaptures {
+				pattern.WriteString("(?<" + rulePlaceholderVariable(name) + ">[^/]+)")
+			} else {
+				pattern.WriteString("[^/?]+")
+			}
+			continue
+		}
+		if strings.Contains(segment, "*") {
+			return "", nil, fmt.Errorf("path %q: * is only supported as the last segment (/*)", rulePath)
+		}
+		if !rulePathCharactersPattern.MatchString(segment) {
+			return "", nil, fmt.Errorf("path %q contains unsupported characters", rulePath)
+		}
+		pattern.WriteString(strings.NewReplacer(".", "[.]", "+", "[+]").Replace(segment))
+	}
+
+	switch {
+	case hasSplat && withCaptures:
+		placeholders["splat"] = true
+		pattern.WriteString("(?:/(?<" + rulePlaceholderVariable("splat") + ">.*))?$")
+	case hasSplat:
+		pattern.WriteString("(?:[/?].*)?$")
+	case pattern.Len() == 1:
+		// the root path
+		pattern.WriteString("/")
+		if !withCaptures {
+			pattern.WriteString("(?:[?].*)?")
+		}
+		pattern.WriteString("$")
+	case withCaptures:
+		pattern.WriteString("/?$")
+	default:
+		pattern.WriteString("/?(?:[?].*)?$")
+	}
+	return pattern.String(), placeholders, nil
+}
+
+// rulePlaceholderVariable is the nginx variable name of a placeholder capture.
+// prefixed so a placeholder named like a built-in variable (:host, :uri) cannot shadow it.
+func rulePlaceholderVariable(name string) string {
+	return "corvus_" + name
+}
+
+// validateNginxStringValue checks a user value that is written into a double quoted nginx string.
+// a quote or backslash would end or escape the string, a $ would read an nginx variable
+// (there is no way to escape it in nginx), control characters have no place in a URL or header.
+func validateNginxStringValue(fieldName string, value string) error {
+	for _, character := range value {
+		if character < 0x20 || character == 0x7f {
+			return fmt.Errorf("%s contains a control character", fieldName)
+		}
+		switch character {
+		case '"', '\\', '$':
+			return fmt.Errorf("%s cannot contain %q", fieldName, character)
+		}
+	}
+	return nil
+}
+
+// isAbsoluteHTTPURL reports whether value starts with http:// or https://
+func isAbsoluteHTTPURL(value string) bool {
+	return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+}
+
+// render returns the server config text.
+//
+// nginx picks the first regex location that matches, in order of appearance, which is the same
+// "first rule wins" order as Netlify. exact locations (=) are checked before regexes, which keeps the
+// rules files themselves unreachable whatever the rules say. the catch-all "location /" comes last.
+func (siteConfig *nginxSiteConfig) render(slug string) string {
+	var config strings.Builder
+
+	fmt.Fprintf(&config, "# generated by corvus for deployment %s, rewritten on every deploy.\n", slug)
+	config.WriteString("# included in the http block of /etc/nginx/nginx.conf, so the maps below are allowed here.\n\n")
+
+	// headers: one map per header block and name, add_header with an empty value sends nothing,
+	// so a path outside the block gets no header. keyed on $request_uri (the path the client asked for)
+	// rather than $uri, so a path served through the SPA fallback still gets its block's headers.
+	var addHeaderLines []string
+	for ruleIndex, rule := range siteConfig.headerRules {
+		for headerIndex, header := range rule.headers {
+			variableName := fmt.Sprintf("corvus_header_%d_%d", ruleIndex, headerIndex)
+			fmt.Fprintf(&config, "map $request_uri $%s {\n", variableName)
+			config.WriteString("    default \"\";\n")
+			fmt.Fprintf(&config, "    \"~*%s\" \"%s\";\n", rule.requestPattern, header.value)
+			config.WriteString("}\n")
+			addHeaderLines = append(addHeaderLines, fmt.Sprintf("    add_header %s $%s always;\n", header.name, variableName))
+		}
+	}
+	if len(addHeaderLines) > 0 {
+		config.WriteString("\n")
+	}
+
+	// clients that accept brotli, for the precompressed .br siblings
+	config.WriteString("map $http_accept_encoding $corvus_accepts_brotli {\n")
+	config.WriteString("    \"~*(^|[ ,])br([ ,;]|$)\" 1;\n")
+	config.WriteString("    default \"\";\n")
+	config.WriteString("}\n\n")
+
+	// clean URLs: $corvus_clean_uri is $uri without a trailing slash, so /about and /about/ both find about.html
+	if siteConfig.cleanURLs {
+		config.WriteString("map $uri $corvus_clean_uri {\n")
+		config.WriteString("    \"~^(?<corvus_clean_uri_match>.+)/$\" $corvus_clean_uri_match;\n")
+		config.WriteString("    default $uri;\n")
+		config.WriteString("}\n\n")
+	}
+
+	config.WriteString("server {\n")
+	config.WriteString("    listen 80;\n")
+	config.WriteString("    server_name _;\n\n")
+	config.WriteString("    root /usr/share/nginx/html;\n")
+	config.WriteString("    index index.html;\n\n")
+	config.WriteString("    # redirects are sent as relative Location headers, behind Traefik nginx does not know\n")
+	config.WriteString("    # the public https URL and would otherwise redirect to http://<slug>:80/...\n")
+	config.WriteString("    absolute_redirect off;\n\n")
+	config.WriteString("    # precompressed .gz siblings, written at deploy time\n")
+	config.WriteString("    gzip_static on;\n")
+	config.WriteString("    gzip_vary on;\n\n")
+
+	if siteConfig.custom404 {
+		fmt.Fprintf(&config, "    error_page 404 /%s;\n\n", notFoundPageFileName)
+	}
+
+	// the trailing slash redirects run in the server rewrite phase, before any location (and redirect rule) is picked
+	switch siteConfig.trailingSlash {
+	case models.TrailingSlashAlways:
+		config.WriteString("    # trailing_slash always: a page path (no file extension in the last segment) that is not a file gets a slash\n")
+		config.WriteString("    if (!-f $request_filename) {\n")
+		config.WriteString("        rewrite \"^(.*/[^./]+)$\" $1/ permanent;\n")
+		config.WriteString("    }\n\n")
+	case models.TrailingSlashNever:
+		config.WriteString("    # trailing_slash never: the slash is dropped, directories are still served from their index.html\n")
+		config.WriteString("    rewrite \"^(.+)/$\" $1 permanent;\n\n")
+	}
+
+	for _, addHeaderLine := range addHeaderLines {
+		config.WriteString(addHeaderLine)
+	}
+	if len(addHeaderLines) > 0 {
+		config.WriteString("\n")
+	}
+
+	fmt.Fprintf(&config, "    location = /%s { return 404; }\n", redirectsFileName)
+	fmt.Fprintf(&config, "    location = /%s { return 404; }\n\n", headersFileName)
+
+	renderBrotliLocations(&config, addHeaderLines)
+
+	for _, rule := range siteConfig.redirectRules {
+		rule.render(&config)
+	}
+
+	// the file, then about.html (clean URLs), then the directory's index.html, then the fallback
+	tryFiles := "$uri"
+	if siteConfig.cleanURLs {
+		tryFiles += " $corvus_clean_uri.html"
+	}
+	tryFiles += " $uri/"
+
+	config.WriteString("    location / {\n")
+	config.WriteString("        # a client that accepts brotli gets the .br sibling when the file has one\n")
+	config.WriteString("        set $corvus_brotli_file \"\";\n")
+	config.WriteString("        if ($corvus_accepts_brotli) {\n")
+	config.WriteString("            set $corvus_brotli_file $request_filename.br;\n")
+	config.WriteString("        }\n")
+	config.WriteString("        if (-f $corvus_brotli_file) {\n")
+	config.WriteString("            rewrite ^ $uri.br last;\n")
+	config.WriteString("        }\n")
+	if siteConfig.spaFallback {
+		config.WriteString("        # SPA fallback, paths that match no file are served by the client-side router\n")
+		fmt.Fprintf(&config, "        try_files %s /index.html;\n", tryFiles)
+	} else {
+		fmt.Fprintf(&config, "        try_files %s =404;\n", tryFiles)
+	}
+	config.WriteString("    }\n")
+	config.WriteString("}\n")
+
+	return config.String()
+}
+
+// renderBrotliLocations writes the locations serving the .br siblings that "location /" rewrites to.
+// nginx:alpine has no brotli module, so the encoding header is added here and each sibling gets the
+// content type of its original file (one location per type, from precompressedContentTypes),
+// without it a .br file would go out as application/octet-stream.
+// internal: only reachable through the rewrite, a client asking for app.js.br directly gets a 404.
+// add_header in a location drops the server-level ones, so the _headers lines are repeated.
+// rendered before the _redirects locations, so a catch-all rule cannot take over the rewritten URI.
+func renderBrotliLocations(config *strings.Builder, addHeaderLines []string) {
+	extensionsByContentType := make(map[string][]string)
+	for extension, contentType := range precompressedContentTypes {
+		extensionsByContentType[contentType] = append(extensionsByContentType[contentType], strings.TrimPrefix(extension, "."))
+	}
+	contentTypes := make([]string, 0, len(extensionsByContentType))
+	for contentType := range extensionsByContentType {
+		contentTypes = append(contentTypes, contentType)
+	}
+	// sorted, so the same deployment always renders the same config
+	sort.Strings(contentTypes)
+
+	config.WriteString("    # precompressed .br siblings (see build/precompress.go), reached through the rewrite in location /\n")
+	for _, contentType := range contentTypes {
+		extensions := extensionsByContentType[contentType]
+		sort.Strings(extensions)
+		fmt.Fprintf(config, "    location ~* \"[.](%s)[.]br$\" {\n", strings.Join(extensions, "|"))
+		config.WriteString("        internal;\n")
+		config.WriteString("        types { }\n")
+		fmt.Fprintf(config, "        default_type %s;\n", contentType)
+		config.WriteString("        add_header Content-Encoding br;\n")
+		config.WriteString("        add_header Vary Accept-Encoding;\n")
+		for _, addHeaderLine := range addHeaderLines {
+			config.WriteString("    " + addHeaderLine)
+		}
+		config.WriteString("    }\n")
+	}
+	config.WriteString("\n")
+}
+
+// render writes the location block of one redirect rule.
+// a rule without force only applies when no file matches the path, so a location without
+// a return falls through to serving the file (a location without a content handler serves static files).
+func (rule *nginxRedirectRule) render(config *strings.Builder) {
+	fmt.Fprintf(config, "    # %s line %d\n", redirectsFileName, rule.lineNumber)
+	fmt.Fprintf(config, "    location ~* \"%s\" {\n", rule.locationPattern)
+
+	switch rule.status {
+	case 200:
+		// rewrite: serve the target's content under the original URL
+		if rule.force {
+			// break serves the new URI in this location instead of matching the locations again,
+			// so a forced "/* /index.html 200!" does not loop back into itself
+			fmt.Fprintf(config, "        rewrite ^ \"%s\" break;\n", rule.target)
+		} else {
+			fmt.Fprintf(config, "        try_files $uri $uri/ \"%s\";\n", rule.target)
+		}
+	case 404:
+		fmt.Fprintf(config, "        error_page 404 \"%s\";\n", rule.target)
+		if rule.force {
+			config.WriteString("        return 404;\n")
+		} else {
+			config.WriteString("        try_files $uri $uri/ =404;\n")
+		}
+	default:
+		// the query string is passed on like Netlify does, unless the target sets its own
+		target := rule.target
+		if !strings.Contains(target, "?") {
+			target += "$is_args$args"
+		}
+		if rule.force {
+			fmt.Fprintf(config, "        return %d \"%s\";\n", rule.status, target)
+		} else {
+			config.WriteString("        if (!-e $request_filename) {\n")
+			fmt.Fprintf(config, "            return %d \"%s\";\n", rule.status, target)
+			config.WriteString("        }\n")
+		}
+	}
+
+	config.WriteString("    }\n\n")
+}
+
+// nginxConfigPath is where the rendered config of a deployment is written:
+// <assetStorageRoot>/.nginx/<slug>.conf
+func (deployerPipeline *DeployerPipeline) nginxConfigPath(slug string) string {
+	return filepath.Join(deployerPipeline.assetStorageRoot, nginxConfigDirectoryName, slug+".conf")
+}
+
+// prepareNginxConfig renders the deployment's server config from its settings and the rules
+// files in the served directory, tests it with `nginx -t` when it contains user rules, and puts it
+// in place. returns the path of the config, to be bind-mounted into the serving container.
+// the config is rendered to a temporary file next to <slug>.conf and only renamed over it once nginx
+// accepted it. <slug>.conf is what the running container has mounted and what ApplyAccessControl
+// reuses, so a config nginx rejects fails the deploy without ever replacing the last good one.
+// called before the old container is stopped, a rejected config leaves that container serving the
+// previous version (its files are in their own run directory, see served_directory.go).
+func (deployerPipeline *DeployerPipeline) prepareNginxConfig(
+	deployment *models.Deployment,
+	servedDirectory string,
+	pipelineLogger *deployerPipelineLogger,
+) (string, error) {
+	deploymentSlug := deployment.Slug
+	nginxConfigStep := pipelineLogger.startStep(stepNginxConfig, map[string]any{
+		"spa_fallback":   deployment.SPAFallback,
+		"custom_404":     deployment.Custom404,
+		"clean_urls":     deployment.CleanURLs,
+		"trailing_slash": deployment.TrailingSlash,
+	})
+
+	siteConfig, err := loadNginxSiteConfig(servedDirectory, deployment)
+	if err != nil {
+		return "", err
+	}
+	if len(siteConfig.redirectRules) > 0 {
+		pipelineLogger.logInfo("%s: %d rules", redirectsFileName, len(siteConfig.redirectRules))
+	}
+	if len(siteConfig.headerRules) > 0 {
+		pipelineLogger.logInfo("%s: %d path blocks", headersFileName, len(siteConfig.headerRules))
+	}
+
+	configPath := deployerPipeline.nginxConfigPath(deploymentSlug)
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create nginx config directory: %w", err)
+	}
+	// in the same directory, so the rename below is atomic. the random part keeps two runs of one slug apart
+	pendingConfigFile, err := os.CreateTemp(filepath.Dir(configPath), deploymentSlug+".conf.*.pending")
+	if err != nil {
+		return "", fmt.Errorf("failed to create nginx config: %w", err)
+	}
+	pendingConfigPath := pendingConfigFile.Name()
+	pendingConfigFile.Close()
+	// removes the pending file when the config is rejected (a no-op after the rename)
+	defer os.Remove(pendingConfigPath)
+
+	// 0644, nginx in the container reads it as a different user than the control plane
+	if err := os.WriteFile(pendingConfigPath, []byte(siteConfig.render(deploymentSlug)), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write nginx config: %w", err)
+	}
+	if err := os.Chmod(pendingConfigPath, 0o644); err != nil {
+		return "", fmt.Errorf("failed to set nginx config permissions: %w", err)
+	}
+
+	// without user rules the config is one of a few fixed templates, testing it would only cost a container start
+	if siteConfig.hasUserRules() {
+		pipelineLogger.logInfo("testing nginx config (nginx -t)")
+		nginxOutput, errValidate := deployerPipeline.dockerClient.ValidateNginxConfig(
+			nginxConfigStep.context,
+			"nginx-test-"+deploymentSlug,
+			servedDirectory,
+			pendingConfigPath,
+		)
+		if nginxOutput != "" {
+			pipelineLogger.logInfo("%s", nginxOutput)
+		}
+		if errValidate != nil {
+			return "", fmt.Errorf("nginx rejected the config generated from %s/%s: %w", redirectsFileName, headersFileName, errValidate)
+		}
+	}
+
+	// a running container keeps the old file (bind mounts follow the inode), the next one gets this one
+	if err := os.Rename(pendingConfigPath, configPath); err != nil {
+		return "", fmt.Errorf("failed to put nginx config in place: %w", err)
+	}
+
+	nginxConfigStep.finish(map[string]any{
+		"redirect_rules": len(siteConfig.redirectRules),
+		"header_rules":   len(siteConfig.headerRules),
+	})
+	return configPath, nil
+}
@@ -5,18 +5,26 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/sasta-kro/corvus-paas/corvus-control-plane/db"
 	"github.com/sasta-kro/corvus-paas/corvus-control-plane/docker"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/metrics"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/tracing"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/util"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // DeployerPipeline holds the dependencies needed to run a deployment.
 // constructed once in main.go and passed to the handler via handlers.RouterDependencies.
-// Each Deploy() call runs independently, the DeployerPipeline itself holds no per-deployment state.
+// Each Deploy() call runs independently, the only per-deployment state is the lock of LockDeployment.
 type DeployerPipeline struct {
 	database     *db.Database
 	dockerClient *docker.DockerClient
 	logger       *slog.Logger
+	metrics      *metrics.ControlPlaneMetrics // nil-safe, fed by the pipeline events and the expiration loop
+	tracer       trace.Tracer                 // never nil (no-op when tracing is off), one trace per pipeline run
 
 	// assetStorageRoot is the base directory on the host where static files are stored.
 	// each deployment gets its own subdirectory `<assetStorageRoot>/<slug>/`
@@ -28,9 +36,9 @@ type DeployerPipeline struct {
 	// TODO logs are written even in v1 so v2 log streaming does not require changing the build system, only adding a read endpoint.
 	logRoot string
 
-	// presetStorageRoot is the base directory containing pre-built static files
-	// for quick-deploy presets. Each preset has its own subdirectory here.
-	presetStorageRoot string
+	// presetRegistry holds the quick-deploy presets found under the preset storage root.
+	// prebuilt deployments resolve their preset_id through it, never through a path join.
+	presetRegistry *PresetRegistry
 
 	// for build output from ephemeral build containers
 	tempBuildStorageRoot string
@@ -38,6 +46,25 @@ type DeployerPipeline struct {
 	// traefikNetwork is the Docker network name (corvus-paas-network) passed to the Nginx container
 	// so Traefik can route traffic to it.
 	traefikNetwork string
+
+	// archiveLimits caps what extracting one uploaded archive may write to disk (zip bomb protection)
+	archiveLimits ArchiveLimits
+
+	// credentialCipher decrypts private repository credentials right before a clone.
+	// nil when CREDENTIALS_ENCRYPTION_KEY is not set (only public repositories can be deployed)
+	credentialCipher *util.CredentialCipher
+
+	// sharedStaticServer serves every deployment in the shared serving mode (SERVING_MODE=shared).
+	// nil in the default container mode, where each deployment gets its own nginx container.
+	sharedStaticServer *SharedStaticServer
+
+	// ipAccessPolicy holds the platform default IP access lists, combined with each deployment's own lists
+	ipAccessPolicy *util.IPAccessPolicy
+
+	// deploymentLocks holds one lock per deployment ID, see LockDeployment.
+	// entries are never removed, one small mutex per deployment ever seen by this process.
+	deploymentLocks      map[string]*sync.Mutex
+	deploymentLocksMutex sync.Mutex
 }
 
 // DeployerPipelineConfig groups the configuration values DeployerPipeline needs.
@@ -46,9 +73,13 @@ type DeployerPipeline struct {
 type DeployerPipelineConfig struct {
 	AssetStorageRoot     string
 	LogRoot              string
-	PresetStorageRoot    string
+	PresetRegistry       *PresetRegistry
 	TempBuildStorageRoot string
 	TraefikNetwork       string
+	ArchiveLimits        ArchiveLimits
+	CredentialCipher     *util.CredentialCipher
+	SharedStaticServer   *SharedStaticServer
+	IPAccessPolicy       *util.IPAccessPolicy
 }
 
 // NewDeployerPipeline constructs a DeployerPipeline with its required dependencies.
@@ -56,20 +87,55 @@ func NewDeployerPipeline(
 	database *db.Database,
 	dockerClient *docker.DockerClient,
 	logger *slog.Logger,
+	controlPlaneMetrics *metrics.ControlPlaneMetrics,
+	tracer trace.Tracer,
 	config DeployerPipelineConfig,
 ) *DeployerPipeline {
 	return &DeployerPipeline{
 		database:             database,
 		dockerClient:         dockerClient,
 		logger:               logger,
+		metrics:              controlPlaneMetrics,
+		tracer:               tracing.TracerOrNoop(tracer),
 		assetStorageRoot:     config.AssetStorageRoot,
 		logRoot:              config.LogRoot,
-		presetStorageRoot:    config.PresetStorageRoot,
+		presetRegistry:       config.PresetRegistry,
 		tempBuildStorageRoot: config.TempBuildStorageRoot,
 		traefikNetwork:       config.TraefikNetwork,
+		archiveLimits:        config.ArchiveLimits,
+		credentialCipher:     config.CredentialCipher,
+		sharedStaticServer:   config.SharedStaticServer,
+		ipAccessPolicy:       config.IPAccessPolicy,
+		deploymentLocks:      make(map[string]*sync.Mutex),
 	}
 }
 
+// LockDeployment takes the deployment's lock and returns the function releasing it.
+// it serialises what changes the container (or shared site) a deployment is served by outside a run,
+// the access control endpoints, with the start of a pipeline run (setStatusDeploying):
+// the endpoints check the status and swap the container under the lock, and a run marks itself
+// deploying under the same lock, so a run can never start between that check and the swap.
+func (deployerPipeline *DeployerPipeline) LockDeployment(deploymentID string) func() {
+	deployerPipeline.deploymentLocksMutex.Lock()
+	deploymentLock, found := deployerPipeline.deploymentLocks[deploymentID]
+	if !found {
+		deploymentLock = &sync.Mutex{}
+		deployerPipeline.deploymentLocks[deploymentID] = deploymentLock
+	}
+	deployerPipeline.deploymentLocksMutex.Unlock()
+
+	deploymentLock.Lock()
+	return deploymentLock.Unlock
+}
+
+// setStatusDeploying sets a deployment's status to "deploying" at the start of a pipeline run,
+// under the deployment lock (see LockDeployment).
+func (deployerPipeline *DeployerPipeline) setStatusDeploying(deploymentID string) error {
+	unlockDeployment := deployerPipeline.LockDeployment(deploymentID)
+	defer unlockDeployment()
+	return deployerPipeline.database.UpdateStatus(deploymentID, models.StatusDeploying)
+}
+
 // openLogFileForCurrentDeployment creates or opens the log file for a deployment (each deployment has its own log file).
 // the log directory is created if it does not exist.
 // the file is opened in append mode so redeployments add to the existing log
@@ -15,7 +15,8 @@ import (
 )
 
 // TeardownDeployment runs the full teardown sequence for a deployment:
-// stop container, remove files, remove log, delete DB row.
+// stop container (or unpublish the site in the shared serving mode), remove files, remove log,
+// remove pipeline events, delete DB row.
 // Used by both the DELETE handler and the expiration cleanup loop.
 // Returns an error if any critical step fails (container or file removal).
 // Log file removal failure is non-fatal and only logged.
@@ -29,6 +30,11 @@ func (deployerPipeline *DeployerPipeline) TeardownDeployment(
 		return fmt.Errorf("failed to remove container: %w", err)
 	}
 
+	// ===== stop serving the site from the shared static server (shared serving mode), before its files go
+	if deployerPipeline.sharedStaticServer != nil {
+		deployerPipeline.sharedStaticServer.unpublish(deployment.Slug)
+	}
+
 	// ===== remove the static files from the asset storage root.
 	// os.RemoveAll is idempotent, returns nil if the path does not exist.
 	if err := deployerPipeline.CleanupFiles(deployment.Slug); err != nil {
@@ -45,6 +51,15 @@ func (deployerPipeline *DeployerPipeline) TeardownDeployment(
 		)
 	}
 
+	// ===== remove the structured pipeline events
+	// non-fatal for the same reason as the log file above.
+	if err := deployerPipeline.database.DeletePipelineEvents(deployment.ID); err != nil {
+		deployerPipeline.logger.Warn("failed to remove pipeline events (non-fatal)",
+			"slug", deployment.Slug,
+			"error", err,
+		)
+	}
+
 	// ===== delete the database record (last)
 	// if any previous step failed and returned early, the record still exists,
 	// allowing the user to retry the delete request.
@@ -65,8 +80,9 @@ func (deployerPipeline *DeployerPipeline) CleanupContainer(ctx context.Context,
 	return deployerPipeline.dockerClient.StopAndRemoveContainer(ctx, containerName)
 }
 
-// CleanupFiles removes the deployment's static files directory from the asset storage root.
-// the path is constructed from the slug: <assetStorageRoot>/<slug>/
+// CleanupFiles removes the deployment's static files directory from the asset storage root,
+// and its rendered nginx config. the paths are constructed from the slug: <assetStorageRoot>/<slug>/
+// and <assetStorageRoot>/.nginx/<slug>.conf
 // os.RemoveAll returns nil if the path does not exist, making this idempotent.
 func (deployerPipeline *DeployerPipeline) CleanupFiles(slug string) error {
 	deploymentDir := filepath.Join(deployerPipeline.assetStorageRoot, slug)
@@ -74,6 +90,12 @@ func (deployerPipeline *DeployerPipeline) CleanupFiles(slug string) error {
 		return fmt.Errorf("failed to remove deployment directory %q: %w", deploymentDir, err)
 	}
 	deployerPipeline.logger.Info("deployment files removed", "path", deploymentDir)
+
+	// the rendered nginx config lives next to the deployment directory, not inside it
+	nginxConfigPath := deployerPipeline.nginxConfigPath(slug)
+	if err := os.Remove(nginxConfigPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove nginx config %q: %w", nginxConfigPath, err)
+	}
 	return nil
 }
 
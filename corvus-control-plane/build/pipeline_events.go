@@ -0,0 +1,172 @@
+package build
+
+// pipeline_events.go records typed pipeline events (step started / finished / failed)
+// into the deployment_events table next to the free-form <slug>.log lines.
+// the text log stays the human-readable record, the events are the machine-readable one.
+// every step is also a span in the run trace, so the same boundaries show up in a trace viewer.
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// pipeline step names. these are part of the GET /api/deployments/{uuid}/events
+// response, so renaming one is an API change for the frontend progress view.
+const (
+	stepPipeline       = "pipeline" // covers the whole run, from start to live or failed
+	stepRepoCheck      = "repo_check"
+	stepResolveBranch  = "resolve_branch"
+	stepClone          = "clone"
+	stepSubmodules     = "submodules"
+	stepLFSPull        = "lfs_pull"
+	stepBuild          = "build"
+	stepReceiveUpload  = "receive_upload"
+	stepExtract        = "extract"
+	stepResolvePreset  = "resolve_preset"
+	stepCopy           = "copy"
+	stepPrecompress    = "precompress"
+	stepNginxConfig    = "nginx_config"
+	stepContainerStart = "container_start"
+	stepPublish        = "publish" // shared serving mode, replaces nginx_config and container_start
+)
+
+// pipelineStep is an in-progress step of a pipeline run.
+// created by deployerPipelineLogger.startStep() and closed exactly once with finish() or fail().
+type pipelineStep struct {
+	pipelineLogger *deployerPipelineLogger
+	name           string
+	startedAt      time.Time
+	closed         bool
+
+	// context carries the step span. Docker SDK calls made inside the step use it,
+	// so their own spans (eg, docker.image_pull) nest under the step.
+	context context.Context
+	span    trace.Span
+}
+
+// startStep records a step_started event and returns the step so the caller can close it.
+// the step becomes the logger's current step, so a later logFailureAndUpdateStatus()
+// marks it failed automatically without every failure branch having to do it.
+func (pipelineLogger *deployerPipelineLogger) startStep(name string, metadata map[string]any) *pipelineStep {
+	return pipelineLogger.startStepAt(name, time.Now(), metadata)
+}
+
+// startStepAt is startStep for work that began before the step could be recorded,
+// eg, the upload is received by the HTTP handler before the pipeline run exists.
+// the step duration and the span both start at startedAt.
+func (pipelineLogger *deployerPipelineLogger) startStepAt(name string, startedAt time.Time, metadata map[string]any) *pipelineStep {
+	stepContext, stepSpan := pipelineLogger.pipeline.tracer.Start(pipelineLogger.runContext, "pipeline."+name,
+		trace.WithAttributes(metadataAttributes(metadata)...),
+		trace.WithTimestamp(startedAt),
+	)
+	step := &pipelineStep{
+		pipelineLogger: pipelineLogger,
+		name:           name,
+		startedAt:      startedAt,
+		context:        stepContext,
+		span:           stepSpan,
+	}
+	pipelineLogger.recordEvent(name, models.EventStepStarted, nil, "", metadata)
+	pipelineLogger.currentStep = step
+	return step
+}
+
+// finish records a step_finished event with the step duration.
+// calling finish() or fail() on an already closed step is a no-op.
+func (step *pipelineStep) finish(metadata map[string]any) {
+	if step.closed {
+		return
+	}
+	step.closed = true
+	duration := time.Since(step.startedAt)
+	step.pipelineLogger.recordEvent(step.name, models.EventStepFinished, &duration, "", metadata)
+	step.span.SetAttributes(metadataAttributes(metadata)...)
+	step.span.SetStatus(codes.Ok, "")
+	step.span.End()
+	if step.pipelineLogger.currentStep == step {
+		step.pipelineLogger.currentStep = nil
+	}
+}
+
+// fail records a step_failed event with the step duration and the failure message.
+func (step *pipelineStep) fail(message string) {
+	if step.closed {
+		return
+	}
+	step.closed = true
+	duration := time.Since(step.startedAt)
+	message = step.pipelineLogger.recordEvent(step.name, models.EventStepFailed, &duration, message, nil)
+	// the masked message is used for the span too, trace backends are not a place for secrets either
+	step.span.SetStatus(codes.Error, message)
+	step.span.End()
+	if step.pipelineLogger.currentStep == step {
+		step.pipelineLogger.currentStep = nil
+	}
+}
+
+// recordEvent writes one event row and returns the message after secret masking.
+// a failure to record an event is logged and ignored,
+// the deployment itself must never fail because of its own instrumentation.
+func (pipelineLogger *deployerPipelineLogger) recordEvent(
+	step string,
+	eventType models.PipelineEventType,
+	duration *time.Duration,
+	message string,
+	metadata map[string]any,
+) string {
+	if pipelineLogger.secretMasker != nil {
+		message = pipelineLogger.secretMasker.Replace(message)
+	}
+
+	event := &models.PipelineEvent{
+		DeploymentID: pipelineLogger.deployment.ID,
+		RunID:        pipelineLogger.runID,
+		Step:         step,
+		Type:         eventType,
+		Message:      message,
+		Metadata:     metadata,
+	}
+	if duration != nil {
+		durationMs := duration.Milliseconds()
+		event.DurationMs = &durationMs
+	}
+
+	// the same transitions feed the /metrics step duration histogram and failure counter
+	switch eventType {
+	case models.EventStepFinished:
+		pipelineLogger.pipeline.metrics.PipelineStepFinished(step, *duration)
+	case models.EventStepFailed:
+		pipelineLogger.pipeline.metrics.PipelineStepFailed(step, *duration)
+	}
+
+	if err := pipelineLogger.pipeline.database.InsertPipelineEvent(event); err != nil {
+		pipelineLogger.pipeline.logger.Warn("failed to record pipeline event (non-fatal)",
+			"slug", pipelineLogger.deployment.Slug,
+			"step", step,
+			"type", eventType,
+			"error", err,
+		)
+	}
+	return message
+}
+
+// finishRun closes the whole-run "pipeline" step as finished. called once the deployment is live.
+func (pipelineLogger *deployerPipelineLogger) finishRun() {
+	pipelineLogger.runStep.finish(nil)
+}
+
+// metadataAttributes converts event metadata into span attributes.
+// values are stringified, metadata is small and only ever holds strings and numbers.
+func metadataAttributes(metadata map[string]any) []attribute.KeyValue {
+	attributes := make([]attribute.KeyValue, 0, len(metadata))
+	for key, value := range metadata {
+		attributes = append(attributes, attribute.String("pipeline."+key, fmt.Sprint(value)))
+	}
+	return attributes
+}
@@ -0,0 +1,329 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/docker"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+)
+
+// DeployGitRepository is the full pipeline for deploying from a public git repository,
+// both source_type "github" and the generic source_type "git" (GitLab, Gitea, Bitbucket, self-hosted, file://).
+// It clones the repo, optionally runs a build command in an ephemeral container,
+// then hands off to the shared deployToNginx helper for the serving steps.
+//
+// the only difference between the two source types is how the repository is checked and its
+// default branch resolved before cloning:
+//   - github.com URLs use the GitHub REST API (a cheap HTTPS GET, and the original implementation)
+//   - every other host uses `git ls-remote`, which needs nothing but the git protocol
+//
+// Called as a goroutine from the handler `go pipeline.DeployGitRepository(request.Context(), deployment)`
+// requestContext is only used to link the pipeline trace to the request trace, the pipeline
+// itself runs on a background context (see newDeployerPipelineLogger) because the HTTP
+// request context is already done by the time this goroutine runs.
+func (deployerPipeline *DeployerPipeline) DeployGitRepository(requestContext context.Context, deployment *models.Deployment) {
+	// ===== opening log file and create pipeline logger (same pattern as DeployZipUpload) ---
+	logFile, errOpenLogFile := deployerPipeline.openLogFileForCurrentDeployment(deployment.Slug)
+	if errOpenLogFile != nil {
+		// non-fatal, the pipeline continues without a log file rather than
+		// failing the deployment over a logging issue (consistent with DeployZipUpload)
+		deployerPipeline.logger.Error("failed to open deployment log file",
+			"slug", deployment.Slug,
+			"error", errOpenLogFile,
+		)
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
+	// setting up the helper logger struct to log to both slog and log file
+	pipelineLogger := newDeployerPipelineLogger(requestContext, deployerPipeline, deployment, logFile)
+
+	// ===== Decrypt the repository credential (private repositories only)
+	// done before the log writer is created, so the token / deploy key is masked in
+	// every line git or the build writes to <slug>.log from here on.
+	gitCredential, errLoadCredential := deployerPipeline.loadGitCredential(deployment)
+	if errLoadCredential != nil {
+		pipelineLogger.logFailureAndUpdateStatus("failed to load repository credentials", errLoadCredential)
+		return
+	}
+	pipelineLogger.maskAdditionalSecrets(gitCredentialSecretValues(gitCredential)...)
+
+	// logWriter is the io.Writer passed to cloneGitRepo() and RunEphemeralBuildContainer()
+	// for capturing git/build output. It falls back to io.Discard when the log file
+	// failed to open, and masks secret-scoped env var values before they reach the file.
+	logWriter := pipelineLogger.outputWriter()
+
+	// ===== Set status as deploying
+	pipelineLogger.logInfo("starting %s deployment pipeline", deployment.SourceType)
+	statusError := deployerPipeline.setStatusDeploying(deployment.ID)
+	if statusError != nil {
+		pipelineLogger.logFailureAndUpdateStatus("failed to set status to deploying", statusError)
+		return
+	}
+
+	// ===== Cloning the repo
+	// GitHubURL / GitURL are *string (pointer) because they are optional for zip deployments (nil-able)
+	// for github and git deployments, the handler validates the matching one is non-nil before inserting.
+	repoURL, hasRepoURL := repositoryURL(deployment)
+	if !hasRepoURL {
+		pipelineLogger.logFailureAndUpdateStatus("repository url is nil",
+			fmt.Errorf("missing repository url on %s deployment %q", deployment.SourceType, deployment.ID),
+		)
+		return
+	}
+
+	// the GitHub API is kept as a fast path for github.com, it answers existence and default branch
+	// without starting a git process. anything else (or a github.com API failure) goes through ls-remote.
+	// private repositories always use ls-remote: the unauthenticated API answers 404 for them,
+	// while ls-remote runs with the same credential as the clone.
+	useGitHubAPI := (deployment.SourceType == models.SourceGitHub || isGitHubRepositoryURL(repoURL)) && gitCredential == nil
+
+	// askpass script or deploy key file for git, removed as soon as this run ends.
+	// nil (and nil-safe) for public repositories.
+	credentialEnvironment, errPrepareCredential := prepareGitCredentialEnvironment(gitCredential, repoURL)
+	if errPrepareCredential != nil {
+		pipelineLogger.logFailureAndUpdateStatus("failed to prepare repository credentials", errPrepareCredential)
+		return
+	}
+	defer credentialEnvironment.cleanup()
+	if gitCredential != nil {
+		pipelineLogger.logInfo("private repository, cloning with the stored %s", gitCredential.Type)
+	}
+
+	// ===== Verify the repository exists before attempting clone
+	// git clone to a non-existent or private repo can hang waiting for credentials
+	// on systems without a TTY. Checking first gives a fast, clear failure instead of an indefinite hang.
+	// for non-GitHub hosts the ls-remote that checks existence also returns the default branch,
+	// remembered here so the resolve branch step below does not run it a second time.
+	repoCheckMethod := "ls_remote"
+	if useGitHubAPI {
+		repoCheckMethod = "github_api"
+	}
+	repoCheckStep := pipelineLogger.startStep(stepRepoCheck, map[string]any{"repo_url": repoURL, "method": repoCheckMethod})
+	var remoteDefaultBranch string
+	var repoCheckErr error
+	if useGitHubAPI {
+		repoCheckErr = checkGitHubRepoExists(repoURL, deployerPipeline.logger)
+	} else {
+		remoteDefaultBranch, repoCheckErr = resolveGitRemoteDefaultBranch(repoURL, credentialEnvironment)
+	}
+	if repoCheckErr != nil {
+		pipelineLogger.logFailureAndUpdateStatus(
+			fmt.Sprintf("repository check failed for %s", repoURL),
+			repoCheckErr,
+		)
+		return
+	}
+	repoCheckStep.finish(nil)
+
+	// the temp working directory path is generated without creating the directory.
+	// git clone creates the destination directory itself. If os.MkdirTemp were used,
+	// the directory would already exist and git clone would fail with "destination path already exists."
+	// this matches the pattern used in DeployZipUpload where filepath.Join(os.TempDir(), ...)
+	// generates a path and lets the subsequent operation create it.
+	// it would look like `.../<tmp folder>/corvus-build-.../<the cloned repo>`
+	tempWorkingDir := filepath.Join(deployerPipeline.tempBuildStorageRoot, "corvus-build-"+deployment.ID)
+	defer func() { // clean up the working directory on any exit path
+		removeError := os.RemoveAll(tempWorkingDir)
+		if removeError != nil {
+			deployerPipeline.logger.Warn("failed to remove temp build directory (non-fatal)",
+				"path", tempWorkingDir,
+				"error", removeError,
+			)
+		}
+	}()
+
+	// a pinned ref (tag, SHA, or branch) replaces the branch entirely, see fetchGitRef
+	pinnedRef := ""
+	if deployment.Ref != nil {
+		pinnedRef = *deployment.Ref
+	}
+	if pinnedRef != "" {
+		pipelineLogger.logInfo("cloning repository: %s (pinned ref: %s)", repoURL, pinnedRef)
+	} else {
+		pipelineLogger.logInfo("cloning repository: %s (branch: %s)", repoURL, deployment.Branch)
+	}
+
+	// ===== Auto-detect default branch if user left the default "main"
+	// Many repos still use "master" or other branch names. If the user did not
+	// change the branch from the default "main", look up the repo's actual
+	// default branch and use that instead.
+	// If the user typed something specific (not "main"), they probably know
+	// what they are doing, so trusting their input.
+	// a pinned ref does not use the branch at all, so there is nothing to resolve.
+	if deployment.Branch == "main" && pinnedRef == "" {
+		resolveBranchStep := pipelineLogger.startStep(stepResolveBranch, nil)
+		// for other hosts the repo check already resolved it
+		actualDefault := remoteDefaultBranch
+		var err error
+		if useGitHubAPI {
+			actualDefault, err = fetchGitHubDefaultBranch(repoURL)
+			if err != nil {
+				// rate limited or API down, the git protocol still works
+				pipelineLogger.logInfo("github API could not resolve the default branch, falling back to git ls-remote: %v", err)
+				actualDefault, err = resolveGitRemoteDefaultBranch(repoURL, credentialEnvironment)
+			}
+		}
+		if err != nil {
+			// non-fatal: fall through and attempt the clone with "main" as-is.
+			pipelineLogger.logInfo("could not auto-detect default branch (non-fatal): %v", err)
+		} else if actualDefault != "main" {
+			pipelineLogger.logInfo("auto-detected default branch: %q (user had %q)", actualDefault, deployment.Branch)
+			deployment.Branch = actualDefault
+		}
+		resolveBranchStep.finish(map[string]any{"branch": deployment.Branch})
+	}
+
+	// monorepo deployments only check out their root directory and shared directories
+	sparseDirectories := sparseCheckoutDirectories(deployment)
+	cloneStepMetadata := map[string]any{"branch": deployment.Branch}
+	if pinnedRef != "" {
+		cloneStepMetadata = map[string]any{"ref": pinnedRef}
+	}
+	if len(sparseDirectories) > 0 {
+		cloneStepMetadata["sparse_directories"] = sparseDirectories
+		pipelineLogger.logInfo("sparse checkout of %s (plus the files at the repository root)", strings.Join(sparseDirectories, ", "))
+	}
+
+	cloneStep := pipelineLogger.startStep(stepClone, cloneStepMetadata)
+	var cloneError error
+	if pinnedRef != "" {
+		cloneError = fetchGitRef(repoURL, pinnedRef, tempWorkingDir, sparseDirectories, logWriter, credentialEnvironment)
+	} else {
+		cloneError = cloneGitRepo(repoURL, deployment.Branch, tempWorkingDir, sparseDirectories, logWriter, credentialEnvironment)
+	}
+	if cloneError != nil {
+		pipelineLogger.logFailureAndUpdateStatus("git clone failed", cloneError)
+		return
+	}
+
+	// the commit that was actually checked out, recorded on the deployment once it is live.
+	// non-fatal: a site that builds fine should not fail because `git log` did.
+	commitSHA, commitMessage, errReadCommit := readGitHeadCommit(tempWorkingDir)
+	if errReadCommit != nil {
+		pipelineLogger.logInfo("could not read the checked out commit (non-fatal): %v", errReadCommit)
+		cloneStep.finish(nil)
+	} else {
+		cloneStep.finish(map[string]any{"commit_sha": commitSHA})
+	}
+	pipelineLogger.logInfo("clone complete")
+	if commitSHA != "" {
+		commitSubject, _, _ := strings.Cut(commitMessage, "\n")
+		pipelineLogger.logInfo("checked out commit %s: %s", commitSHA, commitSubject)
+	}
+
+	// ===== Optional submodules and Git LFS objects (see git_extras.go)
+	if deployment.GitSubmodules {
+		pipelineLogger.logInfo("initialising git submodules")
+		submodulesStep := pipelineLogger.startStep(stepSubmodules, nil)
+		errUpdateSubmodules := updateGitSubmodules(tempWorkingDir, logWriter, credentialEnvironment)
+		if errUpdateSubmodules != nil {
+			pipelineLogger.logFailureAndUpdateStatus("git submodule update failed", errUpdateSubmodules)
+			return
+		}
+		submodulesStep.finish(nil)
+	}
+	if deployment.GitLFS {
+		pipelineLogger.logInfo("downloading Git LFS objects")
+		lfsPullStep := pipelineLogger.startStep(stepLFSPull, nil)
+		errPullLFS := pullGitLFSObjects(tempWorkingDir, sparseDirectories, deployment.GitSubmodules, logWriter, credentialEnvironment)
+		if errPullLFS != nil {
+			pipelineLogger.logFailureAndUpdateStatus("git lfs pull failed", errPullLFS)
+			return
+		}
+		lfsPullStep.finish(nil)
+	}
+
+	// ===== running build command (if provided)
+	if deployment.BuildCommand != "" {
+		pipelineLogger.logInfo("running build command: %s (in %s)", deployment.BuildCommand, deployment.RootDirectory)
+
+		// a root directory that is not in the checkout (typo, or not in that commit) would otherwise
+		// surface as a confusing "no such file or directory" from docker when setting the working dir
+		_, errStatRootDirectory := os.Stat(filepath.Join(tempWorkingDir, deployment.RootDirectory))
+		if errStatRootDirectory != nil {
+			pipelineLogger.logFailureAndUpdateStatus(
+				fmt.Sprintf("root directory %q not found in the repository", deployment.RootDirectory),
+				errStatRootDirectory,
+			)
+			return
+		}
+
+		// decode build and secret scoped environment variables from JSON strings to []string{"KEY=VALUE", ...}
+		// runtime-scoped variables are not passed to the build, they are written at serve time instead.
+		envVarsList, envDecodeError := buildStageEnvVars(deployment)
+		if envDecodeError != nil {
+			pipelineLogger.logFailureAndUpdateStatus("failed to decode environment variables", envDecodeError)
+			return
+		}
+
+		buildContainerName := "building-" + deployment.Slug
+		buildConfig := docker.RunEphemeralBuildContainerConfig{
+			ContainerName:        buildContainerName,
+			BuildCommand:         deployment.BuildCommand,
+			HostSourceDirectory:  tempWorkingDir,
+			WorkingDirectory:     deployment.RootDirectory,
+			EnvironmentVariables: envVarsList,
+			LogWriter:            logWriter,
+		}
+
+		buildStep := pipelineLogger.startStep(stepBuild, map[string]any{"build_command": deployment.BuildCommand})
+		buildError := deployerPipeline.dockerClient.RunEphemeralBuildContainer(buildStep.context, buildConfig)
+		if buildError != nil {
+			pipelineLogger.logFailureAndUpdateStatus("build failed", buildError)
+			return
+		}
+		buildStep.finish(nil)
+		pipelineLogger.logInfo("build complete")
+	} else {
+		pipelineLogger.logInfo("no build command specified, skipping build step")
+	}
+
+	// ===== Handing it off to the shared deployToNginxHelper
+	// tempWorkingDir now contains the cloned (and possibly built) source files.
+	// deployToNginx resolves the output directory, copies to asset storage,
+	// stops any existing container, starts the nginx container, and sets status to live.
+	isLive := deployerPipeline.deployToNginx(
+		deployment,
+		tempWorkingDir,
+		pipelineLogger,
+	)
+
+	// ===== Recording the live commit
+	// only after the deployment went live, so commit_sha always names what is being served
+	// (a failed build of a new commit keeps the previous, still running, commit on record).
+	if isLive && commitSHA != "" {
+		errUpdateCommit := deployerPipeline.database.UpdateCommit(deployment.ID, commitSHA, commitMessage)
+		if errUpdateCommit != nil {
+			deployerPipeline.logger.Error("deployment is live but failed to record its commit",
+				"id", deployment.ID,
+				"commit_sha", commitSHA,
+				"error", errUpdateCommit,
+			)
+			return
+		}
+		deployment.CommitSHA = &commitSHA
+		deployment.CommitMessage = &commitMessage
+	}
+}
+
+// repositoryURL returns the clone URL of a github or git deployment.
+// source_type "github" keeps its URL in github_url (the original column), "git" in git_url.
+func repositoryURL(deployment *models.Deployment) (string, bool) {
+	switch deployment.SourceType {
+	case models.SourceGitHub:
+		if deployment.GitHubURL != nil {
+			return *deployment.GitHubURL, true
+		}
+	case models.SourceGit:
+		if deployment.GitURL != nil {
+			return *deployment.GitURL, true
+		}
+	}
+	return "", false
+}
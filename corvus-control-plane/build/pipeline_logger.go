@@ -1,11 +1,17 @@
 package build
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // deployerPipelineLogger is a helper struct for the DeployerPipeline that
@@ -19,6 +25,94 @@ type deployerPipelineLogger struct {
 	pipeline   *DeployerPipeline
 	deployment *models.Deployment // for .Slug and .ID
 	logFile    *os.File           // nil if the log file could not be opened
+
+	// secretMasker replaces secret-scoped env var values (and the repository credential, see
+	// maskAdditionalSecrets) with a mask before anything is written to the log file or slog.
+	// nil when the deployment has no secrets.
+	secretMasker *strings.Replacer
+
+	// runID groups the structured events of this pipeline run (see pipeline_events.go)
+	runID string
+	// runContext is the background context of the whole run, carrying the run span.
+	// steps derive their own context (and child span) from it.
+	runContext context.Context
+	// runStep is the whole-run "pipeline" step, closed by finishRun() or logFailureAndUpdateStatus()
+	runStep *pipelineStep
+	// currentStep is the step in progress, marked failed by logFailureAndUpdateStatus(). nil between steps.
+	currentStep *pipelineStep
+}
+
+// newDeployerPipelineLogger constructs the per-run pipeline logger and records the
+// step_started event of the whole run, so it should be called once at the start of each pipeline method.
+// logFile may be nil (the pipeline keeps going without a log file).
+//
+// triggerContext is the context of the HTTP request that started the run. it is only used to
+// link the run trace to the request trace, never for cancellation: the run context is built on
+// context.Background() because the request context is cancelled the moment the handler returns,
+// which would cancel all Docker SDK calls mid-flight. the pipeline must outlive the HTTP request.
+func newDeployerPipelineLogger(
+	triggerContext context.Context,
+	pipeline *DeployerPipeline,
+	deployment *models.Deployment,
+	logFile *os.File,
+) *deployerPipelineLogger {
+	pipelineLogger := &deployerPipelineLogger{
+		pipeline:     pipeline,
+		deployment:   deployment,
+		logFile:      logFile,
+		secretMasker: newSecretMasker(deployment),
+		runID:        uuid.New().String(),
+	}
+
+	// a pipeline run gets its own trace (new root) instead of being a child of the request span,
+	// since it keeps running for minutes after the request trace has ended.
+	// the link still lets a trace viewer jump from the run to the request that triggered it.
+	runContext, runSpan := pipeline.tracer.Start(context.Background(), "pipeline.run",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(triggerContext)),
+		trace.WithAttributes(
+			attribute.String("deployment.id", deployment.ID),
+			attribute.String("deployment.slug", deployment.Slug),
+			attribute.String("deployment.source_type", string(deployment.SourceType)),
+			attribute.String("pipeline.run_id", pipelineLogger.runID),
+		),
+	)
+	pipelineLogger.runContext = runContext
+
+	// the run step is not made the currentStep, it stays open underneath the individual steps
+	pipelineLogger.runStep = &pipelineStep{
+		pipelineLogger: pipelineLogger,
+		name:           stepPipeline,
+		startedAt:      time.Now(),
+		context:        runContext,
+		span:           runSpan,
+	}
+	pipeline.metrics.PipelineRunStarted(string(deployment.SourceType))
+	pipelineLogger.recordEvent(stepPipeline, models.EventStepStarted, nil, "", map[string]any{
+		"source_type": deployment.SourceType,
+		"slug":        deployment.Slug,
+	})
+	return pipelineLogger
+}
+
+// maskAdditionalSecrets adds values that are not secret env vars (the decrypted repository credential)
+// to the masking of this run. must be called before outputWriter(), the writer keeps the masker it was built with.
+func (pipelineLogger *deployerPipelineLogger) maskAdditionalSecrets(secretValues ...string) {
+	pipelineLogger.secretMasker = newSecretMasker(pipelineLogger.deployment, secretValues...)
+}
+
+// outputWriter returns the io.Writer used for raw git/build output.
+// If the log file failed to open, io.Discard is returned instead of nil to avoid
+// a nil writer panic when git or Docker tries to write to it.
+// when the deployment has secrets, the writer masks them before they reach the file.
+func (pipelineLogger *deployerPipelineLogger) outputWriter() io.Writer {
+	if pipelineLogger.logFile == nil {
+		return io.Discard
+	}
+	if pipelineLogger.secretMasker == nil {
+		return pipelineLogger.logFile
+	}
+	return &secretMaskingWriter{destination: pipelineLogger.logFile, masker: pipelineLogger.secretMasker}
 }
 
 // logInfo() writes a timestamped entry to the deployment log file and a structured
@@ -26,6 +120,9 @@ type deployerPipelineLogger struct {
 // Used throughout the deployerPipeline to record each step's outcome.
 func (pipelineLogger *deployerPipelineLogger) logInfo(format string, args ...any) {
 	message := fmt.Sprintf(format, args...)
+	if pipelineLogger.secretMasker != nil {
+		message = pipelineLogger.secretMasker.Replace(message)
+	}
 	line := fmt.Sprintf("[%s] %s\n", time.Now().UTC().Format(time.RFC3339), message)
 
 	pipelineLogger.pipeline.logger.Info("deployer pipeline",
@@ -47,6 +144,12 @@ func (pipelineLogger *deployerPipelineLogger) logInfo(format string, args ...any
 func (pipelineLogger *deployerPipelineLogger) logFailureAndUpdateStatus(reason string, err error) {
 	pipelineLogger.logInfo("FAILED: %s: %v", reason, err)
 
+	failureMessage := fmt.Sprintf("%s: %v", reason, err)
+	if pipelineLogger.currentStep != nil {
+		pipelineLogger.currentStep.fail(failureMessage)
+	}
+	pipelineLogger.runStep.fail(failureMessage)
+
 	dbErr := pipelineLogger.pipeline.database.UpdateStatus(pipelineLogger.deployment.ID, models.StatusFailed)
 	if dbErr != nil {
 		pipelineLogger.pipeline.logger.Error("failed to update status to failed",
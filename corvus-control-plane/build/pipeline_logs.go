@@ -0,0 +1,48 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// maxLogChunkBytes caps how much of a deployment log one ReadDeploymentLog call returns,
+// so a huge build log is paged through instead of being loaded into memory at once.
+const maxLogChunkBytes = 1 << 20 // 1MB
+
+// ReadDeploymentLog returns the bytes of <logRoot>/<slug>.log starting at offset,
+// together with the offset to pass on the next call (offset + len(chunk)).
+// used by GET /api/deployments/{uuid}/logs, which the CLI polls to follow a log (`corvus logs -f`).
+//
+// a log file that does not exist yet (the pipeline goroutine has not opened it)
+// is not an error, it reads as empty. an offset past the end of the file (the log was
+// removed and recreated) restarts from the beginning so a follower never gets stuck.
+func (deployerPipeline *DeployerPipeline) ReadDeploymentLog(slug string, offset int64) ([]byte, int64, error) {
+	logPath := filepath.Join(deployerPipeline.logRoot, slug+".log")
+
+	logFile, err := os.Open(logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return []byte{}, 0, nil
+	}
+	if err != nil {
+		return nil, offset, fmt.Errorf("failed to open log file %q: %w", logPath, err)
+	}
+	defer logFile.Close()
+
+	logFileInfo, err := logFile.Stat()
+	if err != nil {
+		return nil, offset, fmt.Errorf("failed to stat log file %q: %w", logPath, err)
+	}
+	if offset < 0 || offset > logFileInfo.Size() {
+		offset = 0
+	}
+
+	// io.NewSectionReader reads [offset, offset+maxLogChunkBytes) without moving a shared file cursor
+	logChunk, err := io.ReadAll(io.NewSectionReader(logFile, offset, maxLogChunkBytes))
+	if err != nil {
+		return nil, offset, fmt.Errorf("failed to read log file %q: %w", logPath, err)
+	}
+	return logChunk, offset + int64(len(logChunk)), nil
+}
@@ -1,7 +1,6 @@
 package build
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -19,15 +18,20 @@ import (
 //
 // steps performed:
 //   - validate the output directory exists within sourceCodeDirectory
-//   - copy the output subdirectory to the asset storage root (to <assetStorageRoot>/<thisDeploymentDir>/)
-//   - stop and remove any existing container for this slug (handles redeployment)
-//   - start nginx container with the asset storage directory bind-mounted
+//   - copy the output subdirectory to this run's own directory in the asset storage root
+//     (<assetStorageRoot>/<slug>/<run id>/, see served_directory.go), the live one is never touched
+//   - precompress text assets into .gz / .br siblings
+//   - serve it (serveDeployment), either:
+//   - publish the site to the shared static server (SERVING_MODE=shared), or
+//   - render and test the deployment's nginx config (SPA fallback, _redirects, _headers),
+//     stop and remove any existing container for this slug (handles redeployment)
+//     and start nginx container with the asset storage directory bind-mounted
+//   - point <slug>/.live at the run directory and remove the previous one
 //   - update status to "live"
 //
 // Returns true if the deployment reached "live" status, false if any step failed.
 // all logging and status updates are handled internally via the pipelineLogger.
 func (deployerPipeline *DeployerPipeline) deployToNginx(
-	deployContext context.Context,
 	deployment *models.Deployment,
 	contentRoot string,
 	pipelineLogger *deployerPipelineLogger,
@@ -37,8 +41,9 @@ func (deployerPipeline *DeployerPipeline) deployToNginx(
 	// contentRoot is the temp directory containing the extracted zip or cloned repo.
 	// it is a single deployment's temp working directory (e.g., /tmp/corvus-build-<uuid>/)
 	// OutputDirectory is user-provided (e.g., "dist", "build", ".").
+	// it is relative to RootDirectory, which is "." for everything but monorepo deployments.
 	// filepath.Join handles the "." case correctly: Join(contentRoot, ".") == contentRoot.
-	outputDirectory := filepath.Join(contentRoot, deployment.OutputDirectory)
+	outputDirectory := filepath.Join(contentRoot, deployment.RootDirectory, deployment.OutputDirectory)
 
 	// Verifying if the output directory actually exists
 	// a wrong OutputDirectory value is a very common user error and should produce
@@ -46,7 +51,7 @@ func (deployerPipeline *DeployerPipeline) deployToNginx(
 	_, errStat := os.Stat(outputDirectory)
 	if errors.Is(errStat, os.ErrNotExist) {
 		pipelineLogger.logFailureAndUpdateStatus(
-			fmt.Sprintf("output directory %q not found in source content (zip/github)", deployment.OutputDirectory),
+			fmt.Sprintf("output directory %q not found in source content (zip/github)", filepath.Join(deployment.RootDirectory, deployment.OutputDirectory)),
 			errStat,
 		)
 		return false
@@ -90,50 +95,96 @@ func (deployerPipeline *DeployerPipeline) deployToNginx(
 	// ===== Copying the output directory to the asset storage root.
 	// the asset storage root is the stable location bind-mounted into the Nginx container.
 	// working directories are ephemeral (temp). the asset storage root persists across deploys.
-	destDirInAssetStorageRoot := filepath.Join(deployerPipeline.assetStorageRoot, deployment.Slug)
+	// every run gets its own directory there, the previous version keeps serving from its directory
+	// while this one is filled, and goes away only once this one is live (see served_directory.go).
+	destDirInAssetStorageRoot := deployerPipeline.runServedDirectory(deployment.Slug, pipelineLogger.runID)
+	servingRunDirectory := false
+	defer func() {
+		// a failed run removes its own directory, what is live stays as it is
+		if !servingRunDirectory {
+			os.RemoveAll(destDirInAssetStorageRoot)
+		}
+	}()
 
 	pipelineLogger.logInfo("copying output directory to asset storage root: %s -> %s", outputDirectory, destDirInAssetStorageRoot)
+	copyStep := pipelineLogger.startStep(stepCopy, map[string]any{"output_directory": deployment.OutputDirectory})
 	errCopySourceCodeDir := util.CopyDirectory(outputDirectory, destDirInAssetStorageRoot)
 	if errCopySourceCodeDir != nil {
 		pipelineLogger.logFailureAndUpdateStatus("failed to copy output directory to asset storage root", errCopySourceCodeDir)
 		return false
 	}
+	copyStep.finish(nil)
 	pipelineLogger.logInfo("files copied to asset storage root")
 
-	// ===== Stop and remove any existing container for this slug
+	// ===== Preparing the served copy of a preset
+	// drops preset.json and fills in the preset's template variables (see preset_templates.go).
+	// this runs on the copy, before the container starts, so the page is never served half-rendered.
+	if deployment.SourceType == models.SourcePrebuilt {
+		errPrepareServedCopy := deployerPipeline.prepareServedPresetCopy(deployment, destDirInAssetStorageRoot, pipelineLogger)
+		if errPrepareServedCopy != nil {
+			pipelineLogger.logFailureAndUpdateStatus("failed to prepare the preset files", errPrepareServedCopy)
+			return false
+		}
+	}
 
-	// this should be a no-op for new deployments (no container exists yet).
-	// for GitHub redeploys, this replaces the currently running container.
-	// StopAndRemoveContainer is idempotent, returns nil if the container does not exist.
-	containerName := "deploy-" + deployment.Slug
-	pipelineLogger.logInfo("stopping existing container if present: %s", containerName)
-	errStopAndRemoveContainer := deployerPipeline.dockerClient.StopAndRemoveContainer(deployContext, containerName)
-	if errStopAndRemoveContainer != nil {
-		pipelineLogger.logFailureAndUpdateStatus("failed to remove existing container", errStopAndRemoveContainer)
+	// ===== Writing the runtime config file for runtime-scoped env vars
+	// this happens after the copy (CopyDirectory wipes the destination) and only
+	// touches the served copy, never the build output or preset source.
+	wroteRuntimeConfig, errWriteRuntimeConfig := writeRuntimeEnvConfig(destDirInAssetStorageRoot, deployment.RuntimeEnvironmentVariables)
+	if errWriteRuntimeConfig != nil {
+		pipelineLogger.logFailureAndUpdateStatus("failed to write runtime environment config", errWriteRuntimeConfig)
 		return false
 	}
+	if wroteRuntimeConfig {
+		pipelineLogger.logInfo("runtime environment variables written to %s", runtimeEnvConfigFileName)
+	}
 
-	// ===== Starting the Nginx container
-	pipelineLogger.logInfo("starting nginx container: %s", containerName)
-	errCreateAndStartNginxContainer := deployerPipeline.dockerClient.CreateAndStartNginxContainer(deployContext, docker.NginxContainerConfig{
-		ContainerName:       containerName,
-		Slug:                deployment.Slug,
-		HostSourceDirectory: destDirInAssetStorageRoot,
-		TraefikNetwork:      deployerPipeline.traefikNetwork,
+	// ===== Precompressing text assets (.gz and .br siblings, see precompress.go)
+	// runs last on the served copy, every file it compresses is final by now.
+	// non-fatal, nginx serves the uncompressed files for anything that was not compressed.
+	precompressStep := pipelineLogger.startStep(stepPrecompress, nil)
+	compressionStats, errPrecompress := precompressStaticAssets(destDirInAssetStorageRoot)
+	if errPrecompress != nil {
+		pipelineLogger.logInfo("warning: precompressing assets failed for some files (non-fatal, served uncompressed): %v", errPrecompress)
+	}
+	if compressionStats.files > 0 {
+		pipelineLogger.logInfo("precompressed %d files (%s): gzip %s (%.0f%% smaller), brotli %s (%.0f%% smaller)",
+			compressionStats.files,
+			formatByteSize(compressionStats.originalBytes),
+			formatByteSize(compressionStats.gzipBytes),
+			savingsPercent(compressionStats.originalBytes, compressionStats.gzipBytes),
+			formatByteSize(compressionStats.brotliBytes),
+			savingsPercent(compressionStats.originalBytes, compressionStats.brotliBytes),
+		)
+	}
+	precompressStep.finish(map[string]any{
+		"files":          compressionStats.files,
+		"original_bytes": compressionStats.originalBytes,
+		"gzip_bytes":     compressionStats.gzipBytes,
+		"brotli_bytes":   compressionStats.brotliBytes,
 	})
-	if errCreateAndStartNginxContainer != nil {
-		pipelineLogger.logFailureAndUpdateStatus("failed to start nginx container", errCreateAndStartNginxContainer)
+
+	// ===== Putting the served directory live (shared static server or nginx container)
+	if !deployerPipeline.serveDeployment(deployment, destDirInAssetStorageRoot, pipelineLogger) {
 		return false
 	}
-	pipelineLogger.logInfo("nginx container started successfully")
+	servingRunDirectory = true
 
-	// ===== Updating container status to live
+	// ===== Recording the run directory as the live one, removing the previous version
+	// non-fatal, the site is already served from the new directory. a stale .live only matters
+	// on the next restart (shared mode) or access control change, which would pick the old files.
+	errSwitchLive := deployerPipeline.switchLiveServedDirectory(deployment.Slug, destDirInAssetStorageRoot)
+	if errSwitchLive != nil {
+		pipelineLogger.logInfo("warning: failed to switch the live directory (non-fatal): %v", errSwitchLive)
+	}
+
+	// ===== Updating status to live
 	errUpdateStatusToLive := deployerPipeline.database.UpdateStatus(deployment.ID, models.StatusLive)
 	if errUpdateStatusToLive != nil {
-		// the container is running but the DB update failed.
+		// the site is served but the DB update failed.
 		// log the error but do not fail the deployment, the site is actually live.
 		// the status inconsistency will be visible in the API response.
-		deployerPipeline.logger.Error("container is live but failed to update status to live",
+		deployerPipeline.logger.Error("site is live but failed to update status to live",
 			"id", deployment.ID,
 			"slug", deployment.Slug,
 			"error", errUpdateStatusToLive,
@@ -141,6 +192,8 @@ func (deployerPipeline *DeployerPipeline) deployToNginx(
 		return false
 	}
 
+	pipelineLogger.finishRun()
+
 	// TODO fix hardcode here
 	pipelineLogger.logInfo("deployment complete. site is live at https://%s-corvus.sasta.dev", deployment.Slug)
 	// dw about the url being http and https since this is just for internal routing between traefik and docker
@@ -152,3 +205,90 @@ func (deployerPipeline *DeployerPipeline) deployToNginx(
 
 	return true
 }
+
+// serveDeployment puts a deployment's served directory live.
+// in the shared serving mode the site is published to the in-process static server (see pipeline_shared_server.go),
+// otherwise the nginx config is rendered and the deployment's nginx container is replaced.
+// returns false when a step failed, the failure is already logged and the status set to failed.
+func (deployerPipeline *DeployerPipeline) serveDeployment(
+	deployment *models.Deployment,
+	servedDirectory string,
+	pipelineLogger *deployerPipelineLogger,
+) bool {
+	// ===== Picking up the password and IP access lists saved since the run was triggered
+	// the access control endpoints refuse changes once the run is deploying (see LockDeployment),
+	// but one saved between the trigger and the start of the run is not in this deployment struct yet
+	if errRefresh := deployerPipeline.refreshAccessControl(deployment); errRefresh != nil {
+		pipelineLogger.logFailureAndUpdateStatus("failed to read the access control settings", errRefresh)
+		return false
+	}
+
+	if deployerPipeline.sharedStaticServer != nil {
+		errPublish := deployerPipeline.publishToSharedStaticServer(deployment, servedDirectory, pipelineLogger)
+		if errPublish != nil {
+			pipelineLogger.logFailureAndUpdateStatus("failed to publish the site to the shared static server", errPublish)
+			return false
+		}
+		return true
+	}
+
+	runtimeEnvVarsList, errDecodeRuntimeEnvVars := decodeEnvVarsToSlice(deployment.RuntimeEnvironmentVariables)
+	if errDecodeRuntimeEnvVars != nil {
+		pipelineLogger.logFailureAndUpdateStatus("failed to decode runtime environment variables", errDecodeRuntimeEnvVars)
+		return false
+	}
+
+	// ===== Resolving the access control (password, IP access lists) into the container's Traefik labels
+	// before anything is stopped, lists the platform defaults now reject fail the deploy here
+	containerName := "deploy-" + deployment.Slug
+	nginxContainerConfig, errAccessConfig := deployerPipeline.nginxContainerAccessConfig(deployment, docker.NginxContainerConfig{
+		ContainerName:        containerName,
+		Slug:                 deployment.Slug,
+		HostSourceDirectory:  servedDirectory,
+		TraefikNetwork:       deployerPipeline.traefikNetwork,
+		EnvironmentVariables: runtimeEnvVarsList,
+	})
+	if errAccessConfig != nil {
+		pipelineLogger.logFailureAndUpdateStatus("failed to resolve the access control", errAccessConfig)
+		return false
+	}
+
+	// ===== Rendering the nginx config (SPA fallback, _redirects, _headers)
+	// tested with `nginx -t` here, before the previous container (if any) is stopped, see nginx_config.go.
+	// a rejected config fails the deploy and is never written over the one that container uses
+	nginxConfigPath, errPrepareNginxConfig := deployerPipeline.prepareNginxConfig(
+		deployment,
+		servedDirectory,
+		pipelineLogger,
+	)
+	if errPrepareNginxConfig != nil {
+		pipelineLogger.logFailureAndUpdateStatus("failed to prepare the nginx config", errPrepareNginxConfig)
+		return false
+	}
+
+	// ===== Stop and remove any existing container for this slug
+
+	// this should be a no-op for new deployments (no container exists yet).
+	// for GitHub redeploys, this replaces the currently running container.
+	// StopAndRemoveContainer is idempotent, returns nil if the container does not exist.
+	containerStartStep := pipelineLogger.startStep(stepContainerStart, map[string]any{"container_name": containerName})
+	pipelineLogger.logInfo("stopping existing container if present: %s", containerName)
+	errStopAndRemoveContainer := deployerPipeline.dockerClient.StopAndRemoveContainer(containerStartStep.context, containerName)
+	if errStopAndRemoveContainer != nil {
+		pipelineLogger.logFailureAndUpdateStatus("failed to remove existing container", errStopAndRemoveContainer)
+		return false
+	}
+
+	// ===== Starting the Nginx container
+	pipelineLogger.logInfo("starting nginx container: %s", containerName)
+	nginxContainerConfig.HostNginxConfigFile = nginxConfigPath
+	errCreateAndStartNginxContainer := deployerPipeline.dockerClient.CreateAndStartNginxContainer(containerStartStep.context, nginxContainerConfig)
+	if errCreateAndStartNginxContainer != nil {
+		pipelineLogger.logFailureAndUpdateStatus("failed to start nginx container", errCreateAndStartNginxContainer)
+		return false
+	}
+	containerStartStep.finish(nil)
+	pipelineLogger.logInfo("nginx container started successfully")
+
+	return true
+}
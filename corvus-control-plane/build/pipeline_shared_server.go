@@ -0,0 +1,129 @@
+package build
+
+// pipeline_shared_server.go connects the pipeline to the shared serving mode (shared_static_server.go):
+// publishing a deployment's site when it goes live, and publishing every live deployment again
+// on startup, the shared static server only keeps its sites in memory.
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+)
+
+// loadSharedSite reads the deployment's served-site settings and rules files, the same
+// loadNginxSiteConfig the container mode renders its nginx config from, and compiles the rules.
+// the site also takes the deployment's access control: basic auth credentials and allowed IP ranges.
+func (deployerPipeline *DeployerPipeline) loadSharedSite(servedDirectory string, deployment *models.Deployment) (*sharedSite, error) {
+	directoryInfo, err := os.Stat(servedDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat served directory: %w", err)
+	}
+	if !directoryInfo.IsDir() {
+		return nil, fmt.Errorf("served directory %q is not a directory", servedDirectory)
+	}
+	siteConfig, err := loadNginxSiteConfig(servedDirectory, deployment)
+	if err != nil {
+		return nil, err
+	}
+	site, err := newSharedSite(servedDirectory, siteConfig)
+	if err != nil {
+		return nil, err
+	}
+	if deployment.BasicAuthUsername != nil && deployment.BasicAuthPasswordHash != nil {
+		site.basicAuthUsername = *deployment.BasicAuthUsername
+		site.basicAuthPasswordHash = []byte(*deployment.BasicAuthPasswordHash)
+		site.basicAuthRealm = deployment.Slug
+	}
+	site.allowedIPRanges, err = deployerPipeline.allowedIPRanges(deployment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve ip access lists: %w", err)
+	}
+	return site, nil
+}
+
+// publishToSharedStaticServer swaps the site served for the deployment's slug.
+// servedDirectory is this run's own directory (served_directory.go), the previous version keeps
+// serving from its directory until the new site is fully loaded and published. a rule that cannot be
+// applied fails the deploy before anything changes (the equivalent of the container mode's `nginx -t`).
+// a container left from the container mode is removed afterwards, so Traefik does not keep
+// routing the slug to it instead of the shared static server.
+func (deployerPipeline *DeployerPipeline) publishToSharedStaticServer(
+	deployment *models.Deployment,
+	servedDirectory string,
+	pipelineLogger *deployerPipelineLogger,
+) error {
+	publishStep := pipelineLogger.startStep(stepPublish, map[string]any{
+		"spa_fallback":   deployment.SPAFallback,
+		"custom_404":     deployment.Custom404,
+		"clean_urls":     deployment.CleanURLs,
+		"trailing_slash": deployment.TrailingSlash,
+	})
+
+	site, err := deployerPipeline.loadSharedSite(servedDirectory, deployment)
+	if err != nil {
+		return err
+	}
+	if len(site.redirectRules) > 0 {
+		pipelineLogger.logInfo("%s: %d rules", redirectsFileName, len(site.redirectRules))
+	}
+	if len(site.headerRules) > 0 {
+		pipelineLogger.logInfo("%s: %d path blocks", headersFileName, len(site.headerRules))
+	}
+
+	deployerPipeline.sharedStaticServer.publish(deployment.Slug, site)
+	pipelineLogger.logInfo("site published to the shared static server")
+
+	// StopAndRemoveContainer is idempotent, a no-op for every deployment made in the shared mode
+	containerName := "deploy-" + deployment.Slug
+	if err := deployerPipeline.dockerClient.StopAndRemoveContainer(publishStep.context, containerName); err != nil {
+		pipelineLogger.logInfo("warning: failed to remove the old nginx container %s (non-fatal): %v", containerName, err)
+	}
+
+	publishStep.finish(map[string]any{
+		"redirect_rules": len(site.redirectRules),
+		"header_rules":   len(site.headerRules),
+	})
+	return nil
+}
+
+// RestoreSharedStaticSites publishes every live deployment to the shared static server.
+// called once from main.go in the shared serving mode, before the server starts listening.
+// a deployment whose files or rules can no longer be loaded is logged and skipped,
+// the others still come back (one broken _redirects file must not take every site down).
+func (deployerPipeline *DeployerPipeline) RestoreSharedStaticSites() error {
+	deployments, err := deployerPipeline.database.ListDeployments()
+	if err != nil {
+		return fmt.Errorf("failed to list deployments: %w", err)
+	}
+
+	restoredCount := 0
+	for _, deployment := range deployments {
+		if deployment.Status != models.StatusLive {
+			continue
+		}
+		servedDirectory, err := deployerPipeline.liveServedDirectory(deployment.Slug)
+		if err != nil {
+			deployerPipeline.logger.Error("failed to find the live directory for the shared static server",
+				"id", deployment.ID,
+				"slug", deployment.Slug,
+				"error", err,
+			)
+			continue
+		}
+		site, err := deployerPipeline.loadSharedSite(servedDirectory, deployment)
+		if err != nil {
+			deployerPipeline.logger.Error("failed to restore site on the shared static server",
+				"id", deployment.ID,
+				"slug", deployment.Slug,
+				"error", err,
+			)
+			continue
+		}
+		deployerPipeline.sharedStaticServer.publish(deployment.Slug, site)
+		restoredCount++
+	}
+
+	deployerPipeline.logger.Info("shared static server sites restored", "count", restoredCount)
+	return nil
+}
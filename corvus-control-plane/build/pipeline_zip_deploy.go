@@ -2,33 +2,39 @@ package build
 
 import (
 	"context"
-	"io"
 	"os"
 	"path/filepath"
+	"time"
 
-	"github.com/sasta-kro/corvus-paas/corvus-control-plane/docker"
 	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
 )
 
 // DeployZipUpload runs the full zip deployment pipeline for the given deployment.
 // It is designed to be called as a goroutine from the HTTP handler.
-// uploadedFile is an io.Reader over the raw zip bytes from the multipart upload from the user
+// stagedUpload is the archive the handler already streamed to disk (see StageUpload),
+// this function takes ownership of it and removes the staging file on every exit path.
+// despite the name (source_type "zip"), the archive can be a zip, tar, tar.gz or tar.zst,
+// the format is detected from the content (see archive_extract.go).
 //
 // pipeline steps:
 //   - open log file for this deployment
 //   - set status to "deploying"
-//   - write uploaded zip bytes to a temp file on disk
-//   - extract the zip to a temp working directory
+//   - record the upload (size, sha256, receive time) as the receive_upload step
+//   - extract the archive to a temp working directory
 //   - hand off to deployToNginx (shared steps: validate output dir, copy to asset storage, start nginx)
+//
+// requestContext is only used to link the pipeline trace to the request trace. the pipeline
+// runs on a background context (see newDeployerPipelineLogger) because the HTTP request context
+// would be cancelled the moment the handler returns, which would cancel all Docker SDK calls mid-flight.
 func (deployerPipeline *DeployerPipeline) DeployZipUpload(
+	requestContext context.Context,
 	deployment *models.Deployment,
-	uploadedFile io.ReadCloser,
+	stagedUpload *StagedUpload,
 ) {
-	// a background context is used for the deployerPipeline goroutine.
-	// the HTTP request context would be cancelled the moment the handler returns,
-	// which would cancel all Docker SDK calls mid-flight.
-	// the deployerPipeline must outlive the HTTP request.
-	deployContext := context.Background()
+	// the staging file is removed here (not in the HTTP handler) because the handler
+	// returns immediately after launching this goroutine. A defer in the handler would
+	// delete the file while this goroutine is still extracting it.
+	defer stagedUpload.Remove()
 
 	// opening the log file for the current deployment (each deployment has its own log file)
 	// all deployerPipeline steps write to this log so (TODO) build output is preserved for v2 streaming.
@@ -46,59 +52,29 @@ func (deployerPipeline *DeployerPipeline) DeployZipUpload(
 		defer logFile.Close()
 	}
 
-	// the uploaded file is closed here (not in the HTTP handler) because the handler
-	// returns immediately after launching this goroutine. A defer in the handler would
-	// close the file while this goroutine is still reading from it.
-	defer uploadedFile.Close()
-
 	// setting up the helper logger struct to log to both slog and log file
-	pipelineLogger := &deployerPipelineLogger{
-		pipeline:   deployerPipeline,
-		deployment: deployment,
-		logFile:    logFile,
-	}
+	pipelineLogger := newDeployerPipelineLogger(requestContext, deployerPipeline, deployment, logFile)
 
 	pipelineLogger.logInfo("Pipeline started for zip deployment %q (slug: %s)", deployment.Name, deployment.Slug)
 
 	// ===== Set status as deploying
 	// status was set to "deploying" at record creation. refreshing here again
 	// handles the redeploy case where a previous run left the status as "live" or "failed".
-	errUpdateStatus := deployerPipeline.database.UpdateStatus(deployment.ID, models.StatusDeploying)
+	errUpdateStatus := deployerPipeline.setStatusDeploying(deployment.ID)
 	if errUpdateStatus != nil {
 		pipelineLogger.logFailureAndUpdateStatus("failed to update status to deploying", errUpdateStatus)
 		return
 	}
 
-	// ===== Write the uploaded zip bytes to a temp zip file on disk
-
-	// os.CreateTemp() creates a new file in the OS default temp directory with a unique name.
-	// the uploaded zip bytes are written to this file creating a .zip file, then the file is passed to
-	// ExtractZipUpload which reads it for extraction. The file is removed via defer after extraction completes.
-	tempZipFileForExtraction, errCreateTempZipFile := os.CreateTemp("", "corvus-upload-*.zip") // `*` is where the random string will be
-	if errCreateTempZipFile != nil {
-		pipelineLogger.logFailureAndUpdateStatus("failed to create temp zip file for zip upload", errCreateTempZipFile)
-		return
-	}
-	// defer removal of the temp zip file so it is cleaned up on any exit path.
-	// the file is closed inside the copy block below before extraction begins.
-	defer os.Remove(tempZipFileForExtraction.Name())
-
-	pipelineLogger.logInfo("writing uploaded zip to temp file: %s", tempZipFileForExtraction.Name())
-
-	// io.Copy streams the uploaded bytes from the request body into the temp zip file.
-	// this avoids loading the entire zip into memory.
-	_, errCopyUploadedZipFileToDisk := io.Copy(tempZipFileForExtraction, uploadedFile)
-	if errCopyUploadedZipFileToDisk != nil {
-		tempZipFileForExtraction.Close()
-		pipelineLogger.logFailureAndUpdateStatus("failed to write uploaded zip to disk", errCopyUploadedZipFileToDisk)
-		return
-	}
-	// close the file before passing its path to the zip extractor.
-	// the extractor opens it fresh for reading. Leaving it open for writing
-	// would cause a file descriptor conflict on some OS/filesystem combinations.
-	tempZipFileForExtraction.Close()
+	// ===== Record the upload
+	// the bytes were already streamed to the staging file by the handler (one disk write, hashed on the way),
+	// the step is back-dated to when the upload started so its duration is the real receive time.
+	receiveUploadStep := pipelineLogger.startStepAt(stepReceiveUpload, stagedUpload.ReceiveStartedAt, nil)
+	pipelineLogger.logInfo("received upload: %d bytes in %s, sha256 %s",
+		stagedUpload.SizeBytes, stagedUpload.ReceiveDuration.Round(time.Millisecond), stagedUpload.SHA256)
+	receiveUploadStep.finish(map[string]any{"bytes": stagedUpload.SizeBytes, "sha256": stagedUpload.SHA256})
 
-	// ===== Extracting the zip to a temp working directory
+	// ===== Extracting the archive to a temp working directory
 	// the working directory name includes the deployment ID for traceability.
 	tempWorkingDir := filepath.Join(os.TempDir(), "corvus-build-"+deployment.ID)
 	defer func() { // clean up the working directory on any exit path
@@ -111,34 +87,33 @@ func (deployerPipeline *DeployerPipeline) DeployZipUpload(
 		}
 	}()
 
-	pipelineLogger.logInfo("extracting zip to working directory: %s", tempWorkingDir)
-	errExtractingZipUpload := ExtractZipUpload(tempZipFileForExtraction.Name(), tempWorkingDir)
+	pipelineLogger.logInfo("extracting archive to working directory: %s", tempWorkingDir)
+	extractStep := pipelineLogger.startStep(stepExtract, nil)
+	detectedArchiveFormat, errExtractingZipUpload := ExtractArchiveUpload(stagedUpload.Path, tempWorkingDir, deployerPipeline.archiveLimits)
 	if errExtractingZipUpload != nil {
-		pipelineLogger.logFailureAndUpdateStatus("failed to extract zip archive", errExtractingZipUpload)
+		pipelineLogger.logFailureAndUpdateStatus("failed to extract uploaded archive", errExtractingZipUpload)
 		return
 	}
-	pipelineLogger.logInfo("zip extracted successfully")
+	extractStep.finish(map[string]any{"format": detectedArchiveFormat})
+	pipelineLogger.logInfo("%s archive extracted successfully", detectedArchiveFormat)
 
 	deployerPipeline.deployToNginx(
-		deployContext,
 		deployment,
 		tempWorkingDir,
 		pipelineLogger,
 	)
 }
 
-// RedeployExistingZip re-creates the Nginx container for an existing deployment
-// using the files already present in the asset storage root.
+// RedeployExistingZip serves an existing deployment again (re-creates its Nginx container, or publishes it
+// to the shared static server) using the files already present in the asset storage root.
 // used for zip redeployments where the original upload no longer exists,
 // and the only copy of the static files is the deployed directory.
 // for github source type, the full clone+build pipeline runs instead (Phase 4).
 //
 // This method doesn't use deployToNginx helper because it does not copy files (they already exist),
-// so it only shares the container stop/start/status update, which is only three steps
-// and not worth extracting into a separate method.
-func (deployerPipeline *DeployerPipeline) RedeployExistingZip(deployment *models.Deployment) {
-	redeployContext := context.Background()
-
+// it only shares the serving step (serveDeployment) and the status update.
+// requestContext is only used to link the pipeline trace to the request trace (same as DeployZipUpload).
+func (deployerPipeline *DeployerPipeline) RedeployExistingZip(requestContext context.Context, deployment *models.Deployment) {
 	logFile, errOpenLogFile := deployerPipeline.openLogFileForCurrentDeployment(deployment.Slug)
 	if errOpenLogFile != nil {
 		deployerPipeline.logger.Error("failed to open deployment log file for redeploy",
@@ -151,55 +126,37 @@ func (deployerPipeline *DeployerPipeline) RedeployExistingZip(deployment *models
 	}
 
 	// setting up the helper logger struct to log to both slog and log file
-	pipelineLogger := &deployerPipelineLogger{
-		pipeline:   deployerPipeline,
-		deployment: deployment,
-		logFile:    logFile,
-	}
+	pipelineLogger := newDeployerPipelineLogger(requestContext, deployerPipeline, deployment, logFile)
 	pipelineLogger.logInfo("redeploy started for deployment %q (slug: %s)", deployment.Name, deployment.Slug)
 
 	// set status to deploying
-	if err := deployerPipeline.database.UpdateStatus(deployment.ID, models.StatusDeploying); err != nil {
+	if err := deployerPipeline.setStatusDeploying(deployment.ID); err != nil {
 		pipelineLogger.logFailureAndUpdateStatus("failed to update status to deploying", err)
 		return
 	}
 
 	// (zip only) verify the extracted zip files still exist on disk
-	deploymentDir := filepath.Join(deployerPipeline.assetStorageRoot, deployment.Slug)
-	if _, err := os.Stat(deploymentDir); os.IsNotExist(err) {
-		pipelineLogger.logFailureAndUpdateStatus("deployment files not found on disk, cannot redeploy", err)
+	deploymentDir, errLiveDirectory := deployerPipeline.liveServedDirectory(deployment.Slug)
+	if errLiveDirectory != nil {
+		pipelineLogger.logFailureAndUpdateStatus("failed to find the deployment files", errLiveDirectory)
 		return
 	}
-
-	// stop and remove the old container
-	containerName := "deploy-" + deployment.Slug
-	pipelineLogger.logInfo("stopping existing container: %s", containerName)
-	errRemoveContainer := deployerPipeline.dockerClient.StopAndRemoveContainer(redeployContext, containerName)
-	if errRemoveContainer != nil {
-		pipelineLogger.logFailureAndUpdateStatus("failed to remove existing container", errRemoveContainer)
+	if _, err := os.Stat(deploymentDir); os.IsNotExist(err) {
+		pipelineLogger.logFailureAndUpdateStatus("deployment files not found on disk, cannot redeploy", err)
 		return
 	}
 
-	// start a new container pointing to the same files
-	pipelineLogger.logInfo("starting nginx container: %s", containerName)
-	errStartNginxContainer := deployerPipeline.dockerClient.CreateAndStartNginxContainer(
-		redeployContext,
-		docker.NginxContainerConfig{
-			ContainerName:       containerName,
-			Slug:                deployment.Slug,
-			HostSourceDirectory: deploymentDir,
-			TraefikNetwork:      deployerPipeline.traefikNetwork,
-		},
-	)
-	if errStartNginxContainer != nil {
-		pipelineLogger.logFailureAndUpdateStatus("failed to start nginx container", errStartNginxContainer)
+	// the same files are served again: the shared static server publishes them with their settings and rules loaded again,
+	// in the container mode the nginx config is rendered again from the _redirects / _headers files still in deploymentDir
+	// (so a config written by an older version of the control plane is brought up to date) and the container replaced.
+	// corvus-env.js was already written into deploymentDir by the original deploy.
+	if !deployerPipeline.serveDeployment(deployment, deploymentDir, pipelineLogger) {
 		return
 	}
-	pipelineLogger.logInfo("nginx container started successfully")
 
 	// update status to live
 	if err := deployerPipeline.database.UpdateStatus(deployment.ID, models.StatusLive); err != nil {
-		deployerPipeline.logger.Error("container is live but failed to update status after redeploy",
+		deployerPipeline.logger.Error("site is live but failed to update status after redeploy",
 			"id", deployment.ID,
 			"slug", deployment.Slug,
 			"error", err,
@@ -207,6 +164,8 @@ func (deployerPipeline *DeployerPipeline) RedeployExistingZip(deployment *models
 		return
 	}
 
+	pipelineLogger.finishRun()
+
 	// TODO fix url hardcode here
 	pipelineLogger.logInfo("redeploy complete. site is live at https://%s-corvus.sasta.dev", deployment.Slug)
 	deployerPipeline.logger.Info("redeploy live",
@@ -0,0 +1,256 @@
+package build
+
+// precompress.go writes gzip (.gz) and brotli (.br) siblings of the compressible files of a served
+// directory at deploy time (app.js -> app.js.gz, app.js.br), so nginx sends the compressed bytes
+// straight from disk instead of compressing on every request (it does not compress at all by default).
+//
+//   - .gz siblings are served by nginx's gzip_static
+//   - .br siblings are picked by a rewrite in the generated server config, the nginx:alpine image
+//     has no brotli module (see nginx_config.go)
+//
+// compression runs once per deploy with the best ratios, at a CPU cost no per-request compression could afford.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	// precompressMinFileBytes skips small files, below ~1KB the compressed response
+	// saves less than a packet and the extra sibling files only cost disk space
+	precompressMinFileBytes = 1024
+
+	// precompressMaxFileBytes skips huge files (a 100MB JSON dump), brotli at a high quality
+	// would hold up the deploy for minutes for one file
+	precompressMaxFileBytes = 32 << 20 // 32MB
+
+	// brotliQuality 9 of 11. 10 and 11 compress a few percent better at ~10x the time,
+	// which a large JavaScript bundle turns into a noticeably slower deploy
+	brotliQuality = 9
+
+	// precompressMinSavingsRatio is how much smaller a compressed sibling must be to be kept.
+	// a file that only shrinks by a few percent (already minified and dense) is not worth the decompression
+	precompressMinSavingsRatio = 0.9
+
+	// maxPrecompressWorkers caps the worker pool, the control plane shares the VM with the build containers
+	maxPrecompressWorkers = 4
+)
+
+// precompressedContentTypes maps the extensions that get compressed siblings to their content type.
+// the .br locations of the nginx config serve each sibling with its original file's type,
+// so this list is also what nginx_config.go renders those locations from.
+// images, fonts other than ttf/otf, video and archives are already compressed and left alone.
+var precompressedContentTypes = map[string]string{
+	".html":        "text/html",
+	".htm":         "text/html",
+	".css":         "text/css",
+	".js":          "application/javascript",
+	".mjs":         "application/javascript",
+	".json":        "application/json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".xml":         "text/xml",
+	".svg":         "image/svg+xml",
+	".txt":         "text/plain",
+	".wasm":        "application/wasm",
+	".ico":         "image/x-icon",
+	".ttf":         "font/ttf",
+	".otf":         "font/otf",
+}
+
+// precompressStats is the outcome of precompressStaticAssets, reported in the deployment log.
+type precompressStats struct {
+	files         int   // files that got at least one sibling
+	originalBytes int64 // size of those files
+	gzipBytes     int64 // size of their .gz siblings
+	brotliBytes   int64 // size of their .br siblings
+}
+
+// precompressedFile is the result of one worker job.
+type precompressedFile struct {
+	originalBytes int64
+	gzipBytes     int64
+	brotliBytes   int64
+	err           error
+}
+
+// precompressStaticAssets writes .gz and .br siblings for every compressible file of servedDirectory,
+// using a small pool of workers. siblings already produced by the site's own build are kept as they are.
+// every file is still attempted when one fails, the first error is returned with the stats of the rest.
+func precompressStaticAssets(servedDirectory string) (precompressStats, error) {
+	var stats precompressStats
+
+	var candidatePaths []string
+	errWalk := filepath.WalkDir(servedDirectory, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		// Type().IsRegular() is false for symlinks, the served copy has none (util.CopyDirectory rejects them)
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		if _, compressible := precompressedContentTypes[strings.ToLower(filepath.Ext(path))]; !compressible {
+			return nil
+		}
+		candidatePaths = append(candidatePaths, path)
+		return nil
+	})
+	if errWalk != nil {
+		return stats, fmt.Errorf("failed to walk served directory: %w", errWalk)
+	}
+
+	workerCount := min(runtime.NumCPU(), maxPrecompressWorkers, max(len(candidatePaths), 1))
+	jobs := make(chan string)
+	results := make(chan precompressedFile)
+
+	var workers sync.WaitGroup
+	for range workerCount {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for path := range jobs {
+				results <- precompressFile(path)
+			}
+		}()
+	}
+
+	// feed the jobs and close results once every worker is done, so the loop below ends
+	go func() {
+		for _, path := range candidatePaths {
+			jobs <- path
+		}
+		close(jobs)
+		workers.Wait()
+		close(results)
+	}()
+
+	var firstError error
+	for result := range results {
+		if result.err != nil {
+			if firstError == nil {
+				firstError = result.err
+			}
+			continue
+		}
+		if result.gzipBytes == 0 && result.brotliBytes == 0 {
+			continue
+		}
+		stats.files++
+		stats.originalBytes += result.originalBytes
+		stats.gzipBytes += result.gzipBytes
+		stats.brotliBytes += result.brotliBytes
+	}
+	return stats, firstError
+}
+
+// precompressFile writes the .gz and .br siblings of one file.
+// a file outside the size range, or that does not compress well enough, gets no siblings (zero sizes).
+// the sizes of a skipped encoding count as the original size, so the savings are not overstated.
+func precompressFile(path string) precompressedFile {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return precompressedFile{err: fmt.Errorf("failed to stat %q: %w", path, err)}
+	}
+	if fileInfo.Size() < precompressMinFileBytes || fileInfo.Size() > precompressMaxFileBytes {
+		return precompressedFile{}
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return precompressedFile{err: fmt.Errorf("failed to read %q: %w", path, err)}
+	}
+
+	result := precompressedFile{originalBytes: int64(len(content))}
+
+	gzipBytes, err := writeCompressedSibling(path+".gz", content, func(buffer *bytes.Buffer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(buffer, gzip.BestCompression)
+	})
+	if err != nil {
+		return precompressedFile{err: err}
+	}
+	brotliBytes, err := writeCompressedSibling(path+".br", content, func(buffer *bytes.Buffer) (io.WriteCloser, error) {
+		return brotli.NewWriterLevel(buffer, brotliQuality), nil
+	})
+	if err != nil {
+		return precompressedFile{err: err}
+	}
+	if gzipBytes == 0 && brotliBytes == 0 {
+		return precompressedFile{}
+	}
+
+	result.gzipBytes = gzipBytes
+	if gzipBytes == 0 {
+		result.gzipBytes = result.originalBytes
+	}
+	result.brotliBytes = brotliBytes
+	if brotliBytes == 0 {
+		result.brotliBytes = result.originalBytes
+	}
+	return result
+}
+
+// writeCompressedSibling compresses content and writes it to siblingPath, returns the compressed size.
+// returns 0 (nothing written) when siblingPath already exists (the build produced it, it wins)
+// or when the compressed content is not at least precompressMinSavingsRatio smaller.
+func writeCompressedSibling(
+	siblingPath string,
+	content []byte,
+	newCompressWriter func(buffer *bytes.Buffer) (io.WriteCloser, error),
+) (int64, error) {
+	if _, err := os.Lstat(siblingPath); err == nil {
+		return 0, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("failed to stat %q: %w", siblingPath, err)
+	}
+
+	var compressed bytes.Buffer
+	writer, err := newCompressWriter(&compressed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create compressor for %q: %w", siblingPath, err)
+	}
+	if _, err := writer.Write(content); err != nil {
+		return 0, fmt.Errorf("failed to compress %q: %w", siblingPath, err)
+	}
+	if err := writer.Close(); err != nil {
+		return 0, fmt.Errorf("failed to compress %q: %w", siblingPath, err)
+	}
+
+	if float64(compressed.Len()) > float64(len(content))*precompressMinSavingsRatio {
+		return 0, nil
+	}
+	// 0644, nginx in the container reads it as a different user than the control plane
+	if err := os.WriteFile(siblingPath, compressed.Bytes(), 0o644); err != nil {
+		return 0, fmt.Errorf("failed to write %q: %w", siblingPath, err)
+	}
+	return int64(compressed.Len()), nil
+}
+
+// savingsPercent is how much smaller compressedBytes is than originalBytes, in percent
+func savingsPercent(originalBytes int64, compressedBytes int64) float64 {
+	if originalBytes == 0 {
+		return 0
+	}
+	return 100 * (1 - float64(compressedBytes)/float64(originalBytes))
+}
+
+// formatByteSize formats a byte count for the deployment log (eg, "1.4MB")
+func formatByteSize(byteCount int64) string {
+	switch {
+	case byteCount >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(byteCount)/(1<<20))
+	case byteCount >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(byteCount)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", byteCount)
+	}
+}
@@ -0,0 +1,331 @@
+package build
+
+// preset_templates.go fills a prebuilt preset's template variables into the served copy of its files.
+// a preset declares its variables and the files that contain their placeholders in preset.json:
+//
+//	"template_variables": [{"name": "EVENT_TITLE", "type": "string", "max_length": 80, "required": true}],
+//	"template_files": ["index.html", "event.json"]
+//
+// every {{EVENT_TITLE}} in those files is replaced with the user's value, escaped for the file it
+// lands in (see templateEscapeForFile). the preset directory itself is never modified, only the
+// copy in the deployment's asset directory, before the container starts serving it.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+)
+
+// maxPresetTemplateValueLength caps any template value (in characters), also when the manifest sets no max_length
+const maxPresetTemplateValueLength = 2000
+
+// maxPresetTemplateFileBytes caps a template file, placeholders are substituted in memory
+const maxPresetTemplateFileBytes = 5 << 20 // 5MB
+
+// the types a template variable can declare, "" in the manifest means presetTemplateTypeString
+const (
+	presetTemplateTypeString = "string" // one line of text
+	presetTemplateTypeText   = "text"   // multi-line text
+	presetTemplateTypeURL    = "url"    // an absolute http(s) URL
+	presetTemplateTypeEmail  = "email"  // a bare address, no display name
+	presetTemplateTypeDate   = "date"   // YYYY-MM-DD
+	presetTemplateTypeColor  = "color"  // #rrggbb
+)
+
+// presetTemplateColorPattern is the accepted shape of a color value
+var presetTemplateColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// templateEscape is how a value is escaped for the file its placeholder is in.
+type templateEscape int
+
+const (
+	// templateEscapeHTML escapes <, >, &, ' and ", safe in element text and quoted attributes
+	templateEscapeHTML templateEscape = iota
+	// templateEscapeJSON escapes the value as the inside of a JSON string (the placeholder sits
+	// between quotes, "{{NAME}}"). encoding/json also escapes <, > and &, so the same value is
+	// safe inside an inline <script> as well.
+	templateEscapeJSON
+)
+
+// templateEscapeForFile picks the escaping of a template file from its extension.
+// files of any other type cannot be template files, there is no safe escaping to pick for them.
+func templateEscapeForFile(templateFile string) (templateEscape, error) {
+	switch strings.ToLower(filepath.Ext(templateFile)) {
+	case ".html", ".htm", ".svg", ".xml":
+		return templateEscapeHTML, nil
+	case ".json", ".webmanifest", ".js", ".mjs":
+		return templateEscapeJSON, nil
+	default:
+		return 0, fmt.Errorf("template file %q: only .html, .htm, .svg, .xml, .json, .webmanifest, .js and .mjs files can contain template variables", templateFile)
+	}
+}
+
+// escapeTemplateValue escapes one value for the given context.
+func escapeTemplateValue(value string, escape templateEscape) string {
+	if escape == templateEscapeHTML {
+		return html.EscapeString(value)
+	}
+	// a JSON encoded string minus its surrounding quotes. Marshal of a string cannot fail.
+	encodedValue, _ := json.Marshal(value)
+	return string(encodedValue[1 : len(encodedValue)-1])
+}
+
+// validatePresetTemplateVariableDefinition checks one entry of template_variables when the manifest is loaded.
+func validatePresetTemplateVariableDefinition(templateVariable *PresetTemplateVariable) error {
+	if templateVariable.Type == "" {
+		templateVariable.Type = presetTemplateTypeString
+	}
+	switch templateVariable.Type {
+	case presetTemplateTypeString, presetTemplateTypeText, presetTemplateTypeURL,
+		presetTemplateTypeEmail, presetTemplateTypeDate, presetTemplateTypeColor:
+	default:
+		return fmt.Errorf("template variable %q has unknown type %q", templateVariable.Name, templateVariable.Type)
+	}
+	if templateVariable.MaxLength < 0 || templateVariable.MaxLength > maxPresetTemplateValueLength {
+		return fmt.Errorf("template variable %q: max_length must be between 0 and %d", templateVariable.Name, maxPresetTemplateValueLength)
+	}
+	// the default goes through the same checks as a user value, a broken default would break every deployment
+	if templateVariable.Default != "" {
+		if err := validatePresetTemplateValue(templateVariable, templateVariable.Default); err != nil {
+			return fmt.Errorf("default of %w", err)
+		}
+	}
+	return nil
+}
+
+// validatePresetTemplateFile checks one entry of template_files when the manifest is loaded
+// and returns it cleaned.
+func validatePresetTemplateFile(presetDirectory string, rawTemplateFile string) (string, error) {
+	templateFile, err := ValidateRelativeDirectory("template file", rawTemplateFile)
+	if err != nil {
+		return "", err
+	}
+	if _, err := templateEscapeForFile(templateFile); err != nil {
+		return "", err
+	}
+	// Lstat, the file is rewritten in the deployment's copy and a symlink there would be followed
+	templateFileInfo, err := os.Lstat(filepath.Join(presetDirectory, templateFile))
+	if err != nil {
+		return "", fmt.Errorf("template file %q not found: %w", rawTemplateFile, err)
+	}
+	if !templateFileInfo.Mode().IsRegular() {
+		return "", fmt.Errorf("template file %q is not a regular file", rawTemplateFile)
+	}
+	if templateFileInfo.Size() > maxPresetTemplateFileBytes {
+		return "", fmt.Errorf("template file %q is larger than %d bytes", rawTemplateFile, maxPresetTemplateFileBytes)
+	}
+	return templateFile, nil
+}
+
+// validatePresetTemplateValue checks a (non-empty) value against its variable's type and length.
+// the error message names the variable but never echoes the value.
+func validatePresetTemplateValue(templateVariable *PresetTemplateVariable, value string) error {
+	if !utf8.ValidString(value) {
+		return fmt.Errorf("template variable %q is not valid UTF-8", templateVariable.Name)
+	}
+
+	maxLength := maxPresetTemplateValueLength
+	if templateVariable.MaxLength > 0 {
+		maxLength = templateVariable.MaxLength
+	}
+	if utf8.RuneCountInString(value) > maxLength {
+		return fmt.Errorf("template variable %q is longer than %d characters", templateVariable.Name, maxLength)
+	}
+
+	for _, character := range value {
+		if character == '\n' && templateVariable.Type == presetTemplateTypeText {
+			continue
+		}
+		if unicode.IsControl(character) && character != '\t' {
+			return fmt.Errorf("template variable %q contains control characters (line breaks only work with type \"text\")", templateVariable.Name)
+		}
+	}
+
+	switch templateVariable.Type {
+	case presetTemplateTypeURL:
+		parsedURL, err := url.Parse(value)
+		// http(s) only: a javascript: URL in an href is script execution, escaping does not help there
+		if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+			return fmt.Errorf("template variable %q must be an absolute http:// or https:// URL", templateVariable.Name)
+		}
+	case presetTemplateTypeEmail:
+		parsedAddress, err := mail.ParseAddress(value)
+		if err != nil || parsedAddress.Address != value {
+			return fmt.Errorf("template variable %q must be a plain email address", templateVariable.Name)
+		}
+	case presetTemplateTypeDate:
+		if _, err := time.Parse(time.DateOnly, value); err != nil {
+			return fmt.Errorf("template variable %q must be a date in YYYY-MM-DD format", templateVariable.Name)
+		}
+	case presetTemplateTypeColor:
+		if !presetTemplateColorPattern.MatchString(value) {
+			return fmt.Errorf("template variable %q must be a color in #rrggbb format", templateVariable.Name)
+		}
+	}
+	return nil
+}
+
+// ResolvePresetTemplateValues validates the user supplied values of a preset's template variables
+// and returns the complete set to store on the deployment: every declared variable, with the default
+// filled in for the ones the user left empty. unknown names, missing required values and values that
+// do not match their type are errors (safe to return to the client, values are never echoed).
+func ResolvePresetTemplateValues(manifest *PresetManifest, providedValues map[string]string) (map[string]string, error) {
+	declaredVariables := make(map[string]bool, len(manifest.TemplateVariables))
+	for _, templateVariable := range manifest.TemplateVariables {
+		declaredVariables[templateVariable.Name] = true
+	}
+	for name := range providedValues {
+		if !declaredVariables[name] {
+			return nil, fmt.Errorf("preset %q has no template variable %q", manifest.ID, name)
+		}
+	}
+
+	resolvedValues := make(map[string]string, len(manifest.TemplateVariables))
+	for index := range manifest.TemplateVariables {
+		templateVariable := &manifest.TemplateVariables[index]
+		value := providedValues[templateVariable.Name]
+		if templateVariable.Type == presetTemplateTypeText {
+			// browsers submit textarea line breaks as \r\n
+			value = strings.ReplaceAll(value, "\r\n", "\n")
+		} else {
+			value = strings.TrimSpace(value)
+		}
+		if value == "" {
+			value = templateVariable.Default
+		}
+		if value == "" {
+			if templateVariable.Required {
+				return nil, fmt.Errorf("template variable %q is required", templateVariable.Name)
+			}
+			resolvedValues[templateVariable.Name] = ""
+			continue
+		}
+		if err := validatePresetTemplateValue(templateVariable, value); err != nil {
+			return nil, err
+		}
+		resolvedValues[templateVariable.Name] = value
+	}
+	return resolvedValues, nil
+}
+
+// presetTemplateValuesForDeployment decodes the template values stored on a deployment.
+//
+// deployments created before template variables existed carried the "Your Message" text as the
+// build env var VITE_CORVUS_MESSAGE. for those (no stored template values) a variable NAME falls back
+// to the build env var VITE_NAME, so redeploying them keeps their message.
+func presetTemplateValuesForDeployment(deployment *models.Deployment, manifest *PresetManifest) (map[string]string, error) {
+	storedValues, err := decodeEnvVarsToMap(deployment.TemplateValues)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stored template values: %w", err)
+	}
+
+	providedValues := make(map[string]string)
+	if storedValues != nil {
+		// resolved again against the current manifest: a variable added to the preset since the
+		// deployment was created gets its default, one that was removed is dropped.
+		for _, templateVariable := range manifest.TemplateVariables {
+			if value, found := storedValues[templateVariable.Name]; found {
+				providedValues[templateVariable.Name] = value
+			}
+		}
+		return ResolvePresetTemplateValues(manifest, providedValues)
+	}
+
+	buildEnvVars, err := decodeEnvVarsToMap(deployment.EnvironmentVariables)
+	if err != nil {
+		return nil, err
+	}
+	for _, templateVariable := range manifest.TemplateVariables {
+		if value, found := buildEnvVars["VITE_"+templateVariable.Name]; found {
+			providedValues[templateVariable.Name] = value
+		}
+	}
+	return ResolvePresetTemplateValues(manifest, providedValues)
+}
+
+// renderPresetTemplateFiles substitutes the template values into the template files of the
+// served copy at servedDirectory. placeholders of variables without a value are replaced with "",
+// so a raw {{NAME}} never shows up on a live page.
+func renderPresetTemplateFiles(servedDirectory string, manifest *PresetManifest, values map[string]string) error {
+	for _, templateFile := range manifest.TemplateFiles {
+		escape, err := templateEscapeForFile(templateFile)
+		if err != nil {
+			return err
+		}
+
+		templateFilePath := filepath.Join(servedDirectory, templateFile)
+		// the copy was made from the validated preset file, Lstat again anyway since this path is written to
+		templateFileInfo, err := os.Lstat(templateFilePath)
+		if err != nil {
+			return fmt.Errorf("template file %q missing from the deployed copy: %w", templateFile, err)
+		}
+		if !templateFileInfo.Mode().IsRegular() {
+			return errors.New("template file " + templateFile + " is not a regular file")
+		}
+
+		content, err := os.ReadFile(templateFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to read template file %q: %w", templateFile, err)
+		}
+
+		// one pass with a Replacer, so a value that itself contains "{{OTHER}}" is not substituted again
+		replacements := make([]string, 0, 2*len(manifest.TemplateVariables))
+		for _, templateVariable := range manifest.TemplateVariables {
+			replacements = append(replacements,
+				"{{"+templateVariable.Name+"}}", escapeTemplateValue(values[templateVariable.Name], escape))
+		}
+		rendered := strings.NewReplacer(replacements...).Replace(string(content))
+
+		if err := os.WriteFile(templateFilePath, []byte(rendered), templateFileInfo.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to write template file %q: %w", templateFile, err)
+		}
+	}
+	return nil
+}
+
+// prepareServedPresetCopy turns the copied preset files at servedDirectory into the deployment's site:
+// preset.json is removed (registry metadata, not part of the site) and the template variables are filled in.
+// called by deployToNginx for prebuilt deployments, after the copy and before the container starts.
+func (deployerPipeline *DeployerPipeline) prepareServedPresetCopy(
+	deployment *models.Deployment,
+	servedDirectory string,
+	pipelineLogger *deployerPipelineLogger,
+) error {
+	errRemoveManifest := os.Remove(filepath.Join(servedDirectory, PresetManifestFileName))
+	if errRemoveManifest != nil && !errors.Is(errRemoveManifest, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove preset manifest from the asset directory: %w", errRemoveManifest)
+	}
+
+	if deployment.PresetID == nil {
+		return errors.New("prebuilt deployment has no preset_id")
+	}
+	preset, err := deployerPipeline.presetRegistry.Lookup(*deployment.PresetID)
+	if err != nil {
+		return err
+	}
+	if len(preset.Manifest.TemplateFiles) == 0 {
+		return nil
+	}
+
+	values, err := presetTemplateValuesForDeployment(deployment, &preset.Manifest)
+	if err != nil {
+		return err
+	}
+	if err := renderPresetTemplateFiles(servedDirectory, &preset.Manifest, values); err != nil {
+		return err
+	}
+	pipelineLogger.logInfo("template variables filled into %d file(s)", len(preset.Manifest.TemplateFiles))
+	return nil
+}
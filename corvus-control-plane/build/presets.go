@@ -0,0 +1,273 @@
+package build
+
+// presets.go holds the registry of prebuilt (one-click) presets.
+// every subdirectory of PRESET_STORAGE_ROOT that carries a preset.json manifest is a preset:
+//
+//	<presetStorageRoot>/<preset id>/preset.json   the manifest (id, title, thumbnail, template variables, ...)
+//	<presetStorageRoot>/<preset id>/index.html    the ready-to-serve site, same as before
+//
+// the registry is loaded once at startup. preset_id values coming from the API are only ever
+// looked up in it, never joined onto a path, so a preset_id like "../../etc" cannot reach the filesystem.
+// adding or changing a preset needs a restart of the control plane.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// PresetManifestFileName is the manifest file every preset directory must contain.
+// it is removed from the served copy of a prebuilt deployment (see deployToNginx).
+const PresetManifestFileName = "preset.json"
+
+// maxPresetManifestBytes caps the manifest read, it is a few hundred bytes of metadata
+const maxPresetManifestBytes = 64 << 10 // 64KB
+
+// maxPresetTemplateVariables caps template_variables per preset
+const maxPresetTemplateVariables = 20
+
+// ErrPresetNotFound is returned by PresetRegistry.Lookup for an unknown (or invalid) preset ID.
+var ErrPresetNotFound = errors.New("preset not found")
+
+// presetIDPattern is what a preset ID (and therefore its directory name) may look like:
+// lowercase letters, digits and single dashes, like a slug. no dots and no slashes,
+// so an ID can never be ".", ".." or a path.
+var presetIDPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// presetTemplateVariableNamePattern is the allowed shape of a template variable name, like an env var name
+var presetTemplateVariableNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// PresetManifest is the content of a preset's preset.json, also returned as-is by GET /api/presets.
+type PresetManifest struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+
+	// Thumbnail is an image file inside the preset directory (eg, "corvus.svg"),
+	// served by GET /api/presets/{id}/thumbnail. optional.
+	Thumbnail string `json:"thumbnail,omitempty"`
+
+	// Version is a free form version string of the preset content (eg, "1.2.0"), shown to users
+	Version string `json:"version"`
+
+	// TemplateVariables are the values a user fills in when deploying the preset (eg, the "Your Message" text)
+	TemplateVariables []PresetTemplateVariable `json:"template_variables"`
+
+	// TemplateFiles are the files (relative to the preset directory) whose {{NAME}} placeholders
+	// are replaced with the template values, see preset_templates.go
+	TemplateFiles []string `json:"template_files"`
+}
+
+// PresetTemplateVariable describes one user supplied value of a preset.
+type PresetTemplateVariable struct {
+	// Name is the placeholder name, uppercase like an env var (eg, "CORVUS_MESSAGE")
+	Name string `json:"name"`
+	// Type is one of string (default), text, url, email, date or color, see validatePresetTemplateValue
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required"`
+	Default     string `json:"default,omitempty"`
+	// MaxLength caps the value length in characters, 0 means no cap
+	MaxLength int `json:"max_length,omitempty"`
+}
+
+// Preset is one loaded preset: its manifest plus where its files are.
+type Preset struct {
+	Manifest PresetManifest
+
+	// Directory is the absolute path of the preset directory
+	Directory string
+}
+
+// PresetRegistry is the set of presets found under the preset storage root.
+// it is read-only after LoadPresetRegistry, so it is safe for concurrent use without locking.
+type PresetRegistry struct {
+	presetsByID map[string]*Preset
+}
+
+// ValidatePresetID checks the shape of a preset ID. it does not check that the preset exists,
+// that is PresetRegistry.Lookup's job.
+func ValidatePresetID(presetID string) error {
+	if len(presetID) > 63 || !presetIDPattern.MatchString(presetID) {
+		return fmt.Errorf("invalid preset id %q: use lowercase letters, digits and dashes", presetID)
+	}
+	return nil
+}
+
+// LoadPresetRegistry reads the manifest of every subdirectory of presetStorageRoot.
+//
+// a broken preset does not stop the control plane from starting, it is logged and left out of
+// the registry (it cannot be deployed). a directory without preset.json is not a preset and is skipped.
+// a missing presetStorageRoot gives an empty registry (prebuilt deployments are then unavailable),
+// which is the normal state of a local development machine.
+// only an unreadable presetStorageRoot is returned as an error.
+func LoadPresetRegistry(presetStorageRoot string, logger *slog.Logger) (*PresetRegistry, error) {
+	registry := &PresetRegistry{presetsByID: make(map[string]*Preset)}
+
+	absolutePresetStorageRoot, err := filepath.Abs(presetStorageRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve preset storage root %q: %w", presetStorageRoot, err)
+	}
+
+	entries, err := os.ReadDir(absolutePresetStorageRoot)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Warn("preset storage root does not exist, no presets available", "path", absolutePresetStorageRoot)
+		return registry, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read preset storage root %q: %w", absolutePresetStorageRoot, err)
+	}
+
+	for _, entry := range entries {
+		presetDirectory := filepath.Join(absolutePresetStorageRoot, entry.Name())
+		// presets are often symlinked into the storage root on the VM, so stat (follow) rather than entry.IsDir()
+		presetDirectoryInfo, err := os.Stat(presetDirectory)
+		if err != nil || !presetDirectoryInfo.IsDir() {
+			continue
+		}
+
+		manifest, err := readPresetManifest(presetDirectory)
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Warn("skipping preset directory without "+PresetManifestFileName, "path", presetDirectory)
+			continue
+		}
+		if err == nil {
+			err = validatePresetManifest(manifest, entry.Name(), presetDirectory)
+		}
+		if err != nil {
+			logger.Error("skipping invalid preset", "path", presetDirectory, "error", err)
+			continue
+		}
+
+		registry.presetsByID[manifest.ID] = &Preset{Manifest: *manifest, Directory: presetDirectory}
+	}
+
+	logger.Info("preset registry loaded", "path", absolutePresetStorageRoot, "presets", len(registry.presetsByID))
+	return registry, nil
+}
+
+// readPresetManifest decodes <presetDirectory>/preset.json.
+// a missing manifest returns the os.Open error as-is, so the caller can tell it apart with errors.Is(err, os.ErrNotExist).
+func readPresetManifest(presetDirectory string) (*PresetManifest, error) {
+	manifestFile, err := os.Open(filepath.Join(presetDirectory, PresetManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer manifestFile.Close()
+
+	var manifest PresetManifest
+	decoder := json.NewDecoder(io.LimitReader(manifestFile, maxPresetManifestBytes))
+	// a typo in a field name ("templte_variables") should fail loudly, not silently drop the field
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", PresetManifestFileName, err)
+	}
+	return &manifest, nil
+}
+
+// validatePresetManifest checks a decoded manifest against its directory.
+func validatePresetManifest(manifest *PresetManifest, directoryName string, presetDirectory string) error {
+	if err := ValidatePresetID(manifest.ID); err != nil {
+		return err
+	}
+	// the ID is the directory name, so the manifest cannot claim another preset's ID
+	if manifest.ID != directoryName {
+		return fmt.Errorf("manifest id %q does not match its directory name %q", manifest.ID, directoryName)
+	}
+	if strings.TrimSpace(manifest.Title) == "" {
+		return errors.New("manifest title is required")
+	}
+	if strings.TrimSpace(manifest.Version) == "" {
+		return errors.New("manifest version is required")
+	}
+
+	// the pipeline validates this again at deploy time, checking it here keeps a broken preset out of the listing
+	if _, err := os.Stat(filepath.Join(presetDirectory, "index.html")); err != nil {
+		return fmt.Errorf("preset has no index.html: %w", err)
+	}
+
+	if manifest.Thumbnail != "" {
+		thumbnail, err := ValidateRelativeDirectory("thumbnail", manifest.Thumbnail)
+		if err != nil {
+			return err
+		}
+		// Lstat, a symlinked thumbnail could point anywhere on the host and it is served by the API
+		thumbnailInfo, err := os.Lstat(filepath.Join(presetDirectory, thumbnail))
+		if err != nil {
+			return fmt.Errorf("thumbnail %q not found: %w", manifest.Thumbnail, err)
+		}
+		if !thumbnailInfo.Mode().IsRegular() {
+			return fmt.Errorf("thumbnail %q is not a regular file", manifest.Thumbnail)
+		}
+		manifest.Thumbnail = thumbnail
+	}
+
+	if len(manifest.TemplateVariables) > maxPresetTemplateVariables {
+		return fmt.Errorf("more than %d template variables", maxPresetTemplateVariables)
+	}
+	seenVariableNames := make(map[string]bool, len(manifest.TemplateVariables))
+	for index := range manifest.TemplateVariables {
+		templateVariable := &manifest.TemplateVariables[index]
+		if !presetTemplateVariableNamePattern.MatchString(templateVariable.Name) {
+			return fmt.Errorf("invalid template variable name %q: use uppercase letters, digits and underscores", templateVariable.Name)
+		}
+		if seenVariableNames[templateVariable.Name] {
+			return fmt.Errorf("duplicate template variable %q", templateVariable.Name)
+		}
+		seenVariableNames[templateVariable.Name] = true
+		if err := validatePresetTemplateVariableDefinition(templateVariable); err != nil {
+			return err
+		}
+	}
+
+	for index, rawTemplateFile := range manifest.TemplateFiles {
+		templateFile, err := validatePresetTemplateFile(presetDirectory, rawTemplateFile)
+		if err != nil {
+			return err
+		}
+		manifest.TemplateFiles[index] = templateFile
+	}
+
+	// encode as [] rather than null in the API response
+	if manifest.TemplateVariables == nil {
+		manifest.TemplateVariables = []PresetTemplateVariable{}
+	}
+	if manifest.TemplateFiles == nil {
+		manifest.TemplateFiles = []string{}
+	}
+	return nil
+}
+
+// Lookup returns the preset with the given ID.
+// the ID's shape is checked first, anything that is not a plain preset ID (a path, "..") is ErrPresetNotFound.
+// a nil registry has no presets.
+func (registry *PresetRegistry) Lookup(presetID string) (*Preset, error) {
+	if registry == nil || ValidatePresetID(presetID) != nil {
+		return nil, ErrPresetNotFound
+	}
+	preset, found := registry.presetsByID[presetID]
+	if !found {
+		return nil, ErrPresetNotFound
+	}
+	return preset, nil
+}
+
+// List returns the manifests of all presets, sorted by ID so the listing order is stable.
+func (registry *PresetRegistry) List() []PresetManifest {
+	if registry == nil {
+		return []PresetManifest{}
+	}
+	manifests := make([]PresetManifest, 0, len(registry.presetsByID))
+	for _, preset := range registry.presetsByID {
+		manifests = append(manifests, preset.Manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].ID < manifests[j].ID })
+	return manifests
+}
@@ -0,0 +1,108 @@
+package build
+
+// served_directory.go lays out the files a deployment serves, so a redeploy goes live in one step:
+//
+//	<assetStorageRoot>/<slug>/<run id>/   one directory per deploy run, filled while the previous one serves
+//	<assetStorageRoot>/<slug>/.live       symlink to the run directory that is live
+//
+// a run copies its output into its own directory, serves it (publishes the shared site, or starts the
+// nginx container over it), and only then points .live at it and removes the previous run directory.
+// nothing ever writes into a directory that is being served, and a failed run only leaves its own
+// directory behind, which it removes.
+//
+// .live is what outlives the process: RestoreSharedStaticSites, ApplyAccessControl and the zip
+// redeploy resolve it to find the live files. deployments made before this layout have their files
+// directly in <slug>/ and no .live, they are served from there until their next deploy moves them.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// livePointerName is the symlink inside <assetStorageRoot>/<slug>/ pointing at the live run directory.
+// a dot name, deployments from before the versioned layout serve <slug>/ itself and it must not clash with their files.
+const livePointerName = ".live"
+
+// runServedDirectory is the directory a pipeline run copies its output into: <assetStorageRoot>/<slug>/<run id>
+func (deployerPipeline *DeployerPipeline) runServedDirectory(slug string, runID string) string {
+	return filepath.Join(deployerPipeline.assetStorageRoot, slug, runID)
+}
+
+// liveServedDirectory returns the directory the deployment currently serves, .live resolved.
+// a deployment from before the versioned layout (no .live) serves <assetStorageRoot>/<slug> itself.
+func (deployerPipeline *DeployerPipeline) liveServedDirectory(slug string) (string, error) {
+	deploymentDirectory := filepath.Join(deployerPipeline.assetStorageRoot, slug)
+	livePointerPath := filepath.Join(deploymentDirectory, livePointerName)
+	// Lstat first, a pre-versioned site can have a file of that name of its own
+	pointerInfo, err := os.Lstat(livePointerPath)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && pointerInfo.Mode()&os.ModeSymlink == 0) {
+		return deploymentDirectory, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to stat the live directory of %q: %w", slug, err)
+	}
+	liveTarget, err := os.Readlink(livePointerPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the live directory of %q: %w", slug, err)
+	}
+	// the target is only ever a run id written by switchLiveServedDirectory, never a path
+	if _, errParse := uuid.Parse(liveTarget); errParse != nil {
+		return "", fmt.Errorf("unexpected live directory %q for %q", liveTarget, slug)
+	}
+	return filepath.Join(deploymentDirectory, liveTarget), nil
+}
+
+// switchLiveServedDirectory points .live at servedDirectory (a run directory that is already served)
+// and removes what the deployment served before: the previous run directory, or the files of the
+// pre-versioned layout. the symlink is replaced with a rename, so .live is never missing or half written.
+//
+// only the previously live directory is removed, not every other run directory: a concurrent run of
+// the same slug (a redeploy during a webhook build) may be filling its own one right now.
+func (deployerPipeline *DeployerPipeline) switchLiveServedDirectory(slug string, servedDirectory string) error {
+	deploymentDirectory := filepath.Join(deployerPipeline.assetStorageRoot, slug)
+	previousDirectory, err := deployerPipeline.liveServedDirectory(slug)
+	if err != nil {
+		return err
+	}
+
+	runID := filepath.Base(servedDirectory)
+	pendingPointerPath := filepath.Join(deploymentDirectory, livePointerName+"."+runID)
+	// relative target, the asset storage root can be moved (or mounted elsewhere) without breaking it
+	if err := os.Symlink(runID, pendingPointerPath); err != nil {
+		return fmt.Errorf("failed to create the live pointer: %w", err)
+	}
+	if err := os.Rename(pendingPointerPath, filepath.Join(deploymentDirectory, livePointerName)); err != nil {
+		os.Remove(pendingPointerPath)
+		return fmt.Errorf("failed to switch the live pointer: %w", err)
+	}
+
+	if previousDirectory == servedDirectory {
+		return nil // already the live directory, nothing to remove
+	}
+	if previousDirectory != deploymentDirectory {
+		return os.RemoveAll(previousDirectory)
+	}
+
+	// pre-versioned layout: the old files sit next to the run directories, everything that is not
+	// the pointer or a run directory (named by its run id) belongs to them
+	entries, err := os.ReadDir(deploymentDirectory)
+	if err != nil {
+		return fmt.Errorf("failed to list the old deployment files: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == livePointerName {
+			continue
+		}
+		if _, errParse := uuid.Parse(entry.Name()); errParse == nil && entry.IsDir() {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(deploymentDirectory, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove the old deployment files: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,466 @@
+package build
+
+// shared_static_server.go is the HTTP side of the "shared" serving mode (SERVING_MODE=shared):
+// one in-process server serves the live directory (<assetStorageRoot>/<slug>/<run id>/, see served_directory.go) of every live deployment,
+// picked by the Host header, instead of one nginx container per deployment.
+//
+// it serves a site the way its rendered nginx config (nginx_config.go) would, from the same parsed settings:
+//   - the SPA fallback, 404.html as the error page, clean URLs and the trailing slash policy
+//   - the _redirects and _headers rules (their regexes are valid Go regexes too)
+//   - the precompressed .br / .gz siblings written by precompress.go
+//   - ETag / Last-Modified, conditional and range requests, through http.ServeContent
+//
+// a site with IP access lists answers 403 to other addresses, a password protected site then asks
+// for its basic auth credentials, both before anything else.
+// a deployment goes live by swapping its site entry (publish), no container is started or stopped.
+// the pipeline side (publishing, restoring on startup) is in pipeline_shared_server.go.
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/util"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SharedStaticServer is the http.Handler of the shared serving mode.
+// constructed once in main.go, served on its own port and handed to the pipeline,
+// which publishes a site when a deployment goes live and removes it on teardown.
+type SharedStaticServer struct {
+	// hostSuffix is what follows the slug in the Host header of a site (eg, "-corvus.sasta.dev")
+	hostSuffix string
+
+	// forwardedDepth is the IP_ACCESS_FORWARDED_DEPTH the IP access lists are checked with,
+	// the same position in X-Forwarded-For the Traefik ipAllowList middleware of the container mode reads
+	forwardedDepth int
+
+	// sites maps a slug to the site currently served for it.
+	// a *sharedSite is never modified once published, a redeploy replaces the whole entry,
+	// so a request holds on to one consistent site even while the next version is published.
+	sitesMutex sync.RWMutex
+	sites      map[string]*sharedSite
+}
+
+// NewSharedStaticServer constructs a SharedStaticServer with no sites published yet.
+func NewSharedStaticServer(hostSuffix string, forwardedDepth int) *SharedStaticServer {
+	return &SharedStaticServer{
+		hostSuffix:     strings.ToLower(hostSuffix),
+		forwardedDepth: forwardedDepth,
+		sites:          make(map[string]*sharedSite),
+	}
+}
+
+// sharedSite is one published deployment: its served directory and its compiled rules.
+type sharedSite struct {
+	directory     string
+	config        *nginxSiteConfig
+	redirectRules []sharedRedirectRule
+	headerRules   []sharedHeaderRule
+
+	// basic auth of a password protected deployment, basicAuthUsername is "" for a public site.
+	// basicAuthRealm is the slug, the same realm the Traefik middleware of the container mode uses.
+	basicAuthUsername     string
+	basicAuthPasswordHash []byte
+	basicAuthRealm        string
+
+	// allowedIPRanges are the address ranges the site is reachable from (the deployment's IP access
+	// lists resolved with the platform defaults), nil for a site reachable from everywhere
+	allowedIPRanges []netip.Prefix
+
+	// verifiedAuthorizations caches the SHA-256 of Authorization headers that passed the bcrypt check.
+	// bcrypt is deliberately slow (~50ms), paying it on every request of a page (and each of its assets)
+	// would make a protected site crawl. only correct credentials are ever added, and a password change
+	// publishes a new sharedSite, which starts with an empty cache.
+	verifiedAuthorizations sync.Map
+}
+
+type sharedRedirectRule struct {
+	nginxRedirectRule
+	pattern *regexp.Regexp
+}
+
+type sharedHeaderRule struct {
+	pattern *regexp.Regexp
+	headers []nginxHeader
+}
+
+// newSharedSite compiles the rule regexes of a site config.
+// the rendered nginx locations are case-insensitive (~*), so the Go regexes are too.
+// the captures of a redirect pattern, (?<corvus_splat>...), keep their names, so the
+// ${corvus_splat} references in a rule target are expanded by regexp.Expand as they are.
+func newSharedSite(directory string, siteConfig *nginxSiteConfig) (*sharedSite, error) {
+	site := &sharedSite{directory: directory, config: siteConfig}
+	for _, rule := range siteConfig.redirectRules {
+		pattern, err := regexp.Compile("(?i)" + rule.locationPattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", redirectsFileName, rule.lineNumber, err)
+		}
+		site.redirectRules = append(site.redirectRules, sharedRedirectRule{nginxRedirectRule: rule, pattern: pattern})
+	}
+	for _, rule := range siteConfig.headerRules {
+		pattern, err := regexp.Compile("(?i)" + rule.requestPattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s path block: %w", headersFileName, err)
+		}
+		site.headerRules = append(site.headerRules, sharedHeaderRule{pattern: pattern, headers: rule.headers})
+	}
+	return site, nil
+}
+
+// publish makes site the one served for slug, replacing the previous version in one step
+func (server *SharedStaticServer) publish(slug string, site *sharedSite) {
+	server.sitesMutex.Lock()
+	defer server.sitesMutex.Unlock()
+	server.sites[slug] = site
+}
+
+// unpublish stops serving slug, a no-op when it is not published
+func (server *SharedStaticServer) unpublish(slug string) {
+	server.sitesMutex.Lock()
+	defer server.sitesMutex.Unlock()
+	delete(server.sites, slug)
+}
+
+// siteForHost returns the site of a Host header ("<slug><hostSuffix>", with or without a port),
+// nil when the host is not a published site.
+func (server *SharedStaticServer) siteForHost(host string) *sharedSite {
+	if hostWithoutPort, _, err := net.SplitHostPort(host); err == nil {
+		host = hostWithoutPort
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	slug, found := strings.CutSuffix(host, server.hostSuffix)
+	if !found || slug == "" {
+		return nil
+	}
+
+	server.sitesMutex.RLock()
+	defer server.sitesMutex.RUnlock()
+	return server.sites[slug]
+}
+
+// ServeHTTP serves a request for a published site.
+// the steps run in the order nginx would apply the rendered config:
+// _headers, the trailing slash redirect, the rules files block, the _redirects rules, then the catch-all.
+func (server *SharedStaticServer) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	site := server.siteForHost(request.Host)
+	if site == nil {
+		http.NotFound(responseWriter, request)
+		return
+	}
+	if site.allowedIPRanges != nil && !util.IPRangesContain(site.allowedIPRanges, server.clientAddress(request)) {
+		http.Error(responseWriter, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if site.basicAuthUsername != "" && !site.isAuthorized(request) {
+		responseWriter.Header().Set("WWW-Authenticate", "Basic realm=\""+site.basicAuthRealm+"\"")
+		http.Error(responseWriter, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		responseWriter.Header().Set("Allow", "GET, HEAD")
+		http.Error(responseWriter, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the cleaned path keeps its trailing slash, it decides the trailing slash policy and directory lookups
+	requestPath := path.Clean("/" + request.URL.Path)
+	if strings.HasSuffix(request.URL.Path, "/") && requestPath != "/" {
+		requestPath += "/"
+	}
+
+	// _headers blocks match the path and query the client sent ($request_uri), and apply to every response
+	requestURI := request.URL.RequestURI()
+	for _, rule := range site.headerRules {
+		if !rule.pattern.MatchString(requestURI) {
+			continue
+		}
+		for _, header := range rule.headers {
+			responseWriter.Header().Add(header.name, header.value)
+		}
+	}
+
+	switch site.config.trailingSlash {
+	case models.TrailingSlashAlways:
+		lastSegment := path.Base(requestPath)
+		if !strings.HasSuffix(requestPath, "/") && !strings.Contains(lastSegment, ".") && !site.isRegularFile(requestPath) {
+			redirectWithQuery(responseWriter, request, requestPath+"/", http.StatusMovedPermanently)
+			return
+		}
+	case models.TrailingSlashNever:
+		if requestPath != "/" && strings.HasSuffix(requestPath, "/") {
+			redirectWithQuery(responseWriter, request, strings.TrimSuffix(requestPath, "/"), http.StatusMovedPermanently)
+			return
+		}
+	}
+
+	if requestPath == "/"+redirectsFileName || requestPath == "/"+headersFileName {
+		site.serveNotFound(responseWriter, request)
+		return
+	}
+
+	// first matching rule wins, same as the order of the nginx locations
+	for _, rule := range site.redirectRules {
+		match := rule.pattern.FindStringSubmatchIndex(requestPath)
+		if match == nil {
+			continue
+		}
+		target := string(rule.pattern.ExpandString(nil, rule.target, requestPath, match))
+		site.serveRedirectRule(responseWriter, request, requestPath, &rule, target)
+		return
+	}
+
+	// the file, then about.html (clean URLs), then the directory's index.html, then the fallback
+	if site.isRegularFile(requestPath) {
+		site.serveFile(responseWriter, request, requestPath, http.StatusOK)
+		return
+	}
+	if site.config.cleanURLs {
+		if cleanPath := strings.TrimSuffix(requestPath, "/"); cleanPath != "" && site.isRegularFile(cleanPath+".html") {
+			site.serveFile(responseWriter, request, cleanPath+".html", http.StatusOK)
+			return
+		}
+	}
+	if indexPath := path.Join(requestPath, "index.html"); site.isRegularFile(indexPath) {
+		site.serveFile(responseWriter, request, indexPath, http.StatusOK)
+		return
+	}
+	if site.config.spaFallback {
+		site.serveFile(responseWriter, request, "/index.html", http.StatusOK)
+		return
+	}
+	site.serveNotFound(responseWriter, request)
+}
+
+// clientAddress returns the address the IP access lists are checked against, the one the Traefik
+// ipAllowList middleware would pick with ipStrategy.depth = forwardedDepth.
+// Traefik appends the address connecting to it to X-Forwarded-For when it forwards the request here,
+// so its depth N is entry N+1 from the right (depth 0, the connection to Traefik, is the last entry).
+// without X-Forwarded-For (the server reached directly, not through Traefik) the connection address is used.
+// an address that cannot be determined is the zero netip.Addr, which no range contains.
+func (server *SharedStaticServer) clientAddress(request *http.Request) netip.Addr {
+	var forwardedAddresses []string
+	for _, headerValue := range request.Header.Values("X-Forwarded-For") {
+		for _, forwardedAddress := range strings.Split(headerValue, ",") {
+			forwardedAddresses = append(forwardedAddresses, strings.TrimSpace(forwardedAddress))
+		}
+	}
+
+	var clientAddress string
+	switch {
+	case len(forwardedAddresses) == 0 && server.forwardedDepth == 0:
+		clientAddress, _, _ = net.SplitHostPort(request.RemoteAddr)
+	case len(forwardedAddresses) > server.forwardedDepth:
+		clientAddress = forwardedAddresses[len(forwardedAddresses)-1-server.forwardedDepth]
+	}
+	address, err := netip.ParseAddr(clientAddress)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return address.WithZone("")
+}
+
+// isAuthorized checks the basic auth credentials of a request against the site's user name and bcrypt hash.
+func (site *sharedSite) isAuthorized(request *http.Request) bool {
+	authorizationDigest := sha256.Sum256([]byte(request.Header.Get("Authorization")))
+	if _, verified := site.verifiedAuthorizations.Load(authorizationDigest); verified {
+		return true
+	}
+
+	username, password, ok := request.BasicAuth()
+	if !ok {
+		return false
+	}
+	// both checks always run, so the response time does not tell whether the user name was right
+	usernameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(site.basicAuthUsername)) == 1
+	passwordMatches := bcrypt.CompareHashAndPassword(site.basicAuthPasswordHash, []byte(password)) == nil
+	if !usernameMatches || !passwordMatches {
+		return false
+	}
+	site.verifiedAuthorizations.Store(authorizationDigest, struct{}{})
+	return true
+}
+
+// serveRedirectRule applies a matched _redirects rule, the same way its rendered nginx location does.
+// a rule without force only applies when nothing exists at the requested path.
+func (site *sharedSite) serveRedirectRule(
+	responseWriter http.ResponseWriter,
+	request *http.Request,
+	requestPath string,
+	rule *sharedRedirectRule,
+	target string,
+) {
+	if !rule.force && site.serveExisting(responseWriter, request, requestPath) {
+		return
+	}
+
+	switch rule.status {
+	case 200:
+		// rewrite: the target's content under the original URL. a target query string has no effect on a static file.
+		targetPath, _, _ := strings.Cut(target, "?")
+		if !site.serveExisting(responseWriter, request, path.Clean("/"+targetPath)) {
+			site.serveNotFound(responseWriter, request)
+		}
+	case 404:
+		targetPath, _, _ := strings.Cut(target, "?")
+		targetPath = path.Clean("/" + targetPath)
+		if site.isRegularFile(targetPath) {
+			site.serveFile(responseWriter, request, targetPath, http.StatusNotFound)
+			return
+		}
+		http.NotFound(responseWriter, request)
+	default:
+		// the query string is passed on like Netlify does, unless the target sets its own
+		if !strings.Contains(target, "?") && request.URL.RawQuery != "" {
+			target += "?" + request.URL.RawQuery
+		}
+		responseWriter.Header().Set("Location", target)
+		responseWriter.WriteHeader(rule.status)
+	}
+}
+
+// serveExisting serves requestPath when it is a file, or a directory with an index.html
+// (try_files $uri $uri/). returns false when neither exists and nothing was written.
+func (site *sharedSite) serveExisting(responseWriter http.ResponseWriter, request *http.Request, requestPath string) bool {
+	if site.isRegularFile(requestPath) {
+		site.serveFile(responseWriter, request, requestPath, http.StatusOK)
+		return true
+	}
+	if indexPath := path.Join(requestPath, "index.html"); site.isRegularFile(indexPath) {
+		site.serveFile(responseWriter, request, indexPath, http.StatusOK)
+		return true
+	}
+	return false
+}
+
+// serveNotFound answers 404, with the site's 404.html when custom_404 is set
+func (site *sharedSite) serveNotFound(responseWriter http.ResponseWriter, request *http.Request) {
+	if site.config.custom404 && site.isRegularFile("/"+notFoundPageFileName) {
+		site.serveFile(responseWriter, request, "/"+notFoundPageFileName, http.StatusNotFound)
+		return
+	}
+	http.NotFound(responseWriter, request)
+}
+
+// filePath maps a cleaned URL path to its path in the site directory.
+// the path was cleaned from a rooted path, so it cannot climb out of the directory with "..".
+func (site *sharedSite) filePath(urlPath string) string {
+	return filepath.Join(site.directory, filepath.FromSlash(urlPath))
+}
+
+// isRegularFile reports whether urlPath is a regular file of the site.
+// Lstat, same as the rules files, a symlink is never followed.
+func (site *sharedSite) isRegularFile(urlPath string) bool {
+	return isRegularFilePath(site.filePath(urlPath))
+}
+
+// serveFile sends the file at urlPath, or its .br / .gz sibling when the client accepts that encoding.
+// a 200 goes through http.ServeContent, which handles ETag, Last-Modified, conditional and range requests.
+// any other status (a 404 page) is sent whole, conditional and range requests only apply to a 200.
+func (site *sharedSite) serveFile(responseWriter http.ResponseWriter, request *http.Request, urlPath string, status int) {
+	originalFilePath := site.filePath(urlPath)
+	servedFilePath := originalFilePath
+	extension := strings.ToLower(filepath.Ext(urlPath))
+
+	// only the extensions precompress.go compresses can have siblings
+	if _, compressible := precompressedContentTypes[extension]; compressible {
+		acceptEncoding := request.Header.Get("Accept-Encoding")
+		hasBrotli := isRegularFilePath(originalFilePath + ".br")
+		hasGzip := isRegularFilePath(originalFilePath + ".gz")
+		if hasBrotli || hasGzip {
+			responseWriter.Header().Add("Vary", "Accept-Encoding")
+		}
+		switch {
+		case hasBrotli && acceptsEncoding(acceptEncoding, "br"):
+			servedFilePath = originalFilePath + ".br"
+			responseWriter.Header().Set("Content-Encoding", "br")
+		case hasGzip && acceptsEncoding(acceptEncoding, "gzip"):
+			servedFilePath = originalFilePath + ".gz"
+			responseWriter.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+
+	// the content type of the original file, a sibling must not go out as application/octet-stream.
+	// mime's table first (it knows more types), precompressedContentTypes for the ones it may not know.
+	// left unset for an unknown extension, http.ServeContent then sniffs the (uncompressed) content.
+	contentType := mime.TypeByExtension(extension)
+	if contentType == "" {
+		contentType = precompressedContentTypes[extension]
+	}
+	if contentType != "" {
+		responseWriter.Header().Set("Content-Type", contentType)
+	}
+
+	servedFile, err := os.Open(servedFilePath)
+	if err != nil {
+		http.NotFound(responseWriter, request)
+		return
+	}
+	defer servedFile.Close()
+	fileInfo, err := servedFile.Stat()
+	if err != nil || !fileInfo.Mode().IsRegular() {
+		http.NotFound(responseWriter, request)
+		return
+	}
+
+	// same ETag format as nginx (hex mtime and size), so switching serving modes does not invalidate caches
+	responseWriter.Header().Set("ETag", fmt.Sprintf("\"%x-%x\"", fileInfo.ModTime().Unix(), fileInfo.Size()))
+
+	if status == http.StatusOK {
+		http.ServeContent(responseWriter, request, urlPath, fileInfo.ModTime(), servedFile)
+		return
+	}
+
+	responseWriter.Header().Set("Content-Length", strconv.FormatInt(fileInfo.Size(), 10))
+	responseWriter.WriteHeader(status)
+	if request.Method != http.MethodHead {
+		_, _ = io.Copy(responseWriter, servedFile)
+	}
+}
+
+// redirectWithQuery redirects to a path on the same site, keeping the query string (nginx rewrite ... permanent does too).
+// the Location is relative, same as the absolute_redirect off of the nginx config.
+func redirectWithQuery(responseWriter http.ResponseWriter, request *http.Request, targetPath string, status int) {
+	if request.URL.RawQuery != "" {
+		targetPath += "?" + request.URL.RawQuery
+	}
+	responseWriter.Header().Set("Location", targetPath)
+	responseWriter.WriteHeader(status)
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows encoding ("br", "gzip").
+// an encoding listed with q=0 is explicitly refused.
+func acceptsEncoding(acceptEncoding string, encoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, parameters, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		if quality, found := strings.CutPrefix(strings.TrimSpace(parameters), "q="); found {
+			if value, err := strconv.ParseFloat(quality, 64); err == nil && value == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// isRegularFilePath reports whether filePath is a regular file (Lstat, symlinks are not followed)
+func isRegularFilePath(filePath string) bool {
+	fileInfo, err := os.Lstat(filePath)
+	return err == nil && fileInfo.Mode().IsRegular()
+}
@@ -0,0 +1,165 @@
+package build
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMaxWindowBytes caps the zstd decoder window (the memory it may allocate for back-references).
+// 64MB is what `zstd --long` uses at most, regular `zstd -19` archives use far less.
+const zstdMaxWindowBytes = 64 << 20
+
+// ExtractTarUpload extracts a tar archive (plain, gzip or zstd compressed, as detected by
+// detectArchiveFormat) into destinationDirectory.
+//
+// (security) on top of the path traversal check every entry gets (same as zip entries),
+// tar can carry links, which zip uploads cannot:
+//   - symlinks are rejected. the deployment output may not contain any (util.CopyDirectory
+//     refuses them), so failing here names the archive entry instead of failing later at the copy.
+//     it also means no entry can ever be written through a symlink ("link -> ." followed by
+//     "link/../../etc/cron.d/x" style tricks, or chains of relative links climbing out)
+//   - hardlinks must point at a regular file already extracted from the same archive.
+//     they are written as a plain copy, so no hardlink to anything outside can ever exist
+//   - device files, FIFOs and other special entries are rejected
+//
+// limits is applied the same way as for zip uploads, hardlink copies count against it too,
+// otherwise thousands of hardlinks to one large file would multiply it on disk.
+func ExtractTarUpload(archiveFilePath string, format archiveFormat, destinationDirectory string, limits ArchiveLimits) error {
+	extractionBudget, errBudget := newArchiveBudget(limits, archiveFilePath)
+	if errBudget != nil {
+		return errBudget
+	}
+
+	errMakeDir := os.MkdirAll(destinationDirectory, 0755)
+	if errMakeDir != nil {
+		return fmt.Errorf("failed to create extraction directory %q: %w", destinationDirectory, errMakeDir)
+	}
+
+	archiveFile, errOpenArchive := os.Open(archiveFilePath)
+	if errOpenArchive != nil {
+		return fmt.Errorf("failed to open tar archive %q: %w", archiveFilePath, errOpenArchive)
+	}
+	defer archiveFile.Close()
+
+	// unlike zip, tar has no central directory, so the archive is read front to back as one stream
+	// through the matching decompressor
+	var tarStream io.Reader = archiveFile
+	switch format {
+	case archiveFormatTarGz:
+		gzipReader, err := gzip.NewReader(archiveFile)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		defer gzipReader.Close()
+		tarStream = gzipReader
+	case archiveFormatTarZst:
+		// the window size is capped so a crafted frame header cannot make the decoder allocate
+		// gigabytes up front (the default allows windows far larger than any static site needs)
+		zstdReader, err := zstd.NewReader(archiveFile, zstd.WithDecoderMaxWindow(zstdMaxWindowBytes), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("failed to open zstd stream: %w", err)
+		}
+		defer zstdReader.Close()
+		tarStream = zstdReader
+	}
+
+	tarReader := tar.NewReader(tarStream)
+	for {
+		tarHeader, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil // end of archive
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar entry: %w", err)
+		}
+
+		errEntryCount := extractionBudget.addEntry()
+		if errEntryCount != nil {
+			return errEntryCount
+		}
+		errExtractTarEntry := extractTarEntry(tarHeader, tarReader, destinationDirectory, extractionBudget)
+		if errExtractTarEntry != nil {
+			return fmt.Errorf("failed to extract entry %q: %w", tarHeader.Name, errExtractTarEntry)
+		}
+	}
+}
+
+// extractTarEntry (helper func) extracts a single tar entry.
+// separated from the loop for the same reason as extractZipEntry (defers run per entry).
+// tarReader is positioned at the entry's content.
+func extractTarEntry(tarHeader *tar.Header, tarReader io.Reader, destinationDirectory string, extractionBudget *archiveBudget) error {
+	entryDestPath, err := entryPathInsideDestination(destinationDirectory, tarHeader.Name)
+	if err != nil {
+		return err
+	}
+
+	switch tarHeader.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(entryDestPath, 0755)
+
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(entryDestPath), 0755); err != nil {
+			return fmt.Errorf("failed to create parent directory for %q: %w", entryDestPath, err)
+		}
+		// only the permission bits are kept (no setuid/setgid/sticky from an untrusted archive)
+		filePermissionsMode := os.FileMode(tarHeader.Mode).Perm()
+		if filePermissionsMode == 0 {
+			filePermissionsMode = 0644
+		}
+		return writeStreamToFile(tarReader, entryDestPath, filePermissionsMode, extractionBudget)
+
+	case tar.TypeSymlink:
+		return fmt.Errorf("symlinks are not allowed in uploaded archives (%q -> %q), archive the file it points at instead",
+			tarHeader.Name, tarHeader.Linkname)
+
+	case tar.TypeLink:
+		// hardlink names are relative to the archive root
+		linkTargetPath, err := entryPathInsideDestination(destinationDirectory, tarHeader.Linkname)
+		if err != nil {
+			return fmt.Errorf("hardlink %q points outside the destination directory (%q)", tarHeader.Name, tarHeader.Linkname)
+		}
+		// Lstat (not Stat) so a hardlink to a symlink does not silently copy whatever the symlink points at
+		linkTargetInfo, err := os.Lstat(linkTargetPath)
+		if err != nil || !linkTargetInfo.Mode().IsRegular() {
+			return fmt.Errorf("hardlink %q must point at a regular file extracted earlier (%q)", tarHeader.Name, tarHeader.Linkname)
+		}
+		linkTargetFile, err := os.Open(linkTargetPath)
+		if err != nil {
+			return fmt.Errorf("failed to open hardlink target %q: %w", linkTargetPath, err)
+		}
+		defer linkTargetFile.Close()
+		if err := os.MkdirAll(filepath.Dir(entryDestPath), 0755); err != nil {
+			return fmt.Errorf("failed to create parent directory for %q: %w", entryDestPath, err)
+		}
+		return writeStreamToFile(linkTargetFile, entryDestPath, linkTargetInfo.Mode().Perm(), extractionBudget)
+
+	case tar.TypeXGlobalHeader:
+		return nil // pax global metadata, not a file
+
+	default:
+		return fmt.Errorf("unsupported tar entry type %q (only files, directories and hardlinks are allowed)", string(tarHeader.Typeflag))
+	}
+}
+
+// writeStreamToFile creates (or truncates) destinationPath and copies source into it,
+// counting the bytes against the archive limits. the Size in the tar header is not trusted.
+// same flags as writeZipEntryToDisk, see the comments there.
+func writeStreamToFile(source io.Reader, destinationPath string, filePermissionsMode os.FileMode, extractionBudget *archiveBudget) error {
+	destinationFile, err := os.OpenFile(destinationPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, filePermissionsMode)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file %q: %w", destinationPath, err)
+	}
+	defer destinationFile.Close()
+
+	if err := extractionBudget.copyEntry(destinationFile, source); err != nil {
+		return fmt.Errorf("failed to write tar entry content to disk: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// StagedUpload is an uploaded archive that has been streamed from the request body
+// straight to its staging file on disk. the staging file is the one the extractor reads,
+// there is no second copy. created by StageUpload in the HTTP handler, then handed to
+// DeployZipUpload, which owns it from there on and removes it after extraction.
+type StagedUpload struct {
+	// Path is the staging file on disk
+	Path string
+
+	// SizeBytes is the number of bytes actually received (not the Content-Length the client claimed)
+	SizeBytes int64
+
+	// SHA256 is the hex encoded sha256 of the archive, computed while it was streamed to disk.
+	// written to the pipeline log and events so an upload can be matched to a local file later.
+	SHA256 string
+
+	// ReceiveStartedAt and ReceiveDuration time the upload itself. the upload happens in the HTTP
+	// handler before the pipeline run exists, the pipeline records it as its receive_upload step afterwards.
+	ReceiveStartedAt time.Time
+	ReceiveDuration  time.Duration
+}
+
+// StageUpload streams source (the file part of the multipart body) into a new staging file,
+// hashing it on the way. nothing is buffered in memory beyond io.Copy's chunk.
+// on error the partially written file is removed, on success the caller owns the file and
+// must either hand it to DeployZipUpload or call Remove().
+func (deployerPipeline *DeployerPipeline) StageUpload(source io.Reader) (*StagedUpload, error) {
+	receiveStartedAt := time.Now()
+
+	// os.CreateTemp() creates a new file in the OS default temp directory with a unique name.
+	// no extension in the name, the format is only known once the magic bytes are read (see archive_extract.go).
+	stagingFile, errCreateStagingFile := os.CreateTemp("", "corvus-upload-*") // `*` is where the random string will be
+	if errCreateStagingFile != nil {
+		return nil, fmt.Errorf("failed to create staging file for upload: %w", errCreateStagingFile)
+	}
+
+	// io.MultiWriter sends every chunk to both the file and the hash,
+	// so the hash costs no second pass over the file
+	uploadHasher := sha256.New()
+	receivedBytes, errCopyUpload := io.Copy(io.MultiWriter(stagingFile, uploadHasher), source)
+	errCloseStagingFile := stagingFile.Close()
+	if errCopyUpload == nil {
+		errCopyUpload = errCloseStagingFile
+	}
+	if errCopyUpload != nil {
+		os.Remove(stagingFile.Name())
+		return nil, fmt.Errorf("failed to stream upload to disk: %w", errCopyUpload)
+	}
+
+	return &StagedUpload{
+		Path:             stagingFile.Name(),
+		SizeBytes:        receivedBytes,
+		SHA256:           hex.EncodeToString(uploadHasher.Sum(nil)),
+		ReceiveStartedAt: receiveStartedAt,
+		ReceiveDuration:  time.Since(receiveStartedAt),
+	}, nil
+}
+
+// Remove deletes the staging file. safe to call more than once.
+func (stagedUpload *StagedUpload) Remove() {
+	os.Remove(stagedUpload.Path)
+}
@@ -3,10 +3,8 @@ package build
 import (
 	"archive/zip"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
-	"strings"
 )
 
 // ExtractZipUpload extracts the contents of a zip archive at zipFilePath into the
@@ -15,7 +13,13 @@ import (
 // which would allow a malicious archive to write files outside the destinationDirectory.
 // Every extracted path is validated to ensure it stays within destinationDirectory before
 // any file or directory is created on disk.
-func ExtractZipUpload(zipFilePath string, destinationDirectory string) error {
+// (security) limits caps the entry count and the decompressed sizes, counted while extracting (zip bomb protection).
+func ExtractZipUpload(zipFilePath string, destinationDirectory string, limits ArchiveLimits) error {
+	extractionBudget, errBudget := newArchiveBudget(limits, zipFilePath)
+	if errBudget != nil {
+		return errBudget
+	}
+
 	// os.MkdirAll creates destinationDirectory and any missing parents.
 	// chmod 0755 - owner has read/write/execute, group and others have read/execute.
 	// (execute permission on a directory means the ability to cd into it)
@@ -35,7 +39,11 @@ func ExtractZipUpload(zipFilePath string, destinationDirectory string) error {
 
 	// zip entry is just a file/folder in a zip file
 	for _, zipEntry := range zipReader.File {
-		errExtractZipEntry := extractZipEntry(zipEntry, destinationDirectory)
+		errEntryCount := extractionBudget.addEntry()
+		if errEntryCount != nil {
+			return errEntryCount
+		}
+		errExtractZipEntry := extractZipEntry(zipEntry, destinationDirectory, extractionBudget)
 		if errExtractZipEntry != nil { // helper func
 			return fmt.Errorf("failed to extract entry %q: %w", zipEntry.Name, errExtractZipEntry)
 		}
@@ -50,24 +58,12 @@ func ExtractZipUpload(zipFilePath string, destinationDirectory string) error {
 // function returns/end, which means file handles stay open for the entire loop.
 // by moving the logic into a called function, each entry's file handle is
 // deferred and closed before the next entry is processed.
-func extractZipEntry(zipEntry *zip.File, destinationDirectory string) error {
-	// filepath.Join cleans the path, resolving any `..` components (cmd to go to parent folder)
-	// The security check below then verifies the cleaned path still starts
-	// with destinationDirectory, rejecting any entry that tried to escape via `..`
-	entryDestPath := filepath.Join(destinationDirectory, zipEntry.Name)
-
-	// Zip slip exploit protection (security) Filepath.Clean resolves `..` segments.
-	// example malicious entry name: "../../etc/passwd"
-	// after Join + Clean: "/tmp/builds/abc123/../../etc/passwd" -> "/etc/passwd"
-	// the HasPrefix() check catches this and rejects it.
-	safePrefix := filepath.Clean(destinationDirectory) + string(os.PathSeparator) // well tbh .Clean() is not necessary here (cuz hard coded)
-
-	// but .Clean() here is necessary cuz zip entry might have sus file names
-	potentiallyMaliciousEntryDestPath := filepath.Clean(entryDestPath) + string(os.PathSeparator)
-	if !strings.HasPrefix(potentiallyMaliciousEntryDestPath, safePrefix) {
-		// this shouldn't really be reached if the path is cleaned properly.
-		// but sometimes, even after cleaning, the file might resolve to a path outside the intended dir. so error for that
-		return fmt.Errorf("zip slip detected: entry %q would write outside destination directory", zipEntry.Name)
+func extractZipEntry(zipEntry *zip.File, destinationDirectory string, extractionBudget *archiveBudget) error {
+	// resolves the entry name against destinationDirectory and rejects names that escape it
+	// ("../../etc/passwd", zip slip). shared with the tar extractor, see archive_extract.go
+	entryDestPath, errEntryPath := entryPathInsideDestination(destinationDirectory, zipEntry.Name)
+	if errEntryPath != nil {
+		return errEntryPath
 	}
 
 	// if folder, just create folder with os.MkdirAll() and bubble up the error if happens
@@ -86,14 +82,14 @@ func extractZipEntry(zipEntry *zip.File, destinationDirectory string) error {
 		return fmt.Errorf("failed to create parent directory for %q: %w", entryDestPath, errMakeParentFolder)
 	}
 
-	return writeZipEntryToDisk(zipEntry, entryDestPath) // helper
+	return writeZipEntryToDisk(zipEntry, entryDestPath, extractionBudget) // helper
 }
 
 // writeZipEntryToDisk opens the zip entry for reading and writes its contents
 // to the destination path on disk. File permissions are taken from the zip entry's stored mode, falling back
 // to 0644 (owner read/write, group and others read-only) if the mode is zero (happens if zipped in Windows).
 // 0644 is the standard permission for files that should be readable but not executable.
-func writeZipEntryToDisk(zipEntry *zip.File, destinationPath string) error {
+func writeZipEntryToDisk(zipEntry *zip.File, destinationPath string, extractionBudget *archiveBudget) error {
 	// open the zip entry's compressed data stream for reading
 	zipEntryReadCloser, errOpenZipEntry := zipEntry.Open()
 	if errOpenZipEntry != nil {
@@ -128,10 +124,12 @@ func writeZipEntryToDisk(zipEntry *zip.File, destinationPath string) error {
 	// this is a pointer (open file handle) on the host filesystem. The defer call makes the os
 	// releases the file descriptor and flushes all pending data to the disk after the function execution ends.
 
-	// io.Copy() decompresses the zip entry and streams the decompressed entry content
-	// into the destination file. It reads data as chunks internally, so arbitrarily large
+	// copyEntry (an io.Copy underneath) decompresses the zip entry and streams the decompressed entry content
+	// into the destination file. It reads data as chunks internally, so large
 	// files are handled without loading the entire entry into memory at once.
-	_, errUncompressAndCopy := io.Copy(destinationFile, zipEntryReadCloser)
+	// every chunk is counted against the archive limits, the UncompressedSize64 in the zip header
+	// is never trusted since the uploader wrote it.
+	errUncompressAndCopy := extractionBudget.copyEntry(destinationFile, zipEntryReadCloser)
 	if errUncompressAndCopy != nil {
 		return fmt.Errorf("failed to write zip entry content to disk: %w", errUncompressAndCopy)
 	}
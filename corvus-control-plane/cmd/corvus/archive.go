@@ -0,0 +1,71 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// writeDirectoryAsZip writes the contents of sourceDirectory as a zip archive to destination.
+// entries are relative to sourceDirectory, so `corvus deploy ./dist` puts index.html
+// at the root of the archive and the default output directory "." works.
+//
+// only regular files and directories are added. symlinks and special files are skipped
+// with a warning, the control plane rejects them anyway (see util.CopyDirectory).
+func writeDirectoryAsZip(sourceDirectory string, destination io.Writer) error {
+	zipWriter := zip.NewWriter(destination)
+
+	walkErr := filepath.WalkDir(sourceDirectory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(sourceDirectory, path)
+		if err != nil {
+			return err
+		}
+		if relativePath == "." {
+			return nil
+		}
+		// zip entry names always use forward slashes, regardless of the OS
+		entryName := filepath.ToSlash(relativePath)
+
+		if entry.IsDir() {
+			_, err := zipWriter.Create(entryName + "/")
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			fmt.Fprintf(os.Stderr, "warning: skipping %s (not a regular file)\n", entryName)
+			return nil
+		}
+
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(fileInfo)
+		if err != nil {
+			return err
+		}
+		header.Name = entryName
+		header.Method = zip.Deflate
+
+		entryWriter, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		sourceFile, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer sourceFile.Close()
+		_, err = io.Copy(entryWriter, sourceFile)
+		return err
+	})
+	if walkErr != nil {
+		return fmt.Errorf("failed to zip %q: %w", sourceDirectory, walkErr)
+	}
+	return zipWriter.Close()
+}
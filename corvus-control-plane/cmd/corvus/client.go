@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+)
+
+// apiClient is a thin wrapper over the control plane REST API.
+// it reuses the models package for response types, so the CLI and the server
+// can never disagree on the JSON shape of a deployment or a pipeline event.
+type apiClient struct {
+	serverURL  string
+	friendCode string
+	httpClient *http.Client
+}
+
+// newAPIClient constructs an apiClient from the loaded config.
+func newAPIClient(config *cliConfig) *apiClient {
+	return &apiClient{
+		serverURL:  config.ServerURL,
+		friendCode: config.FriendCode,
+		// generous timeout because a create request carries the whole zip upload
+		httpClient: &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// apiError is a non-2xx response. the control plane always answers errors with {"error": "..."}.
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *apiError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", err.StatusCode, err.Message)
+}
+
+// createDeploymentFields are the multipart form fields of POST /api/deployments.
+// empty values are not sent, so the server applies its own defaults.
+type createDeploymentFields struct {
+	Name                 string
+	Slug                 string
+	SourceType           models.SourceType
+	GitHubURL            string
+	GitURL               string
+	GitToken             string
+	GitUsername          string
+	GitSSHKey            string
+	Branch               string
+	Ref                  string
+	BuildCommand         string
+	RootDirectory        string
+	SharedDirectories    string
+	GitSubmodules        bool
+	GitLFS               bool
+	OutputDirectory      string
+	SPAFallback          bool
+	Custom404            bool
+	CleanURLs            bool
+	TrailingSlash        string
+	IPAllowList          string
+	IPDenyList           string
+	EnvironmentVariables []models.EnvironmentVariable
+}
+
+// formBool encodes a boolean form field, "" (not sent) for false so the server default applies
+func formBool(value bool) string {
+	if value {
+		return "true"
+	}
+	return ""
+}
+
+// do sends a request and decodes a JSON response body into responseTarget (skipped when nil).
+func (client *apiClient) do(request *http.Request, responseTarget any) (*http.Response, error) {
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", client.serverURL, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response, decodeAPIError(response)
+	}
+	if responseTarget != nil {
+		if err := json.NewDecoder(response.Body).Decode(responseTarget); err != nil {
+			return response, fmt.Errorf("failed to decode response from %s: %w", request.URL.Path, err)
+		}
+	}
+	return response, nil
+}
+
+// decodeAPIError turns an error response into an *apiError, falling back to the raw body
+// when it is not the usual {"error": "..."} JSON (eg, a proxy error page).
+func decodeAPIError(response *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+	var errorBody struct {
+		Error string `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &errorBody) == nil && errorBody.Error != "" {
+		message = errorBody.Error
+	}
+	if message == "" {
+		message = http.StatusText(response.StatusCode)
+	}
+	return &apiError{StatusCode: response.StatusCode, Message: message}
+}
+
+func (client *apiClient) newRequest(method string, path string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequest(method, client.serverURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request for %s: %w", path, err)
+	}
+	return request, nil
+}
+
+// ListDeployments calls GET /api/deployments.
+func (client *apiClient) ListDeployments() ([]*models.Deployment, error) {
+	request, err := client.newRequest(http.MethodGet, "/api/deployments", nil)
+	if err != nil {
+		return nil, err
+	}
+	var deployments []*models.Deployment
+	_, err = client.do(request, &deployments)
+	return deployments, err
+}
+
+// GetDeployment calls GET /api/deployments/{uuid}.
+func (client *apiClient) GetDeployment(deploymentID string) (*models.Deployment, error) {
+	request, err := client.newRequest(http.MethodGet, "/api/deployments/"+url.PathEscape(deploymentID), nil)
+	if err != nil {
+		return nil, err
+	}
+	var deployment models.Deployment
+	_, err = client.do(request, &deployment)
+	return &deployment, err
+}
+
+// CreateDeployment calls POST /api/deployments with a multipart form.
+// when uploadDirectory is non-empty, the directory is zipped on the fly and streamed as the "file" field,
+// so the archive is never written to disk or held in memory as a whole.
+func (client *apiClient) CreateDeployment(fields createDeploymentFields, uploadDirectory string) (*models.Deployment, error) {
+	encodedEnvironmentVariables, err := encodeEnvironmentVariables(fields.EnvironmentVariables)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	multipartWriter := multipart.NewWriter(pipeWriter)
+
+	// the form is written by a goroutine while the HTTP client reads the other end of the pipe.
+	// any error is passed to the reader side with CloseWithError, which fails the request.
+	go func() {
+		formFields := [][2]string{
+			{"name", fields.Name},
+			{"slug", fields.Slug},
+			{"source_type", string(fields.SourceType)},
+			{"github_url", fields.GitHubURL},
+			{"git_url", fields.GitURL},
+			{"git_token", fields.GitToken},
+			{"git_username", fields.GitUsername},
+			{"git_ssh_key", fields.GitSSHKey},
+			{"branch", fields.Branch},
+			{"ref", fields.Ref},
+			{"build_command", fields.BuildCommand},
+			{"root_directory", fields.RootDirectory},
+			{"shared_directories", fields.SharedDirectories},
+			{"git_submodules", formBool(fields.GitSubmodules)},
+			{"git_lfs", formBool(fields.GitLFS)},
+			{"output_directory", fields.OutputDirectory},
+			{"spa_fallback", formBool(fields.SPAFallback)},
+			{"custom_404", formBool(fields.Custom404)},
+			{"clean_urls", formBool(fields.CleanURLs)},
+			{"trailing_slash", fields.TrailingSlash},
+			{"ip_allow_list", fields.IPAllowList},
+			{"ip_deny_list", fields.IPDenyList},
+			{"environment_variables", encodedEnvironmentVariables},
+			{"friend_code", client.friendCode},
+		}
+		for _, formField := range formFields {
+			if formField[1] == "" {
+				continue
+			}
+			if err := multipartWriter.WriteField(formField[0], formField[1]); err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+		}
+
+		if uploadDirectory != "" {
+			fileWriter, err := multipartWriter.CreateFormFile("file", "site.zip")
+			if err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+			if err := writeDirectoryAsZip(uploadDirectory, fileWriter); err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+		}
+		pipeWriter.CloseWithError(multipartWriter.Close())
+	}()
+
+	request, err := client.newRequest(http.MethodPost, "/api/deployments", pipeReader)
+	if err != nil {
+		pipeReader.Close()
+		return nil, err
+	}
+	request.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+
+	var deployment models.Deployment
+	_, err = client.do(request, &deployment)
+	// unblocks the writer goroutine if the server answered before reading the whole body
+	pipeReader.Close()
+	return &deployment, err
+}
+
+// RedeployDeployment calls POST /api/deployments/{uuid}/redeploy.
+// a non-nil ref re-pins the deployment ("" unpins it), nil sends no body and keeps the current ref.
+func (client *apiClient) RedeployDeployment(deploymentID string, ref *string) (*models.Deployment, error) {
+	var body io.Reader
+	if ref != nil {
+		encodedBody, err := json.Marshal(map[string]string{"ref": *ref})
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(encodedBody)
+	}
+	request, err := client.newRequest(http.MethodPost, "/api/deployments/"+url.PathEscape(deploymentID)+"/redeploy", body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	var deployment models.Deployment
+	_, err = client.do(request, &deployment)
+	return &deployment, err
+}
+
+// DeleteDeployment calls DELETE /api/deployments/{uuid}.
+func (client *apiClient) DeleteDeployment(deploymentID string) error {
+	request, err := client.newRequest(http.MethodDelete, "/api/deployments/"+url.PathEscape(deploymentID), nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.do(request, nil)
+	return err
+}
+
+// SetBasicAuth calls PUT /api/deployments/{uuid}/password.
+func (client *apiClient) SetBasicAuth(deploymentID string, username string, password string) (*models.Deployment, error) {
+	encodedBody, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		return nil, err
+	}
+	request, err := client.newRequest(http.MethodPut, "/api/deployments/"+url.PathEscape(deploymentID)+"/password", bytes.NewReader(encodedBody))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	var deployment models.Deployment
+	_, err = client.do(request, &deployment)
+	return &deployment, err
+}
+
+// RemoveBasicAuth calls DELETE /api/deployments/{uuid}/password.
+func (client *apiClient) RemoveBasicAuth(deploymentID string) (*models.Deployment, error) {
+	request, err := client.newRequest(http.MethodDelete, "/api/deployments/"+url.PathEscape(deploymentID)+"/password", nil)
+	if err != nil {
+		return nil, err
+	}
+	var deployment models.Deployment
+	_, err = client.do(request, &deployment)
+	return &deployment, err
+}
+
+// UpdateIPAccess calls PUT /api/deployments/{uuid}/ip-access.
+// a nil list is sent as null, which resets it on the server.
+func (client *apiClient) UpdateIPAccess(deploymentID string, allowList *string, denyList *string) (*models.Deployment, error) {
+	encodedBody, err := json.Marshal(map[string]*string{"ip_allow_list": allowList, "ip_deny_list": denyList})
+	if err != nil {
+		return nil, err
+	}
+	request, err := client.newRequest(http.MethodPut, "/api/deployments/"+url.PathEscape(deploymentID)+"/ip-access", bytes.NewReader(encodedBody))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	var deployment models.Deployment
+	_, err = client.do(request, &deployment)
+	return &deployment, err
+}
+
+// ExtendDeployment calls POST /api/deployments/{uuid}/extend with the configured friend code,
+// minutes 0 lets the server add its default TTL.
+func (client *apiClient) ExtendDeployment(deploymentID string, minutes int) (*models.Deployment, error) {
+	encodedBody, err := json.Marshal(map[string]any{"minutes": minutes, "friend_code": client.friendCode})
+	if err != nil {
+		return nil, err
+	}
+	request, err := client.newRequest(http.MethodPost, "/api/deployments/"+url.PathEscape(deploymentID)+"/extend", bytes.NewReader(encodedBody))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	var deployment models.Deployment
+	_, err = client.do(request, &deployment)
+	return &deployment, err
+}
+
+// SetPinned calls POST (pin) or DELETE (unpin) /api/deployments/{uuid}/pin, authenticated with the admin token.
+func (client *apiClient) SetPinned(deploymentID string, adminToken string, pinned bool) (*models.Deployment, error) {
+	method := http.MethodPost
+	if !pinned {
+		method = http.MethodDelete
+	}
+	request, err := client.newRequest(method, "/api/deployments/"+url.PathEscape(deploymentID)+"/pin", nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+adminToken)
+	var deployment models.Deployment
+	_, err = client.do(request, &deployment)
+	return &deployment, err
+}
+
+// ListLatestRunEvents calls GET /api/deployments/{uuid}/events (latest run only).
+func (client *apiClient) ListLatestRunEvents(deploymentID string) ([]*models.PipelineEvent, error) {
+	request, err := client.newRequest(http.MethodGet, "/api/deployments/"+url.PathEscape(deploymentID)+"/events?run=latest", nil)
+	if err != nil {
+		return nil, err
+	}
+	var events []*models.PipelineEvent
+	_, err = client.do(request, &events)
+	return events, err
+}
+
+// ReadLogs calls GET /api/deployments/{uuid}/logs?offset=N and returns the log chunk
+// and the offset to continue from (X-Log-Next-Offset).
+func (client *apiClient) ReadLogs(deploymentID string, offset int64) ([]byte, int64, error) {
+	path := "/api/deployments/" + url.PathEscape(deploymentID) + "/logs?offset=" + strconv.FormatInt(offset, 10)
+	request, err := client.newRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, offset, err
+	}
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return nil, offset, fmt.Errorf("request to %s failed: %w", client.serverURL, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, offset, decodeAPIError(response)
+	}
+
+	logChunk, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, offset, fmt.Errorf("failed to read log response: %w", err)
+	}
+	nextOffset, err := strconv.ParseInt(response.Header.Get("X-Log-Next-Offset"), 10, 64)
+	if err != nil {
+		// an older server without the header, fall back to counting bytes
+		nextOffset = offset + int64(len(logChunk))
+	}
+	return logChunk, nextOffset, nil
+}
+
+// ResolveDeployment finds a deployment by full id, slug, or unique id prefix,
+// so every command can be given whatever the user has at hand (`corvus logs amber-fox-1a2b`).
+func (client *apiClient) ResolveDeployment(reference string) (*models.Deployment, error) {
+	deployments, err := client.ListDeployments()
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixMatches []*models.Deployment
+	for _, deployment := range deployments {
+		if deployment.ID == reference || deployment.Slug == reference {
+			return deployment, nil
+		}
+		if strings.HasPrefix(deployment.ID, reference) {
+			prefixMatches = append(prefixMatches, deployment)
+		}
+	}
+	switch len(prefixMatches) {
+	case 0:
+		return nil, fmt.Errorf("no deployment matches %q (use `corvus ls` to see ids and slugs)", reference)
+	case 1:
+		return prefixMatches[0], nil
+	default:
+		return nil, fmt.Errorf("%q matches %d deployments, use more characters of the id", reference, len(prefixMatches))
+	}
+}
+
+// encodeEnvironmentVariables builds the environment_variables form value in the
+// {"KEY": {"value": "...", "scope": "..."}} format the create handler accepts.
+func encodeEnvironmentVariables(environmentVariables []models.EnvironmentVariable) (string, error) {
+	if len(environmentVariables) == 0 {
+		return "", nil
+	}
+	type scopedValue struct {
+		Value string                          `json:"value"`
+		Scope models.EnvironmentVariableScope `json:"scope"`
+	}
+	encoded := make(map[string]scopedValue, len(environmentVariables))
+	for _, environmentVariable := range environmentVariables {
+		if _, duplicate := encoded[environmentVariable.Key]; duplicate {
+			return "", errors.New("environment variable " + environmentVariable.Key + " is set more than once")
+		}
+		encoded[environmentVariable.Key] = scopedValue{Value: environmentVariable.Value, Scope: environmentVariable.Scope}
+	}
+	encodedBytes, err := json.Marshal(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode environment variables: %w", err)
+	}
+	return string(encodedBytes), nil
+}
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+)
+
+// pollInterval is how often `deploy`/`redeploy` (waiting) and `logs -f` ask the server for news.
+const pollInterval = time.Second
+
+// environmentFlag collects repeated KEY=VALUE flags (eg, --env A=1 --env B=2) into one scope.
+type environmentFlag struct {
+	scope     models.EnvironmentVariableScope
+	variables *[]models.EnvironmentVariable
+}
+
+func (environment environmentFlag) String() string { return "" }
+
+func (environment environmentFlag) Set(value string) error {
+	key, variableValue, found := strings.Cut(value, "=")
+	if !found || key == "" {
+		return fmt.Errorf("expected KEY=VALUE, got %q", value)
+	}
+	*environment.variables = append(*environment.variables, models.EnvironmentVariable{
+		Key: key, Value: variableValue, Scope: environment.scope,
+	})
+	return nil
+}
+
+// parseInterspersedFlags parses flags that may appear before or after positional arguments
+// (`corvus deploy ./dist --name site` and `corvus deploy --name site ./dist` both work).
+// the standard flag package stops at the first positional argument, so parsing is resumed after it.
+func parseInterspersedFlags(flagSet *flag.FlagSet, arguments []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flagSet.Parse(arguments); err != nil {
+			return nil, err
+		}
+		arguments = flagSet.Args()
+		if len(arguments) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, arguments[0])
+		arguments = arguments[1:]
+	}
+}
+
+// resolveSingleReference resolves the one <id|slug> argument most commands take.
+func resolveSingleReference(client *apiClient, positional []string, usage string) (*models.Deployment, error) {
+	if len(positional) != 1 {
+		return nil, fmt.Errorf("usage: %s", usage)
+	}
+	return client.ResolveDeployment(positional[0])
+}
+
+// runDeploy implements `corvus deploy <dir>` (zip upload), `corvus deploy --github <url>`
+// and `corvus deploy --git <url>` (any other git host).
+func runDeploy(client *apiClient, arguments []string) error {
+	flagSet := flag.NewFlagSet("deploy", flag.ContinueOnError)
+	name := flagSet.String("name", "", "deployment name (default: directory or repository name)")
+	slug := flagSet.String("slug", "", "custom slug for the URL, eg team-docs (default: a random one like amber-ridge-3f9a)")
+	githubURL := flagSet.String("github", "", "deploy a public GitHub repository instead of a local directory")
+	gitURL := flagSet.String("git", "", "deploy a git repository on any host (https://, file://, or ssh with --git-ssh-key) instead of a local directory")
+	// the token is read from an environment variable, a token on the command line ends up in shell history and `ps`
+	gitTokenEnvironmentVariable := flagSet.String("git-token-env", "", "name of an environment variable holding an access token for a private repository")
+	gitUsername := flagSet.String("git-username", "", "username sent with --git-token-env (default: x-access-token)")
+	gitSSHKeyFile := flagSet.String("git-ssh-key", "", "private SSH deploy key file for a private repository (needs an ssh URL)")
+	branch := flagSet.String("branch", "", "git branch (github/git only, default: the repository's default branch)")
+	ref := flagSet.String("ref", "", "pin to a tag, full commit SHA or branch instead of the head of --branch (github/git only)")
+	buildCommand := flagSet.String("build-cmd", "", "build command run in the build container (github/git only)")
+	rootDirectory := flagSet.String("root-dir", "", "app directory inside a monorepo, the build runs there (github/git only)")
+	sharedDirectories := flagSet.String("shared-dirs", "", "comma separated monorepo directories the build also needs, eg packages/ui (github/git only)")
+	gitSubmodules := flagSet.Bool("submodules", false, "initialise git submodules recursively after the clone (github/git only)")
+	gitLFS := flagSet.Bool("lfs", false, "download Git LFS objects after the clone (github/git only)")
+	outputDirectory := flagSet.String("output-dir", "", "directory with the built static files, relative to --root-dir (default \".\")")
+	spaFallback := flagSet.Bool("spa", false, "serve index.html for paths that match no file, for client-side routed apps")
+	custom404 := flagSet.Bool("custom-404", false, "serve 404.html from the output directory as the not found page")
+	cleanURLs := flagSet.Bool("clean-urls", false, "serve /about from about.html")
+	trailingSlash := flagSet.String("trailing-slash", "", "trailing slash policy: ignore (default), always or never")
+	ipAllowList := flagSet.String("ip-allow", "", "comma separated CIDR ranges the site is reachable from (default: the server's default list)")
+	ipDenyList := flagSet.String("ip-deny", "", "comma separated CIDR ranges the site is not reachable from")
+	noWait := flagSet.Bool("no-wait", false, "return as soon as the deployment is created instead of waiting for it to go live")
+	timeout := flagSet.Duration("timeout", 15*time.Minute, "how long to wait for the deployment to go live")
+	var environmentVariables []models.EnvironmentVariable
+	flagSet.Var(environmentFlag{scope: models.EnvScopeBuild, variables: &environmentVariables}, "env", "build-time KEY=VALUE (repeatable)")
+	flagSet.Var(environmentFlag{scope: models.EnvScopeRuntime, variables: &environmentVariables}, "runtime-env", "runtime KEY=VALUE, served in corvus-env.js (repeatable)")
+	flagSet.Var(environmentFlag{scope: models.EnvScopeSecret, variables: &environmentVariables}, "secret-env", "write-only build-time KEY=VALUE, masked in logs (repeatable)")
+
+	positional, err := parseInterspersedFlags(flagSet, arguments)
+	if err != nil {
+		return err
+	}
+
+	fields := createDeploymentFields{
+		Name:                 *name,
+		Slug:                 *slug,
+		Branch:               *branch,
+		Ref:                  *ref,
+		BuildCommand:         *buildCommand,
+		RootDirectory:        *rootDirectory,
+		SharedDirectories:    *sharedDirectories,
+		GitSubmodules:        *gitSubmodules,
+		GitLFS:               *gitLFS,
+		OutputDirectory:      *outputDirectory,
+		SPAFallback:          *spaFallback,
+		Custom404:            *custom404,
+		CleanURLs:            *cleanURLs,
+		TrailingSlash:        *trailingSlash,
+		IPAllowList:          *ipAllowList,
+		IPDenyList:           *ipDenyList,
+		EnvironmentVariables: environmentVariables,
+	}
+	uploadDirectory := ""
+
+	if *githubURL != "" && *gitURL != "" {
+		return errors.New("--github and --git are mutually exclusive")
+	}
+
+	if repoURL := *githubURL + *gitURL; repoURL != "" {
+		if len(positional) != 0 {
+			return errors.New("usage: corvus deploy --github|--git <url> [flags] (no directory argument)")
+		}
+		if *githubURL != "" {
+			fields.SourceType = models.SourceGitHub
+			fields.GitHubURL = *githubURL
+		} else {
+			fields.SourceType = models.SourceGit
+			fields.GitURL = *gitURL
+		}
+		if fields.Name == "" {
+			fields.Name = strings.TrimSuffix(filepath.Base(strings.TrimRight(repoURL, "/")), ".git")
+		}
+		if *gitTokenEnvironmentVariable != "" {
+			fields.GitToken = os.Getenv(*gitTokenEnvironmentVariable)
+			if fields.GitToken == "" {
+				return fmt.Errorf("environment variable %s is empty or not set", *gitTokenEnvironmentVariable)
+			}
+			fields.GitUsername = *gitUsername
+		}
+		if *gitSSHKeyFile != "" {
+			keyContent, err := os.ReadFile(*gitSSHKeyFile)
+			if err != nil {
+				return fmt.Errorf("failed to read SSH key: %w", err)
+			}
+			fields.GitSSHKey = string(keyContent)
+		}
+	} else {
+		if len(positional) != 1 {
+			return errors.New("usage: corvus deploy <directory> [flags] | corvus deploy --github|--git <url> [flags]")
+		}
+		if *gitTokenEnvironmentVariable != "" || *gitSSHKeyFile != "" {
+			return errors.New("--git-token-env and --git-ssh-key only apply to --github/--git deployments")
+		}
+		if *buildCommand != "" || *branch != "" || *ref != "" || *rootDirectory != "" || *sharedDirectories != "" || *gitSubmodules || *gitLFS {
+			return errors.New("--build-cmd, --branch, --ref, --root-dir, --shared-dirs, --submodules and --lfs only apply to --github/--git deployments, build locally before deploying a directory")
+		}
+		absoluteDirectory, err := filepath.Abs(positional[0])
+		if err != nil {
+			return err
+		}
+		directoryInfo, err := os.Stat(absoluteDirectory)
+		if err != nil {
+			return err
+		}
+		if !directoryInfo.IsDir() {
+			return fmt.Errorf("%s is not a directory", positional[0])
+		}
+		fields.SourceType = models.SourceZip
+		uploadDirectory = absoluteDirectory
+		if fields.Name == "" {
+			fields.Name = filepath.Base(absoluteDirectory)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "creating deployment %q (%s)...\n", fields.Name, fields.SourceType)
+	deployment, err := client.CreateDeployment(fields, uploadDirectory)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "deployment %s created (slug %s)\n", deployment.ID, deployment.Slug)
+
+	if *noWait {
+		printDeploymentURL(deployment)
+		return nil
+	}
+	return waitForPipelineRun(client, deployment, "", *timeout)
+}
+
+// runRedeploy implements `corvus redeploy <id|slug>`.
+func runRedeploy(client *apiClient, arguments []string) error {
+	flagSet := flag.NewFlagSet("redeploy", flag.ContinueOnError)
+	noWait := flagSet.Bool("no-wait", false, "return as soon as the redeploy is accepted")
+	ref := flagSet.String("ref", "", "re-pin a github/git deployment to a tag, full commit SHA or branch (\"-\" removes the pin)")
+	timeout := flagSet.Duration("timeout", 15*time.Minute, "how long to wait for the deployment to go live")
+	positional, err := parseInterspersedFlags(flagSet, arguments)
+	if err != nil {
+		return err
+	}
+	deployment, err := resolveSingleReference(client, positional, "corvus redeploy <id|slug> [--ref <ref>] [--no-wait]")
+	if err != nil {
+		return err
+	}
+
+	// remember the current run, so waiting does not mistake the previous run's result for this one
+	previousRunID := ""
+	previousEvents, err := client.ListLatestRunEvents(deployment.ID)
+	if err != nil {
+		return err
+	}
+	if len(previousEvents) > 0 {
+		previousRunID = previousEvents[0].RunID
+	}
+
+	// nil keeps the deployment's current ref, "-" is sent as "" which unpins it
+	var redeployRef *string
+	switch *ref {
+	case "":
+	case "-":
+		redeployRef = new(string)
+	default:
+		redeployRef = ref
+	}
+	if _, err := client.RedeployDeployment(deployment.ID, redeployRef); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "redeploy of %s accepted\n", deployment.Slug)
+	if *noWait {
+		return nil
+	}
+	return waitForPipelineRun(client, deployment, previousRunID, *timeout)
+}
+
+// waitForPipelineRun polls the structured pipeline events until the run after previousRunID
+// finishes, printing each step as it completes. returns an error when the run fails,
+// so `corvus deploy` exits non-zero in CI.
+func waitForPipelineRun(client *apiClient, deployment *models.Deployment, previousRunID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	printedEvents := 0
+	currentRunID := ""
+
+	for {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for %s, check `corvus logs %s`", timeout, deployment.Slug, deployment.Slug)
+		}
+
+		events, err := client.ListLatestRunEvents(deployment.ID)
+		if err != nil {
+			return err
+		}
+		// the pipeline goroutine may not have recorded its first event yet
+		if len(events) == 0 || events[0].RunID == previousRunID {
+			time.Sleep(pollInterval)
+			continue
+		}
+		if events[0].RunID != currentRunID {
+			currentRunID = events[0].RunID
+			printedEvents = 0
+		}
+
+		for _, event := range events[printedEvents:] {
+			printPipelineEvent(event)
+			if event.Step != "pipeline" {
+				continue
+			}
+			switch event.Type {
+			case models.EventStepFinished:
+				printDeploymentURL(deployment)
+				return nil
+			case models.EventStepFailed:
+				return fmt.Errorf("deployment failed: %s (full log: `corvus logs %s`)", event.Message, deployment.Slug)
+			}
+		}
+		printedEvents = len(events)
+		time.Sleep(pollInterval)
+	}
+}
+
+// printPipelineEvent prints one step transition, eg "  ok    clone (2.1s)".
+func printPipelineEvent(event *models.PipelineEvent) {
+	if event.Step == "pipeline" {
+		return // the whole-run step is reported by the caller
+	}
+	duration := ""
+	if event.DurationMs != nil {
+		duration = fmt.Sprintf(" (%.1fs)", float64(*event.DurationMs)/1000)
+	}
+	switch event.Type {
+	case models.EventStepStarted:
+		fmt.Fprintf(os.Stderr, "  ...   %s\n", event.Step)
+	case models.EventStepFinished:
+		fmt.Fprintf(os.Stderr, "  ok    %s%s\n", event.Step, duration)
+	case models.EventStepFailed:
+		fmt.Fprintf(os.Stderr, "  FAIL  %s%s: %s\n", event.Step, duration, event.Message)
+	}
+}
+
+// printDeploymentURL prints the URL on stdout (everything else goes to stderr),
+// so `url=$(corvus deploy ./dist)` works in scripts.
+func printDeploymentURL(deployment *models.Deployment) {
+	if deployment.URL != nil {
+		fmt.Println(*deployment.URL)
+	}
+}
+
+// runList implements `corvus ls`.
+func runList(client *apiClient, arguments []string) error {
+	if len(arguments) != 0 {
+		return errors.New("usage: corvus ls")
+	}
+	deployments, err := client.ListDeployments()
+	if err != nil {
+		return err
+	}
+	if len(deployments) == 0 {
+		fmt.Fprintln(os.Stderr, "no deployments")
+		return nil
+	}
+
+	tableWriter := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tableWriter, "ID\tSLUG\tNAME\tSOURCE\tSTATUS\tEXPIRES\tURL")
+	for _, deployment := range deployments {
+		expires := "never"
+		if deployment.ExpiresAt != nil {
+			expires = time.Until(*deployment.ExpiresAt).Round(time.Minute).String()
+			if time.Now().After(*deployment.ExpiresAt) {
+				expires = "expired"
+			}
+		}
+		url := ""
+		if deployment.URL != nil {
+			url = *deployment.URL
+		}
+		// the first 8 characters are enough to address a deployment (ResolveDeployment accepts prefixes)
+		fmt.Fprintf(tableWriter, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			deployment.ID[:min(8, len(deployment.ID))],
+			deployment.Slug, deployment.Name, deployment.SourceType, deployment.Status, expires, url)
+	}
+	return tableWriter.Flush()
+}
+
+// runLogs implements `corvus logs [-f] <id|slug>`.
+func runLogs(client *apiClient, arguments []string) error {
+	flagSet := flag.NewFlagSet("logs", flag.ContinueOnError)
+	follow := flagSet.Bool("f", false, "keep printing new log lines until interrupted (Ctrl+C)")
+	positional, err := parseInterspersedFlags(flagSet, arguments)
+	if err != nil {
+		return err
+	}
+	deployment, err := resolveSingleReference(client, positional, "corvus logs [-f] <id|slug>")
+	if err != nil {
+		return err
+	}
+
+	interruptChannel := make(chan os.Signal, 1)
+	signal.Notify(interruptChannel, os.Interrupt)
+
+	var offset int64
+	for {
+		logChunk, nextOffset, err := client.ReadLogs(deployment.ID, offset)
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(logChunk) // nolint:errcheck -- a closed stdout (eg, `| head`) just ends the output
+		offset = nextOffset
+
+		if len(logChunk) > 0 {
+			continue // more may be buffered server side, read again right away
+		}
+		if !*follow {
+			return nil
+		}
+		select {
+		case <-interruptChannel:
+			return nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// runRemove implements `corvus rm <id|slug>`.
+func runRemove(client *apiClient, arguments []string) error {
+	deployment, err := resolveSingleReference(client, arguments, "corvus rm <id|slug>")
+	if err != nil {
+		return err
+	}
+	if err := client.DeleteDeployment(deployment.ID); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "deployment %s (%s) removed\n", deployment.Slug, deployment.ID)
+	return nil
+}
+
+// runPassword implements `corvus password <id|slug>`, setting, rotating or removing
+// the password (HTTP basic auth) of a deployment without redeploying it.
+// the password is read from an environment variable, so it stays out of the shell history.
+func runPassword(client *apiClient, arguments []string) error {
+	flagSet := flag.NewFlagSet("password", flag.ContinueOnError)
+	user := flagSet.String("user", "", "user name visitors log in with")
+	passwordEnvironmentVariable := flagSet.String("password-env", "", "name of an environment variable holding the password (8-72 characters)")
+	remove := flagSet.Bool("remove", false, "remove the password, the site becomes public again")
+	positional, err := parseInterspersedFlags(flagSet, arguments)
+	if err != nil {
+		return err
+	}
+	deployment, err := resolveSingleReference(client, positional,
+		"corvus password <id|slug> (--user <name> --password-env <VAR> | --remove)")
+	if err != nil {
+		return err
+	}
+
+	if *remove {
+		if *user != "" || *passwordEnvironmentVariable != "" {
+			return errors.New("--remove cannot be combined with --user or --password-env")
+		}
+		if _, err := client.RemoveBasicAuth(deployment.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "password of %s removed, the site is public\n", deployment.Slug)
+		return nil
+	}
+
+	if *user == "" || *passwordEnvironmentVariable == "" {
+		return errors.New("--user and --password-env are required (or --remove)")
+	}
+	password := os.Getenv(*passwordEnvironmentVariable)
+	if password == "" {
+		return fmt.Errorf("environment variable %s is empty or not set", *passwordEnvironmentVariable)
+	}
+	if _, err := client.SetBasicAuth(deployment.ID, *user, password); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s is now password protected (user %s)\n", deployment.Slug, *user)
+	return nil
+}
+
+// runExtend implements `corvus extend <id|slug>`, pushing back the expiry of a deployment.
+// the friend code from the config raises how long it can be kept alive.
+func runExtend(client *apiClient, arguments []string) error {
+	flagSet := flag.NewFlagSet("extend", flag.ContinueOnError)
+	minutes := flagSet.Int("minutes", 0, "minutes to add (default: the server's TTL)")
+	positional, err := parseInterspersedFlags(flagSet, arguments)
+	if err != nil {
+		return err
+	}
+	deployment, err := resolveSingleReference(client, positional, "corvus extend <id|slug> [--minutes <n>]")
+	if err != nil {
+		return err
+	}
+	if *minutes < 0 {
+		return errors.New("--minutes must be positive")
+	}
+
+	extendedDeployment, err := client.ExtendDeployment(deployment.ID, *minutes)
+	if err != nil {
+		return err
+	}
+	if extendedDeployment.ExpiresAt == nil {
+		fmt.Fprintf(os.Stderr, "%s does not expire\n", deployment.Slug)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "%s now expires in %s (%s)\n", deployment.Slug,
+		time.Until(*extendedDeployment.ExpiresAt).Round(time.Minute), extendedDeployment.ExpiresAt.Local().Format(time.DateTime))
+	return nil
+}
+
+// runPin implements `corvus pin <id|slug>` (admin only), making a deployment never expire,
+// `--remove` gives it the server's TTL again. the admin token is read from CORVUS_ADMIN_TOKEN.
+func runPin(client *apiClient, arguments []string) error {
+	flagSet := flag.NewFlagSet("pin", flag.ContinueOnError)
+	remove := flagSet.Bool("remove", false, "unpin, the deployment expires after the server's TTL again")
+	positional, err := parseInterspersedFlags(flagSet, arguments)
+	if err != nil {
+		return err
+	}
+	deployment, err := resolveSingleReference(client, positional, "corvus pin <id|slug> [--remove]")
+	if err != nil {
+		return err
+	}
+	adminToken := os.Getenv("CORVUS_ADMIN_TOKEN")
+	if adminToken == "" {
+		return errors.New("environment variable CORVUS_ADMIN_TOKEN is empty or not set")
+	}
+
+	if _, err := client.SetPinned(deployment.ID, adminToken, !*remove); err != nil {
+		return err
+	}
+	if *remove {
+		fmt.Fprintf(os.Stderr, "%s unpinned\n", deployment.Slug)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s pinned, it no longer expires\n", deployment.Slug)
+	}
+	return nil
+}
+
+// runIPAccess implements `corvus ip-access <id|slug>`, replacing the IP allow and deny lists
+// of a deployment without redeploying it. a list whose flag is left out is reset
+// (the allow list back to the server's default), `--allow ""` allows every address.
+func runIPAccess(client *apiClient, arguments []string) error {
+	flagSet := flag.NewFlagSet("ip-access", flag.ContinueOnError)
+	allow := flagSet.String("allow", "", "comma separated CIDR ranges the site is reachable from")
+	deny := flagSet.String("deny", "", "comma separated CIDR ranges the site is not reachable from")
+	positional, err := parseInterspersedFlags(flagSet, arguments)
+	if err != nil {
+		return err
+	}
+	deployment, err := resolveSingleReference(client, positional, "corvus ip-access <id|slug> [--allow <ranges>] [--deny <ranges>]")
+	if err != nil {
+		return err
+	}
+
+	// only the flags actually given are sent, the others are null (reset)
+	var allowList, denyList *string
+	flagSet.Visit(func(setFlag *flag.Flag) {
+		switch setFlag.Name {
+		case "allow":
+			allowList = allow
+		case "deny":
+			denyList = deny
+		}
+	})
+
+	updatedDeployment, err := client.UpdateIPAccess(deployment.ID, allowList, denyList)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "ip access of %s updated (allow: %s, deny: %s)\n",
+		deployment.Slug,
+		describeIPAccessList(updatedDeployment.IPAllowList, "server default"),
+		describeIPAccessList(updatedDeployment.IPDenyList, "none"),
+	)
+	return nil
+}
+
+// describeIPAccessList describes a stored IP access list for the `ip-access` output,
+// unsetDescription is what a list that is not set means.
+func describeIPAccessList(list *string, unsetDescription string) string {
+	switch {
+	case list == nil:
+		return unsetDescription
+	case *list == "":
+		return "any address"
+	default:
+		return *list
+	}
+}
+
+// runOpen implements `corvus open <id|slug>`, opening the live site in the default browser.
+func runOpen(client *apiClient, arguments []string) error {
+	deployment, err := resolveSingleReference(client, arguments, "corvus open <id|slug>")
+	if err != nil {
+		return err
+	}
+	if deployment.URL == nil {
+		return fmt.Errorf("deployment %s has no URL", deployment.Slug)
+	}
+
+	var openCommand *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		openCommand = exec.Command("open", *deployment.URL)
+	case "windows":
+		openCommand = exec.Command("rundll32", "url.dll,FileProtocolHandler", *deployment.URL)
+	default:
+		openCommand = exec.Command("xdg-open", *deployment.URL)
+	}
+	if err := openCommand.Start(); err != nil {
+		// no browser (eg, over ssh), the URL is still useful
+		fmt.Println(*deployment.URL)
+		return fmt.Errorf("failed to open a browser: %w", err)
+	}
+	return nil
+}
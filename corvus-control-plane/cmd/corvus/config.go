@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultServerURL is used when neither the config file nor CORVUS_SERVER_URL sets one.
+// matches the control plane's default PORT for local development.
+const defaultServerURL = "http://localhost:8080"
+
+// cliConfig is the content of the CLI config file (JSON):
+//
+//	{
+//	  "server_url": "https://api-corvus.sasta.dev",
+//	  "friend_code": "..."
+//	}
+//
+// the friend code is the only credential the control plane API accepts today,
+// it is sent with every create request to get the extended TTL.
+type cliConfig struct {
+	ServerURL  string `json:"server_url"`
+	FriendCode string `json:"friend_code"`
+}
+
+// configFilePath returns where the config file is read from:
+// $CORVUS_CONFIG when set, otherwise <user config dir>/corvus/config.json
+// (eg, ~/.config/corvus/config.json on linux).
+func configFilePath() (string, error) {
+	if explicitPath := os.Getenv("CORVUS_CONFIG"); explicitPath != "" {
+		return explicitPath, nil
+	}
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the user config directory (set CORVUS_CONFIG instead): %w", err)
+	}
+	return filepath.Join(userConfigDir, "corvus", "config.json"), nil
+}
+
+// loadCLIConfig reads the config file and applies the environment variable overrides.
+// a missing config file is not an error (everything has a default or an env variable),
+// so CI jobs can run with only CORVUS_SERVER_URL and CORVUS_FRIEND_CODE set.
+func loadCLIConfig() (*cliConfig, error) {
+	config := &cliConfig{}
+
+	path, err := configFilePath()
+	if err != nil {
+		return nil, err
+	}
+	configBytes, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read config file %q: %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(configBytes, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %q: %w", path, err)
+		}
+	}
+
+	// env variables win over the file, the usual precedence for CI secrets
+	if serverURL := os.Getenv("CORVUS_SERVER_URL"); serverURL != "" {
+		config.ServerURL = serverURL
+	}
+	if friendCode := os.Getenv("CORVUS_FRIEND_CODE"); friendCode != "" {
+		config.FriendCode = friendCode
+	}
+
+	if config.ServerURL == "" {
+		config.ServerURL = defaultServerURL
+	}
+	config.ServerURL = strings.TrimRight(config.ServerURL, "/")
+	return config, nil
+}
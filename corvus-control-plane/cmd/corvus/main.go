@@ -0,0 +1,90 @@
+// Command corvus is the command-line client for the corvus control plane.
+// it talks to the same REST API as the React frontend, so anything the CLI does
+// can also be done with curl. the point is one-liners for CI jobs and terminals:
+//
+//	corvus deploy ./dist
+//	corvus deploy --github https://github.com/user/repo --build-cmd "npm ci && npm run build" --output-dir dist
+//	corvus deploy --git https://gitea.example.com/user/repo.git
+//	corvus deploy --git git@github.com:user/private.git --git-ssh-key ./deploy_key
+//	corvus ls
+//	corvus logs -f <id|slug>
+//	corvus redeploy <id|slug>
+//	corvus rm <id|slug>
+//	corvus open <id|slug>
+//
+// the server URL and friend code are read from the config file (see config.go),
+// overridable with CORVUS_SERVER_URL and CORVUS_FRIEND_CODE.
+//
+// build with: go build -o corvus ./cmd/corvus
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usageText = `usage: corvus <command> [arguments]
+
+commands:
+  deploy <directory>       zip a local directory and deploy it
+  deploy --github <url>    deploy a GitHub repository
+  deploy --git <url>       deploy a git repository on any host (https://, file:// or ssh)
+                           private repositories: --git-token-env <VAR> or --git-ssh-key <file>
+  ls                       list deployments
+  logs [-f] <id|slug>      print (and follow) the deployment log
+  redeploy <id|slug>       redeploy an existing deployment
+  rm <id|slug>             delete a deployment
+  password <id|slug>       password protect a deployment: --user <name> --password-env <VAR>, or --remove
+  extend <id|slug>         push back the expiry of a deployment (--minutes <n>)
+  pin <id|slug>            admin: make a deployment never expire (--remove unpins), needs CORVUS_ADMIN_TOKEN
+  ip-access <id|slug>      restrict a deployment to IP ranges: --allow <ranges> --deny <ranges> (omitted = reset)
+  open <id|slug>           open the live site in a browser
+
+run "corvus <command> -h" for the flags of a command.
+config: $CORVUS_CONFIG or ~/.config/corvus/config.json ({"server_url": "...", "friend_code": "..."}),
+        overridden by CORVUS_SERVER_URL and CORVUS_FRIEND_CODE.
+`
+
+// commands maps each subcommand to its implementation (commands.go).
+var commands = map[string]func(client *apiClient, arguments []string) error{
+	"deploy":    runDeploy,
+	"ls":        runList,
+	"logs":      runLogs,
+	"redeploy":  runRedeploy,
+	"rm":        runRemove,
+	"password":  runPassword,
+	"ip-access": runIPAccess,
+	"extend":    runExtend,
+	"pin":       runPin,
+	"open":      runOpen,
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		fmt.Fprint(os.Stderr, usageText)
+		os.Exit(2)
+	}
+
+	command, found := commands[os.Args[1]]
+	if !found {
+		fmt.Fprintf(os.Stderr, "corvus: unknown command %q\n\n%s", os.Args[1], usageText)
+		os.Exit(2)
+	}
+
+	config, err := loadCLIConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "corvus: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = command(newAPIClient(config), os.Args[2:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2) // the flag package already printed the command's flags
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "corvus: %v\n", err)
+		os.Exit(1)
+	}
+}
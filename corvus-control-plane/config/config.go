@@ -65,11 +65,96 @@ type AppConfig struct {
 	// ExtendedTTLMinutes is the lifetime when a valid friend code is provided.
 	ExtendedTTLMinutes int
 
+	// MaxLifetimeMinutes caps how long after its creation a public deployment can be kept alive
+	// with POST /api/deployments/:uuid/extend. 0 disables extending for public callers.
+	MaxLifetimeMinutes int
+
+	// ExtendedMaxLifetimeMinutes is the same cap for callers with a valid friend code.
+	ExtendedMaxLifetimeMinutes int
+
+	// AdminToken authenticates the admin-only endpoints (pinning a deployment so it never expires),
+	// sent as "Authorization: Bearer <token>". empty disables them.
+	AdminToken string
+
 	// CORSOrigin is the allowed origin for CORS headers.
 	// set to "*" during development, restrict to the frontend domain in production.
 	CORSOrigin string
+
+	// ReadinessMinFreeDiskMB is the minimum free disk space (in megabytes) each storage root
+	// must have for GET /ready to report ready. below it, builds and extractions start failing
+	// with confusing "no space left on device" errors halfway through a pipeline.
+	ReadinessMinFreeDiskMB int
+
+	// MaxUploadSizeMB caps the size of the request body of POST /api/deployments (the archive plus form fields).
+	// anything larger is cut off while it is being read and answered with 413.
+	MaxUploadSizeMB int
+
+	// UploadTimeoutSeconds is how long POST /api/deployments may take to receive its body.
+	// the server wide 15s ReadTimeout is too short for large uploads on slow connections,
+	// so that one handler extends its own deadline to this.
+	UploadTimeoutSeconds int
+
+	// limits for extracting an uploaded archive (zip bomb protection), see build.ArchiveLimits.
+	// the upload limit alone is not enough, a 40 KB zip can expand to many gigabytes.
+	// 0 disables a limit.
+	MaxArchiveUncompressedMB   int
+	MaxArchiveEntries          int
+	MaxArchiveFileSizeMB       int
+	MaxArchiveCompressionRatio int
+
+	// CredentialsEncryptionKey is the base64 encoded 32 byte AES key that private repository
+	// credentials are encrypted with before they are stored (see util.CredentialCipher).
+	// empty disables private repositories. changing it makes every stored credential unreadable.
+	CredentialsEncryptionKey string
+
+	// SlugDenylist is a comma separated list of extra words user chosen slugs may not contain
+	// (as the whole slug or a dash separated part), on top of the built-in reserved and offensive words.
+	SlugDenylist string
+
+	// DefaultIPAllowList is a comma separated list of CIDR ranges (or addresses) deployments are
+	// reachable from when they have no allow list of their own. empty means every address.
+	DefaultIPAllowList string
+
+	// DefaultIPDenyList is a comma separated list of CIDR ranges (or addresses) no deployment is
+	// reachable from, on top of each deployment's own deny list.
+	DefaultIPDenyList string
+
+	// IPAccessForwardedDepth is how many proxies in front of Traefik append the client address to
+	// X-Forwarded-For (eg, 1 behind a Cloudflare Tunnel). 0 checks the address connecting to Traefik.
+	// Traefik only keeps X-Forwarded-For from its forwardedHeaders.trustedIPs.
+	IPAccessForwardedDepth int
+
+	// TracingExporter selects where OpenTelemetry spans are sent.
+	// accepted values: "none" (default, tracing off) | "otlp" | "stdout" | "file"
+	TracingExporter string
+
+	// TracingOTLPEndpoint is the collector URL for the "otlp" exporter (eg, "http://localhost:4318").
+	// empty falls back to the standard OTEL_EXPORTER_OTLP_ENDPOINT env variable.
+	TracingOTLPEndpoint string
+
+	// TracingFilePath is the file the "file" exporter appends spans to (one JSON span per line).
+	TracingFilePath string
+
+	// ServingMode selects how deployments are served.
+	// accepted values: "container" (default, one nginx container per deployment) |
+	// "shared" (every deployment is served by one static file server inside the control plane)
+	ServingMode string
+
+	// SharedServerPort is the TCP port the shared static server listens on (shared serving mode only).
+	// Traefik routes every deployment host to it, it is separate from the API port.
+	SharedServerPort string
+
+	// SharedServerHostSuffix is what follows the slug in a deployment's host name,
+	// the shared static server picks the deployment from the Host header with it.
+	SharedServerHostSuffix string
 }
 
+// serving modes accepted by ServingMode
+const (
+	ServingModeContainer = "container"
+	ServingModeShared    = "shared"
+)
+
 // NewLogger constructs a *slog.Logger based on the LogFormat field of the config.
 // "text" produces human-readable output for local development
 // any other value (including "json") produces structured JSON output for production
@@ -174,8 +259,37 @@ func LoadAppConfig() *AppConfig {
 		DefaultTTLMinutes:  getEnvInt("DEFAULT_TTL_MINUTES", 15),
 		ExtendedTTLMinutes: getEnvInt("EXTENDED_TTL_MINUTES", 60),
 
+		MaxLifetimeMinutes:         getEnvInt("MAX_LIFETIME_MINUTES", 120),
+		ExtendedMaxLifetimeMinutes: getEnvInt("EXTENDED_MAX_LIFETIME_MINUTES", 1440),
+		AdminToken:                 getEnv("ADMIN_TOKEN", ""),
+
 		CORSOrigin: getEnv("CORS_ORIGIN", "https://corvus.sasta.dev"),
 
+		ReadinessMinFreeDiskMB: getEnvInt("READINESS_MIN_FREE_DISK_MB", 1024),
+
+		MaxUploadSizeMB:            getEnvInt("MAX_UPLOAD_SIZE_MB", 50), // same 50MB the frontend enforces
+		UploadTimeoutSeconds:       getEnvInt("UPLOAD_TIMEOUT_SECONDS", 300),
+		MaxArchiveUncompressedMB:   getEnvInt("MAX_ARCHIVE_UNCOMPRESSED_MB", 500),
+		MaxArchiveEntries:          getEnvInt("MAX_ARCHIVE_ENTRIES", 10000),
+		MaxArchiveFileSizeMB:       getEnvInt("MAX_ARCHIVE_FILE_SIZE_MB", 100),
+		MaxArchiveCompressionRatio: getEnvInt("MAX_ARCHIVE_COMPRESSION_RATIO", 100),
+
+		CredentialsEncryptionKey: getEnv("CREDENTIALS_ENCRYPTION_KEY", ""),
+
+		SlugDenylist: getEnv("SLUG_DENYLIST", ""),
+
+		DefaultIPAllowList:     getEnv("DEFAULT_IP_ALLOW_LIST", ""),
+		DefaultIPDenyList:      getEnv("DEFAULT_IP_DENY_LIST", ""),
+		IPAccessForwardedDepth: getEnvInt("IP_ACCESS_FORWARDED_DEPTH", 0),
+
+		TracingExporter:     getEnv("TRACING_EXPORTER", "none"),
+		TracingOTLPEndpoint: getEnv("TRACING_OTLP_ENDPOINT", ""),
+		TracingFilePath:     getEnv("TRACING_FILE_PATH", "/srv/corvus-paas/logs/traces.jsonl"),
+
+		ServingMode:            getEnv("SERVING_MODE", ServingModeContainer),
+		SharedServerPort:       getEnv("SHARED_SERVER_PORT", "8081"),
+		SharedServerHostSuffix: getEnv("SHARED_SERVER_HOST_SUFFIX", "-corvus.sasta.dev"),
+
 		// TODO add env var for traefik stuff like domains, base domains and stuff here
 	}
 }
@@ -4,6 +4,7 @@
 package db
 
 import (
+	"context"
 	"database/sql" // standard lib for SQL acess. provides DB connection pool and query execution methods
 	"fmt"
 	"log/slog"
@@ -65,16 +66,48 @@ func (database *Database) migrate() error {
 		return fmt.Errorf("failed to execute schema migration (create tables & columns): %w", err)
 	}
 
-	// add preset_id column to existing databases that were created before
-	// this column existed. SQLite returns an error if the column already exists,
+	// add columns to existing databases that were created before
+	// these columns existed. SQLite returns an error if the column already exists,
 	// which we silently ignore since that means the migration already ran.
-	database.connection.Exec("ALTER TABLE deployments ADD COLUMN preset_id TEXT")
+	for _, addColumnStatement := range addedColumnMigrations {
+		database.connection.Exec(addColumnStatement)
+	}
 
 	return nil
 }
 
+// addedColumnMigrations lists the ALTER TABLE statements for every column added
+// after the original schema. new columns are appended here AND to the schema below,
+// so fresh databases get them from CREATE TABLE and old databases get them from here.
+var addedColumnMigrations = []string{
+	"ALTER TABLE deployments ADD COLUMN preset_id TEXT",
+	"ALTER TABLE deployments ADD COLUMN runtime_env_vars TEXT",
+	"ALTER TABLE deployments ADD COLUMN secret_env_vars TEXT",
+	"ALTER TABLE deployments ADD COLUMN git_url TEXT",
+	"ALTER TABLE deployments ADD COLUMN git_credential_type TEXT",
+	"ALTER TABLE deployments ADD COLUMN git_credential TEXT",
+	"ALTER TABLE deployments ADD COLUMN ref TEXT",
+	"ALTER TABLE deployments ADD COLUMN commit_sha TEXT",
+	"ALTER TABLE deployments ADD COLUMN commit_message TEXT",
+	"ALTER TABLE deployments ADD COLUMN root_directory TEXT NOT NULL DEFAULT '.'",
+	"ALTER TABLE deployments ADD COLUMN shared_dirs TEXT",
+	"ALTER TABLE deployments ADD COLUMN git_submodules INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE deployments ADD COLUMN git_lfs INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE deployments ADD COLUMN parent_id TEXT",
+	"ALTER TABLE deployments ADD COLUMN pr_number INTEGER",
+	"ALTER TABLE deployments ADD COLUMN template_values TEXT",
+	"ALTER TABLE deployments ADD COLUMN spa_fallback INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE deployments ADD COLUMN custom_404 INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE deployments ADD COLUMN clean_urls INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE deployments ADD COLUMN trailing_slash TEXT NOT NULL DEFAULT 'ignore'",
+	"ALTER TABLE deployments ADD COLUMN basic_auth_user TEXT",
+	"ALTER TABLE deployments ADD COLUMN basic_auth_hash TEXT",
+	"ALTER TABLE deployments ADD COLUMN ip_allow_list TEXT",
+	"ALTER TABLE deployments ADD COLUMN ip_deny_list TEXT",
+}
+
 /*
-schema is the SQL DDL that defines the deployments table.
+schema is the SQL DDL that defines the deployments table and the deployment_events table.
 It uses IF NOT EXISTS so it is safe to run on every startup, no run if the table exists, creates it if not.
 this is a minimal migration strategy appropriate for a single-node PoC (proof of concept)
 For a production system with multiple schema versions, a proper migration
@@ -88,19 +121,56 @@ CREATE TABLE IF NOT EXISTS deployments (
     name           TEXT NOT NULL,
     source_type    TEXT NOT NULL,
     github_url     TEXT,
+    git_url        TEXT,
+    git_credential_type TEXT,
+    git_credential TEXT,
     branch         TEXT NOT NULL DEFAULT 'main',
+    ref            TEXT,
+    commit_sha     TEXT,
+    commit_message TEXT,
     build_cmd      TEXT NOT NULL DEFAULT '',
+    root_directory TEXT NOT NULL DEFAULT '.',
+    shared_dirs    TEXT,
+    git_submodules INTEGER NOT NULL DEFAULT 0,
+    git_lfs        INTEGER NOT NULL DEFAULT 0,
     output_dir     TEXT NOT NULL DEFAULT '.',
+    spa_fallback   INTEGER NOT NULL DEFAULT 0,
+    custom_404     INTEGER NOT NULL DEFAULT 0,
+    clean_urls     INTEGER NOT NULL DEFAULT 0,
+    trailing_slash TEXT NOT NULL DEFAULT 'ignore',
+    basic_auth_user TEXT,
+    basic_auth_hash TEXT,
+    ip_allow_list TEXT,
+    ip_deny_list TEXT,
     env_vars       TEXT,
+    runtime_env_vars TEXT,
+    secret_env_vars  TEXT,
     status         TEXT NOT NULL,
     url            TEXT,
     webhook_secret TEXT,
     auto_deploy    INTEGER NOT NULL DEFAULT 0,
 	preset_id      TEXT,
+    template_values TEXT,
+    parent_id      TEXT,
+    pr_number      INTEGER,
 	expires_at     DATETIME,
     created_at     DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at     DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
+
+CREATE TABLE IF NOT EXISTS deployment_events (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    deployment_id  TEXT NOT NULL,
+    run_id         TEXT NOT NULL,
+    step           TEXT NOT NULL,
+    type           TEXT NOT NULL,
+    duration_ms    INTEGER,
+    message        TEXT NOT NULL DEFAULT '',
+    metadata       TEXT,
+    created_at     DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_deployment_events_deployment_id ON deployment_events (deployment_id, id);
 `
 
 /*
@@ -165,3 +235,17 @@ func OpenDatabase(dbPath string, logger *slog.Logger) (*Database, error) {
 func (database *Database) CloseDatabase() error {
 	return database.connection.Close()
 }
+
+// Ping runs a trivial query against the database to confirm it can still serve queries.
+// used by GET /ready. a plain PingContext is not enough for SQLite, since it succeeds
+// as long as the pool can hand out a connection, even when the file has become unreadable.
+// because MaxOpenConns is 1, this also waits behind any long running query,
+// so the caller should pass a context with a timeout.
+func (database *Database) Ping(context context.Context) error {
+	var one int
+	err := database.connection.QueryRowContext(context, `SELECT 1`).Scan(&one)
+	if err != nil {
+		return fmt.Errorf("database ping failed: %w", err)
+	}
+	return nil
+}
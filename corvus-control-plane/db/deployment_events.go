@@ -0,0 +1,139 @@
+package db
+
+// deployment_events.go contains all SQL query functions for the deployment_events table.
+// each row is one structured pipeline event (step started / finished / failed).
+// the table is append-only while a deployment exists and is cleared when the deployment is torn down.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+)
+
+// InsertPipelineEvent appends one pipeline event.
+// Metadata is JSON-encoded into the metadata column (NULL when empty).
+// ID and CreatedAt are populated on the passed event after the insert.
+func (database *Database) InsertPipelineEvent(event *models.PipelineEvent) error {
+	query := `
+		INSERT INTO deployment_events (
+			deployment_id, run_id, step, type,
+			duration_ms, message, metadata, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	var encodedMetadata *string // nil inserts NULL
+	if len(event.Metadata) > 0 {
+		metadataBytes, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata for %s event of step %q: %w", event.Type, event.Step, err)
+		}
+		encoded := string(metadataBytes)
+		encodedMetadata = &encoded
+	}
+
+	event.CreatedAt = time.Now().UTC()
+
+	result, err := database.connection.Exec(query,
+		event.DeploymentID,
+		event.RunID,
+		event.Step,
+		event.Type,
+		event.DurationMs, // *int64, nil inserts NULL
+		event.Message,
+		encodedMetadata,
+		event.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert pipeline event for deployment %q: %w", event.DeploymentID, err)
+	}
+
+	// LastInsertId returns the AUTOINCREMENT id SQLite assigned to the row.
+	insertedID, err := result.LastInsertId()
+	if err == nil {
+		event.ID = insertedID
+	}
+	return nil
+}
+
+// ListPipelineEvents returns the events of a deployment in the order they were recorded.
+// when runID is non-empty, only the events of that run are returned.
+func (database *Database) ListPipelineEvents(deploymentID string, runID string) ([]*models.PipelineEvent, error) {
+	query := `
+		SELECT
+			id, deployment_id, run_id, step, type,
+			duration_ms, message, metadata, created_at
+		FROM deployment_events
+		WHERE deployment_id = ?
+		  AND (? = '' OR run_id = ?)
+		ORDER BY id ASC
+	`
+
+	rows, err := database.connection.Query(query, deploymentID, runID, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pipeline events for deployment %q: %w", deploymentID, err)
+	}
+	defer rows.Close()
+
+	var events []*models.PipelineEvent
+	for rows.Next() {
+		var event models.PipelineEvent
+		var encodedMetadata *string // scans NULL -> nil *string
+
+		err := rows.Scan(
+			&event.ID,
+			&event.DeploymentID,
+			&event.RunID,
+			&event.Step,
+			&event.Type,
+			&event.DurationMs, // scans NULL -> nil *int64
+			&event.Message,
+			&encodedMetadata,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pipeline event row: %w", err)
+		}
+
+		if encodedMetadata != nil && *encodedMetadata != "" {
+			if err := json.Unmarshal([]byte(*encodedMetadata), &event.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to decode metadata of pipeline event %d: %w", event.ID, err)
+			}
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating pipeline event rows: %w", err)
+	}
+	return events, nil
+}
+
+// LatestPipelineRunID returns the run_id of the most recent pipeline run of a deployment.
+// returns ErrRecordNotFound when the deployment has no events yet.
+func (database *Database) LatestPipelineRunID(deploymentID string) (string, error) {
+	query := `SELECT run_id FROM deployment_events WHERE deployment_id = ? ORDER BY id DESC LIMIT 1`
+
+	var runID string
+	err := database.connection.QueryRow(query, deploymentID).Scan(&runID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrRecordNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get latest pipeline run for deployment %q: %w", deploymentID, err)
+	}
+	return runID, nil
+}
+
+// DeletePipelineEvents removes every event of a deployment.
+// called during teardown, before the deployment row itself is deleted.
+// deleting zero rows is not an error (a deployment may have no events).
+func (database *Database) DeletePipelineEvents(deploymentID string) error {
+	_, err := database.connection.Exec(`DELETE FROM deployment_events WHERE deployment_id = ?`, deploymentID)
+	if err != nil {
+		return fmt.Errorf("failed to delete pipeline events for deployment %q: %w", deploymentID, err)
+	}
+	return nil
+}
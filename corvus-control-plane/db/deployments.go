@@ -25,16 +25,47 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/mattn/go-sqlite3"
 	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
 )
 
+// deploymentColumns is the column list shared by every query that reads or writes a full
+// deployments row. the order MUST match the argument order in InsertDeployment and the
+// Scan() order in scanDeploymentFields. keeping it in one place means adding a column
+// touches one string instead of every SELECT in this file.
+const deploymentColumns = `
+	id, slug, name,
+	source_type, github_url, git_url, branch,
+	ref, commit_sha, commit_message,
+	git_credential_type, git_credential,
+	build_cmd, root_directory, shared_dirs,
+	git_submodules, git_lfs, output_dir,
+	spa_fallback, custom_404, clean_urls, trailing_slash,
+	basic_auth_user, basic_auth_hash, ip_allow_list, ip_deny_list, env_vars,
+	runtime_env_vars, secret_env_vars,
+	status, url, webhook_secret,
+	auto_deploy, preset_id, template_values, parent_id, pr_number, expires_at,
+	created_at, updated_at
+`
+
+// deploymentColumnPlaceholders returns "?, ?, ..." with one placeholder per column in deploymentColumns.
+func deploymentColumnPlaceholders() string {
+	columnCount := strings.Count(deploymentColumns, ",") + 1
+	return strings.TrimSuffix(strings.Repeat("?, ", columnCount), ", ")
+}
+
 // ErrRecordNotFound is returned by GetDeployment when no row matches the given ID.
 // callers should check for this sentinel error to distinguish "not found" (404)
 // from a real database error (500, internal server error).
 var ErrRecordNotFound = errors.New("deployment not found")
 
+// ErrSlugTaken is returned by InsertDeployment when another deployment already has the slug
+// (the UNIQUE constraint on deployments.slug). a custom slug maps to 409, a random one is regenerated.
+var ErrSlugTaken = errors.New("slug is already taken")
+
 // InsertDeployment writes a new deployment row to the database.
 // the deployment struct MUST have ID, Slug, and Status already populated
 // by the caller (handler or pipeline) before calling this function.
@@ -53,23 +84,8 @@ func (database *Database) InsertDeployment(deployment *models.Deployment) error
 	//    for values. The database driver binds variables to these placeholders
 	//    at execution time. This strictly separates the SQL command from the
 	//    user-provided data, completely eliminating the risk of SQL injection.
-	query := ` 
-		INSERT INTO deployments (
-			id, slug, name,
-			source_type, github_url, branch,
-			build_cmd, output_dir, env_vars, 
-			status, url, webhook_secret, 
-			auto_deploy, preset_id, expires_at,
-			created_at, updated_at
-		) VALUES (
-			?, ?, ?, -- these are parameter placeholders, PostgresSQL uses $1, $2, $3
-			?, ?, ?, 
-			?, ?, ?, 
-			?, ?, ?,
-			?, ?, ?,
-			?, ?
-		)
-	`
+	// deploymentColumnPlaceholders() generates one `?` per column (PostgresSQL uses $1, $2, $3).
+	query := `INSERT INTO deployments (` + deploymentColumns + `) VALUES (` + deploymentColumnPlaceholders() + `)`
 
 	timeNow := time.Now().UTC()
 	// .Now() returns the time from the computer's system clock.
@@ -88,39 +104,68 @@ func (database *Database) InsertDeployment(deployment *models.Deployment) error
 		deployment.Name,
 		deployment.SourceType,
 		deployment.GitHubURL, // *string, nil inserts NULL
+		deployment.GitURL,    // *string, nil inserts NULL
 		deployment.Branch,
+		deployment.Ref,                    // *string, nil inserts NULL
+		deployment.CommitSHA,              // *string, nil inserts NULL
+		deployment.CommitMessage,          // *string, nil inserts NULL
+		deployment.GitCredentialType,      // *GitCredentialType, nil inserts NULL
+		deployment.EncryptedGitCredential, // *string, nil inserts NULL
 		deployment.BuildCommand,
+		deployment.RootDirectory,
+		deployment.SharedDirectories, // *string, nil inserts NULL
+		deployment.GitSubmodules,     // bool, driver converts to 0/1
+		deployment.GitLFS,            // bool, driver converts to 0/1
 		deployment.OutputDirectory,
-		deployment.EnvironmentVariables, // *string, nil inserts NULL
+		deployment.SPAFallback, // bool, driver converts to 0/1
+		deployment.Custom404,   // bool, driver converts to 0/1
+		deployment.CleanURLs,   // bool, driver converts to 0/1
+		deployment.TrailingSlash,
+		deployment.BasicAuthUsername,           // *string, nil inserts NULL
+		deployment.BasicAuthPasswordHash,       // *string, nil inserts NULL
+		deployment.IPAllowList,                 // *string, nil inserts NULL
+		deployment.IPDenyList,                  // *string, nil inserts NULL
+		deployment.EnvironmentVariables,        // *string, nil inserts NULL
+		deployment.RuntimeEnvironmentVariables, // *string, nil inserts NULL
+		deployment.SecretEnvironmentVariables,  // *string, nil inserts NULL
 		deployment.Status,
-		deployment.URL,           // *string, nil inserts NULL
-		deployment.WebhookSecret, // *string, nil inserts NULL
-		deployment.AutoDeploy,    // bool, driver converts to 0/1
-		deployment.PresetID,      // *string, nil inserts NULL
-		deployment.ExpiresAt,     // *time.Time, nil inserts NULL
+		deployment.URL,                // *string, nil inserts NULL
+		deployment.WebhookSecret,      // *string, nil inserts NULL
+		deployment.AutoDeploy,         // bool, driver converts to 0/1
+		deployment.PresetID,           // *string, nil inserts NULL
+		deployment.TemplateValues,     // *string, nil inserts NULL
+		deployment.ParentDeploymentID, // *string, nil inserts NULL
+		deployment.PullRequestNumber,  // *int, nil inserts NULL
+		deployment.ExpiresAt,          // *time.Time, nil inserts NULL
 		deployment.CreatedAt,
 		deployment.UpdatedAt,
 	)
+	if isUniqueSlugViolation(err) {
+		return fmt.Errorf("failed to insert deployment %q: %w", deployment.ID, ErrSlugTaken)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to insert deployment %q: %w", deployment.ID, err)
 	}
 	return nil
 }
 
+// isUniqueSlugViolation reports whether err is SQLite rejecting a duplicate deployments.slug.
+// the constraint is checked by the database instead of a SELECT before the INSERT,
+// which would leave a window for two requests to pick the same slug.
+func isUniqueSlugViolation(err error) bool {
+	var sqliteError sqlite3.Error
+	if !errors.As(err, &sqliteError) || sqliteError.ExtendedCode != sqlite3.ErrConstraintUnique {
+		return false
+	}
+	// the message names the column ("UNIQUE constraint failed: deployments.slug"),
+	// the primary key (id) is a fresh UUID and never collides, but check anyway
+	return strings.Contains(sqliteError.Error(), "deployments.slug")
+}
+
 // GetDeployment fetches a single deployment row by its UUID.
 // returns ErrRecordNotFound if no row matches, which callers map to HTTP 404.
 func (database *Database) GetDeployment(id string) (*models.Deployment, error) {
-	query := `
-		SELECT
-			id, slug, name,
-			source_type, github_url, branch,
-			build_cmd, output_dir, env_vars,
-			status, url, webhook_secret,
-			auto_deploy, preset_id, expires_at,
-			created_at, updated_at
-		FROM deployments
-		WHERE id = ?
-	`
+	query := `SELECT ` + deploymentColumns + ` FROM deployments WHERE id = ?`
 
 	// QueryRow is used for single-rowQueried queries. (Query() is for multiple rows.)
 	// it returns a *sql.Row which has a Scan() method to read the data.
@@ -145,15 +190,7 @@ func (database *Database) GetDeployment(id string) (*models.Deployment, error) {
 // ListDeployments returns all deployment rows ordered by creation time descending (newest first)
 // (newest first), matching the expected dashboard sort order.
 func (database *Database) ListDeployments() ([]*models.Deployment, error) {
-	query := `
-		SELECT
-			id, slug, name, source_type, github_url, branch,
-			build_cmd, output_dir, env_vars, status, url,
-			webhook_secret, auto_deploy, preset_id, expires_at,
-			created_at, updated_at
-		FROM deployments
-		ORDER BY created_at DESC
-	`
+	query := `SELECT ` + deploymentColumns + ` FROM deployments ORDER BY created_at DESC`
 
 	rows, err := database.connection.Query(query) // Query() returns multiple rows as *Rows struct
 	if err != nil {
@@ -282,6 +319,111 @@ func (database *Database) UpdateURL(id string, url string) error {
 	return nil
 }
 
+// UpdateRef pins a deployment to a ref (tag, SHA or branch), nil removes the pin.
+// called by the redeploy handler when the request names a ref, so later redeploys keep using it.
+func (database *Database) UpdateRef(id string, ref *string) error {
+	query := `UPDATE deployments SET ref = ?, updated_at = ? WHERE id = ?`
+
+	result, err := database.connection.Exec(query, ref, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update ref for deployment %q: %w", id, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read rows affected for deployment %q: %w", id, err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UpdateBasicAuth sets the basic auth credentials of a deployment (user name and bcrypt hash of the password),
+// nil for both removes the password protection.
+func (database *Database) UpdateBasicAuth(id string, username *string, passwordHash *string) error {
+	query := `UPDATE deployments SET basic_auth_user = ?, basic_auth_hash = ?, updated_at = ? WHERE id = ?`
+
+	result, err := database.connection.Exec(query, username, passwordHash, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update basic auth for deployment %q: %w", id, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read rows affected for deployment %q: %w", id, err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UpdateIPAccessLists sets the IP allow and deny lists of a deployment (comma separated CIDR ranges),
+// nil resets a list (the allow list back to the platform default).
+func (database *Database) UpdateIPAccessLists(id string, allowList *string, denyList *string) error {
+	query := `UPDATE deployments SET ip_allow_list = ?, ip_deny_list = ?, updated_at = ? WHERE id = ?`
+
+	result, err := database.connection.Exec(query, allowList, denyList, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update ip access lists for deployment %q: %w", id, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read rows affected for deployment %q: %w", id, err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UpdateExpiresAt sets the expiry of a deployment, nil makes it never expire (pinned).
+// its pull request previews get the same expiry, they expire with their parent.
+func (database *Database) UpdateExpiresAt(id string, expiresAt *time.Time) error {
+	query := `UPDATE deployments SET expires_at = ?, updated_at = ? WHERE id = ? OR parent_id = ?`
+
+	result, err := database.connection.Exec(query, expiresAt, time.Now().UTC(), id, id)
+	if err != nil {
+		return fmt.Errorf("failed to update expires_at for deployment %q: %w", id, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read rows affected for deployment %q: %w", id, err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UpdateCommit records the commit a github/git deployment is serving.
+// called by the pipeline after the deployment went live, a failed build keeps the previous commit.
+func (database *Database) UpdateCommit(id string, commitSHA string, commitMessage string) error {
+	query := `UPDATE deployments SET commit_sha = ?, commit_message = ?, updated_at = ? WHERE id = ?`
+
+	result, err := database.connection.Exec(query, commitSHA, commitMessage, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update commit for deployment %q: %w", id, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read rows affected for deployment %q: %w", id, err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 // DeleteDeployment removes a deployment row by ID.
 // the caller is responsible for stopping the container and removing files
 // before calling this function. the Database row is the last thing deleted.
@@ -310,13 +452,7 @@ func (database *Database) DeleteDeployment(id string) error {
 // Deployments with NULL expires_at are never returned (they do not expire).
 func (database *Database) ListExpiredDeployments() ([]*models.Deployment, error) {
 	query := `
-		SELECT
-			id, slug, name,
-			source_type, github_url, branch,
-			build_cmd, output_dir, env_vars, 
-			status, url, webhook_secret,
-			auto_deploy, preset_id, expires_at,
-			created_at, updated_at
+		SELECT ` + deploymentColumns + `
 		FROM deployments
 		WHERE expires_at IS NOT NULL
 		  AND expires_at <= CURRENT_TIMESTAMP
@@ -342,6 +478,41 @@ func (database *Database) ListExpiredDeployments() ([]*models.Deployment, error)
 	return deployments, nil
 }
 
+// DeploymentCount is one row of CountDeploymentsByStatusAndSource.
+type DeploymentCount struct {
+	Status     models.DeploymentStatus
+	SourceType models.SourceType
+	Count      int
+}
+
+// CountDeploymentsByStatusAndSource returns the number of deployments for every
+// (status, source_type) combination that currently exists. used by the /metrics gauge.
+func (database *Database) CountDeploymentsByStatusAndSource() ([]DeploymentCount, error) {
+	query := `
+		SELECT status, source_type, COUNT(*)
+		FROM deployments
+		GROUP BY status, source_type
+	`
+	rows, err := database.connection.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count deployments: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []DeploymentCount
+	for rows.Next() {
+		var count DeploymentCount
+		if err := rows.Scan(&count.Status, &count.SourceType, &count.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan deployment count row: %w", err)
+		}
+		counts = append(counts, count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating deployment count rows: %w", err)
+	}
+	return counts, nil
+}
+
 // Logging In DB Layer or not??
 // In a layered architecture, the database layer avoids logging routine
 // queries to prevent log spam and duplicate error reporting. The database
@@ -391,15 +562,38 @@ func scanDeploymentFields(row scanner) (*models.Deployment, error) {
 		&deployment.Name,
 		&deployment.SourceType,
 		&deployment.GitHubURL, // scans NULL -> nil *string
+		&deployment.GitURL,    // scans NULL -> nil *string
 		&deployment.Branch,
+		&deployment.Ref,                    // scans NULL -> nil *string
+		&deployment.CommitSHA,              // scans NULL -> nil *string
+		&deployment.CommitMessage,          // scans NULL -> nil *string
+		&deployment.GitCredentialType,      // scans NULL -> nil
+		&deployment.EncryptedGitCredential, // scans NULL -> nil *string
 		&deployment.BuildCommand,
+		&deployment.RootDirectory,
+		&deployment.SharedDirectories, // scans NULL -> nil *string
+		&deployment.GitSubmodules,     // scans INTEGER 0/1 -> bool
+		&deployment.GitLFS,            // scans INTEGER 0/1 -> bool
 		&deployment.OutputDirectory,
-		&deployment.EnvironmentVariables, // scans NULL -> nil *string
+		&deployment.SPAFallback, // scans INTEGER 0/1 -> bool
+		&deployment.Custom404,   // scans INTEGER 0/1 -> bool
+		&deployment.CleanURLs,   // scans INTEGER 0/1 -> bool
+		&deployment.TrailingSlash,
+		&deployment.BasicAuthUsername,           // scans NULL -> nil *string
+		&deployment.BasicAuthPasswordHash,       // scans NULL -> nil *string
+		&deployment.IPAllowList,                 // scans NULL -> nil *string
+		&deployment.IPDenyList,                  // scans NULL -> nil *string
+		&deployment.EnvironmentVariables,        // scans NULL -> nil *string
+		&deployment.RuntimeEnvironmentVariables, // scans NULL -> nil *string
+		&deployment.SecretEnvironmentVariables,  // scans NULL -> nil *string
 		&deployment.Status,
-		&deployment.URL,           // scans NULL -> nil *string
-		&deployment.WebhookSecret, // scans NULL -> nil *string
-		&deployment.AutoDeploy,    // scans INTEGER 0/1 -> bool
-		&deployment.PresetID,      // scans NULL -> nil *string
+		&deployment.URL,                // scans NULL -> nil *string
+		&deployment.WebhookSecret,      // scans NULL -> nil *string
+		&deployment.AutoDeploy,         // scans INTEGER 0/1 -> bool
+		&deployment.PresetID,           // scans NULL -> nil *string
+		&deployment.TemplateValues,     // scans NULL -> nil *string
+		&deployment.ParentDeploymentID, // scans NULL -> nil *string
+		&deployment.PullRequestNumber,  // scans NULL -> nil *int
 		&deployment.ExpiresAt,
 		&deployment.CreatedAt,
 		&deployment.UpdatedAt,
@@ -410,3 +604,43 @@ func scanDeploymentFields(row scanner) (*models.Deployment, error) {
 
 	return &deployment, nil
 }
+
+// GetPreviewDeployment fetches the preview deployment of one pull request of a parent deployment.
+// returns ErrRecordNotFound when the pull request has no preview (yet).
+func (database *Database) GetPreviewDeployment(parentID string, pullRequestNumber int) (*models.Deployment, error) {
+	query := `SELECT ` + deploymentColumns + ` FROM deployments WHERE parent_id = ? AND pr_number = ?`
+
+	deployment, err := scanDeploymentFields(database.connection.QueryRow(query, parentID, pullRequestNumber))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get preview deployment of %q for pull request %d: %w", parentID, pullRequestNumber, err)
+	}
+	return deployment, nil
+}
+
+// ListPreviewDeployments returns every preview deployment of a parent deployment, lowest pull request first.
+// empty (nil) when the deployment has no previews.
+func (database *Database) ListPreviewDeployments(parentID string) ([]*models.Deployment, error) {
+	query := `SELECT ` + deploymentColumns + ` FROM deployments WHERE parent_id = ? ORDER BY pr_number`
+
+	rows, err := database.connection.Query(query, parentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list preview deployments of %q: %w", parentID, err)
+	}
+	defer rows.Close()
+
+	var previewDeployments []*models.Deployment
+	for rows.Next() {
+		previewDeployment, err := scanDeploymentFields(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan preview deployment row: %w", err)
+		}
+		previewDeployments = append(previewDeployments, previewDeployment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating preview deployment rows: %w", err)
+	}
+	return previewDeployments, nil
+}
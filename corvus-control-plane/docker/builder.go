@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
@@ -39,6 +40,11 @@ type RunEphemeralBuildContainerConfig struct {
 	// to the same directory on the host.
 	HostSourceDirectory string
 
+	// WorkingDirectory is where the build command runs, relative to /workspace.
+	// "" or "." is /workspace itself, a monorepo app sets its root directory (eg "apps/web").
+	// the whole HostSourceDirectory stays mounted, so the build can still reach shared directories.
+	WorkingDirectory string
+
 	// EnvironmentVariables is a list of KEY=VALUE strings passed to the
 	// container as environment variables. The build process sees them as
 	// normal env vars (eg NODE_ENV=production).
@@ -80,12 +86,13 @@ func (dockerClient *DockerClient) RunEphemeralBuildContainer(
 	// the build command is wrapped in `sh -c` so that shell operators
 	// (&&, ||, ;, pipes) in the user-provided command string are interpreted
 	// by the shell rather than treated as literal arguments.
-	// WorkingDir is set to /workspace so relative paths in the build command
-	// (eg "npm run build" looking for package.json) resolve correctly.
+	// WorkingDir is set to /workspace (or the monorepo root directory inside it) so relative paths
+	// in the build command (eg "npm run build" looking for package.json) resolve correctly.
+	// path (not filepath), the container path is always a Linux path.
 	containerInternalConfig := &container.Config{
 		Image:      buildImage,
 		Cmd:        []string{"sh", "-c", config.BuildCommand},
-		WorkingDir: "/workspace",
+		WorkingDir: path.Join("/workspace", config.WorkingDirectory),
 		Env:        config.EnvironmentVariables,
 
 		// this fixes the permission error that doesn't allow deleting a temp folder in /tmp
@@ -13,6 +13,10 @@ import (
 	"log/slog"
 	"time"
 
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/metrics"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/tracing"
+	"go.opentelemetry.io/otel/trace"
+
 	dockerSDKclient "github.com/docker/docker/client"
 	/* The syntax 'aliasName "path/to/package"' assigns an alias or local identifier to an imported package.
 	 * This override is utilized to:
@@ -29,8 +33,10 @@ import (
 // the SDK client itself manages the connection to the Docker daemon over the Unix socket.
 // it is safe to share a single DockerClient across goroutines cuz the SDK handles concurrency internally.
 type DockerClient struct {
-	sdk    *dockerSDKclient.Client
-	logger *slog.Logger
+	sdk     *dockerSDKclient.Client
+	logger  *slog.Logger
+	metrics *metrics.ControlPlaneMetrics // nil-safe, records image pull durations
+	tracer  trace.Tracer                 // never nil (no-op when tracing is off), creates docker.image_pull spans
 }
 
 // NewClient `docker.NewClient()` constructs a Docker DockerClient (my custom defined struct),
@@ -39,7 +45,7 @@ type DockerClient struct {
 // the connection is live before returning.
 // returning an error here should cause main.go to exit immediately cuz
 // if the Docker daemon is unreachable, the platform cannot function.
-func NewClient(logger *slog.Logger) (*DockerClient, error) {
+func NewClient(logger *slog.Logger, controlPlaneMetrics *metrics.ControlPlaneMetrics, tracer trace.Tracer) (*DockerClient, error) {
 	// > client.NewClientWithOpts is a constructor that initializes the SDK client.
 	// > client.FromEnv reads $DOCKER_HOST, $DOCKER_TLS_VERIFY, $DOCKER_CERT_PATH env variables from
 	// the OS environment. When those are not set (local dev, direct socket),
@@ -60,8 +66,10 @@ func NewClient(logger *slog.Logger) (*DockerClient, error) {
 
 	// creating the custom client (which is just a wrapper for sdk client and logger)
 	corvusDockerClient := &DockerClient{
-		sdk:    sdkClient,
-		logger: logger,
+		sdk:     sdkClient,
+		logger:  logger,
+		metrics: controlPlaneMetrics,
+		tracer:  tracing.TracerOrNoop(tracer),
 	}
 	// a `defer corvusDockerClient.sdk.Close()` is not placed here cuz or else if will
 	// immediately close after a DockerClient struct/obj is created (which is silly)
@@ -4,14 +4,18 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/opencontainers/image-spec/specs-go/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Why need Nginx? Why not a raw Alpine image?
@@ -32,7 +36,7 @@ const nginxImage string = "nginx:alpine"
 
 // NginxContainerConfig holds the parameters/args the caller passes to CreateAndStartNginxContainer().
 // Grouping them in a struct rather than as individual function arguments keeps
-// the function signature stable as more options are added (eg custom nginx config in future implementations).
+// the function signature stable as more options are added (eg the custom nginx config).
 type NginxContainerConfig struct {
 	// the Docker container name. convention: "deploy-<slug>"
 	ContainerName string
@@ -47,9 +51,32 @@ type NginxContainerConfig struct {
 	// it must exist on disk before CreateAndStartNginxContainer() is called.
 	HostSourceDirectory string
 
+	// HostNginxConfigFile is the absolute path on the host of the deployment's rendered
+	// server config (see build/nginx_config.go). it is bind-mounted read-only over the image's
+	// /etc/nginx/conf.d/default.conf. "" keeps the stock nginx:alpine config.
+	HostNginxConfigFile string
+
 	// TraefikNetwork is the Docker network name that both Traefik and
 	// this container must be on for Traefik to proxy traffic to it.
 	TraefikNetwork string
+
+	// BasicAuthUsers is the htpasswd line ("user:<bcrypt hash>") of a password protected deployment,
+	// enforced by a Traefik basicauth middleware in front of the container. "" for a public site.
+	BasicAuthUsers string
+
+	// IPAllowRanges is the comma separated list of CIDR ranges the site is reachable from,
+	// enforced by a Traefik ipAllowList middleware. "" for a site reachable from everywhere.
+	IPAllowRanges string
+
+	// IPStrategyDepth is the ipAllowList's ipStrategy.depth: the position of the client address in
+	// X-Forwarded-For counted from the right, 0 uses the address of the connection to Traefik.
+	IPStrategyDepth int
+
+	// EnvironmentVariables is a list of KEY=VALUE strings for the runtime-scoped
+	// variables of the deployment. nginx:alpine runs envsubst over /etc/nginx/templates
+	// on startup, so these are visible to any templated server config.
+	// nil or empty slice means no extra env vars.
+	EnvironmentVariables []string
 }
 
 // ---
@@ -104,13 +131,14 @@ func (dockerClient *DockerClient) CreateAndStartNginxContainer(context context.C
 	// and using a pointer allows modifying the config in place if needed (not necessary here, but common in more complex scenarios).
 	containerInternalConfig := &container.Config{
 		Image: nginxImage,
+		Env:   config.EnvironmentVariables,
 
 		// Labels are key-value metadata attached to the container.
 		// Traefik watches the Docker socket and reads these labels to
 		// automatically configure routing rules. when this container starts,
 		// Traefik picks up the labels and begins routing <slug>.localhost to it.
 		// no Traefik config file reload is required. this is the "Netlify magic".
-		Labels: traefikLabels(config.Slug, config.TraefikNetwork), // helper func
+		Labels: traefikLabels(config), // helper func
 
 		// Why not set a Cmd field here?
 		// The 'nginx:alpine' image inherently knows how to start its own web server process.
@@ -145,14 +173,7 @@ func (dockerClient *DockerClient) CreateAndStartNginxContainer(context context.C
 		// ReadOnly: true. the container only needs to read the files, not write them.
 		//   locking it read-only is a defence-in-depth measure: even if Nginx/container were compromised,
 		//   it could not modify the source files on the host.
-		Mounts: []mount.Mount{
-			{
-				Type:     mount.TypeBind,
-				Source:   config.HostSourceDirectory,
-				Target:   "/usr/share/nginx/html",
-				ReadOnly: true,
-			},
-		},
+		Mounts: nginxMounts(config.HostSourceDirectory, config.HostNginxConfigFile),
 
 		// RestartPolicy controls what Docker does when the container exits.
 		// "unless-stopped" means: restart automatically on crash or host reboot,
@@ -361,8 +382,22 @@ func (dockerClient *DockerClient) StopAndRemoveContainer(context context.Context
 // discarding the output with io.Discard avoids storing progress text in memory,
 // since the caller only needs to know if the pull succeeded or failed, not the progress detail.
 // in v2, TODO: this stream can be forwarded to the deployment log file for visibility.
-func (dockerClient *DockerClient) pullImageIfNotPresent(context context.Context, imageName string) error {
+func (dockerClient *DockerClient) pullImageIfNotPresent(context context.Context, imageName string) (errPull error) {
 	dockerClient.logger.Info("pulling docker image", "image", imageName)
+	pullStartedAt := time.Now()
+
+	// child span of whatever pipeline step needed the image (build or container_start).
+	// the named return lets the deferred func mark the span failed on any error path.
+	context, pullSpan := dockerClient.tracer.Start(context, "docker.image_pull",
+		trace.WithAttributes(attribute.String("docker.image", imageName)),
+	)
+	defer func() {
+		if errPull != nil {
+			pullSpan.RecordError(errPull)
+			pullSpan.SetStatus(codes.Error, errPull.Error())
+		}
+		pullSpan.End()
+	}()
 
 	/*
 		Docker SDK client's `.ImagePull()` sends a request to the Docker daemon to download
@@ -416,6 +451,7 @@ func (dockerClient *DockerClient) pullImageIfNotPresent(context context.Context,
 		return fmt.Errorf("failed to stream image pull response for %q: %w", imageName, err)
 	}
 
+	dockerClient.metrics.ImagePulled(imageName, time.Since(pullStartedAt))
 	dockerClient.logger.Info("docker image pulled/downloaded and ready", "image", imageName)
 	return nil
 }
@@ -431,13 +467,39 @@ func (dockerClient *DockerClient) pullImageIfNotPresent(context context.Context,
 //   - traefik.http.routers.<slug>.rule          -- match requests where the Host header equals <slug>.domain
 //   - traefik.http.services.<slug>.loadbalancer -- tell Traefik which port inside the container to proxy to
 //     (80 cuz thats where nginx server)
-func traefikLabels(slug string, traefikNetwork string) map[string]string {
-	return map[string]string{
+//
+// a deployment with IP access lists gets an ipAllowList middleware (Traefik answers 403), a password
+// protected deployment a basicauth middleware (bcrypt hashes are accepted as they are, Traefik answers 401),
+// both attached to its router so the request never reaches nginx. the IP check runs first.
+// labels only apply when a container is created, changing either means recreating the container.
+func traefikLabels(config NginxContainerConfig) map[string]string {
+	slug := config.Slug
+	labels := map[string]string{
 		"traefik.enable":                                              "true",
 		"traefik.http.routers." + slug + ".rule":                      "Host(`" + slug + "-corvus.sasta.dev`)",
 		"traefik.http.services." + slug + ".loadbalancer.server.port": "80",
-		"traefik.docker.network":                                      traefikNetwork,
+		"traefik.docker.network":                                      config.TraefikNetwork,
+	}
+
+	var middlewareNames []string
+	if config.IPAllowRanges != "" {
+		middlewareName := slug + "-ipallowlist"
+		labels["traefik.http.middlewares."+middlewareName+".ipallowlist.sourcerange"] = config.IPAllowRanges
+		if config.IPStrategyDepth > 0 {
+			labels["traefik.http.middlewares."+middlewareName+".ipallowlist.ipstrategy.depth"] = strconv.Itoa(config.IPStrategyDepth)
+		}
+		middlewareNames = append(middlewareNames, middlewareName)
+	}
+	if config.BasicAuthUsers != "" {
+		middlewareName := slug + "-auth"
+		labels["traefik.http.middlewares."+middlewareName+".basicauth.users"] = config.BasicAuthUsers
+		labels["traefik.http.middlewares."+middlewareName+".basicauth.realm"] = slug
+		middlewareNames = append(middlewareNames, middlewareName)
+	}
+	if len(middlewareNames) > 0 {
+		labels["traefik.http.routers."+slug+".middlewares"] = strings.Join(middlewareNames, ",")
 	}
+	return labels
 }
 
 // containerAge is a helper used in log output to show how long a container has been running.
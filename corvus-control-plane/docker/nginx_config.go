@@ -0,0 +1,135 @@
+package docker
+
+// nginx_config.go contains the docker side of per-deployment nginx configs:
+// the bind mounts of the serving container and the `nginx -t` check run before a config goes live.
+// the config itself is rendered by the build package (build/nginx_config.go).
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// nginxServerConfigPath is where nginx:alpine's http block includes the server config from
+// (/etc/nginx/nginx.conf includes /etc/nginx/conf.d/*.conf), a rendered config replaces the stock one.
+const nginxServerConfigPath = "/etc/nginx/conf.d/default.conf"
+
+// nginxMounts returns the bind mounts of a serving (or config test) container:
+// the static files, plus the rendered server config when the deployment has one.
+// both read-only, nginx only ever reads them.
+func nginxMounts(hostSourceDirectory string, hostNginxConfigFile string) []mount.Mount {
+	mounts := []mount.Mount{
+		{
+			Type:     mount.TypeBind,
+			Source:   hostSourceDirectory,
+			Target:   "/usr/share/nginx/html",
+			ReadOnly: true,
+		},
+	}
+	if hostNginxConfigFile != "" {
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   hostNginxConfigFile,
+			Target:   nginxServerConfigPath,
+			ReadOnly: true,
+		})
+	}
+	return mounts
+}
+
+// ValidateNginxConfig runs `nginx -t` against a rendered server config in a throwaway
+// nginx:alpine container, with the same mounts the serving container will get.
+//
+// the config contains rules taken from the user's _redirects and _headers files. they are
+// validated when parsed, but only nginx itself can say for sure that it accepts the result.
+// testing it here, before the old container is stopped, means a bad config fails the deploy
+// with the old container still running, instead of leaving a container in a restart loop.
+//
+// returns nginx's output (the "nginx: [emerg] ..." line on failure) and an error when the test fails.
+// follows the same create / start / wait / logs / remove lifecycle as RunEphemeralBuildContainer.
+func (dockerClient *DockerClient) ValidateNginxConfig(
+	validateContext context.Context,
+	containerName string,
+	hostSourceDirectory string,
+	hostNginxConfigFile string,
+) (string, error) {
+	if err := dockerClient.pullImageIfNotPresent(validateContext, nginxImage); err != nil {
+		return "", fmt.Errorf("failed to pull nginx image: %w", err)
+	}
+
+	// Cmd overrides the image's default `nginx -g 'daemon off;'`, the entrypoint scripts still run first
+	// (they skip the read-only default.conf). no network and no labels, Traefik never sees this container.
+	createResponse, errCreate := dockerClient.sdk.ContainerCreate(
+		validateContext,
+		&container.Config{
+			Image: nginxImage,
+			Cmd:   []string{"nginx", "-t", "-q"},
+		},
+		&container.HostConfig{
+			Mounts:      nginxMounts(hostSourceDirectory, hostNginxConfigFile),
+			NetworkMode: "none",
+		},
+		nil,
+		nil,
+		containerName,
+	)
+	if errCreate != nil {
+		return "", fmt.Errorf("failed to create nginx config test container %q: %w", containerName, errCreate)
+	}
+
+	// removed on every path, Force because an early return may leave it running
+	defer func() {
+		errRemove := dockerClient.sdk.ContainerRemove(validateContext, createResponse.ID, container.RemoveOptions{Force: true})
+		if errRemove != nil {
+			dockerClient.logger.Warn("failed to remove nginx config test container (non-fatal)",
+				"container_name", containerName,
+				"error", errRemove,
+			)
+		}
+	}()
+
+	if err := dockerClient.sdk.ContainerStart(validateContext, createResponse.ID, container.StartOptions{}); err != nil {
+		return "", fmt.Errorf("failed to start nginx config test container %q: %w", containerName, err)
+	}
+
+	statusChannel, errorChannel := dockerClient.sdk.ContainerWait(validateContext, createResponse.ID, container.WaitConditionNotRunning)
+	var exitCode int64
+	select {
+	case errWait := <-errorChannel:
+		if errWait != nil {
+			return "", fmt.Errorf("error waiting for nginx config test container %q: %w", containerName, errWait)
+		}
+	case waitStatus := <-statusChannel:
+		exitCode = waitStatus.StatusCode
+	}
+
+	// `nginx -t` output is a couple of lines, buffering it is fine
+	var testOutput bytes.Buffer
+	logReadCloser, errLogs := dockerClient.sdk.ContainerLogs(validateContext, createResponse.ID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	})
+	if errLogs == nil {
+		defer logReadCloser.Close()
+		_, _ = stdcopy.StdCopy(&testOutput, &testOutput, logReadCloser)
+	}
+
+	// the entrypoint prints its own "/docker-entrypoint.sh: ..." progress lines, only nginx's are useful
+	var nginxOutputLines []string
+	for _, line := range strings.Split(testOutput.String(), "\n") {
+		if strings.HasPrefix(line, "nginx:") {
+			nginxOutputLines = append(nginxOutputLines, line)
+		}
+	}
+	nginxOutput := strings.Join(nginxOutputLines, "\n")
+
+	if exitCode != 0 {
+		return nginxOutput, fmt.Errorf("nginx -t exited with code '%d'", exitCode)
+	}
+	return nginxOutput, nil
+}
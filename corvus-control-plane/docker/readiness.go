@@ -0,0 +1,34 @@
+package docker
+
+// readiness.go contains the lightweight daemon checks used by the GET /ready endpoint.
+// they only read state from the daemon, nothing is created or changed.
+
+import (
+	"context"
+	"fmt"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/network"
+)
+
+// Ping sends a ping to the Docker daemon to confirm it is still reachable.
+// the startup ping in NewClient only proves the daemon was up when the process started,
+// the readiness endpoint calls this on every probe.
+func (dockerClient *DockerClient) Ping(context context.Context) error {
+	return dockerClient.ping(context)
+}
+
+// CheckNetworkExists confirms that the named Docker network exists.
+// every Nginx container is attached to the Traefik network, so if it was removed
+// (eg, `docker network prune` or a compose down of the Traefik stack),
+// every new deployment would fail at container start.
+func (dockerClient *DockerClient) CheckNetworkExists(context context.Context, networkName string) error {
+	_, err := dockerClient.sdk.NetworkInspect(context, networkName, network.InspectOptions{})
+	if cerrdefs.IsNotFound(err) {
+		return fmt.Errorf("docker network %q does not exist", networkName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect docker network %q: %w", networkName, err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package handlers
+
+// basic_auth.go holds the endpoints setting and removing the password (HTTP basic auth) of a deployment.
+// the password is hashed with bcrypt here and only the hash is stored, the plain text never reaches
+// the database, the logs or any API response. see build/access_control.go for how it is enforced.
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/db"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// basicAuthUsernamePattern keeps user names free of ':' (the htpasswd separator) and of anything
+// that would need escaping in a Traefik label.
+var basicAuthUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+const (
+	minBasicAuthPasswordLength = 8
+	// bcrypt only hashes the first 72 bytes, a longer password would be silently truncated
+	maxBasicAuthPasswordBytes = 72
+)
+
+// basicAuthRequest is the JSON body of PUT /api/deployments/:uuid/password.
+type basicAuthRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// SetBasicAuth handles PUT /api/deployments/:uuid/password.
+// sets or rotates the password of a deployment, the served files stay as they are.
+// returns 200 with the updated deployment (basic_auth_username set, the hash is never serialised).
+func (handler *DeploymentHandler) SetBasicAuth(responseWriter http.ResponseWriter, request *http.Request) {
+	var basicAuthBody basicAuthRequest
+	errDecodeBody := json.NewDecoder(io.LimitReader(request.Body, 64<<10)).Decode(&basicAuthBody)
+	if errDecodeBody != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "invalid request body: "+errDecodeBody.Error(), handler.logger)
+		return
+	}
+
+	if !basicAuthUsernamePattern.MatchString(basicAuthBody.Username) {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest,
+			"username must be 1-64 characters of letters, digits, '.', '_', '@' or '-'", handler.logger)
+		return
+	}
+	if utf8.RuneCountInString(basicAuthBody.Password) < minBasicAuthPasswordLength {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "password must be at least 8 characters", handler.logger)
+		return
+	}
+	if len(basicAuthBody.Password) > maxBasicAuthPasswordBytes {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "password must be at most 72 bytes", handler.logger)
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(basicAuthBody.Password), bcrypt.DefaultCost)
+	if err != nil {
+		handler.logger.Error("failed to hash basic auth password", "error", err)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to hash password", handler.logger)
+		return
+	}
+	passwordHashString := string(passwordHash)
+
+	handler.updateBasicAuth(responseWriter, request, &basicAuthBody.Username, &passwordHashString)
+}
+
+// RemoveBasicAuth handles DELETE /api/deployments/:uuid/password.
+// makes the deployment public again, returns 200 with the updated deployment.
+func (handler *DeploymentHandler) RemoveBasicAuth(responseWriter http.ResponseWriter, request *http.Request) {
+	handler.updateBasicAuth(responseWriter, request, nil, nil)
+}
+
+// updateBasicAuth saves the credentials of the deployment in the URL (nil, nil removes them)
+// and applies them to the running site.
+func (handler *DeploymentHandler) updateBasicAuth(
+	responseWriter http.ResponseWriter,
+	request *http.Request,
+	username *string,
+	passwordHash *string,
+) {
+	deploymentID := chi.URLParam(request, "uuid")
+
+	// held until the change is live, a pipeline run cannot mark the deployment deploying in between
+	unlockDeployment := handler.deployerPipeline.LockDeployment(deploymentID)
+	defer unlockDeployment()
+
+	deployment, err := handler.database.GetDeployment(deploymentID)
+	if errors.Is(err, db.ErrRecordNotFound) {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusNotFound, "deployment not found", handler.logger)
+		return
+	}
+	if err != nil {
+		handler.logger.Error("failed to get deployment for basic auth", "id", deploymentID, "error", err)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to retrieve deployment", handler.logger)
+		return
+	}
+
+	// a running pipeline replaces the container (or the shared site) itself when it finishes,
+	// applying the password in between would race it. checked under the deployment lock, the
+	// pipeline marks a run deploying under the same lock and reads the saved password when it serves
+	if deployment.Status == models.StatusDeploying {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusConflict,
+			"deployment is currently deploying, retry once it is live", handler.logger)
+		return
+	}
+
+	errUpdateBasicAuth := handler.database.UpdateBasicAuth(deployment.ID, username, passwordHash)
+	if errUpdateBasicAuth != nil {
+		handler.logger.Error("failed to update basic auth", "id", deploymentID, "error", errUpdateBasicAuth)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to update password", handler.logger)
+		return
+	}
+	previousDeployment := *deployment
+	deployment.BasicAuthUsername = username
+	deployment.BasicAuthPasswordHash = passwordHash
+
+	errApplyBasicAuth := handler.deployerPipeline.ApplyAccessControl(request.Context(), deployment, &previousDeployment)
+	if errApplyBasicAuth != nil {
+		handler.logger.Error("failed to apply basic auth", "id", deploymentID, "error", errApplyBasicAuth)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError,
+			"password saved but could not be applied to the running site, redeploy to apply it", handler.logger)
+		return
+	}
+
+	handler.logger.Info("deployment basic auth updated",
+		"id", deploymentID,
+		"slug", deployment.Slug,
+		"protected", username != nil,
+	)
+
+	writeJsonAndRespond(responseWriter, http.StatusOK, deployment)
+}
@@ -13,6 +13,8 @@ func CORSMiddleware(allowedOrigin string) func(http.Handler) http.Handler {
 			responseWriter.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
 			responseWriter.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 			responseWriter.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			// browsers hide non-standard response headers from fetch() unless they are exposed
+			responseWriter.Header().Set("Access-Control-Expose-Headers", logNextOffsetHeader)
 
 			// preflight requests (OPTIONS) get an immediate 204 response
 			// with no body. the browser sends these automatically before
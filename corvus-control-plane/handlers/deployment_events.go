@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/db"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+)
+
+// ListDeploymentEvents handles GET /api/deployments/:uuid/events.
+// returns the structured pipeline events (step started / finished / failed, with durations)
+// of a deployment as a JSON array, oldest first.
+//
+// the optional `run` query parameter selects which pipeline run to return:
+//   - omitted or "latest": only the most recent run (what the frontend progress view polls)
+//   - "all": every run of the deployment (creates and redeploys)
+//   - any other value: the run with that run_id
+func (handler *DeploymentHandler) ListDeploymentEvents(responseWriter http.ResponseWriter, request *http.Request) {
+	deploymentID := chi.URLParam(request, "uuid")
+
+	// confirm the deployment exists so an unknown id is a 404, not an empty list
+	_, err := handler.database.GetDeployment(deploymentID)
+	if errors.Is(err, db.ErrRecordNotFound) {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusNotFound, "deployment not found", handler.logger)
+		return
+	}
+	if err != nil {
+		handler.logger.Error("failed to get deployment for events", "id", deploymentID, "error", err)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to retrieve deployment", handler.logger)
+		return
+	}
+
+	runID := request.URL.Query().Get("run")
+	switch runID {
+	case "all":
+		runID = "" // empty run id means no run filter in ListPipelineEvents
+	case "", "latest":
+		latestRunID, errLatest := handler.database.LatestPipelineRunID(deploymentID)
+		if errors.Is(errLatest, db.ErrRecordNotFound) {
+			// the pipeline goroutine has not recorded anything yet
+			writeJsonAndRespond(responseWriter, http.StatusOK, []*models.PipelineEvent{})
+			return
+		}
+		if errLatest != nil {
+			handler.logger.Error("failed to get latest pipeline run", "id", deploymentID, "error", errLatest)
+			writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to retrieve deployment events", handler.logger)
+			return
+		}
+		runID = latestRunID
+	}
+
+	events, err := handler.database.ListPipelineEvents(deploymentID, runID)
+	if err != nil {
+		handler.logger.Error("failed to list pipeline events", "id", deploymentID, "error", err)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to retrieve deployment events", handler.logger)
+		return
+	}
+
+	// same nil -> [] conversion as ListDeployments, so the client always gets an array
+	if events == nil {
+		events = []*models.PipelineEvent{}
+	}
+	writeJsonAndRespond(responseWriter, http.StatusOK, events)
+}
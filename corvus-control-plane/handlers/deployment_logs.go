@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/db"
+)
+
+// logNextOffsetHeader carries the offset the client should send on its next request.
+// a header (instead of a JSON envelope) keeps the body plain text, so `curl` output is the log itself.
+const logNextOffsetHeader = "X-Log-Next-Offset"
+
+// GetDeploymentLogs handles GET /api/deployments/:uuid/logs.
+// returns the raw text of the deployment log (<slug>.log) as text/plain.
+// the optional `offset` query parameter (bytes, default 0) returns only what was
+// written after that point, and the X-Log-Next-Offset response header tells the client
+// where to continue. polling with the returned offset is how `corvus logs -f` follows a log.
+func (handler *DeploymentHandler) GetDeploymentLogs(responseWriter http.ResponseWriter, request *http.Request) {
+	deploymentID := chi.URLParam(request, "uuid")
+
+	deployment, err := handler.database.GetDeployment(deploymentID)
+	if errors.Is(err, db.ErrRecordNotFound) {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusNotFound, "deployment not found", handler.logger)
+		return
+	}
+	if err != nil {
+		handler.logger.Error("failed to get deployment for logs", "id", deploymentID, "error", err)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to retrieve deployment", handler.logger)
+		return
+	}
+
+	var offset int64
+	if rawOffset := request.URL.Query().Get("offset"); rawOffset != "" {
+		offset, err = strconv.ParseInt(rawOffset, 10, 64)
+		if err != nil || offset < 0 {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "offset must be a non-negative integer", handler.logger)
+			return
+		}
+	}
+
+	logChunk, nextOffset, err := handler.deployerPipeline.ReadDeploymentLog(deployment.Slug, offset)
+	if err != nil {
+		handler.logger.Error("failed to read deployment log", "id", deploymentID, "error", err)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to read deployment log", handler.logger)
+		return
+	}
+
+	responseWriter.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	responseWriter.Header().Set(logNextOffsetHeader, strconv.FormatInt(nextOffset, 10))
+	responseWriter.WriteHeader(http.StatusOK)
+	responseWriter.Write(logChunk) // nolint:errcheck -- same as writeJsonAndRespond
+}
@@ -3,9 +3,11 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
-	"mime/multipart"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -26,6 +28,34 @@ type DeploymentHandler struct {
 	friendCode         string
 	defaultTTLMinutes  int
 	extendedTTLMinutes int
+
+	// maxLifetimeMinutes and extendedMaxLifetimeMinutes cap how long after its creation a deployment
+	// can be kept alive by extending it (without / with the friend code), 0 disables extending
+	maxLifetimeMinutes         int
+	extendedMaxLifetimeMinutes int
+
+	// adminToken authenticates the admin-only pin endpoints, "" disables them
+	adminToken string
+
+	// maxUploadBytes caps the whole multipart body of POST /api/deployments, 0 means no cap
+	maxUploadBytes int64
+
+	// uploadTimeout replaces the server read/write timeouts for POST /api/deployments, 0 keeps them
+	uploadTimeout time.Duration
+
+	// credentialCipher encrypts private repository credentials before they are stored.
+	// nil when CREDENTIALS_ENCRYPTION_KEY is not set, credentials are refused then.
+	credentialCipher *util.CredentialCipher
+
+	// presetRegistry is what preset_id of a prebuilt deployment is checked against
+	presetRegistry *build.PresetRegistry
+
+	// slugPolicy validates user chosen slugs (format, length, denylist)
+	slugPolicy *util.SlugPolicy
+
+	// ipAccessPolicy validates IP access lists, and checks they still allow some address
+	// combined with the platform defaults
+	ipAccessPolicy *util.IPAccessPolicy
 }
 
 // NewDeploymentHandler constructs a DeploymentHandler with its required dependencies.
@@ -36,6 +66,15 @@ func NewDeploymentHandler(
 	friendCode string,
 	defaultTTLMinutes int,
 	extendedTTLMinutes int,
+	maxLifetimeMinutes int,
+	extendedMaxLifetimeMinutes int,
+	adminToken string,
+	maxUploadBytes int64,
+	uploadTimeout time.Duration,
+	credentialCipher *util.CredentialCipher,
+	presetRegistry *build.PresetRegistry,
+	slugPolicy *util.SlugPolicy,
+	ipAccessPolicy *util.IPAccessPolicy,
 ) *DeploymentHandler {
 
 	return &DeploymentHandler{
@@ -46,9 +85,24 @@ func NewDeploymentHandler(
 		friendCode:         friendCode,
 		defaultTTLMinutes:  defaultTTLMinutes,
 		extendedTTLMinutes: extendedTTLMinutes,
+
+		maxLifetimeMinutes:         maxLifetimeMinutes,
+		extendedMaxLifetimeMinutes: extendedMaxLifetimeMinutes,
+		adminToken:                 adminToken,
+
+		maxUploadBytes: maxUploadBytes,
+		uploadTimeout:  uploadTimeout,
+
+		credentialCipher: credentialCipher,
+		presetRegistry:   presetRegistry,
+		slugPolicy:       slugPolicy,
+		ipAccessPolicy:   ipAccessPolicy,
 	}
 }
 
+// maxRandomSlugAttempts is how many random slugs CreateDeployment tries before giving up
+const maxRandomSlugAttempts = 5
+
 // createDeploymentRequest defines the shape of the JSON body accepted by POST /api/deployments.
 // (client wants to create a new app deployment)
 // This is different from the models.Deployment struct, which represents the full deployment record stored in the database.
@@ -61,28 +115,58 @@ type createDeploymentRequest struct {
 	// Name is the human-readable label for this deployment (required)
 	Name string `json:"name"`
 
-	// SourceType must be "zip" or "github" (required)
+	// SourceType must be "zip", "github", "git" or "prebuilt" (required)
 	SourceType models.SourceType `json:"source_type"`
 
 	// GitHubURL is the public GitHub repo URL, required only when source_type is "github"
 	// `omitempty` will set <null> in the database when field is missing
 	GitHubURL *string `json:"github_url,omitempty"`
 
+	// GitURL is the https:// or file:// repo URL on any git host, required only when source_type is "git"
+	GitURL *string `json:"git_url,omitempty"`
+
 	// Branch is the git branch to deploy from, defaults to "main" when omitted
 	// doesn't matter for zip source type. easier to just make it required than have pointers
 	Branch string `json:"branch"`
 
+	// Ref optionally pins a github/git deployment to a tag, full commit SHA or branch.
+	// takes precedence over Branch, nil deploys the head of Branch.
+	Ref *string `json:"ref,omitempty"`
+
 	// BuildCommand is the shell command to run inside the build container before serving.
 	// empty string means no build step (pre-built static site, or a raw zip with no build).
 	BuildCommand string `json:"build_command"`
 
+	// RootDirectory is the app directory inside a monorepo (github/git only), defaults to "."
+	RootDirectory string `json:"root_directory"`
+
+	// SharedDirectories is the cleaned, comma separated list of extra monorepo directories, nil when none
+	SharedDirectories *string `json:"shared_directories,omitempty"`
+
+	// GitSubmodules and GitLFS enable the optional post-clone steps (github/git only)
+	GitSubmodules bool `json:"git_submodules"`
+	GitLFS        bool `json:"git_lfs"`
+
 	// OutputDirectory is the subdirectory containing the final static files.
-	// defaults to "." (root of the archive or repo).
+	// defaults to "." (root of the archive or repo), relative to RootDirectory.
 	OutputDirectory string `json:"output_directory"`
 
-	// EnvironmentVariables is an optional map of environment variables passed to the build container.
-	// stored as a JSON string in SQLite. nil means no env vars.
-	EnvironmentVariables map[string]string `json:"environment_variables,omitempty"`
+	// SPAFallback serves index.html for paths that match no file (client-side routed apps), any source type
+	SPAFallback bool `json:"spa_fallback"`
+
+	// Custom404, CleanURLs and TrailingSlash are the other served-site options, any source type
+	Custom404     bool                       `json:"custom_404"`
+	CleanURLs     bool                       `json:"clean_urls"`
+	TrailingSlash models.TrailingSlashPolicy `json:"trailing_slash"`
+
+	// IPAllowList and IPDenyList are comma separated CIDR ranges, nil leaves the platform defaults
+	IPAllowList *string `json:"ip_allow_list,omitempty"`
+	IPDenyList  *string `json:"ip_deny_list,omitempty"`
+
+	// EnvironmentVariables is the optional list of environment variables, each with its scope
+	// (build, runtime or secret). split into one JSON string per scope for storage in SQLite.
+	// nil means no env vars.
+	EnvironmentVariables []models.EnvironmentVariable `json:"environment_variables,omitempty"`
 
 	// AutoDeploy enables automatic redeployment on GitHub push when true.
 	// Only relevant for github source type.
@@ -148,145 +232,401 @@ func (handler *DeploymentHandler) GetDeployment(responseWriter http.ResponseWrit
 		return
 	}
 
+	// the pull request previews of this deployment (see webhooks.go), one clickable URL per pull request.
+	// non-fatal: the deployment itself is still worth returning without them.
+	previewDeployments, err := handler.database.ListPreviewDeployments(deployment.ID)
+	if err != nil {
+		handler.logger.Error("failed to list preview deployments", "id", deploymentID, "error", err)
+	}
+	for _, previewDeployment := range previewDeployments {
+		deployment.Previews = append(deployment.Previews, models.PreviewDeployment{
+			ID:                previewDeployment.ID,
+			Slug:              previewDeployment.Slug,
+			PullRequestNumber: *previewDeployment.PullRequestNumber,
+			Status:            previewDeployment.Status,
+			URL:               previewDeployment.URL,
+			CommitSHA:         previewDeployment.CommitSHA,
+		})
+	}
+
 	writeJsonAndRespond(responseWriter, http.StatusOK, deployment)
 }
 
+// createDeploymentResponse is the 201 body of POST /api/deployments: the deployment plus its webhook_secret.
+// the secret is json:"-" on the model (every other response is public), the creator is the only one
+// who ever sees it, to configure the GitHub webhook.
+type createDeploymentResponse struct {
+	deployment *models.Deployment
+}
+
+// MarshalJSON adds webhook_secret to the deployment's own JSON (models.Deployment.MarshalJSON).
+// a struct embedding the deployment would not work, its MarshalJSON would be promoted and drop the field.
+func (response createDeploymentResponse) MarshalJSON() ([]byte, error) {
+	deploymentJSON, err := json.Marshal(response.deployment)
+	if err != nil {
+		return nil, err
+	}
+	if response.deployment.WebhookSecret == nil {
+		return deploymentJSON, nil
+	}
+	var responseFields map[string]json.RawMessage
+	if err := json.Unmarshal(deploymentJSON, &responseFields); err != nil {
+		return nil, err
+	}
+	responseFields["webhook_secret"], err = json.Marshal(*response.deployment.WebhookSecret)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(responseFields)
+}
+
 // CreateDeployment handles POST /api/deployments.
-// for source_type "zip" - reads a multipart form upload, validates fields,
+// for source_type "zip" - streams a multipart form upload, validates fields,
 // creates the database record, and fires the deployerPipeline in a goroutine.
 // for source_type "github": calls deploy github pipeline
 // returns 201 immediately with status "deploying".
 // the client polls GET /api/deployments/:id to track progress to "live" or "failed".
 
 func (handler *DeploymentHandler) CreateDeployment(responseWriter http.ResponseWriter, request *http.Request) {
-	// ===== parse multipart form (needed for the zip upload and json in 1 http request)
-
-	// ParseMultipartForm reads the incoming multipart body into memory up to maxMemory bytes.
-	// files larger than maxMemory are spilled to disk automatically by the standard library.
-	// 32MB is a reasonable upper limit for a zip containing a pre-built static site.
-	// large files (video, raw assets) should be excluded from the zip before uploading.
-	const maxMemoryBytes = 32 << 20 // 32MB  TODO maybe handle this better
-	errParseMultipartForm := request.ParseMultipartForm(maxMemoryBytes)
-	if errParseMultipartForm != nil {
-		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "failed to parse multipart form", handler.logger)
+	// ===== stream the multipart form (needed for the zip upload and json in 1 http request)
+
+	// reject an oversize upload before reading a single byte of it when the client announced its size.
+	// Content-Length can be absent (-1, chunked) or lie, so http.MaxBytesReader still enforces the cap
+	// while the body is read off the connection, the header check only makes the honest case fail fast.
+	if handler.maxUploadBytes > 0 {
+		if request.ContentLength > handler.maxUploadBytes {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("upload exceeds the %d MB limit", handler.maxUploadBytes>>20), handler.logger)
+			return
+		}
+		request.Body = http.MaxBytesReader(responseWriter, request.Body, handler.maxUploadBytes)
+	}
+
+	// the server wide ReadTimeout/WriteTimeout (15s, main.go) protect every other endpoint from slow clients,
+	// but a 50MB site on a slow uplink takes longer than that to upload. only this handler gets a longer
+	// deadline, through http.ResponseController (the chi response writer wrappers support Unwrap).
+	// the write deadline is extended too, for HTTP/1 it is counted from when the request headers were read.
+	if handler.uploadTimeout > 0 {
+		responseController := http.NewResponseController(responseWriter)
+		uploadDeadline := time.Now().Add(handler.uploadTimeout)
+		errReadDeadline := responseController.SetReadDeadline(uploadDeadline)
+		errWriteDeadline := responseController.SetWriteDeadline(uploadDeadline)
+		if errReadDeadline != nil || errWriteDeadline != nil {
+			handler.logger.Warn("could not extend the upload deadline, server timeouts apply",
+				"read_error", errReadDeadline, "write_error", errWriteDeadline)
+		}
+	}
+
+	form, errReadForm := readDeploymentForm(request, handler.deployerPipeline)
+	if errReadForm != nil {
+		var errBodyTooLarge *http.MaxBytesError
+		switch {
+		case errors.As(errReadForm, &errBodyTooLarge):
+			writeErrorJsonAndLogIt(responseWriter, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("upload exceeds the %d MB limit", handler.maxUploadBytes>>20), handler.logger)
+		case errors.Is(errReadForm, errFormFieldTooLarge):
+			writeErrorJsonAndLogIt(responseWriter, http.StatusRequestEntityTooLarge, errReadForm.Error(), handler.logger)
+		default:
+			handler.logger.Warn("failed to read multipart form", "error", errReadForm)
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "failed to parse multipart form", handler.logger)
+		}
 		return
 	}
 
+	// the staged upload belongs to this handler until it is handed to the zip pipeline goroutine.
+	// every early return below (validation errors, a file sent with source_type "github", ...) removes it.
+	stagedUploadHandedOff := false
+	defer func() {
+		if form.stagedUpload != nil && !stagedUploadHandedOff {
+			form.stagedUpload.Remove()
+		}
+	}()
+
 	// ===== Read form fields and validate step by step
 
 	var validatedRequest createDeploymentRequest
-	// request.FormValue reads a named field from the parsed multipart form.
+	// form.value reads a named text field from the streamed multipart form.
 	// returns an empty string if the field is absent.
 
-	name := request.FormValue("name")
+	name := form.value("name")
 	if name == "" {
 		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "name is required", handler.logger)
 		return
 	}
 	validatedRequest.Name = name
 
-	rawSourceType := request.FormValue("source_type")
+	rawSourceType := form.value("source_type")
 	sourceType := models.SourceType(rawSourceType) // cast type
-	if sourceType != models.SourceZip && sourceType != models.SourceGitHub && sourceType != models.SourcePrebuilt {
-		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "source_type must be 'zip', 'github', or 'prebuilt'", handler.logger)
+	if sourceType != models.SourceZip && sourceType != models.SourceGitHub && sourceType != models.SourceGit && sourceType != models.SourcePrebuilt {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "source_type must be 'zip', 'github', 'git', or 'prebuilt'", handler.logger)
 		return
 	}
 	validatedRequest.SourceType = models.SourceType(rawSourceType)
 
+	// ===== private repository credential (optional, github and git sources only)
+	// parsed before the URLs because the credential type decides which URL schemes are valid
+	// (an SSH deploy key needs an ssh URL). the plain text credential only lives in this request,
+	// it is encrypted below and stored encrypted, no response ever contains it.
+	gitCredential, errParseCredential := parseGitCredential(form, sourceType)
+	if errParseCredential != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, errParseCredential.Error(), handler.logger)
+		return
+	}
+	var gitCredentialType models.GitCredentialType // "" for public repositories
+	var encryptedGitCredential *string
+	if gitCredential != nil {
+		gitCredentialType = gitCredential.Type
+		var errEncryptCredential error
+		encryptedGitCredential, errEncryptCredential = encryptGitCredential(handler.credentialCipher, gitCredential)
+		if errEncryptCredential != nil {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, errEncryptCredential.Error(), handler.logger)
+			return
+		}
+	}
+
 	// only populate the pointer if source is github
 	// a nil pointer means "not provided", an empty string means "provided but blank".
 	var githubURL *string
 	if sourceType == models.SourceGitHub {
-		rawGitHubURL := request.FormValue("github_url")
+		rawGitHubURL := form.value("github_url")
 		if rawGitHubURL == "" {
 			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "github_url is required when source_type is 'github'", handler.logger)
 			return
 		}
+		// public github_url values are not validated (the GitHub API check catches bad ones),
+		// with a credential the URL scheme has to match it
+		if gitCredential != nil {
+			errInvalidGitHubURL := build.ValidateGitRepositoryURL(rawGitHubURL, gitCredentialType)
+			if errInvalidGitHubURL != nil {
+				writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "invalid github_url: "+errInvalidGitHubURL.Error(), handler.logger)
+				return
+			}
+		}
 		githubURL = &rawGitHubURL
 	}
 	validatedRequest.GitHubURL = githubURL // empty pointer will get passed if not source github
 
-	branch := request.FormValue("branch")
+	// same for the generic git source. unlike github_url (which only ever goes to github.com),
+	// git_url can point anywhere, so the scheme is restricted (see build.ValidateGitRepositoryURL)
+	var gitURL *string
+	if sourceType == models.SourceGit {
+		rawGitURL := form.value("git_url")
+		if rawGitURL == "" {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "git_url is required when source_type is 'git'", handler.logger)
+			return
+		}
+		errInvalidGitURL := build.ValidateGitRepositoryURL(rawGitURL, gitCredentialType)
+		if errInvalidGitURL != nil {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "invalid git_url: "+errInvalidGitURL.Error(), handler.logger)
+			return
+		}
+		gitURL = &rawGitURL
+	}
+	validatedRequest.GitURL = gitURL
+
+	branch := form.value("branch")
 	if branch == "" {
 		branch = "main"
 	}
 	validatedRequest.Branch = branch
 
-	buildCommand := request.FormValue("build_command") // idk if i can even properly validate build commands
+	// optional pinned ref, only meaningful for sources that clone a repository
+	if rawRef := strings.TrimSpace(form.value("ref")); rawRef != "" {
+		if sourceType != models.SourceGitHub && sourceType != models.SourceGit {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "ref only applies to source_type 'github' or 'git'", handler.logger)
+			return
+		}
+		errInvalidRef := build.ValidateGitRef(rawRef)
+		if errInvalidRef != nil {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "invalid ref: "+errInvalidRef.Error(), handler.logger)
+			return
+		}
+		validatedRequest.Ref = &rawRef
+	}
+
+	buildCommand := form.value("build_command") // idk if i can even properly validate build commands
 	validatedRequest.BuildCommand = buildCommand
 
-	outputDirectory := request.FormValue("output_directory")
-	if outputDirectory == "" {
-		outputDirectory = "."
+	// monorepo root directory and the shared directories checked out with it (github/git only).
+	// both end up in host paths and git arguments, so they are validated as relative paths.
+	rootDirectory, errInvalidRootDirectory := build.ValidateRelativeDirectory("root_directory", form.value("root_directory"))
+	if errInvalidRootDirectory != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, errInvalidRootDirectory.Error(), handler.logger)
+		return
 	}
-	validatedRequest.OutputDirectory = outputDirectory
-
-	rawEnvironmentVariables := request.FormValue("environment_variables")
-	// env vars arrive as a JSON string in the form field.
-	// decoding it into a map, then re-encode it as a JSON string for storage.
-	// this round-trip validates the JSON and normalises the format.
-	// TODO the env var doesn't really need to be in the createDeploymentRequest struct so i gotta do something with it.
-	var encodedEnvironmentVariables *string
-	if rawEnvironmentVariables != "" {
-		var envVarsMap map[string]string
-		err := json.Unmarshal([]byte(rawEnvironmentVariables), &envVarsMap)
-		if err != nil {
-			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "environment_variables must be a valid JSON object", handler.logger)
+	sharedDirectories, errInvalidSharedDirectories := build.ParseSharedDirectories(form.value("shared_directories"))
+	if errInvalidSharedDirectories != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, errInvalidSharedDirectories.Error(), handler.logger)
+		return
+	}
+	if rootDirectory != "." || sharedDirectories != "" {
+		if sourceType != models.SourceGitHub && sourceType != models.SourceGit {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "root_directory and shared_directories only apply to source_type 'github' or 'git'", handler.logger)
 			return
 		}
-		if len(envVarsMap) > 0 {
-			envBytes, errMarshal := json.Marshal(envVarsMap)
-			if errMarshal != nil {
-				handler.logger.Error("failed to encode env vars", "error", errMarshal)
-				writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to process environment variables", handler.logger)
-				return
-			}
-			encoded := string(envBytes)
-			encodedEnvironmentVariables = &encoded
+		if rootDirectory == "." {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "shared_directories requires a root_directory", handler.logger)
+			return
 		}
 	}
+	validatedRequest.RootDirectory = rootDirectory
+	if sharedDirectories != "" {
+		validatedRequest.SharedDirectories = &sharedDirectories
+	}
+
+	// optional post-clone steps, same "true" convention as auto_deploy
+	gitSubmodules := form.value("git_submodules") == "true"
+	gitLFS := form.value("git_lfs") == "true"
+	if (gitSubmodules || gitLFS) && sourceType != models.SourceGitHub && sourceType != models.SourceGit {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "git_submodules and git_lfs only apply to source_type 'github' or 'git'", handler.logger)
+		return
+	}
+	validatedRequest.GitSubmodules = gitSubmodules
+	validatedRequest.GitLFS = gitLFS
+
+	// relative to root_directory
+	outputDirectory, errInvalidOutputDirectory := build.ValidateRelativeDirectory("output_directory", form.value("output_directory"))
+	if errInvalidOutputDirectory != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, errInvalidOutputDirectory.Error(), handler.logger)
+		return
+	}
+	validatedRequest.OutputDirectory = outputDirectory
+
+	// served-site options, they apply to every source type and only change the nginx config of the serving container
+	validatedRequest.SPAFallback = form.value("spa_fallback") == "true"
+	validatedRequest.Custom404 = form.value("custom_404") == "true"
+	validatedRequest.CleanURLs = form.value("clean_urls") == "true"
+
+	trailingSlash := models.TrailingSlashPolicy(form.value("trailing_slash"))
+	if trailingSlash == "" {
+		trailingSlash = models.TrailingSlashIgnore
+	}
+	if trailingSlash != models.TrailingSlashIgnore && trailingSlash != models.TrailingSlashAlways && trailingSlash != models.TrailingSlashNever {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "trailing_slash must be 'ignore', 'always' or 'never'", handler.logger)
+		return
+	}
+	validatedRequest.TrailingSlash = trailingSlash
+
+	// IP access lists, enforced in front of the serving container (Traefik) or by the shared static server
+	// an empty field keeps the platform defaults, like leaving it out
+	if rawIPAllowList := strings.TrimSpace(form.value("ip_allow_list")); rawIPAllowList != "" {
+		validatedRequest.IPAllowList = &rawIPAllowList
+	}
+	if rawIPDenyList := strings.TrimSpace(form.value("ip_deny_list")); rawIPDenyList != "" {
+		validatedRequest.IPDenyList = &rawIPDenyList
+	}
+	ipAllowList, ipDenyList, errInvalidIPAccessLists := handler.validateIPAccessLists(validatedRequest.IPAllowList, validatedRequest.IPDenyList)
+	if errInvalidIPAccessLists != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, errInvalidIPAccessLists.Error(), handler.logger)
+		return
+	}
+	validatedRequest.IPAllowList = ipAllowList
+	validatedRequest.IPDenyList = ipDenyList
+
+	rawEnvironmentVariables := form.value("environment_variables")
+	// env vars arrive as a JSON object string in the form field. each value is either a plain
+	// string (build scope, the original format) or {"value": "...", "scope": "build|runtime|secret"}.
+	// the variables are then re-encoded as one JSON map per scope for storage.
+	// this round-trip validates the JSON and normalises the format.
+	environmentVariables, errParseEnvVars := parseScopedEnvironmentVariables(rawEnvironmentVariables)
+	if errParseEnvVars != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, errParseEnvVars.Error(), handler.logger)
+		return
+	}
+	validatedRequest.EnvironmentVariables = environmentVariables
+
+	encodedBuildEnvVars, encodedRuntimeEnvVars, encodedSecretEnvVars, errEncodeEnvVars := encodeEnvironmentVariablesByScope(environmentVariables)
+	if errEncodeEnvVars != nil {
+		handler.logger.Error("failed to encode env vars", "error", errEncodeEnvVars)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to process environment variables", handler.logger)
+		return
+	}
 
 	// form values are always strings. "true" -> true, anything else -> false.
-	rawAutoDeploy := request.FormValue("auto_deploy")
+	rawAutoDeploy := form.value("auto_deploy")
 	autoDeploy := rawAutoDeploy == "true"
 	validatedRequest.AutoDeploy = autoDeploy
 
-	// ===== handle preset_id for prebuilt deployments
+	// ===== handle preset_id and template_variables for prebuilt deployments
 	var presetID *string
+	var encodedTemplateValues *string
+	rawTemplateVariables := form.value("template_variables")
+	if rawTemplateVariables != "" && sourceType != models.SourcePrebuilt {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "template_variables is only supported for source_type 'prebuilt'", handler.logger)
+		return
+	}
 	if sourceType == models.SourcePrebuilt {
-		rawPresetID := request.FormValue("preset_id")
+		rawPresetID := form.value("preset_id")
 		if rawPresetID == "" {
 			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "preset_id is required when source_type is 'prebuilt'", handler.logger)
 			return
 		}
+		// only IDs of the registry are accepted, the raw value never reaches a path join.
+		// Lookup also rejects anything that is not shaped like a preset ID ("../x", "a/b").
+		preset, errLookupPreset := handler.presetRegistry.Lookup(rawPresetID)
+		if errLookupPreset != nil {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest,
+				fmt.Sprintf("unknown preset_id %q, see GET /api/presets for the available presets", rawPresetID), handler.logger)
+			return
+		}
 		presetID = &rawPresetID
+
+		// template_variables is a JSON object of variable name -> value, checked against the preset's manifest.
+		// the resolved values (defaults filled in) are stored, the pipeline substitutes them at deploy time.
+		if len(preset.Manifest.TemplateVariables) > 0 {
+			var providedTemplateValues map[string]string
+			if rawTemplateVariables != "" {
+				if errDecode := json.Unmarshal([]byte(rawTemplateVariables), &providedTemplateValues); errDecode != nil {
+					writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "template_variables must be a JSON object of string values", handler.logger)
+					return
+				}
+			}
+			resolvedTemplateValues, errResolve := build.ResolvePresetTemplateValues(&preset.Manifest, providedTemplateValues)
+			if errResolve != nil {
+				writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, errResolve.Error(), handler.logger)
+				return
+			}
+			encodedTemplateValuesBytes, errEncode := json.Marshal(resolvedTemplateValues)
+			if errEncode != nil {
+				writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to process template variables", handler.logger)
+				return
+			}
+			encodedTemplateValuesString := string(encodedTemplateValuesBytes)
+			encodedTemplateValues = &encodedTemplateValuesString
+		} else if rawTemplateVariables != "" {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest,
+				fmt.Sprintf("preset %q has no template variables", rawPresetID), handler.logger)
+			return
+		}
 		handler.logger.Info("prebuilt deployment requested", "preset_id", rawPresetID)
 	}
 
 	// ===== handle zip file upload
 
-	// FormFile retrieves the uploaded file for the given form field name.
-	// returns the file content as a multipart.File (implements io.Reader)
-	// and a FileHeader containing metadata (original filename, size, MIME type).
-	// returns an error if the field is absent or the upload failed.
-	var uploadedFile multipart.File // multipart.File is an interface that embeds io.Reader, io.ReaderAt, io.Seeker, and io.Closer
-	if validatedRequest.SourceType == models.SourceZip {
-		var formFileErr error
-		uploadedFile, _, formFileErr = request.FormFile("file")
-		if formFileErr != nil {
-			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "file is required for zip source type", handler.logger)
-			return
-		}
-		// the uploadedFile is not closed here. If deferred to close, it will close when this CreateDeployment
-		// function ends. But the DeployerPipeline is in a go routine so
-		// it wont finish yet, thus having closed file errors
-
+	// the "file" part was already streamed to its staging file by readDeploymentForm.
+	// it is only required (and only used) for the zip source type.
+	if validatedRequest.SourceType == models.SourceZip && form.stagedUpload == nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "file is required for zip source type", handler.logger)
+		return
 	}
 
 	// ===== generate deployment identifiers
 
 	deploymentID := uuid.New().String()
-	slug := util.GenerateSlug()
+
+	// slug is optional: a user chosen slug ("team-docs") is validated here and used as-is,
+	// without one a random "adjective-noun-xxxx" slug is generated.
+	// whether the slug is free is only known at insert time (UNIQUE constraint), see below.
+	customSlug := form.value("slug")
+	slug := customSlug
+	if customSlug != "" {
+		if errValidateSlug := handler.slugPolicy.ValidateCustomSlug(customSlug); errValidateSlug != nil {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, errValidateSlug.Error(), handler.logger)
+			return
+		}
+	} else {
+		slug = util.GenerateSlug()
+	}
 
 	// the webhook secret is a 32-byte cryptographically random value encoded as hex.
 	// 32 bytes = 256 bits of entropy, which is the same strength as an HMAC-SHA256 key.
@@ -303,7 +643,7 @@ func (handler *DeploymentHandler) CreateDeployment(responseWriter http.ResponseW
 	// the friend_code field is optional. if provided and matches the configured
 	// code, the deployment gets an extended TTL. otherwise it gets the default TTL.
 	// if no friend code is configured on the backend, all deployments get the default TTL.
-	friendCode := request.FormValue("friend_code")
+	friendCode := form.value("friend_code")
 	ttlMinutes := handler.defaultTTLMinutes
 	if friendCode == handler.friendCode {
 		handler.logger.Info("friend_code activated", "slug", slug, "deploymentID", deploymentID)
@@ -326,30 +666,62 @@ func (handler *DeploymentHandler) CreateDeployment(responseWriter http.ResponseW
 	// the URL is constructed from the slug and set immediately so the client
 	// knows the public address before the container is even started.
 	// the container may not be live yet (status is "deploying") but the URL is deterministic.
-	deploymentURL := "https://" + slug + "-corvus.sasta.dev" // TODO properly pass down BaseDomain env var in AppConfig
-	// https cuz cloudflare tunnel provides that
+	deploymentURL := deploymentURLForSlug(slug)
 
 	// assemble the deployment model to put into database
 	deployment := &models.Deployment{
-		ID:                   deploymentID,
-		Slug:                 slug,
-		Name:                 validatedRequest.Name,
-		SourceType:           validatedRequest.SourceType,
-		GitHubURL:            validatedRequest.GitHubURL,
-		Branch:               validatedRequest.Branch,
-		BuildCommand:         validatedRequest.BuildCommand,
-		OutputDirectory:      validatedRequest.OutputDirectory,
-		EnvironmentVariables: encodedEnvironmentVariables,
-		Status:               models.StatusDeploying,
-		URL:                  &deploymentURL,
-		WebhookSecret:        &webhookSecret,
-		AutoDeploy:           validatedRequest.AutoDeploy,
-		PresetID:             presetID,
-		ExpiresAt:            expiresAt,
+		ID:                          deploymentID,
+		Slug:                        slug,
+		Name:                        validatedRequest.Name,
+		SourceType:                  validatedRequest.SourceType,
+		GitHubURL:                   validatedRequest.GitHubURL,
+		GitURL:                      validatedRequest.GitURL,
+		GitCredentialType:           gitCredentialTypeOrNil(gitCredentialType),
+		EncryptedGitCredential:      encryptedGitCredential,
+		Branch:                      validatedRequest.Branch,
+		Ref:                         validatedRequest.Ref,
+		BuildCommand:                validatedRequest.BuildCommand,
+		RootDirectory:               validatedRequest.RootDirectory,
+		SharedDirectories:           validatedRequest.SharedDirectories,
+		GitSubmodules:               validatedRequest.GitSubmodules,
+		GitLFS:                      validatedRequest.GitLFS,
+		OutputDirectory:             validatedRequest.OutputDirectory,
+		SPAFallback:                 validatedRequest.SPAFallback,
+		Custom404:                   validatedRequest.Custom404,
+		CleanURLs:                   validatedRequest.CleanURLs,
+		TrailingSlash:               validatedRequest.TrailingSlash,
+		IPAllowList:                 validatedRequest.IPAllowList,
+		IPDenyList:                  validatedRequest.IPDenyList,
+		EnvironmentVariables:        encodedBuildEnvVars,
+		RuntimeEnvironmentVariables: encodedRuntimeEnvVars,
+		SecretEnvironmentVariables:  encodedSecretEnvVars,
+		Status:                      models.StatusDeploying,
+		URL:                         &deploymentURL,
+		WebhookSecret:               &webhookSecret,
+		AutoDeploy:                  validatedRequest.AutoDeploy,
+		PresetID:                    presetID,
+		TemplateValues:              encodedTemplateValues,
+		ExpiresAt:                   expiresAt,
 	}
 
 	// ===== Writing to database (persist to database)
-	err = handler.database.InsertDeployment(deployment)
+	// a taken custom slug is the user's to fix (409). a random slug that happens to be taken
+	// (65536 suffixes per word pair) is regenerated, a few attempts make a failure practically impossible.
+	for attempt := 1; ; attempt++ {
+		err = handler.database.InsertDeployment(deployment)
+		if !errors.Is(err, db.ErrSlugTaken) || customSlug != "" || attempt == maxRandomSlugAttempts {
+			break
+		}
+		handler.logger.Warn("random slug already taken, generating another", "slug", deployment.Slug, "attempt", attempt)
+		slug = util.GenerateSlug()
+		deploymentURL = deploymentURLForSlug(slug)
+		deployment.Slug = slug
+		deployment.URL = &deploymentURL
+	}
+	if errors.Is(err, db.ErrSlugTaken) && customSlug != "" {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusConflict, fmt.Sprintf("slug %q is already taken", customSlug), handler.logger)
+		return
+	}
 	if err != nil {
 		handler.logger.Error("failed to insert deployment", "id", deploymentID, "error", err)
 		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to create deployment", handler.logger)
@@ -369,22 +741,24 @@ func (handler *DeploymentHandler) CreateDeployment(responseWriter http.ResponseW
 	// the deployerPipeline runs in a goroutine so the HTTP handler returns immediately.
 	// the client receives 201 with status "deploying" and polls for updates.
 	// the goroutine captures the deployerPipeline pointer and deployment by value (safe since deployment is a pointer).
-	if validatedRequest.SourceType == models.SourceZip && uploadedFile != nil {
-		go handler.deployerPipeline.DeployZipUpload(deployment, uploadedFile)
+	if validatedRequest.SourceType == models.SourceZip {
+		stagedUploadHandedOff = true // the pipeline removes the staging file from here on
+		go handler.deployerPipeline.DeployZipUpload(request.Context(), deployment, form.stagedUpload)
 	}
 
-	if validatedRequest.SourceType == models.SourceGitHub {
-		go handler.deployerPipeline.DeployGitHub(deployment)
+	if validatedRequest.SourceType == models.SourceGitHub || validatedRequest.SourceType == models.SourceGit {
+		go handler.deployerPipeline.DeployGitRepository(request.Context(), deployment)
 	}
 
 	if validatedRequest.SourceType == models.SourcePrebuilt {
-		go handler.deployerPipeline.DeployPrebuilt(deployment)
+		go handler.deployerPipeline.DeployPrebuilt(request.Context(), deployment)
 	}
 
 	// 201 Created is the correct status for a successful resource creation
 	// (the record exists, the deployerPipeline is running.)
 	// 200 OK is for successful reads or updates, not for new resource creation.
-	writeJsonAndRespond(responseWriter, http.StatusCreated, deployment)
+	// the only response carrying the webhook secret, see createDeploymentResponse
+	writeJsonAndRespond(responseWriter, http.StatusCreated, createDeploymentResponse{deployment: deployment})
 	// (response is for the client to do frontend logic and display)
 }
 
@@ -418,6 +792,28 @@ func (handler *DeploymentHandler) DeleteDeployment(responseWriter http.ResponseW
 		"slug", deployment.Slug,
 	)
 
+	// ===== pull request previews go first, they are built from this deployment's settings
+	// and would otherwise be left running without a parent (and without a webhook to ever close them).
+	// the parent is kept when one fails, so the delete can be retried.
+	previewDeployments, err := handler.database.ListPreviewDeployments(deployment.ID)
+	if err != nil {
+		handler.logger.Error("failed to list preview deployments for delete", "id", deploymentID, "error", err)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to delete deployment", handler.logger)
+		return
+	}
+	for _, previewDeployment := range previewDeployments {
+		errTeardownPreview := handler.deployerPipeline.TeardownDeployment(request.Context(), previewDeployment)
+		if errTeardownPreview != nil {
+			handler.logger.Error("teardown of preview deployment failed during delete",
+				"id", previewDeployment.ID,
+				"slug", previewDeployment.Slug,
+				"error", errTeardownPreview,
+			)
+			writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to delete preview deployments", handler.logger)
+			return
+		}
+	}
+
 	// ===== stop and remove the container, and other cleanup stuff
 	errTeardownDeployment := handler.deployerPipeline.TeardownDeployment(request.Context(), deployment)
 	if errTeardownDeployment != nil {
@@ -440,6 +836,13 @@ func (handler *DeploymentHandler) DeleteDeployment(responseWriter http.ResponseW
 	responseWriter.WriteHeader(http.StatusNoContent)
 }
 
+// redeployRequest is the optional JSON body of POST /api/deployments/:uuid/redeploy.
+type redeployRequest struct {
+	// Ref re-pins a github/git deployment to a tag, full commit SHA or branch from this redeploy on
+	// (eg, the commit_sha of a known-good deploy to roll back). "" removes the pin, absent keeps it.
+	Ref *string `json:"ref"`
+}
+
 // RedeployDeployment handles POST /api/deployments/:uuid/redeploy.
 // fetches the existing deployment, validates it can be redeployed,
 // and triggers the appropriate pipeline in a goroutine.
@@ -461,6 +864,39 @@ func (handler *DeploymentHandler) RedeployDeployment(responseWriter http.Respons
 		return
 	}
 
+	// optional JSON body, an empty body keeps the deployment as it is (the original redeploy behaviour)
+	var redeployBody redeployRequest
+	errDecodeBody := json.NewDecoder(io.LimitReader(request.Body, 64<<10)).Decode(&redeployBody)
+	if errDecodeBody != nil && !errors.Is(errDecodeBody, io.EOF) {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "invalid request body: "+errDecodeBody.Error(), handler.logger)
+		return
+	}
+
+	// a ref in the body re-pins the deployment, it is persisted so later redeploys
+	// (and auto deploys) keep building the same ref
+	if redeployBody.Ref != nil {
+		if deployment.SourceType != models.SourceGitHub && deployment.SourceType != models.SourceGit {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "ref only applies to github and git deployments", handler.logger)
+			return
+		}
+		var newRef *string // "" unpins, back to following the branch
+		if trimmedRef := strings.TrimSpace(*redeployBody.Ref); trimmedRef != "" {
+			errInvalidRef := build.ValidateGitRef(trimmedRef)
+			if errInvalidRef != nil {
+				writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "invalid ref: "+errInvalidRef.Error(), handler.logger)
+				return
+			}
+			newRef = &trimmedRef
+		}
+		errUpdateRef := handler.database.UpdateRef(deployment.ID, newRef)
+		if errUpdateRef != nil {
+			handler.logger.Error("failed to update ref for redeploy", "id", deploymentID, "error", errUpdateRef)
+			writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to update deployment ref", handler.logger)
+			return
+		}
+		deployment.Ref = newRef
+	}
+
 	handler.logger.Info("redeploy requested",
 		"id", deploymentID,
 		"slug", deployment.Slug,
@@ -469,14 +905,14 @@ func (handler *DeploymentHandler) RedeployDeployment(responseWriter http.Respons
 
 	// trigger the pipeline based on source type.
 	// zip redeployments use the existing files on disk.
-	// GitHub redeployments will re-clone and rebuild
+	// GitHub and git redeployments will re-clone and rebuild
 	switch deployment.SourceType {
 	case models.SourceZip:
-		go handler.deployerPipeline.RedeployExistingZip(deployment)
-	case models.SourceGitHub:
-		go handler.deployerPipeline.DeployGitHub(deployment)
+		go handler.deployerPipeline.RedeployExistingZip(request.Context(), deployment)
+	case models.SourceGitHub, models.SourceGit:
+		go handler.deployerPipeline.DeployGitRepository(request.Context(), deployment)
 	case models.SourcePrebuilt:
-		go handler.deployerPipeline.DeployPrebuilt(deployment)
+		go handler.deployerPipeline.DeployPrebuilt(request.Context(), deployment)
 
 	default:
 		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "unknown source type", handler.logger)
@@ -0,0 +1,122 @@
+package handlers
+
+// environment_variables.go parses the environment_variables form field of
+// POST /api/deployments into scoped variables and splits them by scope for storage.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+)
+
+// scopedEnvironmentVariableValue is the object form of a single variable in the request:
+//
+//	{"API_TOKEN": {"value": "abc", "scope": "secret"}}
+//
+// a missing scope falls back to "build".
+type scopedEnvironmentVariableValue struct {
+	Value string                          `json:"value"`
+	Scope models.EnvironmentVariableScope `json:"scope"`
+}
+
+// parseScopedEnvironmentVariables decodes the raw environment_variables JSON object.
+// each value may be a plain string (build scope, the format the frontend already sends)
+// or an object with "value" and "scope". Returns nil for an empty field.
+// the returned error message is safe to send to the client (it never echoes values).
+func parseScopedEnvironmentVariables(rawEnvironmentVariables string) ([]models.EnvironmentVariable, error) {
+	if rawEnvironmentVariables == "" {
+		return nil, nil
+	}
+
+	// json.RawMessage delays decoding of each value so both shapes can be accepted.
+	var rawValuesByKey map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(rawEnvironmentVariables), &rawValuesByKey); err != nil {
+		return nil, errors.New("environment_variables must be a valid JSON object")
+	}
+
+	environmentVariables := make([]models.EnvironmentVariable, 0, len(rawValuesByKey))
+	for key, rawValue := range rawValuesByKey {
+		if key == "" || strings.ContainsAny(key, "= \t\n") {
+			return nil, fmt.Errorf("environment variable name %q is not valid", key)
+		}
+
+		// plain string value -> build scope
+		var plainValue string
+		if err := json.Unmarshal(rawValue, &plainValue); err == nil {
+			environmentVariables = append(environmentVariables, models.EnvironmentVariable{
+				Key:   key,
+				Value: plainValue,
+				Scope: models.EnvScopeBuild,
+			})
+			continue
+		}
+
+		var scopedValue scopedEnvironmentVariableValue
+		if err := json.Unmarshal(rawValue, &scopedValue); err != nil {
+			return nil, fmt.Errorf("environment variable %q must be a string or an object with \"value\" and \"scope\"", key)
+		}
+		if scopedValue.Scope == "" {
+			scopedValue.Scope = models.EnvScopeBuild
+		}
+		switch scopedValue.Scope {
+		case models.EnvScopeBuild, models.EnvScopeRuntime, models.EnvScopeSecret:
+		default:
+			return nil, fmt.Errorf("environment variable %q has unknown scope %q (must be 'build', 'runtime', or 'secret')", key, scopedValue.Scope)
+		}
+
+		environmentVariables = append(environmentVariables, models.EnvironmentVariable{
+			Key:   key,
+			Value: scopedValue.Value,
+			Scope: scopedValue.Scope,
+		})
+	}
+
+	// map iteration order is random in Go, sorting keeps logs and storage deterministic.
+	sort.Slice(environmentVariables, func(i, j int) bool {
+		return environmentVariables[i].Key < environmentVariables[j].Key
+	})
+	return environmentVariables, nil
+}
+
+// encodeEnvironmentVariablesByScope splits the variables into one KEY->VALUE map per scope
+// and JSON-encodes each map for its own column (env_vars, runtime_env_vars, secret_env_vars).
+// a scope with no variables is returned as nil so the column stores NULL.
+func encodeEnvironmentVariablesByScope(
+	environmentVariables []models.EnvironmentVariable,
+) (encodedBuild *string, encodedRuntime *string, encodedSecret *string, err error) {
+	variablesByScope := map[models.EnvironmentVariableScope]map[string]string{}
+	for _, variable := range environmentVariables {
+		if variablesByScope[variable.Scope] == nil {
+			variablesByScope[variable.Scope] = map[string]string{}
+		}
+		variablesByScope[variable.Scope][variable.Key] = variable.Value
+	}
+
+	encodeScope := func(scope models.EnvironmentVariableScope) (*string, error) {
+		scopeVariables := variablesByScope[scope]
+		if len(scopeVariables) == 0 {
+			return nil, nil
+		}
+		encodedBytes, errMarshal := json.Marshal(scopeVariables)
+		if errMarshal != nil {
+			return nil, fmt.Errorf("failed to encode %s-scoped environment variables: %w", scope, errMarshal)
+		}
+		encoded := string(encodedBytes)
+		return &encoded, nil
+	}
+
+	if encodedBuild, err = encodeScope(models.EnvScopeBuild); err != nil {
+		return nil, nil, nil, err
+	}
+	if encodedRuntime, err = encodeScope(models.EnvScopeRuntime); err != nil {
+		return nil, nil, nil, err
+	}
+	if encodedSecret, err = encodeScope(models.EnvScopeSecret); err != nil {
+		return nil, nil, nil, err
+	}
+	return encodedBuild, encodedRuntime, encodedSecret, nil
+}
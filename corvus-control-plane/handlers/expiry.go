@@ -0,0 +1,214 @@
+package handlers
+
+// expiry.go holds the endpoints changing when a deployment expires, after CreateDeployment set it:
+//   - POST /api/deployments/:uuid/extend pushes the expiry back, up to a maximum lifetime counted
+//     from the creation (MAX_LIFETIME_MINUTES, or EXTENDED_MAX_LIFETIME_MINUTES with the friend code)
+//   - POST/DELETE /api/deployments/:uuid/pin (admin only) clears expires_at, or gives the deployment a TTL again
+//
+// pull request previews follow their parent (db.UpdateExpiresAt), they cannot be extended or pinned on their own.
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/db"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+)
+
+// extendRequest is the optional JSON body of POST /api/deployments/:uuid/extend.
+type extendRequest struct {
+	// Minutes is how much time to add, from the current expiry (or from now when that already passed).
+	// 0 (absent) adds the caller's TTL: DEFAULT_TTL_MINUTES, or EXTENDED_TTL_MINUTES with the friend code.
+	Minutes int `json:"minutes"`
+
+	// FriendCode raises the maximum lifetime to EXTENDED_MAX_LIFETIME_MINUTES, same code as on create
+	FriendCode string `json:"friend_code"`
+}
+
+// getDeploymentForExpiryChange fetches the deployment in the URL for the expiry endpoints,
+// writing the error response itself (returns nil then). previews are refused, they expire with their parent.
+func (handler *DeploymentHandler) getDeploymentForExpiryChange(responseWriter http.ResponseWriter, request *http.Request) *models.Deployment {
+	deploymentID := chi.URLParam(request, "uuid")
+
+	deployment, err := handler.database.GetDeployment(deploymentID)
+	if errors.Is(err, db.ErrRecordNotFound) {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusNotFound, "deployment not found", handler.logger)
+		return nil
+	}
+	if err != nil {
+		handler.logger.Error("failed to get deployment for expiry change", "id", deploymentID, "error", err)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to retrieve deployment", handler.logger)
+		return nil
+	}
+	if deployment.ParentDeploymentID != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest,
+			"pull request previews expire with their parent deployment, change the parent's expiry instead", handler.logger)
+		return nil
+	}
+	return deployment
+}
+
+// saveExpiresAt stores the new expiry of a deployment (and its previews) and responds with the deployment.
+func (handler *DeploymentHandler) saveExpiresAt(responseWriter http.ResponseWriter, deployment *models.Deployment, expiresAt *time.Time) {
+	errUpdateExpiresAt := handler.database.UpdateExpiresAt(deployment.ID, expiresAt)
+	if errUpdateExpiresAt != nil {
+		handler.logger.Error("failed to update expires_at", "id", deployment.ID, "error", errUpdateExpiresAt)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to update deployment expiry", handler.logger)
+		return
+	}
+	deployment.ExpiresAt = expiresAt
+
+	handler.logger.Info("deployment expiry updated",
+		"id", deployment.ID,
+		"slug", deployment.Slug,
+		"expires_at", expiresAt,
+	)
+
+	// expires_in_seconds is added from the new expires_at when the deployment is serialised
+	writeJsonAndRespond(responseWriter, http.StatusOK, deployment)
+}
+
+// ExtendDeployment handles POST /api/deployments/:uuid/extend.
+// adds time to a deployment's expiry so a running demo does not have to be rebuilt,
+// capped at the caller's maximum lifetime counted from the deployment's creation
+// (an extension past the cap is shortened to it). returns 200 with the updated deployment,
+// 409 when the deployment does not expire or already reached its maximum lifetime.
+func (handler *DeploymentHandler) ExtendDeployment(responseWriter http.ResponseWriter, request *http.Request) {
+	// optional JSON body, an empty body extends by the default TTL
+	var extendBody extendRequest
+	errDecodeBody := json.NewDecoder(io.LimitReader(request.Body, 64<<10)).Decode(&extendBody)
+	if errDecodeBody != nil && !errors.Is(errDecodeBody, io.EOF) {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "invalid request body: "+errDecodeBody.Error(), handler.logger)
+		return
+	}
+
+	// the caller's tier, same friend code as on create. a wrong code is refused here
+	// (on create it silently falls back to the default TTL) so a typo does not go unnoticed.
+	ttlMinutes := handler.defaultTTLMinutes
+	maxLifetimeMinutes := handler.maxLifetimeMinutes
+	if extendBody.FriendCode != "" {
+		if handler.friendCode == "" || extendBody.FriendCode != handler.friendCode {
+			writeErrorJsonAndLogIt(responseWriter, http.StatusForbidden, "invalid friend code", handler.logger)
+			return
+		}
+		ttlMinutes = handler.extendedTTLMinutes
+		maxLifetimeMinutes = handler.extendedMaxLifetimeMinutes
+	}
+	if maxLifetimeMinutes <= 0 {
+		message := "extending deployments is disabled on this server"
+		if extendBody.FriendCode == "" && handler.friendCode != "" && handler.extendedMaxLifetimeMinutes > 0 {
+			message = "extending deployments requires a friend code"
+		}
+		writeErrorJsonAndLogIt(responseWriter, http.StatusForbidden, message, handler.logger)
+		return
+	}
+
+	extensionMinutes := extendBody.Minutes
+	if extensionMinutes == 0 {
+		extensionMinutes = ttlMinutes
+	}
+	if extensionMinutes <= 0 {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "minutes must be a positive number", handler.logger)
+		return
+	}
+	// more than the whole lifetime is shortened to the cap below anyway. clamped before the conversion,
+	// time.Duration(minutes) * time.Minute overflows into a negative duration past ~153 million minutes
+	// (which would set expires_at in the past and get the deployment torn down)
+	extensionMinutes = min(extensionMinutes, maxLifetimeMinutes)
+
+	deployment := handler.getDeploymentForExpiryChange(responseWriter, request)
+	if deployment == nil {
+		return
+	}
+	if deployment.ExpiresAt == nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusConflict, "deployment does not expire", handler.logger)
+		return
+	}
+
+	// time already past the expiry (the cleanup loop has not run yet) is not credited
+	extendedFrom := *deployment.ExpiresAt
+	if now := time.Now().UTC(); extendedFrom.Before(now) {
+		extendedFrom = now
+	}
+	expiresAt := extendedFrom.Add(time.Duration(extensionMinutes) * time.Minute)
+
+	lifetimeEnd := deployment.CreatedAt.UTC().Add(time.Duration(maxLifetimeMinutes) * time.Minute)
+	if !lifetimeEnd.After(*deployment.ExpiresAt) {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusConflict,
+			fmt.Sprintf("deployment already reached its maximum lifetime of %d minutes", maxLifetimeMinutes), handler.logger)
+		return
+	}
+	if expiresAt.After(lifetimeEnd) {
+		expiresAt = lifetimeEnd
+	}
+
+	handler.logger.Info("deployment extension requested",
+		"id", deployment.ID,
+		"slug", deployment.Slug,
+		"minutes", extensionMinutes,
+		"friend_code", extendBody.FriendCode != "",
+	)
+	handler.saveExpiresAt(responseWriter, deployment, &expiresAt)
+}
+
+// authenticateAdmin checks the "Authorization: Bearer <ADMIN_TOKEN>" header of an admin-only request,
+// writing the error response itself when it fails.
+func (handler *DeploymentHandler) authenticateAdmin(responseWriter http.ResponseWriter, request *http.Request) bool {
+	if handler.adminToken == "" {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusForbidden,
+			"admin endpoints are disabled on this server (ADMIN_TOKEN is not set)", handler.logger)
+		return false
+	}
+	bearerToken, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(bearerToken), []byte(handler.adminToken)) != 1 {
+		responseWriter.Header().Set("WWW-Authenticate", "Bearer")
+		writeErrorJsonAndLogIt(responseWriter, http.StatusUnauthorized, "invalid or missing admin token", handler.logger)
+		return false
+	}
+	return true
+}
+
+// PinDeployment handles POST /api/deployments/:uuid/pin (admin only).
+// clears expires_at, the deployment (and its previews) never expire until unpinned or deleted.
+// returns 200 with the updated deployment.
+func (handler *DeploymentHandler) PinDeployment(responseWriter http.ResponseWriter, request *http.Request) {
+	if !handler.authenticateAdmin(responseWriter, request) {
+		return
+	}
+	deployment := handler.getDeploymentForExpiryChange(responseWriter, request)
+	if deployment == nil {
+		return
+	}
+	handler.saveExpiresAt(responseWriter, deployment, nil)
+}
+
+// UnpinDeployment handles DELETE /api/deployments/:uuid/pin (admin only).
+// gives a deployment that does not expire the default TTL again, counted from now
+// (it keeps not expiring when DEFAULT_TTL_MINUTES is 0). returns 200 with the updated deployment.
+func (handler *DeploymentHandler) UnpinDeployment(responseWriter http.ResponseWriter, request *http.Request) {
+	if !handler.authenticateAdmin(responseWriter, request) {
+		return
+	}
+	deployment := handler.getDeploymentForExpiryChange(responseWriter, request)
+	if deployment == nil {
+		return
+	}
+	if deployment.ExpiresAt != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusConflict, "deployment is not pinned", handler.logger)
+		return
+	}
+
+	var expiresAt *time.Time
+	if handler.defaultTTLMinutes > 0 {
+		defaultExpiry := time.Now().UTC().Add(time.Duration(handler.defaultTTLMinutes) * time.Minute)
+		expiresAt = &defaultExpiry
+	}
+	handler.saveExpiresAt(responseWriter, deployment, expiresAt)
+}
@@ -51,8 +51,8 @@ type healthResponse struct {
 // returns a 200 OK with a JSON body confirmation if the service is running.
 // this endpoint is intentionally simple: no db check, no auth, no business logic.
 // it is the minimum signal that the process is alive and the HTTP stack works.
-// a more thorough readiness check (db ping, docker socket check) can be added
-// at GET /ready in a future step.
+// the thorough readiness check (db ping, docker ping, network, disk) lives at GET /ready
+// (see readiness.go), so a Docker outage does not restart a perfectly alive process.
 // handler functions always have the signature (http.ResponseWriter, *http.Request) at the beginning,
 // because they are called by the net/http library with these args when a request is routed to them.
 // The ResponseWriter is used to construct the HTTP response to the client/brower/api,
@@ -69,6 +69,12 @@ func writeErrorJsonAndLogIt(
 	writeJsonAndRespond(responseWriter, statusCode, map[string]string{"error": message})
 }
 
+// deploymentURLForSlug returns the public URL of a deployment.
+// https cuz cloudflare tunnel provides that
+func deploymentURLForSlug(slug string) string {
+	return "https://" + slug + "-corvus.sasta.dev" // TODO properly pass down BaseDomain env var in AppConfig
+}
+
 // generateWebhookSecret returns a cryptographically secure random hex string
 // suitable for use as an HMAC-SHA256 signing secret.
 // 32 random bytes encoded as hex produces a 64-character string.
@@ -0,0 +1,130 @@
+package handlers
+
+// ip_access.go holds the validation of the IP allow and deny lists of a deployment, and the endpoint
+// replacing them. the lists are stored normalised (comma separated CIDR ranges), see util/ip_access.go
+// for how they combine with the platform defaults and build/access_control.go for how they are enforced.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/db"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/util"
+)
+
+// ipAccessRequest is the JSON body of PUT /api/deployments/:uuid/ip-access.
+// both lists are replaced. null (or absent) resets a list: the allow list back to the platform
+// default, the deny list to none. "" as the allow list allows every address, whatever the default.
+type ipAccessRequest struct {
+	IPAllowList *string `json:"ip_allow_list"`
+	IPDenyList  *string `json:"ip_deny_list"`
+}
+
+// normaliseIPAccessList parses one list and returns it in its stored form, nil stays nil.
+func normaliseIPAccessList(listName string, value *string) (*string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	ranges, err := util.ParseIPRangeList(*value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", listName, err)
+	}
+	normalisedList := util.FormatIPRangeList(ranges)
+	return &normalisedList, nil
+}
+
+// validateIPAccessLists normalises both lists and checks that, combined with the platform defaults,
+// they leave some address able to reach the site. the returned error is safe to show to the user.
+func (handler *DeploymentHandler) validateIPAccessLists(allowList *string, denyList *string) (*string, *string, error) {
+	normalisedAllowList, err := normaliseIPAccessList("ip_allow_list", allowList)
+	if err != nil {
+		return nil, nil, err
+	}
+	normalisedDenyList, err := normaliseIPAccessList("ip_deny_list", denyList)
+	if err != nil {
+		return nil, nil, err
+	}
+	// a deny list of only separators is no deny list
+	if normalisedDenyList != nil && *normalisedDenyList == "" {
+		normalisedDenyList = nil
+	}
+	if _, err := handler.ipAccessPolicy.AllowedRanges(normalisedAllowList, normalisedDenyList); err != nil {
+		return nil, nil, err
+	}
+	return normalisedAllowList, normalisedDenyList, nil
+}
+
+// UpdateIPAccess handles PUT /api/deployments/:uuid/ip-access.
+// replaces the IP allow and deny lists of a deployment and applies them to the running site
+// (the container's Traefik middleware is replaced, the files are not redeployed).
+// returns 200 with the updated deployment.
+func (handler *DeploymentHandler) UpdateIPAccess(responseWriter http.ResponseWriter, request *http.Request) {
+	deploymentID := chi.URLParam(request, "uuid")
+
+	var ipAccessBody ipAccessRequest
+	errDecodeBody := json.NewDecoder(io.LimitReader(request.Body, 64<<10)).Decode(&ipAccessBody)
+	if errDecodeBody != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "invalid request body: "+errDecodeBody.Error(), handler.logger)
+		return
+	}
+	ipAllowList, ipDenyList, errInvalidIPAccessLists := handler.validateIPAccessLists(ipAccessBody.IPAllowList, ipAccessBody.IPDenyList)
+	if errInvalidIPAccessLists != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, errInvalidIPAccessLists.Error(), handler.logger)
+		return
+	}
+
+	// held until the change is live, a pipeline run cannot mark the deployment deploying in between
+	unlockDeployment := handler.deployerPipeline.LockDeployment(deploymentID)
+	defer unlockDeployment()
+
+	deployment, err := handler.database.GetDeployment(deploymentID)
+	if errors.Is(err, db.ErrRecordNotFound) {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusNotFound, "deployment not found", handler.logger)
+		return
+	}
+	if err != nil {
+		handler.logger.Error("failed to get deployment for ip access update", "id", deploymentID, "error", err)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to retrieve deployment", handler.logger)
+		return
+	}
+
+	// same as the password, a running pipeline replaces the container (or the shared site) itself.
+	// checked under the deployment lock, see updateBasicAuth
+	if deployment.Status == models.StatusDeploying {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusConflict,
+			"deployment is currently deploying, retry once it is live", handler.logger)
+		return
+	}
+
+	errUpdateIPAccessLists := handler.database.UpdateIPAccessLists(deployment.ID, ipAllowList, ipDenyList)
+	if errUpdateIPAccessLists != nil {
+		handler.logger.Error("failed to update ip access lists", "id", deploymentID, "error", errUpdateIPAccessLists)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to update ip access lists", handler.logger)
+		return
+	}
+	previousDeployment := *deployment
+	deployment.IPAllowList = ipAllowList
+	deployment.IPDenyList = ipDenyList
+
+	errApplyAccessControl := handler.deployerPipeline.ApplyAccessControl(request.Context(), deployment, &previousDeployment)
+	if errApplyAccessControl != nil {
+		handler.logger.Error("failed to apply ip access lists", "id", deploymentID, "error", errApplyAccessControl)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError,
+			"ip access lists saved but could not be applied to the running site, redeploy to apply them", handler.logger)
+		return
+	}
+
+	handler.logger.Info("deployment ip access lists updated",
+		"id", deploymentID,
+		"slug", deployment.Slug,
+		"ip_allow_list_set", ipAllowList != nil,
+		"ip_deny_list_set", ipDenyList != nil,
+	)
+
+	writeJsonAndRespond(responseWriter, http.StatusOK, deployment)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/metrics"
+)
+
+// MetricsMiddleware records the latency of every request in the
+// corvus_http_request_duration_seconds histogram, labelled by the chi route pattern
+// (eg, "/api/deployments/{uuid}") instead of the raw path so each UUID does not create a new series.
+// requests that match no route are recorded under route "unmatched".
+func MetricsMiddleware(controlPlaneMetrics *metrics.ControlPlaneMetrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+			startedAt := time.Now()
+
+			// WrapResponseWriter remembers the status code written by the handler
+			wrappedWriter := middleware.NewWrapResponseWriter(responseWriter, request.ProtoMajor)
+			next.ServeHTTP(wrappedWriter, request)
+
+			// the route pattern is only complete after chi has finished routing,
+			// so it is read after next.ServeHTTP returns
+			route := "unmatched"
+			if routeContext := chi.RouteContext(request.Context()); routeContext != nil {
+				if pattern := routeContext.RoutePattern(); pattern != "" {
+					route = pattern
+				}
+			}
+
+			statusCode := wrappedWriter.Status()
+			if statusCode == 0 {
+				statusCode = http.StatusOK // handler wrote nothing, net/http sends 200
+			}
+			controlPlaneMetrics.HTTPRequestServed(request.Method, route, statusCode, time.Since(startedAt))
+		})
+	}
+}
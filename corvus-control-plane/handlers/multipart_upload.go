@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/build"
+)
+
+// maxFormFieldBytes caps a single non-file form field (name, build_command, environment_variables, ...).
+// the archive is the only part allowed to be large, a 40MB "name" field is not a real request.
+const maxFormFieldBytes = 1 << 20 // 1MB
+
+// errFormFieldTooLarge is returned by readDeploymentForm for a text field above maxFormFieldBytes.
+var errFormFieldTooLarge = errors.New("form field too large")
+
+// deploymentForm is the multipart body of POST /api/deployments, read in one streaming pass.
+// text fields are kept in memory, the "file" part is streamed to its staging file on disk.
+type deploymentForm struct {
+	fields map[string]string
+
+	// stagedUpload is the "file" part, nil when the request has no file.
+	stagedUpload *build.StagedUpload
+}
+
+// value returns a text field, or "" if it was not sent (same semantics as request.FormValue).
+func (form *deploymentForm) value(fieldName string) string {
+	return form.fields[fieldName]
+}
+
+// readDeploymentForm reads the multipart body part by part with request.MultipartReader().
+// unlike ParseMultipartForm (which buffers the upload into a temp file of its own, after which
+// the pipeline copied it into another one), the file part goes straight from the connection into the
+// staging file the extractor reads, hashed on the way (deployerPipeline.StageUpload).
+//
+// parts can arrive in any order (the file may come before source_type), so the file is staged
+// whenever it shows up and the caller decides afterwards whether it is used.
+// on error nothing is left on disk. on success the caller owns form.stagedUpload.
+func readDeploymentForm(request *http.Request, deployerPipeline *build.DeployerPipeline) (*deploymentForm, error) {
+	multipartReader, err := request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	form := &deploymentForm{fields: make(map[string]string)}
+	removeStagedUpload := func() {
+		if form.stagedUpload != nil {
+			form.stagedUpload.Remove()
+		}
+	}
+
+	for {
+		formPart, err := multipartReader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return form, nil
+		}
+		if err != nil {
+			removeStagedUpload()
+			return nil, err
+		}
+
+		fieldName := formPart.FormName()
+		if formPart.FileName() != "" {
+			// only one archive per deployment. other file fields are skipped,
+			// NextPart() discards whatever was not read of the current part.
+			if fieldName != "file" || form.stagedUpload != nil {
+				continue
+			}
+			stagedUpload, errStageUpload := deployerPipeline.StageUpload(formPart)
+			if errStageUpload != nil {
+				removeStagedUpload()
+				return nil, errStageUpload
+			}
+			form.stagedUpload = stagedUpload
+			continue
+		}
+
+		// one byte over the limit is read so an exactly-maxFormFieldBytes field still passes
+		fieldValue, err := io.ReadAll(io.LimitReader(formPart, maxFormFieldBytes+1))
+		if err != nil {
+			removeStagedUpload()
+			return nil, err
+		}
+		if len(fieldValue) > maxFormFieldBytes {
+			removeStagedUpload()
+			return nil, fmt.Errorf("%w: %q", errFormFieldTooLarge, fieldName)
+		}
+		// first value wins, same as request.FormValue
+		if _, alreadySet := form.fields[fieldName]; !alreadySet {
+			form.fields[fieldName] = string(fieldValue)
+		}
+	}
+}
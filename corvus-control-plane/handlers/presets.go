@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"path/filepath"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/build"
+)
+
+// PresetHandler serves the quick-deploy preset listing, so the frontend renders whatever
+// presets the server actually has instead of a hardcoded list.
+type PresetHandler struct {
+	presetRegistry *build.PresetRegistry
+	logger         *slog.Logger
+}
+
+// NewPresetHandler constructs a PresetHandler with its required dependencies.
+func NewPresetHandler(presetRegistry *build.PresetRegistry, logger *slog.Logger) *PresetHandler {
+	return &PresetHandler{
+		presetRegistry: presetRegistry,
+		logger:         logger,
+	}
+}
+
+// ListPresets handles GET /api/presets.
+// returns the manifests of all presets in the registry, sorted by ID.
+// the id of an entry is the preset_id to send when creating a prebuilt deployment.
+func (handler *PresetHandler) ListPresets(responseWriter http.ResponseWriter, request *http.Request) {
+	writeJsonAndRespond(responseWriter, http.StatusOK, handler.presetRegistry.List())
+}
+
+// GetPresetThumbnail handles GET /api/presets/{presetID}/thumbnail.
+// serves the thumbnail image named in the preset's manifest.
+// the path comes from the manifest (validated when the registry was loaded), never from the request.
+func (handler *PresetHandler) GetPresetThumbnail(responseWriter http.ResponseWriter, request *http.Request) {
+	presetID := chi.URLParam(request, "presetID")
+
+	preset, err := handler.presetRegistry.Lookup(presetID)
+	if err != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusNotFound, "preset not found", handler.logger)
+		return
+	}
+	if preset.Manifest.Thumbnail == "" {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusNotFound, "preset has no thumbnail", handler.logger)
+		return
+	}
+
+	// presets change only with a restart, an hour of caching saves the frontend a request per card per visit
+	responseWriter.Header().Set("Cache-Control", "public, max-age=3600")
+	// ServeFile sets the content type from the extension and handles If-Modified-Since / Range
+	http.ServeFile(responseWriter, request, filepath.Join(preset.Directory, preset.Manifest.Thumbnail))
+}
@@ -0,0 +1,191 @@
+package handlers
+
+// readiness.go implements GET /ready, the thorough counterpart of GET /health.
+// /health only proves the process is alive. /ready proves the control plane can actually
+// deploy something right now: the database answers, the Docker daemon answers,
+// the Traefik network exists, and the storage roots are writable with enough free space.
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/db"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/docker"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/util"
+)
+
+// readinessCheckTimeout caps each individual check.
+// a hung Docker daemon or a locked SQLite connection must turn into a failed check,
+// not into a probe that never answers (uptime monitors usually give up after ~10s).
+const readinessCheckTimeout = 3 * time.Second
+
+// ReadinessStorageRoot is one directory the readiness endpoint checks for writability and free space.
+type ReadinessStorageRoot struct {
+	Name string // used in the check name, eg "asset" -> "storage_asset"
+	Path string
+}
+
+// ReadinessHandler holds the dependencies the readiness checks need.
+type ReadinessHandler struct {
+	database         *db.Database
+	dockerClient     *docker.DockerClient
+	logger           *slog.Logger
+	traefikNetwork   string
+	storageRoots     []ReadinessStorageRoot
+	minFreeDiskBytes uint64
+}
+
+// NewReadinessHandler constructs a ReadinessHandler.
+// minFreeDiskMB is converted to bytes once here so the check itself only compares numbers.
+func NewReadinessHandler(
+	database *db.Database,
+	dockerClient *docker.DockerClient,
+	logger *slog.Logger,
+	traefikNetwork string,
+	storageRoots []ReadinessStorageRoot,
+	minFreeDiskMB int,
+) *ReadinessHandler {
+	if minFreeDiskMB < 0 {
+		minFreeDiskMB = 0
+	}
+	return &ReadinessHandler{
+		database:         database,
+		dockerClient:     dockerClient,
+		logger:           logger,
+		traefikNetwork:   traefikNetwork,
+		storageRoots:     storageRoots,
+		minFreeDiskBytes: uint64(minFreeDiskMB) * 1024 * 1024,
+	}
+}
+
+// readinessCheckResult is the outcome of one check in the /ready response.
+type readinessCheckResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"` // "ok" | "fail"
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// readinessResponse is the JSON body returned by the readiness endpoint.
+// Status is "ready" only when every check passed.
+type readinessResponse struct {
+	Status    string                 `json:"status"` // "ready" | "not_ready"
+	Timestamp string                 `json:"timestamp"`
+	Checks    []readinessCheckResult `json:"checks"`
+}
+
+// readinessCheck is a named check function. the context carries the per-check timeout.
+type readinessCheck struct {
+	name string
+	run  func(checkContext context.Context) error
+}
+
+// Ready handles GET /ready.
+// runs every check concurrently (so the slowest check, not the sum, bounds the response time)
+// and returns 200 when all of them pass, or 503 Service Unavailable when any fails.
+// the body always lists every check with its duration so a failing probe is self explanatory.
+func (handler *ReadinessHandler) Ready(responseWriter http.ResponseWriter, request *http.Request) {
+	checks := handler.readinessChecks()
+	results := make([]readinessCheckResult, len(checks))
+
+	var waitGroup sync.WaitGroup
+	for index, check := range checks {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			// results[index] is only written by this goroutine, so no mutex is needed
+			results[index] = runReadinessCheck(request.Context(), check)
+		}()
+	}
+	waitGroup.Wait()
+
+	response := readinessResponse{
+		Status:    "ready",
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Checks:    results,
+	}
+	statusCode := http.StatusOK
+	for _, result := range results {
+		if result.Status != "ok" {
+			response.Status = "not_ready"
+			statusCode = http.StatusServiceUnavailable
+			handler.logger.Warn("readiness check failed", "check", result.Name, "error", result.Error)
+		}
+	}
+
+	writeJsonAndRespond(responseWriter, statusCode, response)
+}
+
+// readinessChecks returns the list of checks in the order they appear in the response.
+func (handler *ReadinessHandler) readinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{name: "database", run: handler.database.Ping},
+		{name: "docker", run: handler.dockerClient.Ping},
+		{name: "traefik_network", run: func(checkContext context.Context) error {
+			return handler.dockerClient.CheckNetworkExists(checkContext, handler.traefikNetwork)
+		}},
+	}
+	for _, storageRoot := range handler.storageRoots {
+		checks = append(checks, readinessCheck{
+			name: "storage_" + storageRoot.Name,
+			run: func(checkContext context.Context) error {
+				return handler.checkStorageRoot(storageRoot.Path)
+			},
+		})
+	}
+	return checks
+}
+
+// runReadinessCheck runs one check with its own timeout and measures how long it took.
+func runReadinessCheck(parentContext context.Context, check readinessCheck) readinessCheckResult {
+	checkContext, cancelCheckContext := context.WithTimeout(parentContext, readinessCheckTimeout)
+	defer cancelCheckContext()
+
+	startedAt := time.Now()
+	err := check.run(checkContext)
+	result := readinessCheckResult{
+		Name:       check.name,
+		Status:     "ok",
+		DurationMs: time.Since(startedAt).Milliseconds(),
+	}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// checkStorageRoot confirms a storage root is writable and has at least minFreeDiskBytes available.
+// the root is created if missing, same as the pipeline does on first use (os.MkdirAll is a no-op
+// when it already exists), so a fresh host is not reported as not ready before its first deployment.
+// writability is proven by actually creating and removing a temp file, since permission bits alone
+// do not account for read-only mounts or SELinux denials.
+func (handler *ReadinessHandler) checkStorageRoot(storageRootPath string) error {
+	if err := os.MkdirAll(storageRootPath, 0755); err != nil {
+		return fmt.Errorf("failed to create storage root %q: %w", storageRootPath, err)
+	}
+
+	probeFile, err := os.CreateTemp(storageRootPath, ".corvus-ready-*")
+	if err != nil {
+		return fmt.Errorf("storage root %q is not writable: %w", storageRootPath, err)
+	}
+	probeFile.Close()
+	if err := os.Remove(probeFile.Name()); err != nil {
+		return fmt.Errorf("failed to remove readiness probe file in %q: %w", storageRootPath, err)
+	}
+
+	freeBytes, err := util.FreeDiskSpaceBytes(storageRootPath)
+	if err != nil {
+		return err
+	}
+	if freeBytes < handler.minFreeDiskBytes {
+		return fmt.Errorf("storage root %q has %d MB free, below the %d MB threshold",
+			storageRootPath, freeBytes/1024/1024, handler.minFreeDiskBytes/1024/1024)
+	}
+	return nil
+}
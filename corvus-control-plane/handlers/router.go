@@ -8,10 +8,15 @@ package handlers
 import (
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/sasta-kro/corvus-paas/corvus-control-plane/build"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/docker"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/metrics"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/util"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/sasta-kro/corvus-paas/corvus-control-plane/db"
 )
@@ -25,11 +30,43 @@ type RouterDependencies struct {
 	Logger           *slog.Logger
 	Database         *db.Database
 	DeployerPipeline *build.DeployerPipeline
+	DockerClient     *docker.DockerClient
+	Metrics          *metrics.ControlPlaneMetrics
+	Tracer           trace.Tracer
 	CORSOrigin       string
 
+	// used only by GET /ready
+	TraefikNetwork         string
+	ReadinessStorageRoots  []ReadinessStorageRoot
+	ReadinessMinFreeDiskMB int
+
 	FriendCode         string
 	DefaultTTLMinutes  int
 	ExtendedTTLMinutes int
+
+	// caps of POST /api/deployments/:uuid/extend, see config.AppConfig
+	MaxLifetimeMinutes         int
+	ExtendedMaxLifetimeMinutes int
+
+	// AdminToken authenticates the admin-only endpoints ("" disables them)
+	AdminToken string
+
+	// MaxUploadBytes caps the request body of POST /api/deployments (0 = no cap)
+	MaxUploadBytes int64
+	// UploadTimeout is the read/write deadline of POST /api/deployments (0 = server timeouts)
+	UploadTimeout time.Duration
+
+	// CredentialCipher encrypts private repository credentials (nil = private repos disabled)
+	CredentialCipher *util.CredentialCipher
+
+	// PresetRegistry is the set of quick-deploy presets (GET /api/presets, preset_id validation)
+	PresetRegistry *build.PresetRegistry
+
+	// SlugPolicy validates user chosen slugs on POST /api/deployments
+	SlugPolicy *util.SlugPolicy
+
+	// IPAccessPolicy validates IP access lists against the platform defaults
+	IPAccessPolicy *util.IPAccessPolicy
 }
 
 // CreateAndSetupRouter constructs the chi multiplexer, attaches middleware, constructs
@@ -50,9 +87,14 @@ func CreateAndSetupRouter(dependencies RouterDependencies) http.Handler {
 	// and logging. They allow applying global rules without repeating code in every handler.
 	// middleware.Logger logs the method, path, status code, and latency of every request.
 	router.Use(middleware.Logger) // TODO replace with a custom slog middleware
+	// one span per request, outermost of the observability middleware so the span covers everything below it
+	router.Use(TracingMiddleware(dependencies.Tracer))
+	// records per-route latency for /metrics. registered before Recoverer (so it wraps it)
+	// so a recovered panic is still observed, with the 500 status Recoverer writes.
+	router.Use(MetricsMiddleware(dependencies.Metrics))
 	// middleware.Recoverer catches panics in handlers and returns a 500 instead of crashing the process.
 	router.Use(middleware.Recoverer)
-	// both are standard inclusions for any production HTTP service.
+	// Logger and Recoverer are standard inclusions for any production HTTP service.
 
 	// --- handler init/construction ---
 	// each handler receives only the dependencies it actually needs.
@@ -61,6 +103,16 @@ func CreateAndSetupRouter(dependencies RouterDependencies) http.Handler {
 	// /health needs only the logger
 	healthHandler := NewHealthHandler(dependencies.Logger)
 
+	// /ready needs everything a deployment depends on, to check it is reachable
+	readinessHandler := NewReadinessHandler(
+		dependencies.Database,
+		dependencies.DockerClient,
+		dependencies.Logger,
+		dependencies.TraefikNetwork,
+		dependencies.ReadinessStorageRoots,
+		dependencies.ReadinessMinFreeDiskMB,
+	)
+
 	// deployment handlers will need the database and logger
 	deploymentHandler := NewDeploymentHandler(
 		dependencies.Database,
@@ -70,6 +122,25 @@ func CreateAndSetupRouter(dependencies RouterDependencies) http.Handler {
 		dependencies.FriendCode,
 		dependencies.DefaultTTLMinutes,
 		dependencies.ExtendedTTLMinutes,
+		dependencies.MaxLifetimeMinutes,
+		dependencies.ExtendedMaxLifetimeMinutes,
+		dependencies.AdminToken,
+		dependencies.MaxUploadBytes,
+		dependencies.UploadTimeout,
+		dependencies.CredentialCipher,
+		dependencies.PresetRegistry,
+		dependencies.SlugPolicy,
+		dependencies.IPAccessPolicy,
+	)
+
+	// the preset listing only reads the registry loaded at startup
+	presetHandler := NewPresetHandler(dependencies.PresetRegistry, dependencies.Logger)
+
+	// webhook deliveries from GitHub (pull request previews), authenticated by their HMAC signature
+	webhookHandler := NewWebhookHandler(
+		dependencies.Database,
+		dependencies.DeployerPipeline,
+		dependencies.Logger,
 	)
 
 	// --- route registration ---
@@ -80,6 +151,11 @@ func CreateAndSetupRouter(dependencies RouterDependencies) http.Handler {
 	// expect health checks at standard root paths (`/health` and not `/api/health`) and do not have context
 	// about the application's internal route grouping and API structure
 	router.Get("/health", healthHandler.Health)
+	// /health = the process is alive, /ready = the process can actually deploy (db, docker, network, disk)
+	router.Get("/ready", readinessHandler.Ready)
+
+	// Prometheus scrape endpoint, kept at the root level for the same reason as /health
+	router.Handle("/metrics", dependencies.Metrics.Handler())
 
 	// This is api route group (basically having an `/api/` prefix (/api/health) for all API routes
 	// non-API routes like /health are kept outside this group intentionally.
@@ -94,6 +170,28 @@ func CreateAndSetupRouter(dependencies RouterDependencies) http.Handler {
 
 		apiRouter.Post("/deployments/{uuid}/redeploy", deploymentHandler.RedeployDeployment)
 
+		apiRouter.Get("/deployments/{uuid}/events", deploymentHandler.ListDeploymentEvents)
+
+		apiRouter.Get("/deployments/{uuid}/logs", deploymentHandler.GetDeploymentLogs)
+
+		// set/rotate or remove the password (HTTP basic auth) of a deployment, without redeploying its files
+		apiRouter.Put("/deployments/{uuid}/password", deploymentHandler.SetBasicAuth)
+		apiRouter.Delete("/deployments/{uuid}/password", deploymentHandler.RemoveBasicAuth)
+		// replace the IP allow and deny lists of a deployment, without redeploying its files
+		apiRouter.Put("/deployments/{uuid}/ip-access", deploymentHandler.UpdateIPAccess)
+
+		// push back the expiry of a deployment (capped by its maximum lifetime)
+		apiRouter.Post("/deployments/{uuid}/extend", deploymentHandler.ExtendDeployment)
+		// admin-only: make a deployment never expire, or give it a TTL again
+		apiRouter.Post("/deployments/{uuid}/pin", deploymentHandler.PinDeployment)
+		apiRouter.Delete("/deployments/{uuid}/pin", deploymentHandler.UnpinDeployment)
+
+		// the {uuid} is the parent deployment, its webhook_secret signs the deliveries
+		apiRouter.Post("/webhooks/github/{uuid}", webhookHandler.HandleGitHubWebhook)
+
+		apiRouter.Get("/presets", presetHandler.ListPresets)
+		apiRouter.Get("/presets/{presetID}/thumbnail", presetHandler.GetPresetThumbnail)
+
 		apiRouter.Get("/validate-code", ValidateFriendCode(dependencies.FriendCode, dependencies.Logger))
 
 		// placeholder to confirm the route group compiles correctly
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingMiddleware starts one span per request. an incoming W3C `traceparent` header
+// (eg, from the frontend or a proxy) is honoured, so the request span joins the caller's trace.
+// the span is stored in the request context, which is how the pipeline goroutines
+// link their run trace back to the request that triggered them.
+//
+// like MetricsMiddleware, the span is named after the chi route pattern
+// ("POST /api/deployments/{uuid}/redeploy"), which is only known after routing,
+// so the name is set after next.ServeHTTP returns.
+func TracingMiddleware(tracer trace.Tracer) func(http.Handler) http.Handler {
+	tracer = tracing.TracerOrNoop(tracer)
+	propagator := propagation.TraceContext{}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+			parentContext := propagator.Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+			requestContext, requestSpan := tracer.Start(parentContext, request.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", request.Method),
+					attribute.String("url.path", request.URL.Path),
+				),
+			)
+			defer requestSpan.End()
+
+			wrappedWriter := middleware.NewWrapResponseWriter(responseWriter, request.ProtoMajor)
+			next.ServeHTTP(wrappedWriter, request.WithContext(requestContext))
+
+			route := "unmatched"
+			if routeContext := chi.RouteContext(request.Context()); routeContext != nil {
+				if pattern := routeContext.RoutePattern(); pattern != "" {
+					route = pattern
+				}
+			}
+
+			statusCode := wrappedWriter.Status()
+			if statusCode == 0 {
+				statusCode = http.StatusOK // handler wrote nothing, net/http sends 200
+			}
+
+			requestSpan.SetName(request.Method + " " + route)
+			requestSpan.SetAttributes(
+				attribute.String("http.route", route),
+				attribute.Int("http.response.status_code", statusCode),
+			)
+			// only 5xx marks the span as failed, a 4xx is the client's problem, not the server's
+			if statusCode >= http.StatusInternalServerError {
+				requestSpan.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", statusCode))
+			}
+		})
+	}
+}
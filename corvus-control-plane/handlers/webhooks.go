@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/build"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/db"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/models"
+)
+
+// maxWebhookPayloadBytes caps a webhook body. GitHub caps its payloads at 25MB,
+// a pull_request payload is a few tens of KB, so anything near this limit is not a real event.
+const maxWebhookPayloadBytes = 5 << 20 // 5MB
+
+// GitHub webhook headers, see https://docs.github.com/en/webhooks/webhook-events-and-payloads
+const (
+	githubEventHeader     = "X-GitHub-Event"
+	githubSignatureHeader = "X-Hub-Signature-256"
+)
+
+// WebhookHandler receives GitHub webhook deliveries for a deployment.
+// the only event acted on is pull_request, which drives preview deployments:
+//   - opened / reopened / synchronize: create or refresh the pull request's preview deployment,
+//     a child of the webhook's deployment built from the pull request head commit
+//   - closed (merged or not): tear the preview down
+type WebhookHandler struct {
+	database         *db.Database
+	deployerPipeline *build.DeployerPipeline
+	logger           *slog.Logger
+
+	// previewsBuilding holds the IDs of the previews with a pipeline running (see runPreviewPipeline).
+	// two runs of one preview would share its build directory and container names and break each other.
+	previewsBuilding      map[string]bool
+	previewsBuildingMutex sync.Mutex
+}
+
+// NewWebhookHandler constructs a WebhookHandler with its dependencies.
+func NewWebhookHandler(database *db.Database, deployerPipeline *build.DeployerPipeline, logger *slog.Logger) *WebhookHandler {
+	return &WebhookHandler{
+		database:         database,
+		deployerPipeline: deployerPipeline,
+		logger:           logger,
+		previewsBuilding: make(map[string]bool),
+	}
+}
+
+// githubPullRequestEvent is the subset of the pull_request webhook payload the previews need.
+type githubPullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		HTMLURL string `json:"html_url"`
+		Head    struct {
+			SHA  string `json:"sha"`
+			Ref  string `json:"ref"`
+			Repo struct {
+				FullName string `json:"full_name"`
+			} `json:"repo"`
+		} `json:"head"`
+		Base struct {
+			Repo struct {
+				FullName string `json:"full_name"`
+			} `json:"repo"`
+		} `json:"base"`
+	} `json:"pull_request"`
+}
+
+// isFromFork reports whether the pull request comes from another repository.
+// a fork's head repo can also be gone (deleted fork), which counts as a fork too.
+func (event *githubPullRequestEvent) isFromFork() bool {
+	return event.PullRequest.Head.Repo.FullName != event.PullRequest.Base.Repo.FullName
+}
+
+// HandleGitHubWebhook handles POST /api/webhooks/github/:uuid.
+// the webhook is configured on the repository with the deployment's webhook_secret as the secret
+// and "application/json" as the content type. every delivery is authenticated by its
+// X-Hub-Signature-256 header (HMAC-SHA256 of the raw body), there is no other auth on this route.
+//
+// returns 202 when a preview pipeline was started, 200 for events that needed no pipeline
+// (ping, a closed pull request, actions that do not change the code).
+func (handler *WebhookHandler) HandleGitHubWebhook(responseWriter http.ResponseWriter, request *http.Request) {
+	deploymentID := chi.URLParam(request, "uuid")
+
+	// the raw body is needed as-is for the signature, so it is read completely before decoding
+	payload, err := io.ReadAll(http.MaxBytesReader(responseWriter, request.Body, maxWebhookPayloadBytes))
+	if err != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusRequestEntityTooLarge, "webhook payload too large", handler.logger)
+		return
+	}
+
+	parentDeployment, err := handler.database.GetDeployment(deploymentID)
+	if errors.Is(err, db.ErrRecordNotFound) {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusNotFound, "deployment not found", handler.logger)
+		return
+	}
+	if err != nil {
+		handler.logger.Error("failed to get deployment for webhook", "id", deploymentID, "error", err)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to retrieve deployment", handler.logger)
+		return
+	}
+
+	// verified before anything else about the request is trusted (including the event header)
+	if parentDeployment.WebhookSecret == nil || !isValidGitHubSignature(payload, request.Header.Get(githubSignatureHeader), *parentDeployment.WebhookSecret) {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusUnauthorized, "invalid webhook signature", handler.logger)
+		return
+	}
+
+	eventName := request.Header.Get(githubEventHeader)
+	switch eventName {
+	case "ping":
+		// sent once when the webhook is created in the GitHub UI
+		writeJsonAndRespond(responseWriter, http.StatusOK, map[string]string{"status": "pong"})
+		return
+	case "pull_request":
+		// handled below
+	default:
+		writeJsonAndRespond(responseWriter, http.StatusOK, map[string]string{"status": "ignored", "event": eventName})
+		return
+	}
+
+	if parentDeployment.SourceType != models.SourceGitHub && parentDeployment.SourceType != models.SourceGit {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "pull request previews need a github or git deployment", handler.logger)
+		return
+	}
+	if parentDeployment.ParentDeploymentID != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "preview deployments cannot have previews of their own", handler.logger)
+		return
+	}
+
+	var pullRequestEvent githubPullRequestEvent
+	if err := json.Unmarshal(payload, &pullRequestEvent); err != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "invalid pull_request payload (is the webhook content type application/json?)", handler.logger)
+		return
+	}
+	if pullRequestEvent.Number <= 0 {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "pull_request payload has no pull request number", handler.logger)
+		return
+	}
+
+	handler.logger.Info("pull request webhook received",
+		"parent_id", parentDeployment.ID,
+		"pull_request", pullRequestEvent.Number,
+		"action", pullRequestEvent.Action,
+	)
+
+	switch pullRequestEvent.Action {
+	case "opened", "reopened", "synchronize":
+		handler.deployPullRequestPreview(responseWriter, request, parentDeployment, &pullRequestEvent)
+	case "closed":
+		handler.teardownPullRequestPreview(responseWriter, request, parentDeployment, pullRequestEvent.Number)
+	default:
+		// edited, labeled, assigned, review_requested, ... do not change the code
+		writeJsonAndRespond(responseWriter, http.StatusOK, map[string]string{"status": "ignored", "action": pullRequestEvent.Action})
+	}
+}
+
+// deployPullRequestPreview creates the preview deployment of a pull request, or points the existing one
+// at the new head commit, and starts the git pipeline for it.
+func (handler *WebhookHandler) deployPullRequestPreview(
+	responseWriter http.ResponseWriter,
+	request *http.Request,
+	parentDeployment *models.Deployment,
+	pullRequestEvent *githubPullRequestEvent,
+) {
+	// the head SHA (not the head branch) is deployed: it exists in the base repository for fork
+	// pull requests too (GitHub keeps it under refs/pull/<n>/head), and it is exactly the commit
+	// the event is about, even when more pushes arrive while this one is building.
+	headSHA := pullRequestEvent.PullRequest.Head.SHA
+	if errInvalidRef := build.ValidateGitRef(headSHA); errInvalidRef != nil {
+		writeErrorJsonAndLogIt(responseWriter, http.StatusBadRequest, "invalid pull request head sha: "+errInvalidRef.Error(), handler.logger)
+		return
+	}
+
+	// the repository credential is never handed to fork pull requests: their code decides where
+	// git connects to (.gitmodules, .lfsconfig). a private repository cannot be cloned without it,
+	// so fork pull requests of a repository with a credential get no preview at all.
+	if pullRequestEvent.isFromFork() && parentDeployment.EncryptedGitCredential != nil {
+		handler.logger.Info("pull request is from a fork of a private repository, no preview is deployed",
+			"parent_id", parentDeployment.ID,
+			"pull_request", pullRequestEvent.Number,
+		)
+		writeJsonAndRespond(responseWriter, http.StatusOK, map[string]string{
+			"status": "ignored",
+			"reason": "pull requests from forks do not get previews of a repository with credentials",
+		})
+		return
+	}
+
+	previewDeployment, err := handler.database.GetPreviewDeployment(parentDeployment.ID, pullRequestEvent.Number)
+	switch {
+	case errors.Is(err, db.ErrRecordNotFound):
+		previewDeployment = newPreviewDeployment(parentDeployment, pullRequestEvent)
+		if pullRequestEvent.isFromFork() && parentDeployment.SecretEnvironmentVariables != nil {
+			// fork pull requests run code nobody on the team wrote yet, so the build gets the
+			// same treatment as GitHub Actions gives forks: no secrets.
+			previewDeployment.SecretEnvironmentVariables = nil
+			handler.logger.Info("pull request is from a fork, secret environment variables are not passed to the preview build",
+				"parent_id", parentDeployment.ID,
+				"pull_request", pullRequestEvent.Number,
+			)
+		}
+		if err := handler.database.InsertDeployment(previewDeployment); err != nil {
+			handler.logger.Error("failed to insert preview deployment", "parent_id", parentDeployment.ID, "error", err)
+			writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to create preview deployment", handler.logger)
+			return
+		}
+		handler.logger.Info("preview deployment created",
+			"id", previewDeployment.ID,
+			"slug", previewDeployment.Slug,
+			"pull_request", pullRequestEvent.Number,
+		)
+
+	case err != nil:
+		handler.logger.Error("failed to get preview deployment", "parent_id", parentDeployment.ID, "error", err)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to retrieve preview deployment", handler.logger)
+		return
+
+	default:
+		// new commits on the pull request, the preview follows the head
+		if err := handler.database.UpdateRef(previewDeployment.ID, &headSHA); err != nil {
+			handler.logger.Error("failed to update preview deployment ref", "id", previewDeployment.ID, "error", err)
+			writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to update preview deployment", handler.logger)
+			return
+		}
+		previewDeployment.Ref = &headSHA
+	}
+
+	// quick pushes to a pull request arrive while the previous commit is still building.
+	// the new head is already saved as the preview's ref, the running pipeline builds it when it is done.
+	handler.previewsBuildingMutex.Lock()
+	alreadyBuilding := handler.previewsBuilding[previewDeployment.ID]
+	handler.previewsBuilding[previewDeployment.ID] = true
+	handler.previewsBuildingMutex.Unlock()
+
+	if alreadyBuilding {
+		handler.logger.Info("preview is still building, the new head is deployed after it",
+			"id", previewDeployment.ID,
+			"pull_request", pullRequestEvent.Number,
+			"head_sha", headSHA,
+		)
+	} else {
+		go handler.runPreviewPipeline(request.Context(), previewDeployment)
+	}
+
+	writeJsonAndRespond(responseWriter, http.StatusAccepted, previewDeployment)
+}
+
+// runPreviewPipeline deploys a preview, then again for as long as its ref moved on during the run
+// (pushes that arrived while it was building), so only one pipeline of a preview ever runs at a time
+// and the last push always ends up live. the pushes in between are skipped, not queued one by one.
+// the ref is re-read under previewsBuildingMutex: a webhook saves the ref before taking the lock,
+// so it either sees this run in progress (and the re-read sees its ref) or starts a new run itself.
+func (handler *WebhookHandler) runPreviewPipeline(requestContext context.Context, previewDeployment *models.Deployment) {
+	for {
+		handler.deployerPipeline.DeployGitRepository(requestContext, previewDeployment)
+
+		handler.previewsBuildingMutex.Lock()
+		latestPreview, err := handler.database.GetDeployment(previewDeployment.ID)
+		if err != nil || latestPreview.Ref == nil || *latestPreview.Ref == *previewDeployment.Ref {
+			// up to date, or the preview was removed (pull request closed) while it was building
+			delete(handler.previewsBuilding, previewDeployment.ID)
+			handler.previewsBuildingMutex.Unlock()
+			return
+		}
+		handler.previewsBuildingMutex.Unlock()
+
+		handler.logger.Info("preview head moved on while it was building, deploying the new head",
+			"id", latestPreview.ID,
+			"head_sha", *latestPreview.Ref,
+		)
+		previewDeployment = latestPreview
+	}
+}
+
+// teardownPullRequestPreview removes the preview deployment of a closed pull request.
+// a pull request without a preview (opened before the webhook existed) is not an error.
+func (handler *WebhookHandler) teardownPullRequestPreview(
+	responseWriter http.ResponseWriter,
+	request *http.Request,
+	parentDeployment *models.Deployment,
+	pullRequestNumber int,
+) {
+	previewDeployment, err := handler.database.GetPreviewDeployment(parentDeployment.ID, pullRequestNumber)
+	if errors.Is(err, db.ErrRecordNotFound) {
+		writeJsonAndRespond(responseWriter, http.StatusOK, map[string]string{"status": "no preview"})
+		return
+	}
+	if err != nil {
+		handler.logger.Error("failed to get preview deployment", "parent_id", parentDeployment.ID, "error", err)
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to retrieve preview deployment", handler.logger)
+		return
+	}
+
+	if err := handler.deployerPipeline.TeardownDeployment(request.Context(), previewDeployment); err != nil {
+		handler.logger.Error("teardown of preview deployment failed",
+			"id", previewDeployment.ID,
+			"slug", previewDeployment.Slug,
+			"error", err,
+		)
+		// 500 makes GitHub show the delivery as failed, it can be redelivered from the webhook settings
+		writeErrorJsonAndLogIt(responseWriter, http.StatusInternalServerError, "failed to remove preview deployment", handler.logger)
+		return
+	}
+
+	handler.logger.Info("preview deployment removed",
+		"id", previewDeployment.ID,
+		"slug", previewDeployment.Slug,
+		"pull_request", pullRequestNumber,
+	)
+	writeJsonAndRespond(responseWriter, http.StatusOK, map[string]string{"status": "removed", "slug": previewDeployment.Slug})
+}
+
+// newPreviewDeployment builds the preview deployment of a pull request from its parent.
+// everything that decides how the site is built is copied (repository, credential, build command,
+// monorepo directories, env vars), the ref is pinned to the pull request head.
+// so are the parent's password and IP access lists, a preview of unreleased work is exactly what they protect.
+// the preview expires with its parent, it has no webhook secret of its own.
+func newPreviewDeployment(parentDeployment *models.Deployment, pullRequestEvent *githubPullRequestEvent) *models.Deployment {
+	// "<parent slug>-pr-<n>" is stable for the lifetime of the pull request,
+	// so reviewers keep the same URL across pushes
+	previewSlug := fmt.Sprintf("%s-pr-%d", parentDeployment.Slug, pullRequestEvent.Number)
+	previewURL := deploymentURLForSlug(previewSlug)
+	headSHA := pullRequestEvent.PullRequest.Head.SHA
+	pullRequestNumber := pullRequestEvent.Number
+
+	previewName := fmt.Sprintf("%s (PR #%d)", parentDeployment.Name, pullRequestNumber)
+	if headRef := strings.TrimSpace(pullRequestEvent.PullRequest.Head.Ref); headRef != "" {
+		previewName = fmt.Sprintf("%s (PR #%d, %s)", parentDeployment.Name, pullRequestNumber, headRef)
+	}
+
+	return &models.Deployment{
+		ID:                          uuid.New().String(),
+		Slug:                        previewSlug,
+		Name:                        previewName,
+		SourceType:                  parentDeployment.SourceType,
+		GitHubURL:                   parentDeployment.GitHubURL,
+		GitURL:                      parentDeployment.GitURL,
+		GitCredentialType:           parentDeployment.GitCredentialType,
+		EncryptedGitCredential:      parentDeployment.EncryptedGitCredential,
+		Branch:                      parentDeployment.Branch,
+		Ref:                         &headSHA,
+		BuildCommand:                parentDeployment.BuildCommand,
+		RootDirectory:               parentDeployment.RootDirectory,
+		SharedDirectories:           parentDeployment.SharedDirectories,
+		GitSubmodules:               parentDeployment.GitSubmodules,
+		GitLFS:                      parentDeployment.GitLFS,
+		OutputDirectory:             parentDeployment.OutputDirectory,
+		SPAFallback:                 parentDeployment.SPAFallback,
+		Custom404:                   parentDeployment.Custom404,
+		CleanURLs:                   parentDeployment.CleanURLs,
+		TrailingSlash:               parentDeployment.TrailingSlash,
+		BasicAuthUsername:           parentDeployment.BasicAuthUsername,
+		BasicAuthPasswordHash:       parentDeployment.BasicAuthPasswordHash,
+		IPAllowList:                 parentDeployment.IPAllowList,
+		IPDenyList:                  parentDeployment.IPDenyList,
+		EnvironmentVariables:        parentDeployment.EnvironmentVariables,
+		RuntimeEnvironmentVariables: parentDeployment.RuntimeEnvironmentVariables,
+		SecretEnvironmentVariables:  parentDeployment.SecretEnvironmentVariables,
+		Status:                      models.StatusDeploying,
+		URL:                         &previewURL,
+		ParentDeploymentID:          &parentDeployment.ID,
+		PullRequestNumber:           &pullRequestNumber,
+		ExpiresAt:                   parentDeployment.ExpiresAt,
+	}
+}
+
+// isValidGitHubSignature checks the X-Hub-Signature-256 header ("sha256=<hex HMAC-SHA256 of the body>").
+// hmac.Equal compares in constant time, so the comparison does not leak how many bytes matched.
+func isValidGitHubSignature(payload []byte, signatureHeader string, webhookSecret string) bool {
+	hexSignature, found := strings.CutPrefix(signatureHeader, "sha256=")
+	if !found {
+		return false
+	}
+	receivedSignature, err := hex.DecodeString(hexSignature)
+	if err != nil {
+		return false
+	}
+
+	signer := hmac.New(sha256.New, []byte(webhookSecret))
+	signer.Write(payload)
+	return hmac.Equal(receivedSignature, signer.Sum(nil))
+}
@@ -12,6 +12,9 @@ import (
 	"github.com/sasta-kro/corvus-paas/corvus-control-plane/build"
 	"github.com/sasta-kro/corvus-paas/corvus-control-plane/docker"
 	"github.com/sasta-kro/corvus-paas/corvus-control-plane/handlers"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/metrics"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/tracing"
+	"github.com/sasta-kro/corvus-paas/corvus-control-plane/util"
 
 	"github.com/sasta-kro/corvus-paas/corvus-control-plane/config"
 	"github.com/sasta-kro/corvus-paas/corvus-control-plane/db"
@@ -57,27 +60,121 @@ func main() {
 	}
 	defer database.CloseDatabase() // close db conn when main() exists
 
+	// metrics registry for /metrics (Prometheus text format).
+	// the deployment count gauge is computed from the database at scrape time.
+	controlPlaneMetrics := metrics.NewControlPlaneMetrics()
+	controlPlaneMetrics.SetDeploymentCountCollector(func() ([]metrics.GaugeSample, error) {
+		deploymentCounts, err := database.CountDeploymentsByStatusAndSource()
+		if err != nil {
+			return nil, err
+		}
+		samples := make([]metrics.GaugeSample, 0, len(deploymentCounts))
+		for _, deploymentCount := range deploymentCounts {
+			samples = append(samples, metrics.GaugeSample{
+				LabelValues: []string{string(deploymentCount.Status), string(deploymentCount.SourceType)},
+				Value:       float64(deploymentCount.Count),
+			})
+		}
+		return samples, nil
+	})
+
+	// OpenTelemetry tracing. the exporter is "none" by default, which hands out a no-op tracer,
+	// so the spans in the handlers, pipeline and docker client cost nothing when tracing is off.
+	tracerProvider, shutdownTracing, err := tracing.NewTracerProvider(context.Background(), tracing.Config{
+		Exporter:     appConfig.TracingExporter,
+		ServiceName:  "corvus-control-plane",
+		OTLPEndpoint: appConfig.TracingOTLPEndpoint,
+		FilePath:     appConfig.TracingFilePath,
+	})
+	if err != nil {
+		log.Fatalf("failed to set up tracing: %v", err)
+	}
+	defer func() {
+		// flush the spans still sitting in the batcher, bounded so a dead collector cannot hang the exit
+		shutdownContext, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelShutdown()
+		if err := shutdownTracing(shutdownContext); err != nil {
+			logger.Error("failed to flush traces on shutdown", "error", err)
+		}
+	}()
+	tracer := tracerProvider.Tracer(tracing.TracerName)
+	logger.Info("tracing configured", "exporter", appConfig.TracingExporter)
+
 	// Docker client setup
-	dockerClient, err := docker.NewClient(logger)
+	dockerClient, err := docker.NewClient(logger, controlPlaneMetrics, tracer)
 	if err != nil {
 		log.Fatalf("failed to connect to docker daemon: %v", err)
 	}
 	defer dockerClient.Close()
 
+	// private repository credentials cipher, nil (disabled) when CREDENTIALS_ENCRYPTION_KEY is empty
+	credentialCipher, err := util.NewCredentialCipher(appConfig.CredentialsEncryptionKey)
+	if err != nil {
+		log.Fatalf("invalid CREDENTIALS_ENCRYPTION_KEY: %v", err)
+	}
+	if credentialCipher == nil {
+		logger.Info("CREDENTIALS_ENCRYPTION_KEY not set, private repository deployments are disabled")
+	}
+
+	// quick-deploy presets, every subdirectory of PRESET_STORAGE_ROOT with a preset.json manifest
+	presetRegistry, err := build.LoadPresetRegistry(appConfig.PresetStorageRoot, logger)
+	if err != nil {
+		log.Fatalf("failed to load presets: %v", err)
+	}
+
+	// platform default IP access lists, combined with each deployment's own lists.
+	// an invalid default would leave every deployment without its own lists unprotected (or unreachable).
+	ipAccessPolicy, err := util.NewIPAccessPolicy(appConfig.DefaultIPAllowList, appConfig.DefaultIPDenyList, appConfig.IPAccessForwardedDepth)
+	if err != nil {
+		log.Fatalf("invalid IP access configuration: %v", err)
+	}
+
+	// serving mode. in the shared mode one in-process static file server serves every deployment
+	// by its Host header (see build/shared_static_server.go), instead of one nginx container per deployment.
+	// nil in the default container mode.
+	var sharedStaticServer *build.SharedStaticServer
+	switch appConfig.ServingMode {
+	case config.ServingModeContainer:
+	case config.ServingModeShared:
+		sharedStaticServer = build.NewSharedStaticServer(appConfig.SharedServerHostSuffix, appConfig.IPAccessForwardedDepth)
+	default:
+		log.Fatalf("invalid SERVING_MODE %q, use %q or %q", appConfig.ServingMode, config.ServingModeContainer, config.ServingModeShared)
+	}
+	logger.Info("serving mode configured", "serving_mode", appConfig.ServingMode)
+
 	// pipeline
 	deployerPipeline := build.NewDeployerPipeline(
 		database,
 		dockerClient,
 		logger,
+		controlPlaneMetrics,
+		tracer,
 		build.DeployerPipelineConfig{
 			AssetStorageRoot:     appConfig.AssetStorageRoot,
 			LogRoot:              appConfig.LogRoot,
-			PresetStorageRoot:    appConfig.PresetStorageRoot,
+			PresetRegistry:       presetRegistry,
 			TempBuildStorageRoot: appConfig.TempBuildStorageRoot,
 			TraefikNetwork:       appConfig.TraefikNetwork,
+			ArchiveLimits: build.ArchiveLimits{
+				MaxTotalUncompressedBytes: int64(appConfig.MaxArchiveUncompressedMB) << 20,
+				MaxEntryCount:             int64(appConfig.MaxArchiveEntries),
+				MaxFileBytes:              int64(appConfig.MaxArchiveFileSizeMB) << 20,
+				MaxCompressionRatio:       int64(appConfig.MaxArchiveCompressionRatio),
+			},
+			CredentialCipher:   credentialCipher,
+			SharedStaticServer: sharedStaticServer,
+			IPAccessPolicy:     ipAccessPolicy,
 		},
 	)
 
+	// the shared static server keeps its sites in memory, every live deployment is published again
+	// before it starts listening
+	if sharedStaticServer != nil {
+		if err := deployerPipeline.RestoreSharedStaticSites(); err != nil {
+			log.Fatalf("failed to restore sites on the shared static server: %v", err)
+		}
+	}
+
 	// Expired container cleanup loop (runs in background goroutine)
 	// uses a separate context that is canceled during graceful shutdown.
 	expirationContext, cancelExpiration := context.WithCancel(context.Background())
@@ -90,12 +187,35 @@ func main() {
 		Logger:           logger,
 		Database:         database,
 		DeployerPipeline: deployerPipeline,
+		DockerClient:     dockerClient,
+		Metrics:          controlPlaneMetrics,
+		Tracer:           tracer,
 		CORSOrigin:       appConfig.CORSOrigin,
 
+		TraefikNetwork: appConfig.TraefikNetwork,
+		ReadinessStorageRoots: []handlers.ReadinessStorageRoot{
+			{Name: "asset", Path: appConfig.AssetStorageRoot},
+			{Name: "log", Path: appConfig.LogRoot},
+			{Name: "temp_build", Path: appConfig.TempBuildStorageRoot},
+		},
+		ReadinessMinFreeDiskMB: appConfig.ReadinessMinFreeDiskMB,
+
 		// TODO there should be a better way than just to pass down 3 raw TTL related variables idk
 		FriendCode:         appConfig.FriendCode,
 		DefaultTTLMinutes:  appConfig.DefaultTTLMinutes,
 		ExtendedTTLMinutes: appConfig.ExtendedTTLMinutes,
+
+		MaxLifetimeMinutes:         appConfig.MaxLifetimeMinutes,
+		ExtendedMaxLifetimeMinutes: appConfig.ExtendedMaxLifetimeMinutes,
+		AdminToken:                 appConfig.AdminToken,
+
+		MaxUploadBytes: int64(appConfig.MaxUploadSizeMB) << 20,
+		UploadTimeout:  time.Duration(appConfig.UploadTimeoutSeconds) * time.Second,
+
+		CredentialCipher: credentialCipher,
+		PresetRegistry:   presetRegistry,
+		SlugPolicy:       util.NewSlugPolicy(appConfig.SlugDenylist),
+		IPAccessPolicy:   ipAccessPolicy,
 	})
 
 	// --- HTTP server construction ---
@@ -113,12 +233,31 @@ func main() {
 	// the response to a slow client (1 byte per hour download speed)
 	// IdleTimeout limits how long an inactive keep-alive TCP connection remains open before the server reclaims
 	// the underlying file descriptor (drops connection)
+	//
+	// POST /api/deployments (archive uploads) is the one exception to the 15s read/write deadlines,
+	// it extends its own deadline to UPLOAD_TIMEOUT_SECONDS (see CreateDeployment).
+	// ReadHeaderTimeout keeps the Slowloris protection for the headers of that request too.
 	server := &http.Server{
-		Addr:         ":" + appConfig.Port,
-		Handler:      router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              ":" + appConfig.Port,
+		Handler:           router,
+		ReadHeaderTimeout: 15 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	// the shared static server gets its own http.Server on its own port (shared serving mode only).
+	// a longer WriteTimeout than the API, sending a large asset to a slow client legitimately takes longer than 15s.
+	var sharedServer *http.Server
+	if sharedStaticServer != nil {
+		sharedServer = &http.Server{
+			Addr:              ":" + appConfig.SharedServerPort,
+			Handler:           sharedStaticServer,
+			ReadHeaderTimeout: 15 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      10 * time.Minute,
+			IdleTimeout:       60 * time.Second,
+		}
 	}
 
 	// --- graceful shutdown ---
@@ -154,6 +293,22 @@ func main() {
 		close(shutdownChannel)
 	}()
 
+	// same for the shared static server, with its own channel. a nil channel is never ready,
+	// so its case in the select below simply never fires in the container mode.
+	var sharedServerShutdownChannel chan error
+	if sharedServer != nil {
+		sharedServerShutdownChannel = make(chan error, 1)
+		go func() {
+			logger.Info("shared static server listening", "addr", sharedServer.Addr)
+
+			err := sharedServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				sharedServerShutdownChannel <- err
+			}
+			close(sharedServerShutdownChannel)
+		}()
+	}
+
 	// block until an OS interrupt or termination signal is received
 	signalChannel := make(chan os.Signal, 1)
 
@@ -181,6 +336,10 @@ func main() {
 		if err != nil {
 			log.Fatalf("http server failed: %v", err)
 		}
+	case err := <-sharedServerShutdownChannel:
+		if err != nil {
+			log.Fatalf("shared static server failed: %v", err)
+		}
 	}
 	// This select block effectively puts the main goroutine to sleep,
 	//waiting for either a termination signal from the OS (like Ctrl+C) or an unexpected server error.
@@ -214,6 +373,12 @@ func main() {
 		logger.Info("server shut down cleanly")
 	}
 
+	if sharedServer != nil {
+		if err := sharedServer.Shutdown(shutdownContext); err != nil {
+			logger.Error("graceful shutdown of the shared static server failed", "error", err)
+		}
+	}
+
 }
 
 // Channel Datatype and Memory Management:
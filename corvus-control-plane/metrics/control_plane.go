@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ControlPlaneMetrics holds every metric the control plane exposes on /metrics.
+// constructed once in main.go and passed via dependency injection to the pipeline,
+// the docker client and the router (same as the logger and database).
+//
+// every method is safe to call on a nil *ControlPlaneMetrics and then does nothing,
+// so packages can be used without metrics wired in.
+type ControlPlaneMetrics struct {
+	registry *Registry
+
+	deployments             *GaugeFunc
+	pipelineRuns            *CounterVec
+	pipelineStepFailures    *CounterVec
+	pipelineStepDuration    *HistogramVec
+	imagePullDuration       *HistogramVec
+	expirationCleanups      *CounterVec
+	expirationCleanupErrors *CounterVec
+	httpRequestDuration     *HistogramVec
+}
+
+// NewControlPlaneMetrics constructs and registers all control plane metrics.
+func NewControlPlaneMetrics() *ControlPlaneMetrics {
+	registry := NewRegistry()
+	return &ControlPlaneMetrics{
+		registry: registry,
+
+		deployments: registry.NewGaugeFunc(
+			"corvus_deployments",
+			"Number of deployments by status and source type.",
+			nil,
+			"status", "source_type",
+		),
+		pipelineRuns: registry.NewCounterVec(
+			"corvus_pipeline_runs_total",
+			"Pipeline runs started (creates and redeploys) by source type.",
+			"source_type",
+		),
+		pipelineStepFailures: registry.NewCounterVec(
+			"corvus_pipeline_step_failures_total",
+			"Pipeline step failures by step. step=\"pipeline\" counts failed runs.",
+			"step",
+		),
+		pipelineStepDuration: registry.NewHistogramVec(
+			"corvus_pipeline_step_duration_seconds",
+			"Duration of pipeline steps (clone, build, copy, container_start, ...) by outcome. step=\"pipeline\" is the whole run.",
+			DefaultDurationBuckets,
+			"step", "outcome",
+		),
+		imagePullDuration: registry.NewHistogramVec(
+			"corvus_docker_image_pull_duration_seconds",
+			"Time spent pulling (or confirming the presence of) a Docker image.",
+			DefaultDurationBuckets,
+			"image",
+		),
+		expirationCleanups: registry.NewCounterVec(
+			"corvus_expiration_cleanups_total",
+			"Expired deployments torn down by the expiration cleanup loop.",
+		),
+		expirationCleanupErrors: registry.NewCounterVec(
+			"corvus_expiration_cleanup_errors_total",
+			"Errors in the expiration cleanup loop (listing or teardown).",
+		),
+		httpRequestDuration: registry.NewHistogramVec(
+			"corvus_http_request_duration_seconds",
+			"HTTP request latency by method, route pattern and status code.",
+			HTTPDurationBuckets,
+			"method", "route", "status_code",
+		),
+	}
+}
+
+// SetDeploymentCountCollector sets the scrape-time source of the corvus_deployments gauge.
+// each sample's LabelValues must be [status, source_type].
+func (controlPlaneMetrics *ControlPlaneMetrics) SetDeploymentCountCollector(collect func() ([]GaugeSample, error)) {
+	if controlPlaneMetrics == nil {
+		return
+	}
+	controlPlaneMetrics.deployments.SetCollector(collect)
+}
+
+// PipelineRunStarted counts one pipeline run.
+func (controlPlaneMetrics *ControlPlaneMetrics) PipelineRunStarted(sourceType string) {
+	if controlPlaneMetrics == nil {
+		return
+	}
+	controlPlaneMetrics.pipelineRuns.Inc(sourceType)
+}
+
+// PipelineStepFinished records the duration of a successful step.
+func (controlPlaneMetrics *ControlPlaneMetrics) PipelineStepFinished(step string, duration time.Duration) {
+	if controlPlaneMetrics == nil {
+		return
+	}
+	controlPlaneMetrics.pipelineStepDuration.Observe(duration.Seconds(), step, "success")
+}
+
+// PipelineStepFailed records the duration of a failed step and counts the failure.
+func (controlPlaneMetrics *ControlPlaneMetrics) PipelineStepFailed(step string, duration time.Duration) {
+	if controlPlaneMetrics == nil {
+		return
+	}
+	controlPlaneMetrics.pipelineStepDuration.Observe(duration.Seconds(), step, "failure")
+	controlPlaneMetrics.pipelineStepFailures.Inc(step)
+}
+
+// ImagePulled records how long an image pull took.
+func (controlPlaneMetrics *ControlPlaneMetrics) ImagePulled(image string, duration time.Duration) {
+	if controlPlaneMetrics == nil {
+		return
+	}
+	controlPlaneMetrics.imagePullDuration.Observe(duration.Seconds(), image)
+}
+
+// ExpirationCleanupPerformed counts one expired deployment torn down.
+func (controlPlaneMetrics *ControlPlaneMetrics) ExpirationCleanupPerformed() {
+	if controlPlaneMetrics == nil {
+		return
+	}
+	controlPlaneMetrics.expirationCleanups.Inc()
+}
+
+// ExpirationCleanupFailed counts one error in the expiration cleanup loop.
+func (controlPlaneMetrics *ControlPlaneMetrics) ExpirationCleanupFailed() {
+	if controlPlaneMetrics == nil {
+		return
+	}
+	controlPlaneMetrics.expirationCleanupErrors.Inc()
+}
+
+// HTTPRequestServed records the latency of one API request.
+// route is the router pattern (eg, "/api/deployments/{uuid}"), never the raw path,
+// so UUIDs do not explode the number of series.
+func (controlPlaneMetrics *ControlPlaneMetrics) HTTPRequestServed(method string, route string, statusCode int, duration time.Duration) {
+	if controlPlaneMetrics == nil {
+		return
+	}
+	controlPlaneMetrics.httpRequestDuration.Observe(duration.Seconds(), method, route, strconv.Itoa(statusCode))
+}
+
+// Handler returns the http.Handler that serves the metrics in the Prometheus text format.
+func (controlPlaneMetrics *ControlPlaneMetrics) Handler() http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if controlPlaneMetrics == nil {
+			return
+		}
+		// write errors mean the scraper disconnected, nothing useful can be done about it
+		controlPlaneMetrics.registry.WriteExposition(responseWriter) // nolint:errcheck
+	})
+}
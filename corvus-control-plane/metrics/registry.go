@@ -0,0 +1,375 @@
+// Package metrics is a small, dependency-free implementation of the Prometheus
+// text exposition format (counters, histograms and scrape-time gauges).
+// the official client_golang library pulls in protobuf and a dozen other modules
+// for features this control plane does not use, so only the subset needed here is implemented.
+// like models and util, this package imports no other internal package.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultDurationBuckets are histogram upper bounds (in seconds) suited to pipeline steps,
+// which range from milliseconds (copy of a small site) to minutes (npm ci + build).
+var DefaultDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// HTTPDurationBuckets are histogram upper bounds (in seconds) for API request latency.
+var HTTPDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricFamily is implemented by every metric type the Registry can expose.
+// a family is one metric name with all of its label combinations (series).
+type metricFamily interface {
+	writeExposition(writer io.Writer) error
+}
+
+// Registry holds every registered metric family and renders them in the
+// Prometheus text format (version 0.0.4) on each scrape.
+// it is safe for concurrent use.
+type Registry struct {
+	mutex    sync.Mutex
+	families []metricFamily
+}
+
+// NewRegistry constructs an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) register(family metricFamily) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.families = append(registry.families, family)
+}
+
+// WriteExposition renders all registered families to the writer in registration order.
+func (registry *Registry) WriteExposition(writer io.Writer) error {
+	registry.mutex.Lock()
+	families := append([]metricFamily(nil), registry.families...)
+	registry.mutex.Unlock()
+
+	for _, family := range families {
+		if err := family.writeExposition(writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ========== counter
+
+// CounterVec is a monotonically increasing counter partitioned by label values.
+type CounterVec struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mutex  sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounterVec creates and registers a counter family.
+func (registry *Registry) NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	counterVec := &CounterVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		series:     map[string]*counterSeries{},
+	}
+	// an unlabelled counter is exposed as 0 from the start, so rate() works before the first Inc()
+	if len(labelNames) == 0 {
+		counterVec.series[""] = &counterSeries{}
+	}
+	registry.register(counterVec)
+	return counterVec
+}
+
+// Inc adds 1 to the series identified by labelValues (in labelNames order).
+func (counterVec *CounterVec) Inc(labelValues ...string) {
+	counterVec.Add(1, labelValues...)
+}
+
+// Add adds a non-negative delta to the series identified by labelValues.
+// negative deltas are ignored, a counter never goes down.
+func (counterVec *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	labelValues = normalizeLabelValues(counterVec.labelNames, labelValues)
+	key := seriesKey(labelValues)
+
+	counterVec.mutex.Lock()
+	defer counterVec.mutex.Unlock()
+	series, exists := counterVec.series[key]
+	if !exists {
+		series = &counterSeries{labelValues: labelValues}
+		counterVec.series[key] = series
+	}
+	series.value += delta
+}
+
+func (counterVec *CounterVec) writeExposition(writer io.Writer) error {
+	counterVec.mutex.Lock()
+	defer counterVec.mutex.Unlock()
+
+	if err := writeHeader(writer, counterVec.name, counterVec.help, "counter"); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(counterVec.series) {
+		series := counterVec.series[key]
+		labels := formatLabels(counterVec.labelNames, series.labelValues, "", "")
+		if _, err := fmt.Fprintf(writer, "%s%s %s\n", counterVec.name, labels, formatFloat(series.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ========== histogram
+
+// HistogramVec counts observations into cumulative buckets, partitioned by label values.
+type HistogramVec struct {
+	name       string
+	help       string
+	labelNames []string
+	buckets    []float64 // sorted upper bounds, +Inf is implicit
+
+	mutex  sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues  []string
+	bucketCounts []uint64 // non-cumulative, one per bucket. made cumulative at exposition time
+	sum          float64
+	count        uint64
+}
+
+// NewHistogramVec creates and registers a histogram family with the given bucket upper bounds.
+func (registry *Registry) NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	sortedBuckets := append([]float64(nil), buckets...)
+	sort.Float64s(sortedBuckets)
+
+	histogramVec := &HistogramVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		buckets:    sortedBuckets,
+		series:     map[string]*histogramSeries{},
+	}
+	registry.register(histogramVec)
+	return histogramVec
+}
+
+// Observe records one value (eg, a duration in seconds) in the series identified by labelValues.
+func (histogramVec *HistogramVec) Observe(value float64, labelValues ...string) {
+	labelValues = normalizeLabelValues(histogramVec.labelNames, labelValues)
+	key := seriesKey(labelValues)
+
+	histogramVec.mutex.Lock()
+	defer histogramVec.mutex.Unlock()
+	series, exists := histogramVec.series[key]
+	if !exists {
+		series = &histogramSeries{
+			labelValues:  labelValues,
+			bucketCounts: make([]uint64, len(histogramVec.buckets)),
+		}
+		histogramVec.series[key] = series
+	}
+
+	// the first bucket whose upper bound is >= value gets the observation.
+	// values above the largest bound only count towards +Inf (the total count).
+	bucketIndex := sort.SearchFloat64s(histogramVec.buckets, value)
+	if bucketIndex < len(series.bucketCounts) {
+		series.bucketCounts[bucketIndex]++
+	}
+	series.sum += value
+	series.count++
+}
+
+func (histogramVec *HistogramVec) writeExposition(writer io.Writer) error {
+	histogramVec.mutex.Lock()
+	defer histogramVec.mutex.Unlock()
+
+	if err := writeHeader(writer, histogramVec.name, histogramVec.help, "histogram"); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(histogramVec.series) {
+		series := histogramVec.series[key]
+
+		var cumulativeCount uint64
+		for bucketIndex, upperBound := range histogramVec.buckets {
+			cumulativeCount += series.bucketCounts[bucketIndex]
+			labels := formatLabels(histogramVec.labelNames, series.labelValues, "le", formatFloat(upperBound))
+			if _, err := fmt.Fprintf(writer, "%s_bucket%s %d\n", histogramVec.name, labels, cumulativeCount); err != nil {
+				return err
+			}
+		}
+		infLabels := formatLabels(histogramVec.labelNames, series.labelValues, "le", "+Inf")
+		plainLabels := formatLabels(histogramVec.labelNames, series.labelValues, "", "")
+		_, err := fmt.Fprintf(writer, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			histogramVec.name, infLabels, series.count,
+			histogramVec.name, plainLabels, formatFloat(series.sum),
+			histogramVec.name, plainLabels, series.count,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ========== scrape-time gauge
+
+// GaugeSample is one series of a GaugeFunc, produced at scrape time.
+type GaugeSample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// GaugeFunc is a gauge whose series are computed on every scrape by a callback,
+// for values that already live somewhere else (eg, deployment counts in SQLite)
+// and would drift if they were tracked separately in memory.
+type GaugeFunc struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mutex   sync.Mutex
+	collect func() ([]GaugeSample, error)
+}
+
+// NewGaugeFunc creates and registers a gauge family. collect may be nil and set later
+// with SetCollector (the metric is exposed with no series until then).
+func (registry *Registry) NewGaugeFunc(name string, help string, collect func() ([]GaugeSample, error), labelNames ...string) *GaugeFunc {
+	gaugeFunc := &GaugeFunc{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		collect:    collect,
+	}
+	registry.register(gaugeFunc)
+	return gaugeFunc
+}
+
+// SetCollector replaces the scrape-time callback.
+func (gaugeFunc *GaugeFunc) SetCollector(collect func() ([]GaugeSample, error)) {
+	gaugeFunc.mutex.Lock()
+	defer gaugeFunc.mutex.Unlock()
+	gaugeFunc.collect = collect
+}
+
+func (gaugeFunc *GaugeFunc) writeExposition(writer io.Writer) error {
+	gaugeFunc.mutex.Lock()
+	collect := gaugeFunc.collect
+	gaugeFunc.mutex.Unlock()
+
+	if err := writeHeader(writer, gaugeFunc.name, gaugeFunc.help, "gauge"); err != nil {
+		return err
+	}
+	if collect == nil {
+		return nil
+	}
+
+	samples, err := collect()
+	if err != nil {
+		// a failing source (eg, the database) must not break the whole scrape.
+		// the family is exposed with no series, which alerting can detect as absent().
+		return nil
+	}
+	for _, sample := range samples {
+		labelValues := normalizeLabelValues(gaugeFunc.labelNames, sample.LabelValues)
+		labels := formatLabels(gaugeFunc.labelNames, labelValues, "", "")
+		if _, err := fmt.Fprintf(writer, "%s%s %s\n", gaugeFunc.name, labels, formatFloat(sample.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ========== exposition helpers
+
+func writeHeader(writer io.Writer, name string, help string, metricType string) error {
+	_, err := fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, metricType)
+	return err
+}
+
+// normalizeLabelValues pads or truncates labelValues to the number of label names,
+// so a caller passing the wrong count produces a visible "" label instead of a panic.
+func normalizeLabelValues(labelNames []string, labelValues []string) []string {
+	normalized := make([]string, len(labelNames))
+	copy(normalized, labelValues)
+	return normalized
+}
+
+// seriesKey joins label values with a byte that cannot appear in valid UTF-8 text.
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func sortedKeys[V any](seriesByKey map[string]V) []string {
+	keys := make([]string, 0, len(seriesByKey))
+	for key := range seriesByKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLabels renders {name="value",...}. extraName/extraValue append one more label
+// (used for the histogram "le" label). returns "" when there are no labels at all.
+func formatLabels(labelNames []string, labelValues []string, extraName string, extraValue string) string {
+	if len(labelNames) == 0 && extraName == "" {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for index, labelName := range labelNames {
+		if index > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(labelName)
+		builder.WriteString(`="`)
+		builder.WriteString(escapeLabelValue(labelValues[index]))
+		builder.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(labelNames) > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(extraName)
+		builder.WriteString(`="`)
+		builder.WriteString(extraValue)
+		builder.WriteByte('"')
+	}
+	builder.WriteByte('}')
+	return builder.String()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabelValue(value string) string { return labelValueEscaper.Replace(value) }
+func escapeHelp(help string) string        { return helpEscaper.Replace(help) }
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
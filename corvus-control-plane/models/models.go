@@ -3,7 +3,10 @@
 // foundation of the dependency graph. other packages (db, handlers, build) import from model.go
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 /*
 DeploymentStatus and SourceType are both string under the hood, but giving them their own type
@@ -51,12 +54,99 @@ const (
 	// SourceGitHub means the deployment source is a public GitHub repository URL
 	SourceGitHub SourceType = "github"
 
+	// SourceGit means the deployment source is a public git repository on any host
+	// (GitLab, Gitea, Bitbucket, self-hosted) reachable over https:// or file://.
+	// built exactly like SourceGitHub, only the repository check uses `git ls-remote`
+	// instead of the GitHub REST API.
+	SourceGit SourceType = "git"
+
 	// SourcePrebuilt means the deployment uses pre-built static files stored on
 	// the server's filesystem. Used for quick-deploy presets (Vite Starter, React App)
 	// to skip the clone + build steps entirely and deploy in seconds.
 	SourcePrebuilt SourceType = "prebuilt"
 )
 
+// EnvironmentVariableScope controls which pipeline stages can see an environment variable
+// and whether its value is ever returned by the API.
+type EnvironmentVariableScope string
+
+const (
+	// EnvScopeBuild variables are passed only to the ephemeral build container.
+	// this is the default scope when a variable does not declare one (matches the old behaviour).
+	EnvScopeBuild EnvironmentVariableScope = "build"
+
+	// EnvScopeRuntime variables are not baked in at build time. They are written into a
+	// generated runtime config file (corvus-env.js) next to the served files and passed
+	// to the serving container. Meant for public values like analytics keys.
+	EnvScopeRuntime EnvironmentVariableScope = "runtime"
+
+	// EnvScopeSecret variables are write-only. They are passed to the build container
+	// like build-scoped variables, but are never returned by the API and are masked
+	// in the deployment log. Meant for deploy tokens, registry credentials, etc.
+	EnvScopeSecret EnvironmentVariableScope = "secret"
+)
+
+// PreviewDeployment is the short form of a pull request preview, listed on its parent deployment
+// so the parent links to one clickable URL per open pull request.
+type PreviewDeployment struct {
+	ID                string           `json:"id"`
+	Slug              string           `json:"slug"`
+	PullRequestNumber int              `json:"pull_request_number"`
+	Status            DeploymentStatus `json:"status"`
+	URL               *string          `json:"url,omitempty"`
+	CommitSHA         *string          `json:"commit_sha,omitempty"`
+}
+
+// TrailingSlashPolicy is what the serving nginx does with a trailing slash on a page URL.
+type TrailingSlashPolicy string
+
+const (
+	// TrailingSlashIgnore serves /about and /about/ alike, no redirect. the default.
+	TrailingSlashIgnore TrailingSlashPolicy = "ignore"
+
+	// TrailingSlashAlways redirects /about to /about/ (paths whose last segment has no file extension),
+	// for sites whose generator links to directories (about/index.html).
+	TrailingSlashAlways TrailingSlashPolicy = "always"
+
+	// TrailingSlashNever redirects /about/ to /about, for sites that link without the slash.
+	TrailingSlashNever TrailingSlashPolicy = "never"
+)
+
+// GitCredentialType is the kind of credential a private repository deployment clones with.
+type GitCredentialType string
+
+const (
+	// GitCredentialToken is an HTTPS access token (GitHub/Gitea/GitLab personal or deploy token),
+	// sent as the password of an https:// clone URL.
+	GitCredentialToken GitCredentialType = "token"
+
+	// GitCredentialSSHKey is a private SSH deploy key, used with ssh:// or git@host:repo URLs.
+	GitCredentialSSHKey GitCredentialType = "ssh_key"
+)
+
+// GitCredential is the decrypted credential of a private repository deployment.
+// it only ever exists in memory, the database holds it encrypted (Deployment.EncryptedGitCredential)
+// as JSON of this struct, and no API response contains it.
+type GitCredential struct {
+	Type GitCredentialType `json:"type"`
+
+	// Username goes with a token (most hosts accept any non-empty name, GitHub uses "x-access-token").
+	// unused for SSH keys, the user comes from the URL (git@...).
+	Username string `json:"username,omitempty"`
+
+	// Secret is the token or the PEM/OpenSSH private key
+	Secret string `json:"secret"`
+}
+
+// EnvironmentVariable is a single KEY=VALUE pair together with its scope.
+// used by the handler to parse the create request before the variables are split
+// into one JSON map per scope for storage.
+type EnvironmentVariable struct {
+	Key   string                   `json:"key"`
+	Value string                   `json:"value"`
+	Scope EnvironmentVariableScope `json:"scope"`
+}
+
 /*
 Deployment is the central data model for the application.
 it maps 1:1 to the deployments table in SQLite and is the struct
@@ -85,32 +175,116 @@ type Deployment struct {
 	// Name is the human-readable label the user assigns to the deployment
 	Name string `json:"name" db:"name"`
 
-	// SourceType is either "zip" or "github"
+	// SourceType is "zip", "github", "git" or "prebuilt"
 	SourceType SourceType `json:"source_type" db:"source_type"`
 
 	// GitHubURL is the public repo URL, only populated for github source type
 	// why POINTER? it allows the field to be nil unlike a value string, which defaults to "" (empty string)
 	GitHubURL *string `json:"github_url,omitempty" db:"github_url"`
 
+	// GitURL is the repository URL (https:// or file://), only populated for git source type
+	GitURL *string `json:"git_url,omitempty" db:"git_url"`
+
+	// GitCredentialType tells whether the repository is cloned with a token or an SSH key.
+	// nil for public repositories. returned by the API so the UI can show the repo is private,
+	// the credential itself never is.
+	GitCredentialType *GitCredentialType `json:"git_credential_type,omitempty" db:"git_credential_type"`
+
+	// EncryptedGitCredential is a models.GitCredential as JSON, encrypted with util.CredentialCipher.
+	// `json:"-"` keeps it out of every API response, even encrypted.
+	EncryptedGitCredential *string `json:"-" db:"git_credential"`
+
 	// Branch is the git branch to clone and build from, should default to "main"
 	Branch string `json:"branch" db:"branch"`
 
+	// Ref pins the deployment to a tag or full commit SHA (a branch name works too).
+	// when set it wins over Branch: exactly this ref is fetched on every (re)deploy,
+	// instead of whatever the branch points to at the time. nil follows Branch.
+	Ref *string `json:"ref,omitempty" db:"ref"`
+
+	// CommitSHA and CommitMessage describe the commit that is currently live,
+	// recorded after a github/git deployment goes live. nil until the first successful deploy.
+	// a known-good CommitSHA can be sent back as the ref of a redeploy to roll back to it.
+	CommitSHA     *string `json:"commit_sha,omitempty" db:"commit_sha"`
+	CommitMessage *string `json:"commit_message,omitempty" db:"commit_message"`
+
 	// BuildCommand is the shell command run inside the build container before serving.
 	// empty string means no build step (pre-built static site).
 	// example: "npm ci && npm run build"
 	BuildCommand string `json:"build_command" db:"build_command"`
 
+	// RootDirectory is the app's directory inside a monorepo (github/git only), defaults to "." (repo root).
+	// the build command runs there and OutputDirectory is relative to it.
+	// anything other than "." also switches the clone to a sparse checkout of this directory
+	// plus SharedDirectories (see build/monorepo.go).
+	// example: "apps/web"
+	RootDirectory string `json:"root_directory" db:"root_directory"`
+
+	// SharedDirectories is a comma separated list of other directories the build needs
+	// from a monorepo, checked out next to RootDirectory. nil when none.
+	// example: "packages/ui,packages/tsconfig"
+	SharedDirectories *string `json:"shared_directories,omitempty" db:"shared_dirs"`
+
+	// GitSubmodules initialises submodules recursively (shallow) after the clone, github/git only.
+	// stored as INTEGER 0/1, same as AutoDeploy.
+	GitSubmodules bool `json:"git_submodules" db:"git_submodules"`
+
+	// GitLFS runs `git lfs pull` after the clone (and in submodules when GitSubmodules is set), github/git only.
+	GitLFS bool `json:"git_lfs" db:"git_lfs"`
+
 	// OutputDirectory is the directory inside the repo or extracted zip that contains
 	// the final static files to serve. defaults to "." (root of the archive).
 	// example: "dist", "build", "out"
 	OutputDirectory string `json:"output_directory" db:"output_directory"`
 
-	// EnvironmentVariables is a JSON-encoded key-value map of environment variables
+	// SPAFallback serves index.html for any path that matches no file, so a client-side routed app
+	// (React Router, Vue Router) survives a refresh on /dashboard/settings instead of a 404.
+	// rendered into the deployment's nginx config, see build/nginx_config.go.
+	SPAFallback bool `json:"spa_fallback" db:"spa_fallback"`
+
+	// Custom404 serves the output directory's 404.html (with status 404) for paths that match no file,
+	// instead of nginx's built-in error page. the deploy fails if there is no 404.html.
+	Custom404 bool `json:"custom_404" db:"custom_404"`
+
+	// CleanURLs serves /about from about.html, the layout most static site generators write.
+	CleanURLs bool `json:"clean_urls" db:"clean_urls"`
+
+	// TrailingSlash is the redirect policy for a trailing slash on page URLs, defaults to "ignore".
+	TrailingSlash TrailingSlashPolicy `json:"trailing_slash" db:"trailing_slash"`
+
+	// BasicAuthUsername is the user name of the HTTP basic auth protecting the site.
+	// nil for a public site. returned by the API so the UI can show the site is password protected.
+	BasicAuthUsername *string `json:"basic_auth_username,omitempty" db:"basic_auth_user"`
+
+	// BasicAuthPasswordHash is the bcrypt hash of the basic auth password, the password itself is never stored.
+	// `json:"-"`, not even the hash leaves the server.
+	BasicAuthPasswordHash *string `json:"-" db:"basic_auth_hash"`
+
+	// IPAllowList is a comma separated list of CIDR ranges the site is reachable from (eg, "10.8.0.0/16,192.0.2.7/32").
+	// nil uses the platform default (DEFAULT_IP_ALLOW_LIST), an empty string allows every address.
+	IPAllowList *string `json:"ip_allow_list,omitempty" db:"ip_allow_list"`
+
+	// IPDenyList is a comma separated list of CIDR ranges the site is not reachable from,
+	// on top of the platform default deny list (DEFAULT_IP_DENY_LIST). nil for none.
+	IPDenyList *string `json:"ip_deny_list,omitempty" db:"ip_deny_list"`
+
+	// EnvironmentVariables is a JSON-encoded key-value map of build-scoped environment variables
 	// passed into the build container. stored as a string in SQLite.
 	// example: {"NODE_ENV":"production"}
 	// nil means no env vars were provided
 	EnvironmentVariables *string `json:"environment_variables,omitempty" db:"environment_variables"`
 
+	// RuntimeEnvironmentVariables is a JSON-encoded key-value map of runtime-scoped variables.
+	// they are written to corvus-env.js in the served directory and passed to the serving container,
+	// but never to the build container.
+	// example: {"ANALYTICS_KEY":"G-XXXX"}
+	RuntimeEnvironmentVariables *string `json:"runtime_environment_variables,omitempty" db:"runtime_env_vars"`
+
+	// SecretEnvironmentVariables is a JSON-encoded key-value map of secret-scoped variables.
+	// `json:"-"` makes encoding/json skip the field entirely, so secrets are write-only:
+	// they go into the build container but are never serialized into an API response.
+	SecretEnvironmentVariables *string `json:"-" db:"secret_env_vars"`
+
 	// Status is the current lifecycle state of the deployment
 	Status DeploymentStatus `json:"status" db:"status"`
 
@@ -121,8 +295,11 @@ type Deployment struct {
 	URL *string `json:"url,omitempty" db:"url"`
 
 	// WebhookSecret is the HMAC-SHA256 signing secret for GitHub webhook verification.
-	// generated at deployment creation time, returned once to the user, never logged
-	WebhookSecret *string `json:"webhook_secret,omitempty" db:"webhook_secret"`
+	// generated at deployment creation time, returned once to the user (only in the create response,
+	// see handlers.createDeploymentResponse), never logged.
+	// it is the only auth of the webhook route, so it is never serialized anywhere else:
+	// GET /api/deployments is public, anyone reading it could sign pull request events.
+	WebhookSecret *string `json:"-" db:"webhook_secret"`
 
 	// AutoDeploy controls whether a push to the configured branch triggers a rebuild.
 	// stored as INTEGER 0/1 in SQLite (SQLite has no native boolean type).
@@ -133,6 +310,23 @@ type Deployment struct {
 	// example: "vite-starter", "react-app"
 	PresetID *string `json:"preset_id,omitempty" db:"preset_id"`
 
+	// TemplateValues is a JSON-encoded map of the preset's template variables to their values
+	// (defaults filled in), substituted into the preset's template files at deploy time.
+	// only populated for prebuilt deployments of presets that declare template variables.
+	// example: {"CORVUS_MESSAGE":"hello there"}
+	TemplateValues *string `json:"template_values,omitempty" db:"template_values"`
+
+	// ParentDeploymentID is set on pull request preview deployments: the github/git deployment
+	// whose webhook received the pull_request event. nil for every other deployment.
+	ParentDeploymentID *string `json:"parent_deployment_id,omitempty" db:"parent_id"`
+
+	// PullRequestNumber is the pull request a preview deployment builds, nil when ParentDeploymentID is nil.
+	PullRequestNumber *int `json:"pull_request_number,omitempty" db:"pr_number"`
+
+	// Previews are the active pull request previews of this deployment.
+	// not a column: filled in by the GetDeployment handler from the child rows.
+	Previews []PreviewDeployment `json:"previews,omitempty" db:"-"`
+
 	// ExpiresAt is the timestamp when this deployment should be automatically
 	// cleaned up (container stopped, files removed, DB row deleted).
 	// nil means the deployment does not expire.
@@ -144,3 +338,78 @@ type Deployment struct {
 	// UpdatedAt is refreshed on every status transition
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
+
+// MarshalJSON adds expires_in_seconds, the time left before the deployment expires, to the JSON
+// of a deployment (omitted when it does not expire, 0 once it is past its expiry).
+// it is computed when the response is written, so it is never stale and needs no column.
+// the client can count down from it without trusting its own clock against expires_at.
+func (deployment Deployment) MarshalJSON() ([]byte, error) {
+	// deploymentJSON has the same fields but not this method, so json.Marshal does not recurse into it
+	type deploymentJSON Deployment
+
+	var expiresInSeconds *int64
+	if deployment.ExpiresAt != nil {
+		remainingSeconds := max(int64(time.Until(*deployment.ExpiresAt).Seconds()), 0)
+		expiresInSeconds = &remainingSeconds
+	}
+	return json.Marshal(struct {
+		deploymentJSON
+		ExpiresInSeconds *int64 `json:"expires_in_seconds,omitempty"`
+	}{
+		deploymentJSON:   deploymentJSON(deployment),
+		ExpiresInSeconds: expiresInSeconds,
+	})
+}
+
+// PipelineEventType is the kind of a structured pipeline event.
+type PipelineEventType string
+
+const (
+	// EventStepStarted is recorded when a pipeline step begins
+	EventStepStarted PipelineEventType = "step_started"
+
+	// EventStepFinished is recorded when a pipeline step completes successfully.
+	// carries the step duration.
+	EventStepFinished PipelineEventType = "step_finished"
+
+	// EventStepFailed is recorded when a pipeline step fails.
+	// carries the step duration and the failure message.
+	EventStepFailed PipelineEventType = "step_failed"
+)
+
+/*
+PipelineEvent is a typed, machine-readable record of one step transition in a pipeline run.
+It is written next to the free-form <slug>.log lines so tools (and the frontend progress view)
+can tell which steps ran, in what order, and how long each one took.
+maps 1:1 to the deployment_events table.
+*/
+type PipelineEvent struct {
+	// ID is the autoincrement row id, which is also the event order
+	ID int64 `json:"id" db:"id"`
+
+	// DeploymentID is the UUID of the deployment this event belongs to
+	DeploymentID string `json:"deployment_id" db:"deployment_id"`
+
+	// RunID groups the events of a single pipeline run (one create or one redeploy).
+	RunID string `json:"run_id" db:"run_id"`
+
+	// Step is the pipeline step name. example: "clone", "build", "copy", "container_start"
+	// the special step "pipeline" covers the whole run.
+	Step string `json:"step" db:"step"`
+
+	// Type is started, finished or failed
+	Type PipelineEventType `json:"type" db:"type"`
+
+	// DurationMs is how long the step took. nil for step_started events.
+	DurationMs *int64 `json:"duration_ms,omitempty" db:"duration_ms"`
+
+	// Message is a short human-readable note. for failures, the error message.
+	Message string `json:"message,omitempty" db:"message"`
+
+	// Metadata holds step-specific details (eg, branch, image, bytes copied).
+	// stored as a JSON string in SQLite.
+	Metadata map[string]any `json:"metadata,omitempty" db:"metadata"`
+
+	// CreatedAt is when the event was recorded
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,143 @@
+// Package tracing sets up the OpenTelemetry tracer provider used by the control plane.
+// the rest of the app only ever sees a trace.Tracer (passed via dependency injection, same as the logger),
+// so the exporter choice (OTLP, stdout, file or none) is decided here and nowhere else.
+//
+// spans produced by the control plane:
+//   - one trace per HTTP request (handlers.TracingMiddleware)
+//   - one trace per pipeline run, linked to the HTTP request that triggered it (build package),
+//     with a child span per pipeline step (repo_check, clone, build, copy, container_start, ...)
+//   - docker.image_pull spans under the step that needed the image (docker package)
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TracerName is the instrumentation scope name of every span the control plane creates.
+const TracerName = "github.com/sasta-kro/corvus-paas/corvus-control-plane"
+
+// accepted values of Config.Exporter
+const (
+	ExporterNone   = "none"   // tracing disabled, a no-op tracer is used (default)
+	ExporterOTLP   = "otlp"   // OTLP over HTTP to a collector (Jaeger, Tempo, otel-collector, ...)
+	ExporterStdout = "stdout" // pretty-printed JSON spans on stdout, for local debugging
+	ExporterFile   = "file"   // JSON spans appended to a file, for local debugging without a collector
+)
+
+// Config groups the tracing settings. mirrors the tracing fields of config.AppConfig
+// so this package does not import the config package (same as build.DeployerPipelineConfig).
+type Config struct {
+	Exporter    string
+	ServiceName string
+
+	// OTLPEndpoint is the full collector URL (eg, "http://localhost:4318").
+	// an http:// scheme disables TLS. when empty, the exporter falls back to the standard
+	// OTEL_EXPORTER_OTLP_ENDPOINT / OTEL_EXPORTER_OTLP_TRACES_ENDPOINT env variables (or localhost:4318).
+	OTLPEndpoint string
+
+	// FilePath is where the "file" exporter appends spans.
+	FilePath string
+}
+
+// ShutdownFunc flushes any buffered spans and releases the exporter.
+// main.go calls it during graceful shutdown so the spans of the last seconds are not lost.
+type ShutdownFunc func(context.Context) error
+
+// NewTracerProvider builds the tracer provider for the configured exporter.
+// "none" (or an empty value) returns a no-op provider whose spans cost next to nothing,
+// so the instrumentation can stay in the code unconditionally.
+func NewTracerProvider(setupContext context.Context, config Config) (trace.TracerProvider, ShutdownFunc, error) {
+	noopShutdown := func(context.Context) error { return nil }
+
+	var spanExporter sdktrace.SpanExporter
+	var closeExporterOutput func() error // only the file exporter owns an extra resource
+
+	switch config.Exporter {
+	case "", ExporterNone:
+		return noop.NewTracerProvider(), noopShutdown, nil
+
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		if config.OTLPEndpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(config.OTLPEndpoint))
+		}
+		// otlptracehttp.New does not connect yet, an unreachable collector only shows up
+		// as export errors later (spans are dropped, requests are never blocked on it)
+		otlpExporter, err := otlptracehttp.New(setupContext, options...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create otlp trace exporter: %w", err)
+		}
+		spanExporter = otlpExporter
+
+	case ExporterStdout:
+		stdoutExporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create stdout trace exporter: %w", err)
+		}
+		spanExporter = stdoutExporter
+
+	case ExporterFile:
+		if config.FilePath == "" {
+			return nil, nil, fmt.Errorf("tracing exporter %q requires a file path", ExporterFile)
+		}
+		traceFile, err := os.OpenFile(config.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open trace file %q: %w", config.FilePath, err)
+		}
+		fileExporter, err := stdouttrace.New(stdouttrace.WithWriter(traceFile))
+		if err != nil {
+			traceFile.Close()
+			return nil, nil, fmt.Errorf("failed to create file trace exporter: %w", err)
+		}
+		spanExporter = fileExporter
+		closeExporterOutput = traceFile.Close
+
+	default:
+		return nil, nil, fmt.Errorf("unknown tracing exporter %q (expected %q, %q, %q or %q)",
+			config.Exporter, ExporterNone, ExporterOTLP, ExporterStdout, ExporterFile)
+	}
+
+	serviceResource, err := resource.Merge(
+		resource.Default(), // host, process and telemetry.sdk attributes
+		resource.NewSchemaless(attribute.String("service.name", config.ServiceName)),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	// the batcher exports in the background, so a slow collector never slows down a request or a pipeline step
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(serviceResource),
+	)
+
+	shutdown := func(shutdownContext context.Context) error {
+		errShutdown := tracerProvider.Shutdown(shutdownContext)
+		if closeExporterOutput != nil {
+			if errClose := closeExporterOutput(); errClose != nil && errShutdown == nil {
+				errShutdown = errClose
+			}
+		}
+		return errShutdown
+	}
+	return tracerProvider, shutdown, nil
+}
+
+// TracerOrNoop returns tracer, or a no-op tracer when it is nil.
+// constructors that accept a tracer call this so a nil tracer never needs a nil check at span sites.
+func TracerOrNoop(tracer trace.Tracer) trace.Tracer {
+	if tracer == nil {
+		return noop.NewTracerProvider().Tracer(TracerName)
+	}
+	return tracer
+}
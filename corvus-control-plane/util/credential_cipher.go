@@ -0,0 +1,77 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// CredentialCipher encrypts repository credentials (access tokens, SSH deploy keys)
+// before they are written to the database, so a copy of corvus.db alone does not leak them.
+// AES-256-GCM: the ciphertext is authenticated, a tampered or truncated value fails to decrypt
+// instead of decrypting to garbage.
+//
+// the key lives outside the database (CREDENTIALS_ENCRYPTION_KEY), constructed once in main.go.
+type CredentialCipher struct {
+	aead cipher.AEAD
+}
+
+// NewCredentialCipher builds a cipher from a base64 encoded 32 byte key
+// (generate one with `openssl rand -base64 32`).
+// an empty key returns (nil, nil): credential storage is disabled and deployments
+// that send credentials are rejected, rather than storing them unencrypted.
+func NewCredentialCipher(base64Key string) (*CredentialCipher, error) {
+	if base64Key == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(base64Key)
+	if err != nil {
+		return nil, fmt.Errorf("credential encryption key is not valid base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("credential encryption key must be 32 bytes (AES-256), got %d", len(key))
+	}
+
+	blockCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(blockCipher)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM cipher: %w", err)
+	}
+	return &CredentialCipher{aead: aead}, nil
+}
+
+// Encrypt returns base64(nonce || ciphertext). a fresh random nonce is used for every call,
+// so encrypting the same token twice gives two different values.
+func (credentialCipher *CredentialCipher) Encrypt(plaintext []byte) (string, error) {
+	nonce := make([]byte, credentialCipher.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := credentialCipher.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt. fails if the value was encrypted with another key or modified.
+func (credentialCipher *CredentialCipher) Decrypt(encoded string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("encrypted credential is not valid base64: %w", err)
+	}
+	nonceSize := credentialCipher.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("encrypted credential is too short")
+	}
+	plaintext, err := credentialCipher.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		// the GCM error is deliberately generic, the most likely cause is a rotated key
+		return nil, errors.New("failed to decrypt credential (wrong CREDENTIALS_ENCRYPTION_KEY or corrupted value)")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package util
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// FreeDiskSpaceBytes returns the number of bytes available to an unprivileged user
+// on the filesystem that contains path (what `df` reports as "Avail").
+// Bavail is used instead of Bfree because Bfree includes the blocks reserved for root,
+// which the control plane cannot count on when it runs as a normal user.
+func FreeDiskSpaceBytes(path string) (uint64, error) {
+	var filesystemStats syscall.Statfs_t
+	if err := syscall.Statfs(path, &filesystemStats); err != nil {
+		return 0, fmt.Errorf("failed to stat filesystem of %q: %w", path, err)
+	}
+	// the field types differ between linux and darwin (int64 vs uint32 block size),
+	// converting both to uint64 keeps this file compiling on both.
+	return uint64(filesystemStats.Bavail) * uint64(filesystemStats.Bsize), nil
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package util
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// FreeDiskSpaceBytes is not implemented outside unix systems.
+// the platform only runs on linux hosts, this stub only exists so the module still compiles elsewhere.
+func FreeDiskSpaceBytes(path string) (uint64, error) {
+	return 0, fmt.Errorf("free disk space check is not supported on %s (path %q)", runtime.GOOS, path)
+}
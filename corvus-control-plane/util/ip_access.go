@@ -0,0 +1,227 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// the limits of the IP access lists.
+// the effective ranges end up in one Traefik label, a deny entry cut out of a wide allowed range
+// turns into up to 32 (IPv4) or 128 (IPv6) ranges, MaxEffectiveIPRanges keeps the label reasonable.
+const (
+	MaxIPAccessListEntries = 100
+	MaxEffectiveIPRanges   = 1000
+)
+
+// allAddressRanges is what an empty allow list means when a deny list is set: every address.
+var allAddressRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/0"),
+	netip.MustParsePrefix("::/0"),
+}
+
+// IPAccessPolicy holds the platform-wide IP access lists (DEFAULT_IP_ALLOW_LIST, DEFAULT_IP_DENY_LIST)
+// and resolves the address ranges a deployment is reachable from.
+// it is read-only after NewIPAccessPolicy, so one instance is shared by the handlers and the pipeline.
+type IPAccessPolicy struct {
+	// defaultAllowList applies to deployments without an allow list of their own
+	defaultAllowList []netip.Prefix
+	// defaultDenyList applies to every deployment, on top of the deployment's own deny list
+	defaultDenyList []netip.Prefix
+
+	// ForwardedDepth is how many proxies in front of Traefik append to X-Forwarded-For
+	// (Traefik's ipStrategy.depth), 0 uses the address of the connection to Traefik.
+	// behind a Cloudflare Tunnel the connection comes from cloudflared, the client is in X-Forwarded-For.
+	ForwardedDepth int
+}
+
+// NewIPAccessPolicy builds an IPAccessPolicy from the comma separated platform default lists.
+// an invalid entry is an error, main.go refuses to start with it.
+func NewIPAccessPolicy(defaultAllowList string, defaultDenyList string, forwardedDepth int) (*IPAccessPolicy, error) {
+	allowRanges, err := ParseIPRangeList(defaultAllowList)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default allow list: %w", err)
+	}
+	denyRanges, err := ParseIPRangeList(defaultDenyList)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default deny list: %w", err)
+	}
+	if forwardedDepth < 0 {
+		return nil, fmt.Errorf("forwarded depth must not be negative, got %d", forwardedDepth)
+	}
+	policy := &IPAccessPolicy{
+		defaultAllowList: allowRanges,
+		defaultDenyList:  denyRanges,
+		ForwardedDepth:   forwardedDepth,
+	}
+	// the defaults alone must leave something reachable, or every deployment without its own list would be
+	if _, err := policy.AllowedRanges(nil, nil); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// ParseIPRangeList parses a comma (or whitespace) separated list of CIDR ranges and single addresses
+// ("10.0.0.0/8, 192.168.1.7, 2001:db8::/32"). a single address is a /32 (/128 for IPv6).
+// ranges are normalised to their network address, "" returns nil.
+// the returned error is safe to show to the user.
+func ParseIPRangeList(value string) ([]netip.Prefix, error) {
+	entries := strings.FieldsFunc(value, func(character rune) bool {
+		return character == ',' || character == ' ' || character == '\t' || character == '\n' || character == '\r'
+	})
+	if len(entries) > MaxIPAccessListEntries {
+		return nil, fmt.Errorf("at most %d entries are allowed, got %d", MaxIPAccessListEntries, len(entries))
+	}
+
+	var ranges []netip.Prefix
+	for _, entry := range entries {
+		var ipRange netip.Prefix
+		if strings.Contains(entry, "/") {
+			parsedRange, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a valid CIDR range", entry)
+			}
+			ipRange = parsedRange.Masked()
+		} else {
+			address, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a valid IP address", entry)
+			}
+			if address.Zone() != "" {
+				return nil, fmt.Errorf("%q: zoned IPv6 addresses are not allowed", entry)
+			}
+			ipRange = netip.PrefixFrom(address, address.BitLen())
+		}
+		// Traefik and the shared static server compare the client address in its plain IPv4 form
+		if ipRange.Addr().Is4In6() {
+			return nil, fmt.Errorf("%q: write IPv4-mapped addresses in their IPv4 form", entry)
+		}
+		ranges = append(ranges, ipRange)
+	}
+	return normaliseIPRanges(ranges), nil
+}
+
+// FormatIPRangeList joins ranges into the comma separated form stored in the database
+// and passed to Traefik. nil and empty return "".
+func FormatIPRangeList(ranges []netip.Prefix) string {
+	formattedRanges := make([]string, len(ranges))
+	for index, ipRange := range ranges {
+		formattedRanges[index] = ipRange.String()
+	}
+	return strings.Join(formattedRanges, ",")
+}
+
+// AllowedRanges resolves the address ranges a deployment is reachable from, given its own lists
+// (the comma separated database columns, nil = not set):
+//   - the deployment's allow list replaces the platform default allow list
+//   - the deny lists are combined, the platform default deny list always applies
+//   - an empty allow list with a deny list set means every address except the denied ones
+//
+// the deny ranges are cut out of the allowed ranges, the result is a plain allow list
+// (Traefik's ipAllowList middleware has no deny counterpart).
+// returns nil when the deployment is reachable from everywhere, and an error (safe to show
+// to the user) when the lists are invalid or leave no address allowed.
+func (policy *IPAccessPolicy) AllowedRanges(allowList *string, denyList *string) ([]netip.Prefix, error) {
+	allowRanges := policy.defaultAllowList
+	if allowList != nil {
+		ownAllowRanges, err := ParseIPRangeList(*allowList)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allow list: %w", err)
+		}
+		allowRanges = ownAllowRanges
+	}
+	denyRanges := slices.Clone(policy.defaultDenyList)
+	if denyList != nil {
+		ownDenyRanges, err := ParseIPRangeList(*denyList)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny list: %w", err)
+		}
+		denyRanges = append(denyRanges, ownDenyRanges...)
+	}
+
+	if len(allowRanges) == 0 && len(denyRanges) == 0 {
+		return nil, nil
+	}
+	if len(allowRanges) == 0 {
+		allowRanges = allAddressRanges
+	}
+
+	remainingRanges := allowRanges
+	for _, denyRange := range denyRanges {
+		var cutRanges []netip.Prefix
+		for _, allowedRange := range remainingRanges {
+			cutRanges = append(cutRanges, subtractIPRange(allowedRange, denyRange)...)
+		}
+		remainingRanges = cutRanges
+		if len(remainingRanges) > MaxEffectiveIPRanges {
+			return nil, fmt.Errorf("the deny list splits the allowed addresses into more than %d ranges, use fewer or wider deny ranges", MaxEffectiveIPRanges)
+		}
+	}
+	if len(remainingRanges) == 0 {
+		return nil, errors.New("the deny list blocks every address the allow list allows")
+	}
+	return normaliseIPRanges(remainingRanges), nil
+}
+
+// subtractIPRange returns the parts of ipRange not covered by excludedRange.
+// a range containing excludedRange is split in halves until the halves are either inside it
+// (dropped) or outside it (kept), which yields one range per bit between the two prefix lengths.
+func subtractIPRange(ipRange netip.Prefix, excludedRange netip.Prefix) []netip.Prefix {
+	if !ipRange.Overlaps(excludedRange) {
+		return []netip.Prefix{ipRange}
+	}
+	if excludedRange.Bits() <= ipRange.Bits() {
+		return nil // excludedRange covers all of ipRange
+	}
+	lowerHalf, upperHalf := splitIPRange(ipRange)
+	return append(subtractIPRange(lowerHalf, excludedRange), subtractIPRange(upperHalf, excludedRange)...)
+}
+
+// splitIPRange splits a range into its two halves (one bit longer prefix each).
+func splitIPRange(ipRange netip.Prefix) (netip.Prefix, netip.Prefix) {
+	prefixLength := ipRange.Bits()
+	addressBytes := ipRange.Addr().AsSlice()
+	addressBytes[prefixLength/8] |= 0x80 >> (prefixLength % 8)
+	upperAddress, _ := netip.AddrFromSlice(addressBytes)
+	return netip.PrefixFrom(ipRange.Addr(), prefixLength+1), netip.PrefixFrom(upperAddress, prefixLength+1)
+}
+
+// normaliseIPRanges sorts the ranges and drops duplicates and ranges inside another one of the list.
+func normaliseIPRanges(ranges []netip.Prefix) []netip.Prefix {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sortedRanges := slices.Clone(ranges)
+	// by address first, a wider range sorts before the ranges it contains (same address, shorter prefix)
+	slices.SortFunc(sortedRanges, func(first netip.Prefix, second netip.Prefix) int {
+		if addressOrder := first.Addr().Compare(second.Addr()); addressOrder != 0 {
+			return addressOrder
+		}
+		return first.Bits() - second.Bits()
+	})
+
+	normalisedRanges := sortedRanges[:1]
+	for _, ipRange := range sortedRanges[1:] {
+		lastRange := normalisedRanges[len(normalisedRanges)-1]
+		// sorted by address, a range inside an earlier one can only be inside the last kept one
+		if lastRange.Bits() <= ipRange.Bits() && lastRange.Contains(ipRange.Addr()) {
+			continue
+		}
+		normalisedRanges = append(normalisedRanges, ipRange)
+	}
+	return normalisedRanges
+}
+
+// IPRangesContain reports whether address is inside one of the ranges.
+// IPv4-mapped IPv6 addresses (from a dual stack listener) are matched in their IPv4 form.
+func IPRangesContain(ranges []netip.Prefix, address netip.Addr) bool {
+	address = address.Unmap()
+	for _, ipRange := range ranges {
+		if ipRange.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
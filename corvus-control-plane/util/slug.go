@@ -5,8 +5,11 @@ package util
 // Functions here have no dependencies on other internal packages.
 
 import (
+	"errors"
 	"fmt"
 	"math/rand/v2"
+	"regexp"
+	"strings"
 )
 
 // adjectives and nouns form the human-readable component of a deployment slug.
@@ -14,7 +17,7 @@ import (
 // not from wordlist size. words are chosen to be unambiguous when spoken aloud
 // (no homophones like "blue/blew") and safe in a professional context.
 // Can be swapped out for a slug generation library in the future.
-// TODO: feature to allow users to customize the slug with their input text (with validation)
+// users can also pick their own slug, see SlugPolicy.ValidateCustomSlug.
 var adjectives = []string{
 	"amber", "azure", "bold", "calm", "cedar", "clean", "clear",
 	"crisp", "dawn", "dusk", "emerald", "fair", "firm", "fleet",
@@ -67,3 +70,93 @@ func GenerateSlug() string {
 
 	return fmt.Sprintf("%s-%s-%s", adjective, noun, uuidSuffix)
 }
+
+// the length limits of a user chosen slug.
+// the slug ends up in a hostname label, "<slug>-corvus" (and "<slug>-pr-<n>-corvus" for pull request
+// previews), which DNS caps at 63 characters. 40 leaves room for both suffixes.
+const (
+	MinCustomSlugLength = 3
+	MaxCustomSlugLength = 40
+)
+
+// customSlugPattern is a DNS label: lowercase letters, digits and dashes, no dash at either end.
+var customSlugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// previewSlugSuffixPattern matches the suffix of pull request preview slugs ("<parent>-pr-<n>"),
+// which custom slugs must not end with or they would take a future preview's slug.
+var previewSlugSuffixPattern = regexp.MustCompile(`-pr-[0-9]+$`)
+
+// reservedSlugWords are never valid custom slugs on their own: the platform's own hostnames and
+// names that would look like an official page of the platform. as part of a slug they are fine ("team-docs").
+var reservedSlugWords = []string{
+	"admin", "api", "app", "assets", "auth", "billing", "blog", "cdn", "corvus", "dashboard",
+	"docs", "help", "internal", "login", "mail", "metrics", "official", "root", "security",
+	"static", "status", "support", "system", "traefik", "www",
+}
+
+// offensiveSlugWords are blocked as a whole slug and as any dash separated part of one.
+// kept short on purpose, operators extend it with SLUG_DENYLIST (matched the same way).
+var offensiveSlugWords = []string{
+	"asshole", "bitch", "bastard", "cunt", "dick", "fuck", "fucker", "fucking",
+	"motherfucker", "nazi", "porn", "shit", "slut", "whore",
+}
+
+// SlugPolicy validates user chosen slugs against the format rules and the denylist.
+// it is read-only after NewSlugPolicy, so one instance is shared by all requests.
+type SlugPolicy struct {
+	// reservedSlugs are denied only as the whole slug
+	reservedSlugs map[string]bool
+	// deniedWords (offensive and operator supplied, all lowercase) are denied as the whole slug or any part of it
+	deniedWords map[string]bool
+}
+
+// NewSlugPolicy builds a SlugPolicy from the built-in word lists plus the comma separated
+// extraDenylist (the SLUG_DENYLIST env var). entries are matched case-insensitively.
+func NewSlugPolicy(extraDenylist string) *SlugPolicy {
+	reservedSlugs := make(map[string]bool, len(reservedSlugWords))
+	for _, word := range reservedSlugWords {
+		reservedSlugs[word] = true
+	}
+	deniedWords := make(map[string]bool, len(offensiveSlugWords))
+	for _, word := range offensiveSlugWords {
+		deniedWords[word] = true
+	}
+	for _, word := range strings.Split(extraDenylist, ",") {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" {
+			deniedWords[word] = true
+		}
+	}
+	return &SlugPolicy{reservedSlugs: reservedSlugs, deniedWords: deniedWords}
+}
+
+// ValidateCustomSlug checks a user chosen slug. the returned error is safe to show to the user.
+// the slug must already be lowercase, it is not normalised (the caller stores exactly what was checked).
+//
+// a reserved word only blocks the exact slug ("docs" but not "team-docs"). a denied word blocks the
+// slug when it is the whole slug or one of its dash separated parts, so "fuck-this" is blocked
+// but "scunthorpe-news" is not (no substring matching).
+func (slugPolicy *SlugPolicy) ValidateCustomSlug(slug string) error {
+	if len(slug) < MinCustomSlugLength || len(slug) > MaxCustomSlugLength {
+		return fmt.Errorf("slug must be between %d and %d characters", MinCustomSlugLength, MaxCustomSlugLength)
+	}
+	if !customSlugPattern.MatchString(slug) {
+		return errors.New("slug may only contain lowercase letters, digits and dashes, and must start and end with a letter or digit")
+	}
+	if strings.Contains(slug, "--") {
+		// "xn--" is the punycode prefix, double dashes are reserved in hostnames
+		return errors.New("slug must not contain consecutive dashes")
+	}
+	if previewSlugSuffixPattern.MatchString(slug) {
+		return errors.New("slug must not end with -pr-<number>, that form is reserved for pull request previews")
+	}
+	if slugPolicy.reservedSlugs[slug] {
+		return errors.New("this slug is reserved")
+	}
+	for _, part := range strings.Split(slug, "-") {
+		if slugPolicy.deniedWords[part] {
+			return errors.New("this slug is not available")
+		}
+	}
+	return nil
+}
@@ -4,7 +4,7 @@
  */
 import { apiGet, apiPost, apiDelete, apiPostFormData } from "./client";
 import { API_BASE_URL } from "../config/constants";
-import type { Deployment } from "../types/deployment";
+import type { Deployment, PipelineEvent, PresetManifest, TrailingSlashPolicy } from "../types/deployment";
 import { extractNameFromFilename } from "../lib/utils";
 
 /** Creates a new deployment from a zip file upload */
@@ -35,6 +35,10 @@ export async function createGitHubDeployment(params: {
   branch: string;
   buildCommand: string;
   outputDirectory: string;
+  spaFallback?: boolean;
+  custom404?: boolean;
+  cleanUrls?: boolean;
+  trailingSlash?: TrailingSlashPolicy;
   environmentVariables?: Record<string, string>;
   friendCode?: string;
 }): Promise<Deployment> {
@@ -45,6 +49,18 @@ export async function createGitHubDeployment(params: {
   formData.append("branch", params.branch || "main");
   formData.append("build_command", params.buildCommand);
   formData.append("output_directory", params.outputDirectory || "dist");
+  if (params.spaFallback) {
+    formData.append("spa_fallback", "true");
+  }
+  if (params.custom404) {
+    formData.append("custom_404", "true");
+  }
+  if (params.cleanUrls) {
+    formData.append("clean_urls", "true");
+  }
+  if (params.trailingSlash) {
+    formData.append("trailing_slash", params.trailingSlash);
+  }
   if (params.environmentVariables && Object.keys(params.environmentVariables).length > 0) {
     formData.append("environment_variables", JSON.stringify(params.environmentVariables));
   }
@@ -60,6 +76,7 @@ export async function createPrebuiltDeployment(params: {
   name: string;
   presetId: string;
   environmentVariables?: Record<string, string>;
+  templateVariables?: Record<string, string>;
   friendCode?: string;
 }): Promise<Deployment> {
   const formData = new FormData();
@@ -71,6 +88,9 @@ export async function createPrebuiltDeployment(params: {
   if (params.environmentVariables && Object.keys(params.environmentVariables).length > 0) {
     formData.append("environment_variables", JSON.stringify(params.environmentVariables));
   }
+  if (params.templateVariables && Object.keys(params.templateVariables).length > 0) {
+    formData.append("template_variables", JSON.stringify(params.templateVariables));
+  }
   if (params.friendCode) {
     formData.append("friend_code", params.friendCode);
   }
@@ -83,6 +103,11 @@ export async function getDeployment(id: string): Promise<Deployment> {
   return apiGet<Deployment>(`/api/deployments/${id}`);
 }
 
+/** Fetches the structured pipeline events of the latest run of a deployment */
+export async function getDeploymentEvents(id: string): Promise<PipelineEvent[]> {
+  return apiGet<PipelineEvent[]>(`/api/deployments/${id}/events`);
+}
+
 /** Deletes a deployment by ID */
 export async function deleteDeployment(id: string): Promise<void> {
   return apiDelete<void>(`/api/deployments/${id}`);
@@ -93,6 +118,16 @@ export async function redeployDeployment(id: string): Promise<Deployment> {
   return apiPost<Deployment>(`/api/deployments/${id}/redeploy`);
 }
 
+/** Lists the quick-deploy presets available on the server */
+export async function listPresets(): Promise<PresetManifest[]> {
+  return apiGet<PresetManifest[]>("/api/presets");
+}
+
+/** URL of a preset's thumbnail image, for presets whose manifest names one */
+export function presetThumbnailUrl(presetId: string): string {
+  return `${API_BASE_URL}/api/presets/${encodeURIComponent(presetId)}/thumbnail`;
+}
+
 /** Validates a friend code against the backend */
 export async function validateFriendCode(
   code: string
@@ -24,8 +24,10 @@ export default function DeployPanel({ onDeployStarted }: DeployPanelProps) {
     setIsDeploying(true);
     try {
       const envVars: Record<string, string> = { ...preset.environmentVariables };
+      // the "Your Message" text is the preset's CORVUS_MESSAGE template variable (see its preset.json)
+      const templateVariables: Record<string, string> = {};
       if (message && preset.requiresTextInput) {
-        envVars["VITE_CORVUS_MESSAGE"] = message;
+        templateVariables["CORVUS_MESSAGE"] = message;
       }
 
       let deployment: Deployment;
@@ -36,6 +38,7 @@ export default function DeployPanel({ onDeployStarted }: DeployPanelProps) {
           name: preset.requiresTextInput ? "Custom Message" : preset.name,
           presetId: preset.presetId,
           environmentVariables: Object.keys(envVars).length > 0 ? envVars : undefined,
+          templateVariables,
           friendCode: friendCode || undefined,
         });
       } else if (preset.githubUrl) {
@@ -74,12 +77,12 @@ export default function DeployPanel({ onDeployStarted }: DeployPanelProps) {
     }
   };
 
-  const handleGitHubDeploy = async (repoUrl: string, branch: string, buildCommand: string, outputDirectory: string) => {
+  const handleGitHubDeploy = async (repoUrl: string, branch: string, buildCommand: string, outputDirectory: string, spaFallback: boolean) => {
     setIsDeploying(true);
     try {
       const deployment = await createGitHubDeployment({
         name: extractNameFromGithubUrl(repoUrl),
-        githubUrl: repoUrl, branch, buildCommand, outputDirectory,
+        githubUrl: repoUrl, branch, buildCommand, outputDirectory, spaFallback,
         friendCode: friendCode || undefined,
       });
       onDeployStarted(deployment);
@@ -1,5 +1,6 @@
 import { useState, useRef, useCallback } from "react";
 import { formatFileSize } from "../../lib/utils";
+import { ACCEPTED_ARCHIVE_EXTENSIONS } from "../../config/constants";
 
 interface DragDropZoneProps {
   onFileSelected: (file: File) => void;
@@ -117,12 +118,12 @@ export default function DragDropZone({
         <rect x="10.5" y="16.5" width="3" height="2" rx="0.5" fill="var(--sumi-ghost)" stroke="none" />
       </svg>
       <p style={{ color: "var(--sumi-light)", fontSize: "0.95rem" }}>
-        {isDragOver ? "Drop your file here" : "Drag and drop a .zip file, or click to browse"}
+        {isDragOver ? "Drop your file here" : "Drag and drop a .zip, .tar, .tar.gz or .tar.zst file, or click to browse"}
       </p>
       <p style={{ color: "var(--sumi-ghost)", fontSize: "0.8rem", marginTop: "0.3rem", fontStyle: "italic" }}>
         Maximum file size: 50MB
       </p>
-      <input ref={inputRef} type="file" accept=".zip" onChange={handleFileInput} className="hidden" aria-label="Upload zip file" />
+      <input ref={inputRef} type="file" accept={ACCEPTED_ARCHIVE_EXTENSIONS.join(",")} onChange={handleFileInput} className="hidden" aria-label="Upload archive file" />
       {error && <p style={{ color: "var(--vermillion)", fontSize: "0.8rem", marginTop: "0.75rem" }}>{error}</p>}
     </div>
   );
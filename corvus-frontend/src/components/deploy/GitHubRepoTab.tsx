@@ -13,7 +13,7 @@ function isNodeCommand(cmd: string): boolean {
 }
 
 interface GitHubRepoTabProps {
-  onDeploy: (repoUrl: string, branch: string, buildCommand: string, outputDirectory: string) => void;
+  onDeploy: (repoUrl: string, branch: string, buildCommand: string, outputDirectory: string, spaFallback: boolean) => void;
   disabled: boolean;
 }
 
@@ -22,6 +22,7 @@ export default function GitHubRepoTab({ onDeploy, disabled }: GitHubRepoTabProps
   const [branch, setBranch] = useState("main");
   const [buildCommand, setBuildCommand] = useState("");
   const [outputDirectory, setOutputDirectory] = useState("dist");
+  const [spaFallback, setSpaFallback] = useState(false);
   const [urlError, setUrlError] = useState("");
   const [buildError, setBuildError] = useState("");
   const [showBuildWarning, setShowBuildWarning] = useState(false);
@@ -33,7 +34,7 @@ export default function GitHubRepoTab({ onDeploy, disabled }: GitHubRepoTabProps
     return valid;
   };
 
-  const doDeploy = () => onDeploy(repoUrl.trim(), branch.trim() || "main", buildCommand.trim(), outputDirectory.trim() || ".");
+  const doDeploy = () => onDeploy(repoUrl.trim(), branch.trim() || "main", buildCommand.trim(), outputDirectory.trim() || ".", spaFallback);
 
   const handleDeploy = () => {
     if (!validate()) return;
@@ -80,6 +81,12 @@ export default function GitHubRepoTab({ onDeploy, disabled }: GitHubRepoTabProps
           </div>
         </div>
 
+        <label htmlFor="gh-spa-fallback" style={{ display: "flex", alignItems: "center", gap: "0.5rem", fontSize: "0.85rem" }}>
+          <input id="gh-spa-fallback" type="checkbox" checked={spaFallback}
+            onChange={(e) => setSpaFallback(e.target.checked)} disabled={disabled} />
+          Single-page app (serve index.html for unknown paths, for React Router and friends)
+        </label>
+
         <DeployButton onClick={handleDeploy} disabled={disabled || !repoUrl.trim()} loading={disabled} />
       </div>
     </>
@@ -1,7 +1,7 @@
 import { useState, useCallback } from "react";
 import DragDropZone from "./DragDropZone";
 import DeployButton from "./DeployButton";
-import { MAX_FILE_SIZE_BYTES } from "../../config/constants";
+import { ACCEPTED_ARCHIVE_EXTENSIONS, MAX_FILE_SIZE_BYTES } from "../../config/constants";
 
 interface ZipUploadTabProps {
   onDeploy: (file: File, outputDirectory: string, buildCommand: string) => void;
@@ -16,7 +16,7 @@ export default function ZipUploadTab({ onDeploy, disabled }: ZipUploadTabProps)
 
   const handleFileSelected = useCallback((file: File) => {
     setFileError(null);
-    if (!file.name.endsWith(".zip")) { setFileError("Only .zip files are accepted."); setSelectedFile(null); return; }
+    if (!ACCEPTED_ARCHIVE_EXTENSIONS.some((extension) => file.name.endsWith(extension))) { setFileError("Only .zip, .tar, .tar.gz and .tar.zst files are accepted."); setSelectedFile(null); return; }
     if (file.size > MAX_FILE_SIZE_BYTES) { setFileError("File exceeds the 50MB limit."); setSelectedFile(null); return; }
     setSelectedFile(file);
   }, []);
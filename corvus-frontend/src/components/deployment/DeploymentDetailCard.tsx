@@ -81,13 +81,34 @@ export default function DeploymentDetailCard({
       <div className="brush-divider-thin mb-5" />
       <div className="space-y-3" style={{ fontSize: "0.9rem" }}>
         {[
-          { label: "Source", value: deployment.source_type === "github" ? "GitHub" : deployment.source_type === "prebuilt" ? `Preset (${deployment.preset_id || "prebuilt"})` : "Zip Upload" },
+          { label: "Source", value: deployment.source_type === "github" ? "GitHub" : deployment.source_type === "git" ? "Git" : deployment.source_type === "prebuilt" ? `Preset (${deployment.preset_id || "prebuilt"})` : "Zip Upload" },
           ...(deployment.github_url ? [{
             label: "Repository",
             value: deployment.github_url.replace(/\.git$/, "").split("/").slice(-2).join("/"),
             href: deployment.github_url.replace(/\.git$/, ""),
           }] : []),
-          { label: "Branch", value: deployment.branch },
+          ...(deployment.git_url ? [{
+            label: "Repository",
+            value: deployment.git_url.replace(/\.git$/, "").split("/").slice(-2).join("/"),
+          }] : []),
+          deployment.ref ? { label: "Pinned Ref", value: deployment.ref } : { label: "Branch", value: deployment.branch },
+          ...(deployment.root_directory && deployment.root_directory !== "." ? [{
+            label: "Root Directory",
+            value: deployment.root_directory,
+          }] : []),
+          ...(deployment.commit_sha ? [{
+            label: "Commit",
+            value: `${deployment.commit_sha.slice(0, 7)} ${(deployment.commit_message || "").split("\n")[0]}`.trim(),
+          }] : []),
+          ...(deployment.pull_request_number ? [{
+            label: "Preview Of",
+            value: `PR #${deployment.pull_request_number}`,
+          }] : []),
+          ...(deployment.previews || []).map((preview) => ({
+            label: `PR #${preview.pull_request_number} Preview`,
+            value: `${preview.slug} (${preview.status})`,
+            href: preview.url,
+          })),
           { label: "Created", value: formatTimestamp(deployment.created_at) },
           { label: "Updated", value: formatTimestamp(deployment.updated_at) },
         ].map((row) => (
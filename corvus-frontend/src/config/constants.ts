@@ -17,6 +17,7 @@ export const POLL_TIMEOUT_MS = 120000;
 // Upload limits
 export const MAX_FILE_SIZE_BYTES = 50 * 1024 * 1024;
 export const MAX_FILE_SIZE_DISPLAY = "50MB";
+export const ACCEPTED_ARCHIVE_EXTENSIONS = [".zip", ".tar", ".tar.gz", ".tgz", ".tar.zst", ".tzst"];
 
 // "Your Message" preset
 export const MAX_MESSAGE_LENGTH = 100;
@@ -1,5 +1,6 @@
 export type DeploymentStatus = "deploying" | "live" | "failed";
-export type SourceType = "zip" | "github" | "prebuilt";
+export type SourceType = "zip" | "github" | "git" | "prebuilt";
+export type TrailingSlashPolicy = "ignore" | "always" | "never";
 
 export interface Deployment {
   id: string;
@@ -7,20 +8,85 @@ export interface Deployment {
   name: string;
   source_type: SourceType;
   github_url?: string;
+  git_url?: string;
   branch: string;
+  ref?: string;
+  commit_sha?: string;
+  commit_message?: string;
   build_command: string;
+  root_directory: string;
+  shared_directories?: string;
+  git_submodules: boolean;
+  git_lfs: boolean;
   output_directory: string;
+  spa_fallback: boolean;
+  custom_404: boolean;
+  clean_urls: boolean;
+  trailing_slash: TrailingSlashPolicy;
+  basic_auth_username?: string;
+  ip_allow_list?: string;
+  ip_deny_list?: string;
   environment_variables?: string;
   status: DeploymentStatus;
   url?: string;
+  // only in the POST /api/deployments response, never returned again
   webhook_secret?: string;
   auto_deploy: boolean;
   preset_id?: string;
+  template_values?: string;
+  parent_deployment_id?: string;
+  pull_request_number?: number;
+  previews?: PreviewDeployment[];
   expires_at?: string;
+  expires_in_seconds?: number;
   created_at: string;
   updated_at: string;
 }
 
+export interface PreviewDeployment {
+  id: string;
+  slug: string;
+  pull_request_number: number;
+  status: DeploymentStatus;
+  url?: string;
+  commit_sha?: string;
+}
+
+export type PipelineEventType = "step_started" | "step_finished" | "step_failed";
+
+/** A structured pipeline event from GET /api/deployments/:id/events */
+export interface PipelineEvent {
+  id: number;
+  deployment_id: string;
+  run_id: string;
+  step: string;
+  type: PipelineEventType;
+  duration_ms?: number;
+  message?: string;
+  metadata?: Record<string, unknown>;
+  created_at: string;
+}
+
+export interface PresetTemplateVariable {
+  name: string;
+  description?: string;
+  required: boolean;
+  type: "string" | "text" | "url" | "email" | "date" | "color";
+  default?: string;
+  max_length?: number;
+}
+
+/** A preset's preset.json manifest, as listed by GET /api/presets */
+export interface PresetManifest {
+  id: string;
+  title: string;
+  description: string;
+  thumbnail?: string;
+  version: string;
+  template_variables: PresetTemplateVariable[];
+  template_files: string[];
+}
+
 export interface DeployPreset {
   id: string;
   name: string;